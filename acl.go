@@ -0,0 +1,306 @@
+package ldapserver
+
+import (
+	"context"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ACLAccess is a set of access rights checked by an ACL.
+type ACLAccess int
+
+const (
+	ACLRead    ACLAccess = 1 << iota // see entries and attributes in search results
+	ACLCompare                       // compare attribute values
+	ACLWrite                         // add, delete, modify and rename entries
+	ACLBind                          // bind as the entry
+	ACLAll     = ACLRead | ACLCompare | ACLWrite | ACLBind
+)
+
+// ACLRule grants or denies access rights to a subject on the entries of a
+// subtree.
+type ACLRule struct {
+	// Who is the subject of the rule: "*" for everyone, "anonymous",
+	// "users" for any bound session, "self" for the entry itself,
+	// "dn:<dn>" for one identity, "subtree:<dn>" for the identities below
+	// a DN, "group:<dn>" for the members of a group (see ACL.IsMember).
+	Who string
+
+	// Target is the DN of the subtree the rule applies to, "" for all
+	// entries.
+	Target string
+
+	// Attributes restricts the rule to these attributes, nil for the
+	// entry and all its attributes.
+	Attributes []string
+
+	Access ACLAccess
+	Deny   bool
+}
+
+// ACL is an access control engine evaluated before the requests reach the
+// handlers: rules are tried in order and the first one matching the
+// subject, the entry, the attribute and the access right decides. Access
+// is denied when no rule matches. The identity of a request is its bound
+// DN, or the identity returned by Server.ProxyAuthorizer, which must be a
+// DN or a "dn:" authzId: the requests performed as other identities, e.g.
+// "u:" ones, are denied.
+//
+//	acl := &ldap.ACL{Rules: []ldap.ACLRule{
+//		{Who: "anonymous", Target: "ou=people,dc=example,dc=com", Access: ldap.ACLBind},
+//		{Who: "users", Attributes: []string{"userPassword"}, Access: ldap.ACLAll, Deny: true},
+//		{Who: "users", Access: ldap.ACLRead | ldap.ACLCompare},
+//	}}
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return acl.Handler(routes) }
+type ACL struct {
+	Rules []ACLRule
+
+	// IsMember reports whether the identity dn is a member of the group,
+	// needed by "group:" subjects.
+	IsMember func(ctx context.Context, group, dn string) bool
+
+	// ExtendedOperations are the names of the extended operations allowed
+	// to everyone, e.g. those checking the access themselves. Who Am I?
+	// and Cancel are allowed, Password Modify and Refresh are checked as
+	// writes of the userPassword and entryTtl of their entry, and the
+	// other extended operations are denied. The updates of transactions
+	// are checked when they are committed.
+	ExtendedOperations []ldap.LDAPOID
+}
+
+// Allowed reports whether identity, "" for anonymous, has access to the
+// attribute of the entry dn. An empty attribute checks the entry itself.
+func (a *ACL) Allowed(ctx context.Context, identity string, access ACLAccess, dn, attribute string) bool {
+	identity, dn = NormalizeDN(identity), NormalizeDN(dn)
+	for _, rule := range a.Rules {
+		if rule.Access&access != access || !InScope(dn, rule.Target, SearchRequestHomeSubtree) {
+			continue
+		}
+		if !rule.matchesAttribute(attribute) || !a.matchesWho(ctx, rule.Who, identity, dn) {
+			continue
+		}
+		return !rule.Deny
+	}
+	return false
+}
+
+func (rule *ACLRule) matchesAttribute(attribute string) bool {
+	if rule.Attributes == nil {
+		return true
+	}
+	for _, name := range rule.Attributes {
+		if attribute != "" && strings.EqualFold(name, attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACL) matchesWho(ctx context.Context, who, identity, dn string) bool {
+	kind, value, _ := strings.Cut(who, ":")
+	switch strings.ToLower(kind) {
+	case "*":
+		return true
+	case "anonymous":
+		return identity == ""
+	case "users":
+		return identity != ""
+	case "self":
+		return identity != "" && identity == dn
+	case "dn":
+		return identity != "" && identity == NormalizeDN(value)
+	case "subtree":
+		return identity != "" && InScope(identity, value, SearchRequestHomeSubtree)
+	case "group":
+		return identity != "" && a.IsMember != nil && a.IsMember(ctx, value, identity)
+	}
+	return false
+}
+
+// aclIdentity returns the DN a request is performed as: the proxied
+// identity if any, else the bound DN. ok is false when the proxied
+// identity is not a DN.
+func aclIdentity(ctx context.Context) (dn string, ok bool) {
+	if p, ok := ProxiedAuthorizationFromContext(ctx); ok {
+		if strings.HasPrefix(p.Identity, "u:") {
+			return "", false
+		}
+		dn = strings.TrimPrefix(p.Identity, "dn:")
+		if _, err := ParseDN(dn); err != nil {
+			return "", false
+		}
+		return dn, true
+	}
+	state, _ := AuthStateFromContext(ctx)
+	return state.BoundDN, true
+}
+
+// Handler returns a handler enforcing the ACL before calling next: denied
+// requests are answered with insufficientAccessRights (invalidCredentials
+// for binds) and search result entries are filtered. The entries with
+// attributes of the search filter the identity can not read are dropped,
+// so that the filter does not tell their values.
+func (a *ACL) Handler(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		identity, allowed := aclIdentity(ctx)
+
+		switch r := m.ProtocolOp().(type) {
+		case ldap.BindRequest:
+			if allowed && r.AuthenticationChoice() == "simple" && r.Name() != "" &&
+				!a.Allowed(ctx, identity, ACLBind, string(r.Name()), "") {
+				res := NewBindResponse(LDAPResultInvalidCredentials)
+				w.Write(res)
+				return
+			}
+		case ldap.SearchRequest:
+			f, err := searchFilter(r)
+			if err != nil {
+				allowed = false
+				break
+			}
+			w = &aclResponseWriter{ResponseWriter: w, acl: a, ctx: ctx, identity: identity, asserted: a.filterAttributes(f)}
+		case ldap.CompareRequest:
+			allowed = allowed && a.Allowed(ctx, identity, ACLCompare, string(r.Entry()), string(r.Ava().AttributeDesc()))
+		case ldap.AddRequest:
+			allowed = allowed && a.Allowed(ctx, identity, ACLWrite, string(r.Entry()), "")
+			for _, attr := range r.Attributes() {
+				allowed = allowed && a.Allowed(ctx, identity, ACLWrite, string(r.Entry()), string(attr.Type_()))
+			}
+		case ldap.DelRequest:
+			allowed = allowed && a.Allowed(ctx, identity, ACLWrite, string(r), "")
+		case ldap.ModifyRequest:
+			for _, change := range r.Changes() {
+				name := string(change.Modification().Type_())
+				allowed = allowed && a.Allowed(ctx, identity, ACLWrite, string(r.Object()), name)
+			}
+		case ldap.ModifyDNRequest:
+			allowed = allowed && a.allowedModifyDN(ctx, identity, r)
+		case ldap.ExtendedRequest:
+			allowed = allowed && a.allowedExtended(ctx, identity, m, r)
+		}
+
+		if !allowed {
+			if res := responseFor(m.ProtocolOp(), LDAPResultInsufficientAccessRights, "access denied"); res != nil {
+				w.Write(res)
+			}
+			return
+		}
+		next.ServeLDAP(ctx, w, m)
+	})
+}
+
+// allowedModifyDN reports whether identity may rename the entry of r: it
+// needs write access to the entry, to the attributes of its new RDN, and
+// to the entry at its new DN and its new superior, as for an add.
+func (a *ACL) allowedModifyDN(ctx context.Context, identity string, r ldap.ModifyDNRequest) bool {
+	req, err := decodeModifyDNRequest(r)
+	if err != nil || !a.Allowed(ctx, identity, ACLWrite, req.Entry, "") {
+		return false
+	}
+	rdn, err := ParseDN(req.NewRDN)
+	if err != nil || len(rdn) != 1 {
+		return false
+	}
+	newDN := req.NewDN()
+	if !a.Allowed(ctx, identity, ACLWrite, newDN, "") {
+		return false
+	}
+	for _, ava := range rdn[0] {
+		if !a.Allowed(ctx, identity, ACLWrite, newDN, ava.Type) {
+			return false
+		}
+	}
+	return req.NewSuperior == nil || a.Allowed(ctx, identity, ACLWrite, *req.NewSuperior, "")
+}
+
+// allowedExtended reports whether identity may perform the extended
+// operation r of the message m, see ACL.ExtendedOperations.
+func (a *ACL) allowedExtended(ctx context.Context, identity string, m *Message, r ldap.ExtendedRequest) bool {
+	switch r.RequestName() {
+	case NoticeOfWhoAmI, NoticeOfCancel:
+		return true
+	case NoticeOfPasswordModify:
+		v, err := m.ExtendedValue()
+		req, ok := v.(*PasswordModifyRequest)
+		if err != nil || !ok {
+			return false
+		}
+		dn := identity
+		if req.UserIdentity != nil {
+			dn = strings.TrimPrefix(string(req.UserIdentity), "dn:")
+		}
+		return dn != "" && a.Allowed(ctx, identity, ACLWrite, dn, "userPassword")
+	case NoticeOfRefresh:
+		v, err := m.ExtendedValue()
+		req, ok := v.(*RefreshRequest)
+		return err == nil && ok && a.Allowed(ctx, identity, ACLWrite, req.EntryName, "entryTtl")
+	}
+	for _, name := range a.ExtendedOperations {
+		if name == r.RequestName() {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAttributes returns the attributes asserted by the search filter f,
+// those of the rules when it asserts any attribute.
+func (a *ACL) filterAttributes(f berElement) []string {
+	names, untyped := f.attributes()
+	if untyped {
+		for _, rule := range a.Rules {
+			names = append(names, rule.Attributes...)
+		}
+	}
+	return names
+}
+
+// aclResponseWriter drops the entries and attributes of search results the
+// identity can not read.
+type aclResponseWriter struct {
+	ResponseWriter
+	acl      *ACL
+	ctx      context.Context
+	identity string
+	asserted []string // attributes of the search filter
+}
+
+func (w *aclResponseWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *aclResponseWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if e, ok := po.(ldap.SearchResultEntry); ok {
+		if po, ok = w.filter(e); !ok {
+			return nil
+		}
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// filter returns the part of the entry the identity can read, ok is false
+// when the entry is hidden.
+func (w *aclResponseWriter) filter(e ldap.SearchResultEntry) (po ldap.ProtocolOp, ok bool) {
+	dn, attributes, err := decodeSearchResultEntry(e)
+	if err != nil || !w.acl.Allowed(w.ctx, w.identity, ACLRead, dn, "") {
+		return nil, false
+	}
+	for _, name := range w.asserted {
+		if !w.acl.Allowed(w.ctx, w.identity, ACLRead, dn, name) {
+			return nil, false
+		}
+	}
+	filtered := NewSearchResultEntry(dn)
+	for _, attr := range attributes {
+		if !w.acl.Allowed(w.ctx, w.identity, ACLRead, dn, attr.Name) {
+			continue
+		}
+		values := make([]ldap.AttributeValue, len(attr.Values))
+		for i, v := range attr.Values {
+			values[i] = ldap.AttributeValue(v)
+		}
+		filtered.AddAttribute(ldap.AttributeDescription(attr.Name), values...)
+	}
+	return filtered, true
+}
@@ -0,0 +1,59 @@
+package ldapserver
+
+import (
+	"context"
+	"testing"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// TestACLExtended checks that Password Modify is checked as a write of
+// the userPassword of its user, and that the unknown extended operations
+// and the proxied identities which are not DNs are denied.
+func TestACLExtended(t *testing.T) {
+	acl := &ACL{Rules: []ACLRule{
+		{Who: "self", Target: "uid=self,dc=example,dc=com", Attributes: []string{"userPassword"}, Access: ACLWrite},
+		{Who: "users", Attributes: []string{"userPassword"}, Access: ACLAll, Deny: true},
+		{Who: "users", Access: ACLAll},
+	}}
+	served := false
+	h := acl.Handler(HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) { served = true }))
+	bound := context.WithValue(context.Background(), authStateKey{}, AuthState{BoundDN: "uid=jdoe,dc=example,dc=com"})
+	self := context.WithValue(context.Background(), authStateKey{}, AuthState{BoundDN: "uid=self,dc=example,dc=com"})
+	proxied := context.WithValue(bound, proxiedAuthorizationKey{}, ProxiedAuthorization{AuthzID: "u:jdoe", Identity: "u:jdoe"})
+
+	passwordModify := berSequence(berEncode(berClassContext, false, 2, []byte("Correct#Horse9")))
+	for _, tt := range []struct {
+		name    string
+		ctx     context.Context
+		oid     ldap.LDAPOID
+		value   []byte
+		allowed bool
+	}{
+		{"Password Modify", bound, NoticeOfPasswordModify, passwordModify, false},
+		{"Password Modify of self", self, NoticeOfPasswordModify, passwordModify, true},
+		{"Who Am I?", bound, NoticeOfWhoAmI, nil, true},
+		{"unknown", bound, "1.2.3.4", nil, false},
+		{"u: identity", proxied, NoticeOfWhoAmI, nil, false},
+	} {
+		r, err := NewExtendedRequest(tt.oid, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, err := newResponseMessage(1, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		served = false
+		rec := &recorder{}
+		h.ServeLDAP(tt.ctx, rec, &Message{LDAPMessage: message})
+		if served != tt.allowed {
+			t.Errorf("%s: served %v, want %v", tt.name, served, tt.allowed)
+		}
+		if !tt.allowed {
+			if code, _ := ResultCode(rec.responses[0]); code != LDAPResultInsufficientAccessRights {
+				t.Errorf("%s: result %d, want insufficientAccessRights", tt.name, code)
+			}
+		}
+	}
+}
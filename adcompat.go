@@ -0,0 +1,235 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// NoticeOfFastBind is the OID of the LDAP_SERVER_FAST_BIND_OID extended
+// operation of Active Directory.
+const NoticeOfFastBind ldap.LDAPOID = "1.2.840.113556.1.4.1781"
+
+// Error codes of the failed binds of Active Directory, the data of their
+// diagnostic messages.
+const (
+	adInvalidCredentials = "52e"
+	adPasswordExpired    = "532"
+	adMustResetPassword  = "773"
+	adAccountLockedOut   = "775"
+)
+
+// ADCompat answers the binds the way Active Directory does, for the
+// appliances and applications written against it:
+//
+//   - the bind names DOMAIN\user and user@domain are mapped to the DNs of
+//     the users by Resolve, the session being bound as the DN,
+//   - the LDAP_SERVER_FAST_BIND extended operation puts the connection in
+//     fast bind mode: its simple binds only check the credentials, the
+//     session staying anonymous, and the other binds are refused,
+//   - the failed binds get the diagnostic messages of Active Directory,
+//     e.g. "80090308: LdapErr: DSID-0C09044E, comment:
+//     AcceptSecurityContext error, data 52e, v4563".
+//
+// The data of the diagnostic messages tells a locked account (775), an
+// expired password (532) or a password to reset (773) from the Password
+// Policy response control of the handler, which ADCompat requests.
+//
+//	ad := &ldap.ADCompat{Resolve: func(ctx context.Context, domain, user string) (string, error) {
+//		return "cn=" + user + ",cn=users,dc=example,dc=com", nil
+//	}}
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return ad.Handler(routes) }
+type ADCompat struct {
+	// Resolve returns the DN of the user of the domain, as given in the
+	// bind name: a NetBIOS name for DOMAIN\user, a DNS name for
+	// user@domain. An *Error with noSuchObject, or an empty DN, fails the
+	// bind with invalidCredentials. The names are not mapped if nil.
+	Resolve func(ctx context.Context, domain, user string) (dn string, err error)
+}
+
+// adFastBindKey is the session key of the connections in fast bind mode.
+type adFastBindKey struct{}
+
+// Handler returns a handler applying the compatibility mode before
+// calling next.
+func (a *ADCompat) Handler(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		switch r := m.ProtocolOp().(type) {
+		case ldap.ExtendedRequest:
+			if r.RequestName() == NoticeOfFastBind {
+				m.Client.Store(adFastBindKey{}, true)
+				w.Write(NewExtendedResponse(LDAPResultSuccess))
+				return
+			}
+		case ldap.BindRequest:
+			if !a.bind(ctx, w, m, r) {
+				return
+			}
+			w = &adBindWriter{ResponseWriter: w, policy: m.PasswordPolicy()}
+			m.LDAPMessage = withPasswordPolicy(m)
+		}
+		next.ServeLDAP(ctx, w, m)
+	})
+}
+
+// bind prepares the bind r for the handler: it maps its name and applies
+// the fast bind mode. It returns false when the bind was answered.
+func (a *ADCompat) bind(ctx context.Context, w ResponseWriter, m *Message, r ldap.BindRequest) bool {
+	_, fast := m.Client.Load(adFastBindKey{})
+	if fast && r.AuthenticationChoice() != "simple" {
+		w.Write(NewBindResponse(LDAPResultAuthMethodNotSupported, DiagnosticMessage("only simple binds are allowed in fast bind mode")))
+		return false
+	}
+	if fast {
+		m.SetBoundDN("")
+	}
+
+	name := string(r.Name())
+	domain, user, ok := splitADName(name)
+	if !ok || a.Resolve == nil {
+		return true
+	}
+	dn, err := a.Resolve(ctx, domain, user)
+	var e *Error
+	switch {
+	case err == nil && dn != "":
+	case err == nil || errors.As(err, &e) && e.ResultCode == LDAPResultNoSuchObject:
+		w.Write(NewBindResponse(LDAPResultInvalidCredentials, DiagnosticMessage(adDiagnostic(adInvalidCredentials))))
+		return false
+	default:
+		w.Write(ErrorResponse(r, err))
+		return false
+	}
+
+	message, err := bindMessageAs(m.LDAPMessage, dn)
+	if err != nil {
+		w.Write(NewBindResponse(LDAPResultProtocolError, DiagnosticMessage(err.Error())))
+		return false
+	}
+	m.LDAPMessage = message
+	if !fast {
+		m.SetBoundDN(dn)
+	}
+	return true
+}
+
+// bindMessageAs returns the bind message m with the name dn.
+func bindMessageAs(m *ldap.LDAPMessage, dn string) (*ldap.LDAPMessage, error) {
+	data, err := protocolOpBytes(m.ProtocolOp())
+	if err != nil {
+		return nil, err
+	}
+	// BindRequest ::= [APPLICATION 0] SEQUENCE { version, name, authentication }
+	op, err := berParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := op.children()
+	if err != nil || len(fields) != 3 {
+		return nil, errors.New("malformed bind request")
+	}
+	fields[1].value = []byte(dn)
+	return messageWithProtocolOp(m, op.with(fields).encode())
+}
+
+// splitADName splits the bind names DOMAIN\user and user@domain, ok being
+// false for the DNs and the other names.
+func splitADName(name string) (domain, user string, ok bool) {
+	if strings.Contains(name, "=") {
+		return "", "", false
+	}
+	if domain, user, ok := strings.Cut(name, `\`); ok && domain != "" && user != "" {
+		return domain, user, true
+	}
+	if i := strings.LastIndexByte(name, '@'); i > 0 && i < len(name)-1 {
+		return name[i+1:], name[:i], true
+	}
+	return "", "", false
+}
+
+// adDiagnostic returns the diagnostic message of a failed bind with the
+// AD error code data.
+func adDiagnostic(data string) string {
+	return fmt.Sprintf("80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data %s, v4563", data)
+}
+
+// withPasswordPolicy returns the message of the bind m with the Password
+// Policy control, for the handler to tell why the bind failed.
+func withPasswordPolicy(m *Message) *ldap.LDAPMessage {
+	if m.PasswordPolicy() {
+		return m.LDAPMessage
+	}
+	controls := append(m.Controls(), Control{OID: ControlPasswordPolicy})
+	message, err := newResponseMessage(m.MessageID().Int(), m.ProtocolOp(), controls)
+	if err != nil {
+		return m.LDAPMessage
+	}
+	return message
+}
+
+// adBindWriter gives the failed binds the diagnostic messages of Active
+// Directory.
+type adBindWriter struct {
+	ResponseWriter
+	policy bool // the client requested the Password Policy control
+}
+
+func (w *adBindWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *adBindWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if _, ok := po.(ldap.BindResponse); !ok {
+		return w.ResponseWriter.WriteWithControls(po, controls...)
+	}
+	policyError := PolicyNoError
+	kept := controls[:0:0]
+	for _, c := range controls {
+		if c.OID == ControlPasswordPolicy {
+			policyError = decodePasswordPolicyError(c.Value)
+			if !w.policy {
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	if code, _ := ResultCode(po); code == LDAPResultInvalidCredentials {
+		data := adInvalidCredentials
+		switch policyError {
+		case PolicyAccountLocked:
+			data = adAccountLockedOut
+		case PolicyPasswordExpired:
+			data = adPasswordExpired
+		case PolicyChangeAfterReset:
+			data = adMustResetPassword
+		}
+		po = NewBindResponse(LDAPResultInvalidCredentials, DiagnosticMessage(adDiagnostic(data)))
+	}
+	return w.ResponseWriter.WriteWithControls(po, kept...)
+}
+
+// decodePasswordPolicyError returns the error of the Password Policy
+// response control value, PolicyNoError if none.
+func decodePasswordPolicyError(value []byte) PasswordPolicyError {
+	// PasswordPolicyResponseValue ::= SEQUENCE { warning [0] OPTIONAL,
+	//     error [1] ENUMERATED OPTIONAL }
+	e, err := berParseAll(value)
+	if err != nil {
+		return PolicyNoError
+	}
+	fields, err := e.children()
+	if err != nil {
+		return PolicyNoError
+	}
+	for _, f := range fields {
+		if f.is(berClassContext, 1) && !f.constructed {
+			if v, err := f.int(); err == nil {
+				return PasswordPolicyError(v)
+			}
+		}
+	}
+	return PolicyNoError
+}
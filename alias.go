@@ -0,0 +1,43 @@
+package ldapserver
+
+import "strings"
+
+// IsAlias reports whether the entry is an alias (RFC 4512 section 2.6),
+// of the object class alias, naming another entry by its
+// aliasedObjectName.
+func IsAlias(e *Entry) bool {
+	for _, oc := range e.Get("objectClass") {
+		if strings.EqualFold(oc, "alias") || oc == "2.5.6.1" {
+			return true
+		}
+	}
+	return false
+}
+
+// DerefAlias returns the entry named by the alias e, following the chains
+// of aliases, or e when it is not an alias. get returns the entry dn of
+// the directory, nil when there is none. An alias naming no entry and a
+// loop of aliases are an aliasProblem.
+func DerefAlias(e *Entry, get func(dn string) (*Entry, error)) (*Entry, error) {
+	seen := make(map[string]bool)
+	for IsAlias(e) {
+		key := NormalizeDN(e.DN)
+		if seen[key] {
+			return nil, NewError(LDAPResultAliasProblem, "alias loop at "+e.DN)
+		}
+		seen[key] = true
+		target := e.Get("aliasedObjectName")
+		if len(target) != 1 {
+			return nil, NewError(LDAPResultAliasProblem, "invalid aliasedObjectName of "+e.DN)
+		}
+		next, err := get(target[0])
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			return nil, NewError(LDAPResultAliasProblem, "alias "+e.DN+" names no entry: "+target[0])
+		}
+		e = next
+	}
+	return e, nil
+}
@@ -0,0 +1,91 @@
+package ldapserver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// AuthState is the authentication state of a connection, as established
+// by its last successful bind.
+type AuthState struct {
+	BoundDN string    // "" while the session is anonymous
+	Method  string    // "simple" or the upper case SASL mechanism, "" when anonymous
+	BoundAt time.Time // time of the bind, zero when anonymous
+}
+
+// Anonymous reports whether the session has no authenticated identity.
+func (s AuthState) Anonymous() bool {
+	return s.BoundDN == ""
+}
+
+type authStateKey struct{}
+
+// AuthStateFromContext returns the authentication state of the connection
+// when the request carried by ctx was received.
+func AuthStateFromContext(ctx context.Context) (AuthState, bool) {
+	s, ok := ctx.Value(authStateKey{}).(AuthState)
+	return s, ok
+}
+
+// AuthState returns the current authentication state of the connection.
+func (c *client) AuthState() AuthState {
+	c.Lock()
+	defer c.Unlock()
+	return c.auth
+}
+
+// BoundDN returns the DN of the last successful bind of the connection,
+// "" while the session is anonymous.
+func (c *client) BoundDN() string {
+	return c.AuthState().BoundDN
+}
+
+// SetBoundDN sets the identity the bind request m establishes when it
+// succeeds, its name by default: e.g. the DN a handler resolved the user
+// name of the bind to, or "" for a bind only checking the credentials, the
+// session staying anonymous.
+func (m *Message) SetBoundDN(dn string) {
+	m.boundDN = &dn
+}
+
+// authMethod returns the AuthState method of a bind request.
+func authMethod(r ldap.BindRequest) string {
+	if mechanism, _, ok := saslCredentials(r); ok {
+		return strings.ToUpper(mechanism)
+	}
+	return r.AuthenticationChoice()
+}
+
+// resetAuth reverts the session to anonymous when a bind request arrives
+// (RFC 4513 section 4): the session keeps no identity while the bind is in
+// progress, and stays anonymous if it fails. Requests are processed one at
+// a time, so the operations received before the bind are complete.
+func (c *client) resetAuth() {
+	c.Lock()
+	defer c.Unlock()
+	c.auth = AuthState{}
+	c.principal = ""
+}
+
+// checkAuthentication answers the requests of anonymous sessions when
+// Server.RequireAuthentication is set. It returns true when the request
+// was answered.
+func (c *client) checkAuthentication(w ResponseWriter, message *ldap.LDAPMessage) bool {
+	if !c.srv.RequireAuthentication || !c.AuthState().Anonymous() {
+		return false
+	}
+	switch r := message.ProtocolOp().(type) {
+	case ldap.SearchRequest:
+		if r.BaseObject() == "" && r.Scope() == SearchRequestScopeBaseObject {
+			return false // the root DSE tells how to bind
+		}
+	case ldap.ModifyRequest, ldap.AddRequest, ldap.DelRequest, ldap.ModifyDNRequest, ldap.CompareRequest:
+	default:
+		return false
+	}
+	w.Write(responseFor(message.ProtocolOp(), LDAPResultInsufficientAccessRights, "authentication required"))
+	return true
+}
@@ -0,0 +1,129 @@
+package ldapserver
+
+import (
+	"context"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Backend is the storage of a directory, separated from the protocol: its
+// methods run the operations of the requests, and BackendMux serves them.
+// Errors are answered with ErrorResponse, an *Error giving the result
+// code, e.g. noSuchObject; a backend without an operation returns an
+// *Error with unwillingToPerform.
+type Backend interface {
+	// Bind checks the password of a simple bind of dn, failing with
+	// invalidCredentials. Anonymous binds are answered by BackendMux.
+	Bind(ctx context.Context, dn, password string) error
+
+	// Search calls f with the entries in the scope of the search matching
+	// its filter, failing with noSuchObject when its base does not exist.
+	// It returns the error of f, which stops the search. BackendMux
+	// selects the requested attributes of the entries and enforces the
+	// size limit.
+	Search(ctx context.Context, r ldap.SearchRequest, f func(e *Entry) error) error
+
+	// Add adds the entry e, below an existing parent.
+	Add(ctx context.Context, e *Entry) error
+
+	// Delete deletes the leaf entry dn.
+	Delete(ctx context.Context, dn string) error
+
+	// Modify applies the changes to the entry dn, atomically.
+	Modify(ctx context.Context, dn string, changes []Modification) error
+
+	// ModifyDN renames an entry or moves it under a new superior, with
+	// the entries below it.
+	ModifyDN(ctx context.Context, r ModifyDNRequest) error
+
+	// Compare reports whether the attribute of the entry dn has the
+	// value, failing with noSuchAttribute when the entry has no such
+	// attribute.
+	Compare(ctx context.Context, dn, attribute, value string) (bool, error)
+}
+
+// BackendMux returns a RouteMux serving the requests with the backend b.
+// Routes can be added to it, e.g. for extended operations, which it
+// answers with unwillingToPerform otherwise.
+//
+//	routes := ldap.BackendMux(backend)
+//	routes.Extended(handlePasswordModify).RequestName(ldap.NoticeOfPasswordModify)
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return routes }
+func BackendMux(b Backend) *RouteMux {
+	mux := NewRouteMux()
+	mux.Bind(ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		r := m.GetBindRequest()
+		if r.AuthenticationChoice() != "simple" {
+			return NewError(LDAPResultAuthMethodNotSupported, "only simple binds are supported")
+		}
+		name, password := string(r.Name()), string(r.AuthenticationSimple())
+		if password == "" {
+			if name != "" {
+				// RFC 4513 section 5.1.2
+				return NewError(LDAPResultUnwillingToPerform, "unauthenticated bind")
+			}
+			return w.Write(NewBindResponse(LDAPResultSuccess))
+		}
+		if err := b.Bind(ctx, name, password); err != nil {
+			return err
+		}
+		return w.Write(NewBindResponse(LDAPResultSuccess))
+	}))
+	mux.Search(ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		r := m.GetSearchRequest()
+		n, limit := 0, int(r.SizeLimit())
+		err := b.Search(ctx, r, func(e *Entry) error {
+			if limit > 0 && n == limit {
+				return NewError(LDAPResultSizeLimitExceeded, "")
+			}
+			n++
+			return w.Write(SelectAttributes(e, r.Attributes(), bool(r.TypesOnly())))
+		})
+		if err != nil {
+			return err
+		}
+		return w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	}))
+	mux.Add(ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		if err := b.Add(ctx, EntryFromAddRequest(m.GetAddRequest())); err != nil {
+			return err
+		}
+		return w.Write(NewAddResponse(LDAPResultSuccess))
+	}))
+	mux.Delete(ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		if err := b.Delete(ctx, string(m.GetDeleteRequest())); err != nil {
+			return err
+		}
+		return w.Write(NewDeleteResponse(LDAPResultSuccess))
+	}))
+	mux.Modify(ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		r := m.GetModifyRequest()
+		if err := b.Modify(ctx, string(r.Object()), Modifications(r)); err != nil {
+			return err
+		}
+		return w.Write(NewModifyResponse(LDAPResultSuccess))
+	}))
+	mux.ModifyDN(ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		r, err := m.ModifyDN()
+		if err != nil {
+			return NewError(LDAPResultProtocolError, err.Error())
+		}
+		if err := b.ModifyDN(ctx, r); err != nil {
+			return err
+		}
+		return w.Write(NewModifyDNResponse(LDAPResultSuccess))
+	}))
+	mux.Compare(ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		r := m.GetCompareRequest()
+		ava := r.Ava()
+		ok, err := b.Compare(ctx, string(r.Entry()), string(ava.AttributeDesc()), string(ava.AssertionValue()))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return w.Write(NewCompareResponse(LDAPResultCompareTrue))
+		}
+		return w.Write(NewCompareResponse(LDAPResultCompareFalse))
+	}))
+	return mux
+}
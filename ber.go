@@ -0,0 +1,381 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Minimal BER codec for the parts of LDAP messages goldap does not expose
+// (SASL credentials, control values, extended operation values). LDAP only
+// uses low tag numbers and definite lengths, which is all this supports.
+
+const (
+	berClassUniversal   = 0x00
+	berClassApplication = 0x40
+	berClassContext     = 0x80
+
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagNull        = 0x05
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x10
+	berTagSet         = 0x11
+)
+
+var errBERTruncated = errors.New("ber: truncated element")
+
+// berElement is a decoded BER type-length-value.
+type berElement struct {
+	class       int
+	constructed bool
+	tag         int
+	value       []byte // content octets
+}
+
+// berParse decodes the first element of data and returns the remaining
+// bytes.
+func berParse(data []byte) (e berElement, rest []byte, err error) {
+	if len(data) < 2 {
+		return e, nil, errBERTruncated
+	}
+	b := data[0]
+	e.class = int(b & 0xc0)
+	e.constructed = b&0x20 != 0
+	e.tag = int(b & 0x1f)
+	if e.tag == 0x1f {
+		return e, nil, errors.New("ber: high tag numbers are not supported")
+	}
+
+	length := int(data[1])
+	offset := 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 {
+			return e, nil, errors.New("ber: indefinite length is not supported")
+		}
+		if n > 4 || len(data) < offset+n {
+			return e, nil, errBERTruncated
+		}
+		length = 0
+		for _, c := range data[offset : offset+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(data)-offset < length {
+		return e, nil, errBERTruncated
+	}
+	e.value = data[offset : offset+length]
+	return e, data[offset+length:], nil
+}
+
+// berParseAll decodes a single element which must span all of data.
+func berParseAll(data []byte) (berElement, error) {
+	e, rest, err := berParse(data)
+	if err == nil && len(rest) > 0 {
+		err = errors.New("ber: trailing data")
+	}
+	return e, err
+}
+
+// children decodes the elements contained in a constructed element.
+func (e berElement) children() ([]berElement, error) {
+	var list []berElement
+	data := e.value
+	for len(data) > 0 {
+		child, rest, err := berParse(data)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, child)
+		data = rest
+	}
+	return list, nil
+}
+
+func (e berElement) is(class, tag int) bool {
+	return e.class == class && e.tag == tag
+}
+
+func (e berElement) int() (int64, error) {
+	if len(e.value) == 0 || len(e.value) > 8 {
+		return 0, fmt.Errorf("ber: invalid integer length %d", len(e.value))
+	}
+	v := int64(int8(e.value[0])) // sign extension
+	for _, c := range e.value[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+func (e berElement) bool() bool {
+	return len(e.value) > 0 && e.value[0] != 0
+}
+
+// berEncode builds an element from its content octets.
+func berEncode(class int, constructed bool, tag int, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := byte(class) | byte(tag)
+	if constructed {
+		b |= 0x20
+	}
+	out := make([]byte, 0, n+6)
+	out = append(out, b)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	case n <= 0xffffff:
+		out = append(out, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+// encode returns the encoding of the element.
+func (e berElement) encode() []byte {
+	return berEncode(e.class, e.constructed, e.tag, e.value)
+}
+
+// with returns the constructed element e with the children.
+func (e berElement) with(children []berElement) berElement {
+	var value []byte
+	for _, c := range children {
+		value = append(value, c.encode()...)
+	}
+	e.value = value
+	return e
+}
+
+func berSequence(content ...[]byte) []byte {
+	return berEncode(berClassUniversal, true, berTagSequence, content...)
+}
+
+func berOctetString(s []byte) []byte {
+	return berEncode(berClassUniversal, false, berTagOctetString, s)
+}
+
+func berString(s string) []byte {
+	return berOctetString([]byte(s))
+}
+
+func berBoolean(v bool) []byte {
+	if v {
+		return berEncode(berClassUniversal, false, berTagBoolean, []byte{0xff})
+	}
+	return berEncode(berClassUniversal, false, berTagBoolean, []byte{0x00})
+}
+
+func berIntegerContent(v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		// stop once the remaining bits are pure sign extension
+		if (v == 0 && b[0]&0x80 == 0) || (v == -1 && b[0]&0x80 != 0) {
+			return b
+		}
+	}
+}
+
+func berInteger(v int64) []byte {
+	return berEncode(berClassUniversal, false, berTagInteger, berIntegerContent(v))
+}
+
+func berEnumerated(v int64) []byte {
+	return berEncode(berClassUniversal, false, berTagEnumerated, berIntegerContent(v))
+}
+
+// protocolOpBytes returns the BER encoding of a goldap protocol op.
+func protocolOpBytes(po ldap.ProtocolOp) ([]byte, error) {
+	data, err := ldap.NewLDAPMessageWithProtocolOp(po).Write()
+	if err != nil {
+		return nil, err
+	}
+	// LDAPMessage ::= SEQUENCE { messageID, protocolOp, controls }
+	msg, err := berParseAll(data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	_, rest, err := berParse(msg.value)
+	if err != nil {
+		return nil, err
+	}
+	_, tail, err := berParse(rest)
+	if err != nil {
+		return nil, err
+	}
+	return rest[:len(rest)-len(tail)], nil
+}
+
+// messageWithProtocolOp returns the message m with the encoded protocol
+// op, its message ID and controls kept, e.g. for the requests a middleware
+// rewrites.
+func messageWithProtocolOp(m *ldap.LDAPMessage, op []byte) (*ldap.LDAPMessage, error) {
+	data, err := m.Write()
+	if err != nil {
+		return nil, err
+	}
+	// LDAPMessage ::= SEQUENCE { messageID, protocolOp, controls }
+	msg, err := berParseAll(data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := msg.children()
+	if err != nil || len(fields) < 2 {
+		return nil, errors.New("ber: malformed LDAPMessage")
+	}
+	if fields[1], err = berParseAll(op); err != nil {
+		return nil, err
+	}
+	out, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, msg.with(fields).encode()))
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// saslCredentials returns the SASL mechanism and credentials of a bind
+// request, ok is false for other authentication choices.
+func saslCredentials(r ldap.BindRequest) (mechanism string, credentials []byte, ok bool) {
+	if r.AuthenticationChoice() != "sasl" {
+		return "", nil, false
+	}
+	data, err := protocolOpBytes(r)
+	if err != nil {
+		return "", nil, false
+	}
+	// BindRequest ::= [APPLICATION 0] SEQUENCE { version, name, authentication }
+	op, err := berParseAll(data)
+	if err != nil {
+		return "", nil, false
+	}
+	fields, err := op.children()
+	if err != nil || len(fields) != 3 {
+		return "", nil, false
+	}
+	// SaslCredentials ::= [3] SEQUENCE { mechanism, credentials OPTIONAL }
+	sasl, err := fields[2].children()
+	if err != nil || len(sasl) == 0 {
+		return "", nil, false
+	}
+	mechanism = string(sasl[0].value)
+	if len(sasl) > 1 {
+		credentials = sasl[1].value
+	}
+	return mechanism, credentials, true
+}
+
+// ResultCode returns the result code of a response built on LDAPResult,
+// ok is false for other protocol ops; goldap has no getter for it.
+func ResultCode(po ldap.ProtocolOp) (code int, ok bool) {
+	data, err := protocolOpBytes(po)
+	if err != nil {
+		return 0, false
+	}
+	// LDAPResult ::= SEQUENCE { resultCode ENUMERATED, matchedDN, ... }
+	op, err := berParseAll(data)
+	if err != nil || !op.constructed {
+		return 0, false
+	}
+	fields, err := op.children()
+	if err != nil || len(fields) < 3 || !fields[0].is(berClassUniversal, berTagEnumerated) {
+		return 0, false
+	}
+	v, err := fields[0].int()
+	if err != nil {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// decodeProtocolOp turns the encoding of a protocol op into the matching
+// goldap type, for the fields goldap has no setter for.
+func decodeProtocolOp(op []byte) (ldap.ProtocolOp, error) {
+	data := berSequence(berInteger(0), op)
+	m, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, data))
+	if err != nil {
+		return nil, err
+	}
+	return m.ProtocolOp(), nil
+}
+
+// decodeSearchResultEntry returns the DN and attributes of an entry,
+// goldap has no getters for them.
+func decodeSearchResultEntry(e ldap.SearchResultEntry) (dn string, attributes []EntryAttribute, err error) {
+	data, err := protocolOpBytes(e)
+	if err != nil {
+		return "", nil, err
+	}
+	// SearchResultEntry ::= [APPLICATION 4] SEQUENCE { objectName,
+	//     attributes PartialAttributeList }
+	op, err := berParseAll(data)
+	if err != nil {
+		return "", nil, err
+	}
+	fields, err := op.children()
+	if err != nil || len(fields) != 2 {
+		return "", nil, errors.New("ber: malformed SearchResultEntry")
+	}
+	list, err := fields[1].children()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, pa := range list {
+		// PartialAttribute ::= SEQUENCE { type, vals SET OF value }
+		parts, err := pa.children()
+		if err != nil || len(parts) != 2 {
+			return "", nil, errors.New("ber: malformed PartialAttribute")
+		}
+		vals, err := parts[1].children()
+		if err != nil {
+			return "", nil, err
+		}
+		a := EntryAttribute{Name: string(parts[0].value)}
+		for _, v := range vals {
+			a.Values = append(a.Values, string(v.value))
+		}
+		attributes = append(attributes, a)
+	}
+	return string(fields[0].value), attributes, nil
+}
+
+func decodeModifyDNRequest(r ldap.ModifyDNRequest) (ModifyDNRequest, error) {
+	data, err := protocolOpBytes(r)
+	if err != nil {
+		return ModifyDNRequest{}, err
+	}
+	// ModifyDNRequest ::= [APPLICATION 12] SEQUENCE { entry, newrdn,
+	//     deleteoldrdn, newSuperior [0] OPTIONAL }
+	op, err := berParseAll(data)
+	if err != nil {
+		return ModifyDNRequest{}, err
+	}
+	fields, err := op.children()
+	if err != nil || len(fields) < 3 || len(fields) > 4 {
+		return ModifyDNRequest{}, errors.New("ber: malformed ModifyDNRequest")
+	}
+	req := ModifyDNRequest{
+		Entry:        string(fields[0].value),
+		NewRDN:       string(fields[1].value),
+		DeleteOldRDN: fields[2].bool(),
+	}
+	if len(fields) == 4 {
+		superior := string(fields[3].value)
+		req.NewSuperior = &superior
+	}
+	return req, nil
+}
@@ -0,0 +1,254 @@
+package ldapserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// BindThrottle slows down and locks out repeated failed binds from the
+// same client IP address or for the same DN. Set Server.BindThrottle to
+// enable it. A bind answered with invalidCredentials counts as a failure;
+// a successful bind clears the failures of its DN.
+type BindThrottle struct {
+	// MaxFailures is the number of failures within Window after which
+	// the IP address or DN is locked out, 5 if zero.
+	MaxFailures int
+
+	// Window is the period over which failures are counted, 15 minutes
+	// if zero.
+	Window time.Duration
+
+	// Lockout is how long binds are rejected once MaxFailures is
+	// reached, Window if zero.
+	Lockout time.Duration
+
+	// Delay, if positive, tarpits the binds following a failure: the
+	// bind waits Delay, doubled for each further failure, up to
+	// MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+
+	// OnEvent, if set, is called for failures, lockouts and rejected
+	// binds, e.g. to feed an intrusion detection system.
+	OnEvent func(BindThrottleEvent)
+
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+// BindThrottleEventKind is the kind of a BindThrottleEvent.
+type BindThrottleEventKind int
+
+const (
+	BindFailed   BindThrottleEventKind = iota // a bind failed
+	BindLocked                                // a key reached MaxFailures
+	BindRejected                              // a bind was refused during a lockout
+)
+
+// BindThrottleEvent reports the activity of a BindThrottle.
+type BindThrottleEvent struct {
+	Kind     BindThrottleEventKind
+	IP       string
+	DN       string
+	Failures int       // failures of the IP address or DN in the window
+	Until    time.Time // end of the lockout, for BindLocked and BindRejected
+}
+
+type throttleEntry struct {
+	failures    int
+	first       time.Time // start of the counting window
+	lockedUntil time.Time
+}
+
+// throttlePruneSize is the number of tracked keys above which expired
+// entries are dropped.
+const throttlePruneSize = 4096
+
+func (t *BindThrottle) maxFailures() int {
+	if t.MaxFailures <= 0 {
+		return 5
+	}
+	return t.MaxFailures
+}
+
+func (t *BindThrottle) window() time.Duration {
+	if t.Window <= 0 {
+		return 15 * time.Minute
+	}
+	return t.Window
+}
+
+func (t *BindThrottle) lockout() time.Duration {
+	if t.Lockout <= 0 {
+		return t.window()
+	}
+	return t.Lockout
+}
+
+func throttleKeys(ip, dn string) []string {
+	keys := []string{"ip:" + ip}
+	if dn != "" {
+		keys = append(keys, "dn:"+NormalizeDN(dn))
+	}
+	return keys
+}
+
+// Reset clears the failures and lockout of an IP address and of a DN,
+// either may be empty.
+func (t *BindThrottle) Reset(ip, dn string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ip != "" {
+		delete(t.entries, "ip:"+ip)
+	}
+	if dn != "" {
+		delete(t.entries, "dn:"+NormalizeDN(dn))
+	}
+}
+
+// check returns the delay to impose on a bind, or the end of the lockout
+// when the bind must be rejected.
+func (t *BindThrottle) check(ip, dn string) (delay time.Duration, lockedUntil time.Time) {
+	now := time.Now()
+	failures := 0
+
+	t.mu.Lock()
+	for _, key := range throttleKeys(ip, dn) {
+		e := t.entries[key]
+		if e == nil {
+			continue
+		}
+		if e.lockedUntil.After(lockedUntil) {
+			lockedUntil = e.lockedUntil
+		}
+		if now.Sub(e.first) < t.window() && e.failures > failures {
+			failures = e.failures
+		}
+	}
+	t.mu.Unlock()
+
+	if lockedUntil.After(now) {
+		t.emit(BindThrottleEvent{Kind: BindRejected, IP: ip, DN: dn, Failures: failures, Until: lockedUntil})
+		return 0, lockedUntil
+	}
+	if t.Delay <= 0 || failures == 0 {
+		return 0, time.Time{}
+	}
+	delay = t.Delay
+	for i := 1; i < failures && (t.MaxDelay <= 0 || delay < t.MaxDelay); i++ {
+		delay *= 2
+	}
+	if t.MaxDelay > 0 && delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay, time.Time{}
+}
+
+// failed records a failed bind.
+func (t *BindThrottle) failed(ip, dn string) {
+	now := time.Now()
+	var events []BindThrottleEvent
+
+	t.mu.Lock()
+	if t.entries == nil {
+		t.entries = make(map[string]*throttleEntry)
+	}
+	if len(t.entries) > throttlePruneSize {
+		t.pruneLocked(now)
+	}
+	failures := 0
+	for _, key := range throttleKeys(ip, dn) {
+		e := t.entries[key]
+		if e == nil || now.Sub(e.first) >= t.window() {
+			e = &throttleEntry{first: now}
+			t.entries[key] = e
+		}
+		e.failures++
+		failures = max(failures, e.failures)
+		if e.failures >= t.maxFailures() {
+			e.lockedUntil = now.Add(t.lockout())
+			events = append(events, BindThrottleEvent{Kind: BindLocked, IP: ip, DN: dn, Failures: e.failures, Until: e.lockedUntil})
+		}
+	}
+	t.mu.Unlock()
+
+	t.emit(BindThrottleEvent{Kind: BindFailed, IP: ip, DN: dn, Failures: failures})
+	for _, e := range events {
+		t.emit(e)
+	}
+}
+
+// succeeded clears the failures of the DN of a successful bind.
+func (t *BindThrottle) succeeded(dn string) {
+	if dn != "" {
+		t.Reset("", dn)
+	}
+}
+
+func (t *BindThrottle) pruneLocked(now time.Time) {
+	for key, e := range t.entries {
+		if now.Sub(e.first) >= t.window() && now.After(e.lockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (t *BindThrottle) emit(e BindThrottleEvent) {
+	if t.OnEvent != nil {
+		t.OnEvent(e)
+	}
+}
+
+// throttleBind applies Server.BindThrottle to a bind request, waiting out
+// the tarpit delay. It returns true when the request was answered.
+func (c *client) throttleBind(ctx context.Context, w ResponseWriter, r ldap.BindRequest) bool {
+	t := c.srv.BindThrottle
+	if t == nil {
+		return false
+	}
+	delay, lockedUntil := t.check(remoteIP(c.transport()), string(r.Name()))
+	if !lockedUntil.IsZero() {
+		c.srv.logf("client %d bind rejected until %s: too many failures", c.Numero, lockedUntil.Format(time.RFC3339))
+		c.Lock()
+		c.bindRejected = true // not a failure of its own
+		c.Unlock()
+		res := NewBindResponse(LDAPResultInvalidCredentials)
+		res.SetDiagnosticMessage("too many failed binds")
+		w.Write(res)
+		return true
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return true // abandoned
+		}
+	}
+	return false
+}
+
+// observeThrottle records the result of a bind in Server.BindThrottle.
+func (c *client) observeThrottle(r ldap.BindRequest, code int) {
+	t := c.srv.BindThrottle
+	if t == nil {
+		return
+	}
+	c.Lock()
+	rejected := c.bindRejected
+	c.bindRejected = false
+	c.Unlock()
+	if rejected {
+		return
+	}
+	switch code {
+	case LDAPResultInvalidCredentials:
+		t.failed(remoteIP(c.transport()), string(r.Name()))
+	case LDAPResultSuccess:
+		t.succeeded(string(r.Name()))
+	}
+}
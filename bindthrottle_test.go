@@ -0,0 +1,44 @@
+package ldapserver
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBindThrottleRelock checks that a failure following a lockout which
+// expired within the window locks the key out again.
+func TestBindThrottleRelock(t *testing.T) {
+	th := &BindThrottle{MaxFailures: 2, Window: time.Hour, Lockout: 50 * time.Millisecond}
+	th.failed("192.0.2.1", "")
+	if _, until := th.check("192.0.2.1", ""); !until.IsZero() {
+		t.Fatal("locked out after one failure")
+	}
+	th.failed("192.0.2.1", "")
+	if _, until := th.check("192.0.2.1", ""); until.IsZero() {
+		t.Fatal("not locked out after MaxFailures failures")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, until := th.check("192.0.2.1", ""); !until.IsZero() {
+		t.Fatal("still locked out after the lockout expired")
+	}
+	th.failed("192.0.2.1", "")
+	if _, until := th.check("192.0.2.1", ""); until.IsZero() {
+		t.Fatal("not locked out again after a failure following the lockout")
+	}
+}
+
+// TestBindThrottleNormalizesDN checks that the spellings of a DN share
+// their failures.
+func TestBindThrottleNormalizesDN(t *testing.T) {
+	th := &BindThrottle{MaxFailures: 2, Window: time.Hour, Lockout: time.Hour}
+	th.failed("192.0.2.1", "uid=bob,ou=people")
+	th.failed("192.0.2.2", "UID=Bob, ou=People")
+	if _, until := th.check("192.0.2.3", "uid=bob,ou=people"); until.IsZero() {
+		t.Fatal("not locked out after failures under another spelling of the DN")
+	}
+	th.Reset("", "uid=bob , ou=people")
+	if _, until := th.check("192.0.2.3", "uid=bob,ou=people"); !until.IsZero() {
+		t.Fatal("still locked out after a reset under another spelling of the DN")
+	}
+}
@@ -0,0 +1,272 @@
+package ldapserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// CertManager holds the server certificates for LDAPS and StartTLS. The
+// certificate is selected by SNI server name during each handshake, and
+// certificates loaded from files can be reloaded atomically without
+// dropping established connections.
+//
+// Set Server.CertManager to use it, or plug GetCertificate into your own
+// tls.Config.
+type CertManager struct {
+	// GetCertificateFunc, if set, is called when no loaded certificate
+	// matches the requested server name, e.g. to fetch one from Vault.
+	GetCertificateFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	// ErrorLog, if set, receives reload errors from Watch and
+	// ReloadOnSignal.
+	ErrorLog func(error)
+
+	mu      sync.Mutex // serializes modifications of sources
+	sources []*certSource
+	set     atomic.Pointer[certSet]
+}
+
+type certSource struct {
+	certFile, keyFile string
+	modTime           time.Time
+	cert              *tls.Certificate
+}
+
+type certSet struct {
+	byName map[string]*tls.Certificate
+	def    *tls.Certificate
+}
+
+// NewCertManager returns an empty certificate manager.
+func NewCertManager() *CertManager {
+	return &CertManager{}
+}
+
+// AddCertificate adds a certificate served for the DNS names and common
+// name of its leaf. The first certificate added is the default one.
+func (m *CertManager) AddCertificate(cert tls.Certificate) error {
+	if err := parseLeaf(&cert); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources = append(m.sources, &certSource{cert: &cert})
+	m.publish()
+	return nil
+}
+
+// AddKeyPairFiles loads a certificate from PEM encoded files. The files are
+// read again by Reload.
+func (m *CertManager) AddKeyPairFiles(certFile, keyFile string) error {
+	src := &certSource{certFile: certFile, keyFile: keyFile}
+	if err := src.load(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources = append(m.sources, src)
+	m.publish()
+	return nil
+}
+
+// Reload reads all certificate files again and swaps the new set in
+// atomically. If any file fails to load, the current set is kept.
+func (m *CertManager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reload(false)
+}
+
+func (m *CertManager) reload(onlyChanged bool) error {
+	fresh := make([]*certSource, len(m.sources))
+	changed := false
+	for i, src := range m.sources {
+		fresh[i] = src
+		if src.certFile == "" {
+			continue
+		}
+		if onlyChanged && !src.changed() {
+			continue
+		}
+		s := &certSource{certFile: src.certFile, keyFile: src.keyFile}
+		if err := s.load(); err != nil {
+			return err
+		}
+		fresh[i] = s
+		changed = true
+	}
+	if changed || !onlyChanged {
+		m.sources = fresh
+		m.publish()
+	}
+	return nil
+}
+
+// publish builds the name index from the sources. m.mu must be held.
+func (m *CertManager) publish() {
+	set := &certSet{byName: make(map[string]*tls.Certificate)}
+	for _, src := range m.sources {
+		if set.def == nil {
+			set.def = src.cert
+		}
+		leaf := src.cert.Leaf
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := set.byName[name]; !ok {
+				set.byName[name] = src.cert
+			}
+		}
+	}
+	m.set.Store(set)
+}
+
+// GetCertificate selects the certificate for a handshake. It is meant to
+// be used as tls.Config.GetCertificate.
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := m.set.Load()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	if set != nil && name != "" {
+		if cert, ok := set.byName[name]; ok {
+			return cert, nil
+		}
+		// wildcard certificates cover a single label
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if cert, ok := set.byName["*"+name[i:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+	if m.GetCertificateFunc != nil {
+		cert, err := m.GetCertificateFunc(hello)
+		if cert != nil || err != nil {
+			return cert, err
+		}
+	}
+	if set != nil && set.def != nil {
+		return set.def, nil
+	}
+	return nil, errors.New("ldapserver: no certificate available")
+}
+
+// TLSConfig returns a copy of base (or a new config if base is nil) which
+// selects certificates with m.
+func (m *CertManager) TLSConfig(base *tls.Config) *tls.Config {
+	var cfg *tls.Config
+	if base == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = base.Clone()
+	}
+	cfg.Certificates = nil
+	cfg.GetCertificate = m.GetCertificate
+	return cfg
+}
+
+// ReloadOnSignal reloads the certificates each time one of sigs is
+// received, SIGHUP if none is given, until ctx is done.
+func (m *CertManager) ReloadOnSignal(ctx context.Context, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				if err := m.Reload(); err != nil {
+					m.logError(err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Watch polls the certificate files every interval and reloads the ones
+// whose modification time changed, until ctx is done. This picks up files
+// rotated by cert-manager, Vault agent and the like.
+func (m *CertManager) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				m.mu.Lock()
+				err := m.reload(true)
+				m.mu.Unlock()
+				if err != nil {
+					m.logError(err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *CertManager) logError(err error) {
+	if m.ErrorLog != nil {
+		m.ErrorLog(err)
+	}
+}
+
+func (src *certSource) load() error {
+	cert, err := tls.LoadX509KeyPair(src.certFile, src.keyFile)
+	if err != nil {
+		return fmt.Errorf("ldapserver: loading %s: %w", src.certFile, err)
+	}
+	if err := parseLeaf(&cert); err != nil {
+		return err
+	}
+	src.cert = &cert
+	src.modTime = src.stat()
+	return nil
+}
+
+func (src *certSource) stat() time.Time {
+	var t time.Time
+	for _, name := range []string{src.certFile, src.keyFile} {
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+func (src *certSource) changed() bool {
+	return !src.stat().Equal(src.modTime)
+}
+
+func parseLeaf(cert *tls.Certificate) error {
+	if cert.Leaf != nil {
+		return nil
+	}
+	if len(cert.Certificate) == 0 {
+		return errors.New("ldapserver: empty certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	return nil
+}
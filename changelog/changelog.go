@@ -0,0 +1,333 @@
+// Package changelog records the write operations of a directory in a
+// changelog (draft-good-ldap-changelog): a Backend decorator numbering the
+// adds, deletes, modifies and modify DNs made through it, served as the
+// entries of the cn=changelog naming context, and as the SyncSource and
+// DirSyncSource of the replication providers:
+//
+//	log := &changelog.Changelog{Backend: dir.Backend()}
+//	routes := ldap.BackendMux(log)
+//	sync := &ldap.SyncProvider{Source: log, ServerID: 1}
+//	dirsync := &ldap.DirSyncProvider{Source: log}
+//	server.HandleConnection = func(net.Conn) ldap.Handler {
+//		return sync.Handler(dirsync.Handler(routes))
+//	}
+//
+// The changes are kept in memory, up to MaxChanges.
+package changelog
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/ldif"
+)
+
+// DefaultBase is the DN of the changelog when Changelog.Base is empty.
+const DefaultBase = "cn=changelog"
+
+// DefaultMaxChanges is the number of changes kept when
+// Changelog.MaxChanges is zero.
+const DefaultMaxChanges = 10000
+
+// Change is a write operation recorded in the changelog.
+type Change struct {
+	Number int64 // changeNumber, from 1
+	CSN    ldap.CSN
+	Time   time.Time
+
+	// Record is the change: its DN, ChangeType and the fields of the
+	// type.
+	Record *ldif.Record
+
+	// Entry is the entry after the change, at its new DN for a modify DN,
+	// nil for a delete.
+	Entry *ldap.Entry
+
+	// UUID is the entryUUID of the entry before the change, or a UUID
+	// derived from its DN when it has none.
+	UUID []byte
+}
+
+// Changelog is a Backend decorator recording the changes made through it,
+// and serving them as the entries changeNumber=N below Base. It is safe
+// for concurrent use, its writes being serialized so that the changes are
+// numbered in the order of the backend.
+type Changelog struct {
+	ldap.Backend
+
+	// Base is the DN of the changelog, DefaultBase if empty. The backend
+	// is not searched below it.
+	Base string
+
+	// MaxChanges is the number of changes kept, the oldest ones being
+	// dropped; DefaultMaxChanges if zero.
+	MaxChanges int
+
+	// ServerID is the server ID of the CSNs of the changes.
+	ServerID int
+
+	writes   sync.Mutex // serializes the writes and their recording
+	mu       sync.Mutex
+	changes  []*Change // oldest first
+	last     int64     // number of the last change
+	dropped  ldap.CSN  // CSN of the last dropped change
+	csnTime  time.Time
+	csnCount int
+	watchers map[*watcher]bool
+}
+
+// ChangesAfter returns the changes kept after the change number since,
+// oldest first.
+func (l *Changelog) ChangesAfter(since int64) []*Change {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var changes []*Change
+	for _, c := range l.changes {
+		if c.Number > since {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// LastChangeNumber returns the number of the last change, 0 when there is
+// none, e.g. for the lastChangeNumber attribute of the root DSE.
+func (l *Changelog) LastChangeNumber() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+func (l *Changelog) Add(ctx context.Context, e *ldap.Entry) error {
+	if l.inChangelog(e.DN) {
+		return errReadOnly
+	}
+	l.writes.Lock()
+	defer l.writes.Unlock()
+	if err := l.Backend.Add(ctx, e); err != nil {
+		return err
+	}
+	stored := l.entry(ctx, e.DN)
+	if stored == nil {
+		stored = e.Clone()
+	}
+	l.record(&ldif.Record{DN: e.DN, ChangeType: ldif.ChangeAdd, Attributes: stored.Attributes}, stored, uuid(stored, e.DN))
+	return nil
+}
+
+func (l *Changelog) Delete(ctx context.Context, dn string) error {
+	if l.inChangelog(dn) {
+		return errReadOnly
+	}
+	l.writes.Lock()
+	defer l.writes.Unlock()
+	old := l.entry(ctx, dn)
+	if err := l.Backend.Delete(ctx, dn); err != nil {
+		return err
+	}
+	l.record(&ldif.Record{DN: dn, ChangeType: ldif.ChangeDelete}, nil, uuid(old, dn))
+	return nil
+}
+
+func (l *Changelog) Modify(ctx context.Context, dn string, changes []ldap.Modification) error {
+	if l.inChangelog(dn) {
+		return errReadOnly
+	}
+	l.writes.Lock()
+	defer l.writes.Unlock()
+	if err := l.Backend.Modify(ctx, dn, changes); err != nil {
+		return err
+	}
+	rec := &ldif.Record{DN: dn, ChangeType: ldif.ChangeModify}
+	for _, c := range changes {
+		rec.Changes = append(rec.Changes, ldif.Change{Operation: c.Operation, Attribute: c.Attribute, Values: c.Values})
+	}
+	e := l.entry(ctx, dn)
+	l.record(rec, e, uuid(e, dn))
+	return nil
+}
+
+func (l *Changelog) ModifyDN(ctx context.Context, r ldap.ModifyDNRequest) error {
+	if l.inChangelog(r.Entry) {
+		return errReadOnly
+	}
+	l.writes.Lock()
+	defer l.writes.Unlock()
+	old := l.entry(ctx, r.Entry)
+	if err := l.Backend.ModifyDN(ctx, r); err != nil {
+		return err
+	}
+	rec := &ldif.Record{DN: r.Entry, ChangeType: ldif.ChangeModRDN, NewRDN: r.NewRDN, DeleteOldRDN: r.DeleteOldRDN, NewSuperior: r.NewSuperior}
+	l.record(rec, l.entry(ctx, r.NewDN()), uuid(old, r.Entry))
+	return nil
+}
+
+// Search serves the searches below Base from the changelog, and the other
+// ones from the backend.
+func (l *Changelog) Search(ctx context.Context, r goldap.SearchRequest, f func(e *ldap.Entry) error) error {
+	base := string(r.BaseObject())
+	if !l.inChangelog(base) {
+		return l.Backend.Search(ctx, r, f)
+	}
+	filter, err := ldap.NewSearchFilter(r)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, e := range l.entries() {
+		found = found || ldap.NormalizeDN(e.DN) == ldap.NormalizeDN(base)
+		if !ldap.InScope(e.DN, base, int(r.Scope())) {
+			continue
+		}
+		ok, err := filter.Match(*e)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := f(e); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return &ldap.Error{ResultCode: ldap.LDAPResultNoSuchObject, MatchedDN: l.base(), DiagnosticMessage: "no such entry: " + base}
+	}
+	return nil
+}
+
+// Compare compares the values of the changelog entries below Base, and of
+// the backend entries otherwise.
+func (l *Changelog) Compare(ctx context.Context, dn, attribute, value string) (bool, error) {
+	if !l.inChangelog(dn) {
+		return l.Backend.Compare(ctx, dn, attribute, value)
+	}
+	for _, e := range l.entries() {
+		if ldap.NormalizeDN(e.DN) != ldap.NormalizeDN(dn) {
+			continue
+		}
+		return ldap.CompareEntry(e, attribute, value, nil)
+	}
+	return false, &ldap.Error{ResultCode: ldap.LDAPResultNoSuchObject, MatchedDN: l.base(), DiagnosticMessage: "no such entry: " + dn}
+}
+
+// errReadOnly is the error of the updates of the changelog entries.
+var errReadOnly = ldap.NewError(ldap.LDAPResultUnwillingToPerform, "the changelog is read-only")
+
+// record adds the change rec, e being the entry after it and id its UUID
+// before it, and notifies the watchers.
+func (l *Changelog) record(rec *ldif.Record, e *ldap.Entry, id []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if now.After(l.csnTime) {
+		l.csnTime, l.csnCount = now, 0
+	} else {
+		l.csnCount++
+	}
+	l.last++
+	c := &Change{Number: l.last, CSN: ldap.NewCSN(l.csnTime, l.csnCount, l.ServerID), Time: now, Record: rec, Entry: e, UUID: id}
+	l.changes = append(l.changes, c)
+	limit := l.MaxChanges
+	if limit <= 0 {
+		limit = DefaultMaxChanges
+	}
+	if n := len(l.changes) - limit; n > 0 {
+		l.dropped = l.changes[n-1].CSN
+		l.changes = append(l.changes[:0:0], l.changes[n:]...)
+	}
+	l.notify(c)
+}
+
+// entry returns the entry dn of the backend, nil if it can not be read.
+func (l *Changelog) entry(ctx context.Context, dn string) *ldap.Entry {
+	r, err := ldap.NewSearchRequest(dn, ldap.SearchRequestScopeBaseObject, "(objectClass=*)")
+	if err != nil {
+		return nil
+	}
+	var entry *ldap.Entry
+	l.Backend.Search(ctx, r, func(e *ldap.Entry) error {
+		entry = e
+		return nil
+	})
+	return entry
+}
+
+func (l *Changelog) base() string {
+	if l.Base == "" {
+		return DefaultBase
+	}
+	return l.Base
+}
+
+// inChangelog reports whether dn is the changelog or one of its entries.
+func (l *Changelog) inChangelog(dn string) bool {
+	return ldap.InScope(dn, l.base(), ldap.SearchRequestHomeSubtree)
+}
+
+// entries returns the changelog entry and the entries of the changes.
+func (l *Changelog) entries() []*ldap.Entry {
+	base := l.base()
+	root := &ldap.Entry{DN: base}
+	root.Add("objectClass", "top", "nsContainer")
+	if dn, err := ldap.ParseDN(base); err == nil && len(dn) > 0 {
+		for _, ava := range dn[0] {
+			root.Add(ava.Type, ava.Value)
+		}
+	}
+	entries := []*ldap.Entry{root}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.changes {
+		entries = append(entries, c.entry(base))
+	}
+	return entries
+}
+
+// entry returns the changelog entry of the change.
+func (c *Change) entry(base string) *ldap.Entry {
+	number := strconv.FormatInt(c.Number, 10)
+	e := &ldap.Entry{DN: "changeNumber=" + number + "," + base}
+	e.Add("objectClass", "top", "changeLogEntry")
+	e.Add("changeNumber", number)
+	e.Add("targetDN", c.Record.DN)
+	e.Add("changeType", c.Record.ChangeType)
+	e.Add("changeTime", c.Time.Format(ldap.GeneralizedTimeFormat))
+	switch c.Record.ChangeType {
+	case ldif.ChangeAdd, ldif.ChangeModify:
+		if changes, err := ldif.Changes(c.Record); err == nil {
+			e.Add("changes", changes)
+		}
+	case ldif.ChangeModRDN:
+		e.Add("newRDN", c.Record.NewRDN)
+		e.Add("deleteOldRDN", strings.ToUpper(strconv.FormatBool(c.Record.DeleteOldRDN)))
+		if c.Record.NewSuperior != nil {
+			e.Add("newSuperior", *c.Record.NewSuperior)
+		}
+	}
+	return e
+}
+
+// uuid returns the entryUUID of the entry e, or a version 5 UUID of the
+// SHA-1 hash of the normalized dn when e is nil or has none.
+func uuid(e *ldap.Entry, dn string) []byte {
+	if e != nil {
+		if values := e.Get("entryUUID"); len(values) > 0 {
+			if id, err := hex.DecodeString(strings.ReplaceAll(values[0], "-", "")); err == nil && len(id) == 16 {
+				return id
+			}
+		}
+	}
+	sum := sha1.Sum([]byte(ldap.NormalizeDN(dn)))
+	id := sum[:16]
+	id[6] = id[6]&0x0f | 0x50 // version 5
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return id
+}
@@ -0,0 +1,262 @@
+package changelog
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/ldif"
+)
+
+// watchBuffer is the number of changes buffered for a watcher, which is
+// dropped when it falls further behind.
+const watchBuffer = 256
+
+// watcher is a refreshAndPersist search watching the changes.
+type watcher struct {
+	r  goldap.SearchRequest
+	ch chan ldap.SyncChange
+}
+
+// update is a change of an entry in the content of a search.
+type update struct {
+	state  ldap.SyncState
+	uuid   []byte
+	dn     string
+	entry  *ldap.Entry // nil for SyncDelete
+	number int64
+	csn    ldap.CSN
+}
+
+// Changes returns the changes after since of the entries of the search m,
+// the last change of each entry only, implementing ldap.SyncSource. The
+// whole content is returned when since is "" or older than the changes
+// kept.
+func (l *Changelog) Changes(ctx context.Context, m *ldap.Message, since ldap.CSN) (changes []ldap.SyncChange, full bool, err error) {
+	r := m.GetSearchRequest()
+	l.mu.Lock()
+	full = since == "" || since < l.dropped
+	var updates []update
+	if !full {
+		for _, c := range l.changes {
+			if c.CSN > since {
+				updates = append(updates, c.updates(r)...)
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if full {
+		var csn ldap.CSN
+		updates, _, csn, err = l.content(ctx, r)
+		for i := range updates {
+			updates[i].csn = csn
+		}
+	}
+	for _, u := range collapse(updates) {
+		changes = append(changes, u.syncChange(r))
+	}
+	return changes, full, err
+}
+
+// Watch sends the changes after since of the entries of the search m until
+// ctx is done, implementing ldap.SyncSource. The channel is closed when
+// the consumer falls too far behind, and resumes from its cookie.
+func (l *Changelog) Watch(ctx context.Context, m *ldap.Message, since ldap.CSN) (<-chan ldap.SyncChange, error) {
+	r := m.GetSearchRequest()
+	l.mu.Lock()
+	var backlog []update
+	for _, c := range l.changes {
+		if c.CSN > since {
+			backlog = append(backlog, c.updates(r)...)
+		}
+	}
+	w := &watcher{r: r, ch: make(chan ldap.SyncChange, len(backlog)+watchBuffer)}
+	for _, u := range backlog {
+		w.ch <- u.syncChange(r)
+	}
+	if l.watchers == nil {
+		l.watchers = make(map[*watcher]bool)
+	}
+	l.watchers[w] = true
+	l.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		l.mu.Lock()
+		if l.watchers[w] {
+			delete(l.watchers, w)
+			close(w.ch)
+		}
+		l.mu.Unlock()
+	}()
+	return w.ch, nil
+}
+
+// notify sends the change c to the watchers. l.mu must be held.
+func (l *Changelog) notify(c *Change) {
+	for w := range l.watchers {
+		for _, u := range c.updates(w.r) {
+			select {
+			case w.ch <- u.syncChange(w.r):
+			default:
+				delete(l.watchers, w)
+				close(w.ch)
+			}
+			if !l.watchers[w] {
+				break
+			}
+		}
+	}
+}
+
+// DirSyncChanges returns the entries of the search m changed after the
+// change number of state, implementing ldap.DirSyncSource. The whole
+// content is returned in a single response for an empty state, or one
+// older than the changes kept. Deleted entries have the attribute
+// isDeleted set to TRUE, and the values of the attributes are always
+// complete.
+func (l *Changelog) DirSyncChanges(ctx context.Context, m *ldap.Message, state []byte, flags, maxBytes int) (entries []goldap.SearchResultEntry, next []byte, more bool, err error) {
+	r := m.GetSearchRequest()
+	since := int64(-1)
+	if len(state) == 8 {
+		since = int64(binary.BigEndian.Uint64(state))
+	}
+	l.mu.Lock()
+	full := since < 0 || (l.dropped != "" && len(l.changes) > 0 && l.changes[0].Number > since+1)
+	var updates []update
+	last := l.last
+	if !full {
+		for _, c := range l.changes {
+			if c.Number > since {
+				updates = append(updates, c.updates(r)...)
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if full {
+		if updates, last, _, err = l.content(ctx, r); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	size := 0
+	for _, u := range collapse(updates) {
+		if !full && size > 0 && size+u.size() > maxBytes {
+			return entries, changeNumber(since), true, nil
+		}
+		size += u.size()
+		if u.entry == nil {
+			e := ldap.NewSearchResultEntry(u.dn)
+			e.AddAttribute("isDeleted", "TRUE")
+			entries = append(entries, e)
+		} else {
+			entries = append(entries, ldap.SelectAttributes(u.entry, r.Attributes(), bool(r.TypesOnly())))
+		}
+		since = u.number
+	}
+	return entries, changeNumber(last), false, nil
+}
+
+// content returns the entries of the search r as SyncAdd updates, with the
+// number and CSN of the last change, the writes being blocked meanwhile.
+func (l *Changelog) content(ctx context.Context, r goldap.SearchRequest) (updates []update, last int64, csn ldap.CSN, err error) {
+	l.writes.Lock()
+	defer l.writes.Unlock()
+	err = l.Search(ctx, r, func(e *ldap.Entry) error {
+		updates = append(updates, update{state: ldap.SyncAdd, uuid: uuid(e, e.DN), dn: e.DN, entry: e})
+		return nil
+	})
+	l.mu.Lock()
+	last = l.last
+	if len(l.changes) > 0 {
+		csn = l.changes[len(l.changes)-1].CSN
+	}
+	l.mu.Unlock()
+	return updates, last, csn, err
+}
+
+// updates returns the updates of the entries of the search r made by the
+// change: the entries leaving the content of the search are deleted, and
+// an entry renamed without entryUUID is deleted and added again, its UUID
+// being derived from its DN.
+func (c *Change) updates(r goldap.SearchRequest) []update {
+	base, scope := string(r.BaseObject()), int(r.Scope())
+	filter, _ := ldap.NewSearchFilter(r)
+	var updates []update
+	remove := func(id []byte, dn string) {
+		if ldap.InScope(dn, base, scope) {
+			updates = append(updates, update{state: ldap.SyncDelete, uuid: id, dn: dn, number: c.Number, csn: c.CSN})
+		}
+	}
+	put := func(state ldap.SyncState, id []byte, e *ldap.Entry) {
+		if ok, _ := filter.Match(*e); ok && ldap.InScope(e.DN, base, scope) {
+			updates = append(updates, update{state: state, uuid: id, dn: e.DN, entry: e, number: c.Number, csn: c.CSN})
+		} else if state == ldap.SyncModify {
+			remove(id, e.DN)
+		}
+	}
+	switch {
+	case c.Entry == nil:
+		remove(c.UUID, c.Record.DN)
+	case c.Record.ChangeType == ldif.ChangeAdd:
+		put(ldap.SyncAdd, c.UUID, c.Entry)
+	case c.Record.ChangeType == ldif.ChangeModify:
+		put(ldap.SyncModify, c.UUID, c.Entry)
+	default:
+		id := uuid(c.Entry, c.Entry.DN)
+		if bytes.Equal(id, c.UUID) {
+			put(ldap.SyncModify, id, c.Entry)
+		} else {
+			remove(c.UUID, c.Record.DN)
+			put(ldap.SyncAdd, id, c.Entry)
+		}
+	}
+	return updates
+}
+
+// collapse returns the last update of each entry, in the order of the last
+// updates.
+func collapse(updates []update) []update {
+	last := make(map[string]int, len(updates))
+	for i, u := range updates {
+		last[string(u.uuid)] = i
+	}
+	collapsed := updates[:0:0]
+	for i, u := range updates {
+		if last[string(u.uuid)] == i {
+			collapsed = append(collapsed, u)
+		}
+	}
+	return collapsed
+}
+
+func (u update) syncChange(r goldap.SearchRequest) ldap.SyncChange {
+	change := ldap.SyncChange{State: u.state, UUID: u.uuid, DN: u.dn, CSN: u.csn}
+	if u.entry != nil {
+		change.Entry = ldap.SelectAttributes(u.entry, r.Attributes(), bool(r.TypesOnly()))
+	}
+	return change
+}
+
+// size returns the approximate encoded size of the entry of the update.
+func (u update) size() int {
+	n := len(u.dn)
+	if u.entry != nil {
+		for _, a := range u.entry.Attributes {
+			n += len(a.Name)
+			for _, v := range a.Values {
+				n += len(v)
+			}
+		}
+	}
+	return n
+}
+
+// changeNumber returns the DirSync state of the change number n.
+func changeNumber(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
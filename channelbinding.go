@@ -0,0 +1,106 @@
+package ldapserver
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Channel binding types (RFC 5929, RFC 9266), for SASL mechanisms binding
+// the authentication to the TLS session, e.g. SCRAM-PLUS or GSSAPI.
+const (
+	ChannelBindingTLSUnique         = "tls-unique"
+	ChannelBindingTLSServerEndPoint = "tls-server-end-point"
+	ChannelBindingTLSExporter       = "tls-exporter"
+)
+
+// ChannelBinding returns the channel binding data of the TLS session of
+// the connection for the type typ. tls-unique is only defined up to TLS
+// 1.2 and tls-exporter needs TLS 1.3 or the extended master secret.
+func (c *client) ChannelBinding(typ string) ([]byte, error) {
+	state, ok := c.TLSConnectionState()
+	if !ok {
+		return nil, errors.New("no TLS session")
+	}
+
+	switch typ {
+	case ChannelBindingTLSUnique:
+		if len(state.TLSUnique) == 0 {
+			return nil, errors.New("tls-unique is not available with TLS 1.3")
+		}
+		return state.TLSUnique, nil
+
+	case ChannelBindingTLSExporter:
+		return state.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+
+	case ChannelBindingTLSServerEndPoint:
+		c.Lock()
+		cfg := c.tlsConfig
+		c.Unlock()
+		cert, err := serverCertificate(cfg, state.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		return serverEndPoint(cert), nil
+	}
+	return nil, fmt.Errorf("unknown channel binding type %q", typ)
+}
+
+// serverCertificate returns the certificate the server presented in a
+// handshake with cfg, selected again from the server name.
+func serverCertificate(cfg *tls.Config, serverName string) (*x509.Certificate, error) {
+	if cfg == nil {
+		return nil, errors.New("unknown server certificate")
+	}
+
+	var cert *tls.Certificate
+	if cfg.GetCertificate != nil {
+		c, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			return nil, err
+		}
+		cert = c
+	}
+	if cert == nil {
+		for i := range cfg.Certificates {
+			leaf, err := certLeaf(&cfg.Certificates[i])
+			if err != nil {
+				continue
+			}
+			if cert == nil || serverName != "" && leaf.VerifyHostname(serverName) == nil {
+				cert = &cfg.Certificates[i]
+			}
+		}
+	}
+	if cert == nil {
+		return nil, errors.New("unknown server certificate")
+	}
+	return certLeaf(cert)
+}
+
+func certLeaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("empty certificate")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// serverEndPoint hashes the certificate with the hash of its signature,
+// SHA-256 when that is MD5 or SHA-1 (RFC 5929 section 4.1).
+func serverEndPoint(cert *x509.Certificate) []byte {
+	h := crypto.SHA256
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = crypto.SHA512
+	}
+	hash := h.New()
+	hash.Write(cert.Raw)
+	return hash.Sum(nil)
+}
@@ -0,0 +1,167 @@
+package ldapserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// maxDatagramSize is the largest UDP payload.
+const maxDatagramSize = 65535
+
+// ServeUDP answers connectionless LDAP (CLDAP) requests received on conn,
+// such as Active Directory "LDAP ping" rootDSE searches. Every datagram must
+// hold a single SearchRequest; the responses written by the handler are
+// sent back to the source address in one datagram.
+//
+// The handler comes from HandleConnection, called with a net.Conn whose
+// RemoteAddr is the datagram source. Request contexts derive from
+// context.Background() through ConnContext, BaseContext is not used.
+// ServeUDP returns when conn is closed or the server is shut down.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	if s.HandleConnection == nil {
+		return fmt.Errorf("no LDAP Request Handler defined")
+	}
+
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	s.mu.Lock()
+	s.doneChanLocked()
+	if s.packetConns == nil {
+		s.packetConns = make(map[net.PacketConn]struct{})
+	}
+	s.packetConns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.packetConns, conn)
+		s.mu.Unlock()
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		message, err := readDatagram(buf[:n])
+		if err != nil {
+			s.logf("cldap %s: %s", addr, err)
+			continue
+		}
+		if _, ok := message.ProtocolOp().(ldap.SearchRequest); !ok {
+			s.logf("cldap %s: unsupported %s", addr, message.ProtocolOpName())
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.serveDatagram(conn, addr, message)
+	}
+}
+
+func readDatagram(data []byte) (msg *ldap.LDAPMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid datagram received hex=%x, %#v", data, r)
+		}
+	}()
+	m, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, rewriteAbsoluteFilters(data)))
+	return &m, err
+}
+
+func (s *Server) serveDatagram(conn net.PacketConn, addr net.Addr, message *ldap.LDAPMessage) {
+	defer s.wg.Done()
+	receivedAt := time.Now()
+
+	dc := &datagramConn{pc: conn, addr: addr}
+	handler := s.HandleConnection(dc)
+	if handler == nil {
+		return
+	}
+
+	connCtx := s.connContext(context.Background(), dc)
+	ctx, cancel := context.WithCancel(connCtx)
+	defer cancel()
+	ctx = context.WithValue(ctx, requestInfoKey{}, RequestInfo{
+		RemoteAddr: addr,
+		MessageID:  message.MessageID().Int(),
+		ReceivedAt: receivedAt,
+	})
+
+	w := &datagramWriter{messageID: message.MessageID().Int()}
+	m := &Message{
+		LDAPMessage: message,
+		Client:      &client{srv: s, ctx: connCtx, rwc: dc},
+		ctx:         ctx,
+	}
+	func() {
+		defer s.recoverDatagram(addr, w, message.ProtocolOp())
+		handler.ServeLDAP(ctx, w, m)
+	}()
+
+	if w.buf.Len() == 0 {
+		return
+	}
+	if _, err := conn.WriteTo(w.buf.Bytes(), addr); err != nil {
+		s.logf("cldap %s: write error: %s", addr, err)
+	}
+}
+
+// datagramWriter collects the responses to a CLDAP request.
+type datagramWriter struct {
+	messageID int
+	buf       bytes.Buffer
+	responded bool // the final response was written
+}
+
+func (w *datagramWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *datagramWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	m, err := newResponseMessage(w.messageID, po, controls)
+	if err != nil {
+		return err
+	}
+	data, err := m.Write()
+	if err != nil {
+		return err
+	}
+	w.buf.Write(data.Bytes())
+	w.responded = w.responded || isFinalResponse(po)
+	return nil
+}
+
+// datagramConn presents the source of a datagram as a net.Conn to
+// HandleConnection and handlers. Reads always fail; writes are sent to the
+// source address as separate datagrams.
+type datagramConn struct {
+	pc   net.PacketConn
+	addr net.Addr
+}
+
+var errDatagramRead = errors.New("ldapserver: read on a CLDAP datagram connection")
+
+func (c *datagramConn) Read(b []byte) (int, error)         { return 0, errDatagramRead }
+func (c *datagramConn) Write(b []byte) (int, error)        { return c.pc.WriteTo(b, c.addr) }
+func (c *datagramConn) Close() error                       { return nil }
+func (c *datagramConn) LocalAddr() net.Addr                { return c.pc.LocalAddr() }
+func (c *datagramConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *datagramConn) SetDeadline(t time.Time) error      { return nil }
+func (c *datagramConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *datagramConn) SetWriteDeadline(t time.Time) error { return nil }
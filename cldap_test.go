@@ -0,0 +1,68 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// TestServeUDPPanic checks that a panicking handler of a CLDAP request is
+// answered with other, and does not stop the server.
+func TestServeUDPPanic(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		ErrorLogger: func(string) {},
+		HandleConnection: func(net.Conn) Handler {
+			return HandlerFunc(func(context.Context, ResponseWriter, *Message) { panic("boom") })
+		},
+	}
+	go s.ServeUDP(pc)
+	defer s.Close()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r, err := NewSearchRequest("", SearchRequestScopeBaseObject, "(objectClass=*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newResponseMessage(1, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Write(data.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, maxDatagramSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("request %d: %s", i, err)
+		}
+		res, err := readDatagram(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		done, ok := res.ProtocolOp().(ldap.SearchResultDone)
+		if !ok {
+			t.Fatalf("got %s, want SearchResultDone", res.ProtocolOpName())
+		}
+		if code, _ := ResultCode(done); code != LDAPResultOther {
+			t.Errorf("result %d, want other", code)
+		}
+	}
+}
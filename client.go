@@ -3,8 +3,12 @@ package ldapserver
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ldap "github.com/lor00x/goldap/message"
@@ -14,14 +18,41 @@ type client struct {
 	sync.Mutex
 	Numero        int
 	srv           *Server
+	listener      *ListenerConfig // nil for connections from Serve
+	ip            string          // connection limits accounting key
+	ctx           context.Context // base context of the requests
+	conn          net.Conn        // as accepted, reported to Server.ConnState
 	rwc           net.Conn
 	br            *bufio.Reader
 	bw            *bufio.Writer
-	chanOut       chan *ldap.LDAPMessage
+	chanOut       chan *outMessage
 	wg            sync.WaitGroup
 	closing       chan bool
 	requestCancel map[int]context.CancelFunc
 	writeDone     chan bool
+	notice        *ldap.LDAPMessage // Notice of Disconnection to send on close
+	readStopped   bool              // no more requests are read or served
+	outMu         sync.RWMutex      // guards chanOut against Notify after close
+	outClosed     bool
+	connectedAt   time.Time
+	auth          AuthState
+	sasl          *saslExchange // SASL bind in progress
+	principal     string        // Kerberos principal of the session
+	subscriptions int           // live Dispatcher subscriptions
+	certDN        string        // identity mapped from the client certificate
+	peerCred      *PeerCredentials
+	pendingLayer  SecurityLayer // installed after the SASL bind succeeds
+	tlsConfig     *tls.Config   // configuration of the TLS handshake
+	bindRejected  bool          // bind answered by the BindThrottle
+	pagedSearches map[string]*pagedSearch
+	pagedCookie   uint64 // last paged results cookie
+	vlvSearches   map[string]*vlvSearch
+	vlvContext    uint64 // last Virtual List View context ID
+	transactions  map[string]*transaction
+	txnCounter    uint64                // last transaction identifier
+	unbound       bool                  // an unbind request was received
+	writeErr      atomic.Pointer[error] // first error writing to the connection
+	values        map[any]any           // session store, see Store
 }
 
 func (c *client) GetConn() net.Conn {
@@ -29,6 +60,8 @@ func (c *client) GetConn() net.Conn {
 }
 
 func (c *client) SetConn(conn net.Conn) {
+	c.Lock()
+	defer c.Unlock()
 	c.rwc = conn
 	c.br = bufio.NewReader(c.rwc)
 	c.bw = bufio.NewWriter(c.rwc)
@@ -38,47 +71,83 @@ func (c *client) Addr() net.Addr {
 	return c.rwc.RemoteAddr()
 }
 
+// TLSConnectionState returns the state of the TLS session of the
+// connection, from LDAPS or StartTLS. ok is false on a plaintext
+// connection, so handlers can refuse simple binds without TLS
+// (RFC 4513 section 5.1.2).
+func (c *client) TLSConnectionState() (state *tls.ConnectionState, ok bool) {
+	tlsConn, ok := c.transport().(*tls.Conn)
+	if !ok {
+		return nil, false
+	}
+	cs := tlsConn.ConnectionState()
+	return &cs, true
+}
+
 func (c *client) serve() {
 	defer c.close()
 
-	c.closing = make(chan bool)
-	handler := c.srv.HandleConnection(c.rwc)
-	if handler == nil {
-		return
-	}
+	c.srv.logf("Connection client [%d] from %s accepted", c.Numero, c.rwc.RemoteAddr().String())
 
-	// Create the ldap response queue to be writted to client (buffered to 20)
-	// buffered to 20 means that If client is slow to handler responses, Server
+	// c.chanOut is the ldap response queue to be writted to client, it is
+	// unbuffered so that If client is slow to handler responses, Server
 	// Handlers will stop to send more respones
-	c.chanOut = make(chan *ldap.LDAPMessage)
 	c.writeDone = make(chan bool)
 	// for each message in c.chanOut send it to client
 	go func() {
 		for msg := range c.chanOut {
-			c.writeMessage(msg)
+			// after a write error, the messages are drained so that
+			// writers never block
+			if msg.LDAPMessage != nil && c.writeError() == nil {
+				if err := c.writeMessage(msg.LDAPMessage); err != nil {
+					c.failWrite(err)
+				}
+			}
+			if msg.written != nil {
+				close(msg.written)
+			}
 		}
 		close(c.writeDone)
 	}()
 
-	// Listen for server signal to shutdown
+	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
+		c.tlsConfig = c.srv.TLSConfig
+		if c.listener != nil && c.listener.TLSConfig != nil {
+			c.tlsConfig = c.listener.TLSConfig
+		}
+		c.tlsConfig = c.srv.tlsConfig(c.tlsConfig)
+		if err := c.handshake(tlsConn); err != nil {
+			c.srv.logf("client %d TLS handshake error: %s", c.Numero, err)
+			return
+		}
+	}
+
+	if unixConn, ok := c.rwc.(*net.UnixConn); ok {
+		cred, err := peerCredentials(unixConn)
+		if err != nil {
+			c.srv.logf("client %d peer credentials: %s", c.Numero, err)
+		}
+		c.peerCred = cred
+	}
+
+	handler := c.srv.HandleConnection(c.rwc)
+	if handler == nil {
+		return
+	}
+
+	// Listen for server signal to shutdown. We only stop reading here: the
+	// Notice of Disconnection is queued by close(), once every response
+	// already accepted from handlers has been handed to the writer.
 	go func() {
-		for {
-			select {
-			case <-c.srv.chDone: // server signals shutdown process
-				c.wg.Add(1)
-				r := NewExtendedResponse(LDAPResultUnwillingToPerform)
-				r.SetDiagnosticMessage("server is about to stop")
-				r.SetResponseName(NoticeOfDisconnection)
-
-				m := ldap.NewLDAPMessageWithProtocolOp(r)
-
-				c.chanOut <- m
-				c.wg.Done()
-				c.rwc.SetReadDeadline(time.Now().Add(time.Millisecond))
-				return
-			case <-c.closing:
-				return
+		select {
+		case <-c.srv.chDone: // server signals shutdown process
+			c.Lock()
+			if c.notice == nil {
+				c.notice = noticeOfDisconnection(LDAPResultUnwillingToPerform, "server is about to stop")
 			}
+			c.stopReadingLocked()
+			c.Unlock()
+		case <-c.closing:
 		}
 	}()
 
@@ -87,38 +156,90 @@ func (c *client) serve() {
 	//
 	// XXX:FIXME enlarging the buffer may cause abandon requests to be
 	// ignored, if they fire before the message starts processing.
-	inbox := make(chan *ldap.LDAPMessage, 1)
+	inbox := make(chan inMessage, 1)
+	upgradeDone := make(chan struct{})
 	go func() {
 		defer close(inbox)
+		defer c.recoverReader()
 		for {
-			if c.srv.ReadTimeout > 0 {
+			// the deadline must not undo that of stopReading
+			c.Lock()
+			stopped := c.readStopped
+			if !stopped && c.srv.ReadTimeout > 0 {
 				c.rwc.SetReadDeadline(time.Now().Add(c.srv.ReadTimeout))
 			}
+			c.Unlock()
+			if stopped {
+				return
+			}
 
-			message, err := readMessage(c.br)
+			message, err := ReadMessage(c.br)
+			receivedAt := time.Now()
+			if err == nil && c.stopped() {
+				// read from the buffer after the client was disconnected
+				return
+			}
 			if err != nil {
 				c.srv.logf("client %d readMessage error: %s", c.Numero, err)
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					// nobody is left to read the responses, e.g. of a
+					// persistent search
+					c.cancelRequests()
+				}
 				return
 			}
 
-			switch message.ProtocolOp().(type) {
+			switch r := message.ProtocolOp().(type) {
 			case ldap.AbandonRequest:
-				c.cancelMessageID(int(message.MessageID()))
+				c.cancelMessageID(int(r))
+				c.serveNoResponse(handler, message)
 			case ldap.UnbindRequest:
+				// RFC 4511 section 4.3: outstanding operations are abandoned
+				c.cancelRequests()
+				c.Lock()
+				c.unbound = true
+				c.Unlock()
+				c.serveNoResponse(handler, message)
 				return
 			default:
-				inbox <- message
+				inbox <- inMessage{LDAPMessage: message, receivedAt: receivedAt}
+				if c.isStartTLS(message) || isSaslBind(message) {
+					// don't touch c.br until the connection is upgraded
+					<-upgradeDone
+				}
 			}
 		}
 	}()
 
-	for message := range inbox {
+	for in := range inbox {
+		message := in.LDAPMessage
+		if c.stopped() {
+			// queued before the client was disconnected: dropped, the
+			// reader only has to be released
+			if c.isStartTLS(message) || isSaslBind(message) {
+				upgradeDone <- struct{}{}
+			}
+			continue
+		}
 		if c.srv.WriteTimeout > 0 {
 			c.rwc.SetWriteDeadline(time.Now().Add(c.srv.WriteTimeout))
 		}
 
+		c.setState(StateActive)
+		if c.isStartTLS(message) {
+			c.startTLS(message)
+			c.setState(StateIdle)
+			upgradeDone <- struct{}{}
+			continue
+		}
+
 		c.wg.Add(1)
-		c.ProcessRequestMessage(handler, message)
+		c.ProcessRequestMessage(handler, message, in.receivedAt)
+		if isSaslBind(message) {
+			c.installSecurityLayer()
+			upgradeDone <- struct{}{}
+		}
+		c.setState(StateIdle)
 	}
 }
 
@@ -146,43 +267,199 @@ func (c *client) close() {
 	c.Unlock()
 	c.srv.logf("client %d close() - Abandon signal sent to processors", c.Numero)
 
-	c.wg.Wait()      // wait for all current running request processor to end
+	c.wg.Wait() // wait for all current running request processor to end
+
+	// Handlers are done, so the notice is the last message on the wire
+	c.Lock()
+	notice := c.notice
+	c.Unlock()
+	if notice != nil {
+		c.chanOut <- &outMessage{LDAPMessage: notice}
+	}
+	c.outMu.Lock()
+	c.outClosed = true
 	close(c.chanOut) // No more message will be sent to client, close chanOUT
+	c.outMu.Unlock()
 	c.srv.logf("client [%d] request processors ended", c.Numero)
 
 	<-c.writeDone // Wait for the last message sent to be written
 	c.rwc.Close() // close client connection
 	c.srv.logf("client [%d] connection closed", c.Numero)
+	c.closeValues()
+	c.closeVLVSearches()
+	c.setState(StateClosed)
+	if c.srv.OnDisconnect != nil {
+		c.srv.OnDisconnect(c.info())
+	}
 
+	c.srv.release(c.ip)
+	c.srv.mu.Lock()
+	delete(c.srv.clients, c)
+	c.srv.mu.Unlock()
 	c.srv.wg.Done() // signal to server that client shutdown is ok
 }
 
-func (c *client) writeMessage(m *ldap.LDAPMessage) {
-	data, _ := m.Write()
+// noticeOfDisconnection builds the unsolicited Notice of Disconnection
+// (RFC 4511 section 4.4.1), sent with message ID 0.
+func noticeOfDisconnection(resultCode int, diagnostic string) *ldap.LDAPMessage {
+	r := NewUnsolicitedNotification(NoticeOfDisconnection, resultCode, diagnostic)
+	return ldap.NewLDAPMessageWithProtocolOp(r)
+}
+
+// abort cancels the requests in progress and closes the connection under
+// the client's feet, see Server.Close.
+func (c *client) abort() {
+	c.Lock()
+	defer c.Unlock()
+	for _, cancelCtx := range c.requestCancel {
+		cancelCtx()
+	}
+	c.rwc.Close()
+}
+
+func (c *client) writeMessage(m *ldap.LDAPMessage) error {
+	data, err := m.Write()
+	if err != nil {
+		return err
+	}
 	c.srv.logf(">>> %d - %s - hex=%x", c.Numero, m.ProtocolOpName(), data.Bytes())
-	c.bw.Write(data.Bytes())
-	c.bw.Flush()
+	if _, err := c.bw.Write(data.Bytes()); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// failWrite records the first error writing to the connection: the
+// requests in progress are cancelled and the read loop stops.
+func (c *client) failWrite(err error) {
+	if !c.writeErr.CompareAndSwap(nil, &err) {
+		return
+	}
+	c.srv.logf("client %d write error: %s", c.Numero, err)
+	c.cancelRequests()
+	c.Lock()
+	c.stopReadingLocked()
+	c.Unlock()
+}
+
+// stopReadingLocked stops the read loop: the request being read is
+// interrupted, and those already read but not yet served are dropped.
+// c must be locked.
+func (c *client) stopReadingLocked() {
+	c.readStopped = true
+	c.rwc.SetReadDeadline(time.Now())
+}
+
+// stopped reports whether the read loop was stopped.
+func (c *client) stopped() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.readStopped
+}
+
+// writeError returns the error that ended the writes to the connection,
+// nil while it works.
+func (c *client) writeError() error {
+	if err := c.writeErr.Load(); err != nil {
+		return *err
+	}
+	return nil
 }
 
 // ResponseWriter interface is used by an LDAP handler to
 // construct an LDAP response.
 type ResponseWriter interface {
-	// Write writes the LDAPResponse to the connection as part of an LDAP reply.
-	Write(po ldap.ProtocolOp)
+	// Write writes the LDAPResponse to the connection as part of an LDAP
+	// reply. It returns an error when the response can not be sent: the
+	// connection failed or the request was cancelled.
+	Write(po ldap.ProtocolOp) error
+
+	// WriteWithControls writes the LDAPResponse with response controls,
+	// e.g. the cookie of a paged search.
+	WriteWithControls(po ldap.ProtocolOp, controls ...Control) error
+}
+
+// inMessage is a request read from the connection.
+type inMessage struct {
+	*ldap.LDAPMessage
+	receivedAt time.Time
+}
+
+// outMessage is a message queued for the writer goroutine. When written
+// is not nil, it is closed once the message has been flushed.
+type outMessage struct {
+	*ldap.LDAPMessage
+	written chan struct{}
 }
 
 type responseWriterImpl struct {
-	chanOut   chan *ldap.LDAPMessage
+	chanOut   chan *outMessage
+	ctx       context.Context // cancelled with the request
 	messageID int
+	client    *client
+	request   ldap.ProtocolOp
+	m         *Message
+	responded *atomic.Bool // the final response was written
+}
+
+func (w responseWriterImpl) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+// WriteWithControls queues the response for the writer goroutine. Once the
+// request is cancelled, only the final response is still sent, in case the
+// client waits for it; the other responses return the context error
+// instead of blocking.
+func (w responseWriterImpl) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if err := w.client.writeError(); err != nil {
+		return err
+	}
+	final := isFinalResponse(po)
+	done := w.ctx.Done()
+	if final {
+		done = nil
+	} else if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if w.m != nil {
+		controls = append(controls, w.m.readEntryControls(po)...)
+	}
+	m, err := newResponseMessage(w.messageID, po, controls)
+	if err != nil {
+		w.client.srv.logf("client %d invalid response controls: %s", w.client.Numero, err)
+		m, _ = newResponseMessage(w.messageID, po, nil)
+	}
+	w.client.observeResponse(w.request, po, w.m)
+	select {
+	case w.chanOut <- &outMessage{LDAPMessage: m}:
+	case <-done:
+		return w.ctx.Err()
+	}
+	if final && w.responded != nil {
+		w.responded.Store(true)
+	}
+	return nil
 }
 
-func (w responseWriterImpl) Write(po ldap.ProtocolOp) {
-	m := ldap.NewLDAPMessageWithProtocolOp(po)
-	m.SetMessageID(w.messageID)
-	w.chanOut <- m
+// observeResponse tracks the session state from the responses written
+// by handlers.
+func (c *client) observeResponse(request, po ldap.ProtocolOp, m *Message) {
+	if _, ok := po.(ldap.BindResponse); !ok {
+		return
+	}
+	code, ok := ResultCode(po)
+	if !ok {
+		return
+	}
+	if r, ok := request.(ldap.BindRequest); ok {
+		c.observeBind(r, code, m)
+	}
+	if code == LDAPResultSuccess {
+		c.setState(StateBound)
+	}
 }
 
-func (c *client) ProcessRequestMessage(handler Handler, message *ldap.LDAPMessage) {
+func (c *client) ProcessRequestMessage(handler Handler, message *ldap.LDAPMessage, receivedAt time.Time) {
 	defer c.wg.Done()
 
 	messageID := message.MessageID().Int()
@@ -191,8 +468,15 @@ func (c *client) ProcessRequestMessage(handler Handler, message *ldap.LDAPMessag
 		Client:      c,
 	}
 
-	ctx, cancelCtx := context.WithCancel(context.Background())
+	ctx, cancelCtx := context.WithCancel(c.ctx)
 	defer cancelCtx()
+	bind, isBind := message.ProtocolOp().(ldap.BindRequest)
+	if isBind {
+		c.resetAuth()
+	}
+	state := c.AuthState()
+	ctx = context.WithValue(ctx, authStateKey{}, state)
+	ctx = context.WithValue(ctx, requestInfoKey{}, c.requestInfo(messageID, receivedAt, state))
 
 	// store the cancel function in case we get an abandon message
 	c.Lock()
@@ -209,9 +493,95 @@ func (c *client) ProcessRequestMessage(handler Handler, message *ldap.LDAPMessag
 
 	var w responseWriterImpl
 	w.chanOut = c.chanOut
+	w.ctx = ctx
 	w.messageID = messageID
+	w.client = c
+	w.request = message.ProtocolOp()
+	w.m = m
+	w.responded = new(atomic.Bool)
+
+	if c.checkSecurityStrength(w, message) {
+		return
+	}
+
+	if c.checkAuthentication(w, message) {
+		return
+	}
+
+	if isBind && c.throttleBind(ctx, w, bind) {
+		return
+	}
+
+	ctx, ok := c.proxyAuthorization(ctx, w, m)
+	if !ok {
+		return
+	}
+
+	if c.whoAmI(ctx, w, m) {
+		return
+	}
+
+	if c.transactionOp(ctx, w, m, handler) {
+		return
+	}
+
+	if ex := c.beginSasl(message.ProtocolOp()); ex != nil && c.saslBind(ctx, w, m, ex) {
+		return
+	}
+
+	m.ctx = ctx
+	if c.srv.EnforceSearchLimits {
+		handler = searchLimits(handler)
+	}
+	func() {
+		defer c.recoverHandler(w)
+		handler.ServeLDAP(ctx, w, m)
+	}()
+	c.finalize(ctx, w)
+}
+
+// finalize answers the request with Server.UnansweredResult when the
+// handler returned without writing the final response, unless the request
+// was cancelled.
+func (c *client) finalize(ctx context.Context, w responseWriterImpl) {
+	code := c.srv.UnansweredResult
+	if code < 0 || w.responded.Load() || ctx.Err() != nil {
+		return
+	}
+	if code == 0 {
+		code = LDAPResultOperationsError
+	}
+	c.srv.logf("client %d message %d: handler returned without response", c.Numero, w.messageID)
+	if res := responseFor(w.request, code, "no response from the server"); res != nil {
+		w.Write(res)
+	}
+}
+
+// cancelRequests cancels the context of the requests in progress.
+func (c *client) cancelRequests() {
+	c.Lock()
+	defer c.Unlock()
+	for _, cancelCtx := range c.requestCancel {
+		cancelCtx()
+	}
+}
+
+// serveNoResponse passes an abandon or unbind request to the handler once
+// the operations it ends are cancelled, e.g. to a RouteMux.Abandon route.
+// It runs on the read loop, so the handler must not block; its responses
+// are dropped.
+func (c *client) serveNoResponse(handler Handler, message *ldap.LDAPMessage) {
+	m := &Message{LDAPMessage: message, Client: c, ctx: c.ctx}
+	defer c.recoverNoResponse(message.MessageID().Int())
+	handler.ServeLDAP(c.ctx, noResponseWriter{}, m)
+}
+
+// noResponseWriter drops the responses to the requests without response.
+type noResponseWriter struct{}
 
-	handler.ServeLDAP(ctx, w, m)
+func (noResponseWriter) Write(po ldap.ProtocolOp) error { return nil }
+func (noResponseWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	return nil
 }
 
 func (c *client) cancelMessageID(messageID int) {
@@ -0,0 +1,53 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// handshake completes the TLS handshake of an LDAPS connection before the
+// first request is read, so the client certificate can be mapped and the
+// TLS state is known to HandleConnection and handlers.
+func (c *client) handshake(tlsConn *tls.Conn) error {
+	timeout := c.srv.ReadTimeout
+	if timeout <= 0 {
+		timeout = DefaultTLSHandshakeTimeout
+	}
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return c.mapClientCert(tlsConn)
+}
+
+// mapClientCert derives the connection identity from the client
+// certificate with Server.CertMapper. An error means the certificate is
+// not acceptable and the connection must be closed.
+func (c *client) mapClientCert(tlsConn *tls.Conn) error {
+	if c.srv.CertMapper == nil {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	dn, err := c.srv.CertMapper(state.PeerCertificates[0])
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	c.certDN = dn
+	c.Unlock()
+	c.srv.logf("client %d certificate mapped to %q", c.Numero, dn)
+	return nil
+}
+
+// ClientCertDN returns the DN Server.CertMapper derived from the client
+// certificate. ok is false when the client did not present a certificate
+// or no mapper is configured.
+func (c *client) ClientCertDN() (dn string, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	return c.certDN, c.certDN != ""
+}
@@ -0,0 +1,129 @@
+package ldapserver
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ClientInfo is a snapshot of a client connection, see Server.Clients.
+type ClientInfo struct {
+	Numero      int
+	RemoteAddr  net.Addr
+	Listener    string // see Client.ListenerName
+	BoundDN     string // "" for anonymous sessions
+	InFlight    int    // requests in progress
+	ConnectedAt time.Time
+	Unbound     bool // the client sent an unbind request
+}
+
+// Age returns how long the client has been connected.
+func (i ClientInfo) Age() time.Duration {
+	return time.Since(i.ConnectedAt)
+}
+
+// Clients returns a snapshot of the connected clients, ordered by Numero.
+func (s *Server) Clients() []ClientInfo {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	infos := make([]ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		infos = append(infos, c.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Numero < infos[j].Numero })
+	return infos
+}
+
+// info returns a snapshot of the client.
+func (c *client) info() ClientInfo {
+	c.Lock()
+	defer c.Unlock()
+	return ClientInfo{
+		Numero:      c.Numero,
+		RemoteAddr:  c.rwc.RemoteAddr(),
+		Listener:    c.ListenerName(),
+		BoundDN:     c.auth.BoundDN,
+		InFlight:    len(c.requestCancel),
+		ConnectedAt: c.connectedAt,
+		Unbound:     c.unbound,
+	}
+}
+
+// CloseClient disconnects a single client: the requests in progress are
+// cancelled, a Notice of Disconnection is sent and the connection is
+// closed. It reports whether a client with that number was connected.
+func (s *Server) CloseClient(numero int) bool {
+	s.mu.Lock()
+	var target *client
+	for c := range s.clients {
+		if c.Numero == numero {
+			target = c
+			break
+		}
+	}
+	s.mu.Unlock()
+	if target == nil {
+		return false
+	}
+
+	target.disconnect(LDAPResultUnwillingToPerform, "connection closed by the administrator")
+	return true
+}
+
+// disconnect stops reading from the client, drops the requests it has not
+// served yet and cancels the others; the Notice of Disconnection is sent
+// by close() once the handlers are done.
+func (c *client) disconnect(resultCode int, diagnostic string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.notice == nil {
+		c.notice = noticeOfDisconnection(resultCode, diagnostic)
+	}
+	for _, cancelCtx := range c.requestCancel {
+		cancelCtx()
+	}
+	c.stopReadingLocked()
+}
+
+// observeBind records the identity of the session from the response to a
+// bind request, m being the request as served, if known.
+func (c *client) observeBind(r ldap.BindRequest, code int, m *Message) {
+	c.Lock()
+	ex := c.sasl
+	c.Unlock()
+	c.observeThrottle(r, code)
+
+	dn := ""
+	if code == LDAPResultSuccess {
+		dn = string(r.Name())
+		if m != nil && m.boundDN != nil {
+			dn = *m.boundDN
+		}
+		if ex != nil && ex.server != nil {
+			dn = ex.server.Identity()
+		}
+	}
+
+	c.Lock()
+	c.auth = AuthState{}
+	if dn != "" {
+		c.auth = AuthState{BoundDN: dn, Method: authMethod(r), BoundAt: time.Now()}
+	}
+	if code != LDAPResultSaslBindInProgress && code != LDAPResultSuccess {
+		c.pendingLayer = nil
+	}
+	if ex == nil || code != LDAPResultSaslBindInProgress && code != LDAPResultSuccess {
+		c.principal = ""
+	} else if _, ok := ex.server.(*gssServer); !ok {
+		c.principal = ""
+	}
+	c.endSasl(code)
+	c.Unlock()
+}
@@ -0,0 +1,62 @@
+package ldapserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestCloseClientDropsQueuedRequests checks that the requests read but not
+// yet served when a client is disconnected are not served.
+func TestCloseClientDropsQueuedRequests(t *testing.T) {
+	started, release := make(chan int, 3), make(chan struct{})
+	routes := NewRouteMux()
+	routes.Search(func(ctx context.Context, w ResponseWriter, m *Message) {
+		started <- m.MessageID().Int()
+		<-release
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	s := &Server{ReadTimeout: time.Minute, HandleConnection: func(net.Conn) Handler { return routes }}
+	peer, conn := net.Pipe()
+	defer peer.Close()
+	go s.ServeConn(conn)
+	defer s.Close()
+	go io.Copy(io.Discard, peer)
+
+	// the requests are written at once, so that the server buffers them
+	var buf bytes.Buffer
+	for id := 1; id <= 3; id++ {
+		r, err := NewSearchRequest("", SearchRequestHomeSubtree, "(cn=*)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := newResponseMessage(id, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := m.Write()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(data.Bytes())
+	}
+	go peer.Write(buf.Bytes())
+
+	if id := <-started; id != 1 {
+		t.Fatalf("request %d served first, want 1", id)
+	}
+	clients := s.Clients()
+	if len(clients) != 1 || !s.CloseClient(clients[0].Numero) {
+		t.Fatal("the client was not closed")
+	}
+	close(release)
+
+	select {
+	case id := <-started:
+		t.Errorf("request %d served after the client was closed", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,79 @@
+package ldapserver
+
+import (
+	"errors"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// CompareEntry evaluates the assertion attribute=value of a compare
+// request (RFC 4511 section 4.10) against the entry e, with the equality
+// rule of the attribute: that of its type in schema, or without a schema
+// the rule used by MatchFilter, e.g. distinguishedNameMatch for member or
+// integerMatch for uidNumber. It fails with noSuchAttribute when the entry
+// has no value of the attribute, undefinedAttributeType for an attribute
+// unknown to schema, inappropriateMatching for one without equality rule,
+// and invalidAttributeSyntax for a value invalid for the rule.
+//
+//	e, ok := entries[ldap.NormalizeDN(dn)]
+//	if !ok {
+//		return false, ldap.NewError(ldap.LDAPResultNoSuchObject, dn)
+//	}
+//	return ldap.CompareEntry(e, attribute, value, schema)
+func CompareEntry(e *Entry, attribute, value string, schema *Schema) (bool, error) {
+	rule, err := equalityRule(attribute, schema)
+	if err != nil {
+		return false, err
+	}
+	values := e.Get(attribute)
+	if len(values) == 0 {
+		return false, NewError(LDAPResultNoSuchAttribute, attribute)
+	}
+	if rule.match == nil {
+		if _, ok := rule.normalize(value); !ok {
+			return false, NewError(LDAPResultInvalidAttributeSyntax, "invalid value of "+attribute)
+		}
+	}
+	return matchValues(values, func(v string) matchResult { return rule.equal(v, value) }) == matchTrue, nil
+}
+
+// CompareResponseFor returns the response to the compare request r against
+// the entry e, see CompareEntry: compareTrue, compareFalse, or the error
+// result.
+func CompareResponseFor(e *Entry, r ldap.CompareRequest, schema *Schema) ldap.CompareResponse {
+	ava := r.Ava()
+	ok, err := CompareEntry(e, string(ava.AttributeDesc()), string(ava.AssertionValue()), schema)
+	var lerr *Error
+	switch {
+	case errors.As(err, &lerr):
+		return NewCompareResponse(lerr.ResultCode, DiagnosticMessage(lerr.DiagnosticMessage))
+	case ok:
+		return NewCompareResponse(LDAPResultCompareTrue)
+	}
+	return NewCompareResponse(LDAPResultCompareFalse)
+}
+
+// equalityRule returns the equality rule of the attribute description, see
+// CompareEntry.
+func equalityRule(attribute string, schema *Schema) (*matchingRule, error) {
+	if schema == nil {
+		return attributeRule(attribute), nil
+	}
+	name, _, _ := strings.Cut(attribute, ";")
+	a, ok := schema.AttributeType(name)
+	if !ok {
+		return nil, NewError(LDAPResultUndefinedAttributeType, name)
+	}
+	for i := 0; a != nil && i < 16; i++ {
+		if a.Equality != "" {
+			if r := matchingRules[strings.ToLower(a.Equality)]; r != nil {
+				return r, nil
+			}
+			// a rule MatchFilter does not implement, e.g. UUIDMatch
+			return attributeRule(attribute), nil
+		}
+		a, _ = schema.AttributeType(a.Superior)
+	}
+	return nil, NewError(LDAPResultInappropriateMatching, "no equality rule for "+name)
+}
@@ -0,0 +1,81 @@
+package ldapserver
+
+import (
+	"net"
+	"time"
+)
+
+// admit counts a new connection against MaxConnections and
+// MaxConnectionsPerIP. It returns the key to pass to release, and false
+// when a limit is exceeded.
+func (s *Server) admit(conn net.Conn) (ip string, ok bool) {
+	if s.MaxConnections <= 0 && s.MaxConnectionsPerIP <= 0 {
+		return "", true
+	}
+	if s.MaxConnectionsPerIP > 0 {
+		ip = remoteIP(conn)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaxConnections > 0 && s.connCount >= s.MaxConnections {
+		return "", false
+	}
+	if s.MaxConnectionsPerIP > 0 && s.connsPerIP[ip] >= s.MaxConnectionsPerIP {
+		return "", false
+	}
+	s.connCount++
+	if s.MaxConnectionsPerIP > 0 {
+		if s.connsPerIP == nil {
+			s.connsPerIP = make(map[string]int)
+		}
+		s.connsPerIP[ip]++
+	}
+	return ip, true
+}
+
+// release undoes admit once the connection is closed.
+func (s *Server) release(ip string) {
+	if s.MaxConnections <= 0 && s.MaxConnectionsPerIP <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connCount--
+	if s.MaxConnectionsPerIP > 0 {
+		if s.connsPerIP[ip]--; s.connsPerIP[ip] <= 0 {
+			delete(s.connsPerIP, ip)
+		}
+	}
+}
+
+// refuse closes an over-limit connection, first sending a Notice of
+// Disconnection with resultCode busy when BusyNotice is set. It does not
+// block the accept loop.
+func (s *Server) refuse(conn net.Conn) {
+	if !s.BusyNotice {
+		conn.Close()
+		return
+	}
+	go func() {
+		defer conn.Close()
+		data, err := noticeOfDisconnection(LDAPResultBusy, "too many connections").Write()
+		if err != nil {
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Write(data.Bytes())
+	}()
+}
+
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,47 @@
+package ldapserver
+
+// ConnState represents the state of a client connection, see
+// Server.ConnState.
+type ConnState int
+
+const (
+	// StateNew is a connection that has just been accepted.
+	StateNew ConnState = iota
+
+	// StateActive is a connection with a request in progress.
+	StateActive
+
+	// StateIdle is a connection waiting for its next request.
+	StateIdle
+
+	// StateBound is reported, while the connection is active, when a
+	// bind request has been answered with success.
+	StateBound
+
+	// StateClosed is a closed connection. This is a terminal state.
+	StateClosed
+)
+
+var connStateName = map[ConnState]string{
+	StateNew:    "new",
+	StateActive: "active",
+	StateIdle:   "idle",
+	StateBound:  "bound",
+	StateClosed: "closed",
+}
+
+func (s ConnState) String() string {
+	return connStateName[s]
+}
+
+// setState reports a state change of the connection to Server.ConnState.
+// The callback always receives the connection as accepted, before any
+// StartTLS upgrade.
+func (c *client) setState(state ConnState) {
+	if c.conn == nil {
+		return // CLDAP
+	}
+	if c.srv.ConnState != nil {
+		c.srv.ConnState(c.conn, state)
+	}
+}
@@ -59,6 +59,8 @@ const (
 	LDAPResultUnavailable                  = 52
 	LDAPResultUnwillingToPerform           = 53
 	LDAPResultLoopDetect                   = 54
+	LDAPResultSortControlMissing           = 60
+	LDAPResultOffsetRangeError             = 61
 	LDAPResultNamingViolation              = 64
 	LDAPResultObjectClassViolation         = 65
 	LDAPResultNotAllowedOnNonLeaf          = 66
@@ -66,7 +68,9 @@ const (
 	LDAPResultEntryAlreadyExists           = 68
 	LDAPResultObjectClassModsProhibited    = 69
 	LDAPResultAffectsMultipleDSAs          = 71
+	LDAPResultVirtualListViewError         = 76
 	LDAPResultOther                        = 80
+	LDAPResultAuthorizationDenied          = 123
 
 	ErrorNetwork         = 200
 	ErrorFilterCompile   = 201
@@ -85,6 +89,14 @@ const SearchRequestScopeBaseObject = 0
 const SearchRequestSingleLevel = 1
 const SearchRequestHomeSubtree = 2
 
+// Search Request derefAliases values (RFC 4511 section 4.5.1.3)
+const (
+	NeverDerefAliases   = 0
+	DerefInSearching    = 1
+	DerefFindingBaseObj = 2
+	DerefAlways         = 3
+)
+
 // Extended operation responseName and requestName
 const (
 	NoticeOfDisconnection   ldap.LDAPOID = "1.3.6.1.4.1.1466.20036"
@@ -93,4 +105,9 @@ const (
 	NoticeOfWhoAmI          ldap.LDAPOID = "1.3.6.1.4.1.4203.1.11.3"
 	NoticeOfGetConnectionID ldap.LDAPOID = "1.3.6.1.4.1.26027.1.6.2"
 	NoticeOfPasswordModify  ldap.LDAPOID = "1.3.6.1.4.1.4203.1.11.1"
+
+	NoticeOfStartTransaction   ldap.LDAPOID = "1.3.6.1.1.21.1"
+	NoticeOfEndTransaction     ldap.LDAPOID = "1.3.6.1.1.21.3"
+	NoticeOfAbortedTransaction ldap.LDAPOID = "1.3.6.1.1.21.4"
+	NoticeOfRefresh            ldap.LDAPOID = "1.3.6.1.4.1.1466.101.119.1"
 )
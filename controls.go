@@ -0,0 +1,415 @@
+package ldapserver
+
+import (
+	"errors"
+	"sync"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Request control OIDs with typed accessors on Message.
+const (
+	ControlPagedResults ldap.LDAPOID = "1.2.840.113556.1.4.319"   // RFC 2696
+	ControlSortRequest  ldap.LDAPOID = "1.2.840.113556.1.4.473"   // RFC 2891
+	ControlManageDsaIT  ldap.LDAPOID = "2.16.840.1.113730.3.4.2"  // RFC 3296
+	ControlVLVRequest   ldap.LDAPOID = "2.16.840.1.113730.3.4.9"  // draft-ietf-ldapext-ldapv3-vlv
+	ControlSyncRequest  ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.1" // RFC 4533
+	ControlDirSync      ldap.LDAPOID = "1.2.840.113556.1.4.841"   // MS-ADTS 3.1.1.3.4.1.3
+)
+
+// Control is a control of a request.
+type Control struct {
+	OID      ldap.LDAPOID
+	Critical bool
+	Value    []byte // nil when the control has no value
+
+	// Decoded is the value decoded by the ControlDecoder registered for
+	// the OID, nil when there is none or it failed with Err.
+	Decoded any
+	Err     error
+}
+
+// ControlDecoder decodes the value of a control, nil if absent.
+type ControlDecoder func(value []byte) (any, error)
+
+var (
+	controlDecodersMu sync.RWMutex
+	controlDecoders   = map[ldap.LDAPOID]ControlDecoder{
+		ControlPagedResults:         decodePagedResults,
+		ControlSortRequest:          decodeSortKeys,
+		ControlVLVRequest:           decodeVLVRequest,
+		ControlSyncRequest:          decodeSyncRequest,
+		ControlDirSync:              decodeDirSyncRequest,
+		ControlPreRead:              decodeAttributeSelection,
+		ControlPostRead:             decodeAttributeSelection,
+		ControlProxiedAuthorization: func(value []byte) (any, error) { return string(value), nil },
+	}
+)
+
+// RegisterControl registers the decoder of the controls with the given
+// OID, replacing any previous one. Decoded values are available in
+// Control.Decoded.
+func RegisterControl(oid ldap.LDAPOID, decode ControlDecoder) {
+	controlDecodersMu.Lock()
+	defer controlDecodersMu.Unlock()
+	controlDecoders[oid] = decode
+}
+
+// registeredControls returns the OIDs of the registered control decoders.
+func registeredControls() []ldap.LDAPOID {
+	controlDecodersMu.RLock()
+	defer controlDecodersMu.RUnlock()
+	oids := make([]ldap.LDAPOID, 0, len(controlDecoders))
+	for oid := range controlDecoders {
+		oids = append(oids, oid)
+	}
+	return oids
+}
+
+// Controls returns the controls of the request, decoded with the
+// registered decoders.
+func (m *Message) Controls() []Control {
+	if m.LDAPMessage.Controls() == nil {
+		return nil
+	}
+	controlDecodersMu.RLock()
+	defer controlDecodersMu.RUnlock()
+
+	var controls []Control
+	for _, c := range *m.LDAPMessage.Controls() {
+		control := Control{OID: c.ControlType(), Critical: bool(c.Criticality())}
+		if v := c.ControlValue(); v != nil {
+			control.Value = []byte(*v)
+		}
+		if decode := controlDecoders[control.OID]; decode != nil {
+			control.Decoded, control.Err = decode(control.Value)
+		}
+		controls = append(controls, control)
+	}
+	return controls
+}
+
+// Control returns the control of the request with the given OID.
+func (m *Message) Control(oid ldap.LDAPOID) (Control, bool) {
+	for _, c := range m.Controls() {
+		if c.OID == oid {
+			return c, true
+		}
+	}
+	return Control{}, false
+}
+
+// PagedResults is the value of a Simple Paged Results control.
+type PagedResults struct {
+	Size   int    // requested page size
+	Cookie []byte // empty on the first request
+}
+
+// PagedResults returns the Simple Paged Results control of the request.
+func (m *Message) PagedResults() (*PagedResults, bool) {
+	c, ok := m.Control(ControlPagedResults)
+	if !ok || c.Err != nil {
+		return nil, false
+	}
+	return c.Decoded.(*PagedResults), true
+}
+
+func decodePagedResults(value []byte) (any, error) {
+	// realSearchControlValue ::= SEQUENCE { size INTEGER, cookie OCTET STRING }
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := seq.children()
+	if err != nil || len(fields) != 2 {
+		return nil, errors.New("malformed paged results control")
+	}
+	size, err := fields[0].int()
+	if err != nil {
+		return nil, err
+	}
+	return &PagedResults{Size: int(size), Cookie: fields[1].value}, nil
+}
+
+// SortKey is a key of a Server Side Sorting request control.
+type SortKey struct {
+	AttributeType string
+	OrderingRule  string // "" for the default ordering of the attribute
+	Reverse       bool
+}
+
+// SortKeys returns the keys of the Server Side Sorting control of the
+// request.
+func (m *Message) SortKeys() ([]SortKey, bool) {
+	c, ok := m.Control(ControlSortRequest)
+	if !ok || c.Err != nil {
+		return nil, false
+	}
+	return c.Decoded.([]SortKey), true
+}
+
+func decodeSortKeys(value []byte) (any, error) {
+	// SortKeyList ::= SEQUENCE OF SEQUENCE { attributeType, orderingRule
+	//     [0] OPTIONAL, reverseOrder [1] BOOLEAN DEFAULT FALSE }
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	list, err := seq.children()
+	if err != nil || len(list) == 0 {
+		return nil, errors.New("malformed sort control")
+	}
+	keys := make([]SortKey, 0, len(list))
+	for _, item := range list {
+		fields, err := item.children()
+		if err != nil || len(fields) == 0 {
+			return nil, errors.New("malformed sort key")
+		}
+		key := SortKey{AttributeType: string(fields[0].value)}
+		for _, f := range fields[1:] {
+			switch {
+			case f.is(berClassContext, 0):
+				key.OrderingRule = string(f.value)
+			case f.is(berClassContext, 1):
+				key.Reverse = f.bool()
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// VLVRequest is the value of a Virtual List View request control: the
+// target entry is chosen by Offset or, when ByValue is set, as the first
+// entry whose first sort key is greater than or equal to
+// GreaterThanOrEqual.
+type VLVRequest struct {
+	BeforeCount int
+	AfterCount  int
+
+	Offset       int // position of the target, from 1
+	ContentCount int // client estimate of the number of entries, 0 if none
+
+	ByValue            bool
+	GreaterThanOrEqual []byte
+
+	ContextID []byte // from the previous response, nil on the first request
+}
+
+// VLV returns the Virtual List View control of the request.
+func (m *Message) VLV() (*VLVRequest, bool) {
+	c, ok := m.Control(ControlVLVRequest)
+	if !ok || c.Err != nil {
+		return nil, false
+	}
+	return c.Decoded.(*VLVRequest), true
+}
+
+func decodeVLVRequest(value []byte) (any, error) {
+	// VirtualListViewRequest ::= SEQUENCE { beforeCount INTEGER,
+	//     afterCount INTEGER, target CHOICE { byOffset [0] SEQUENCE {
+	//     offset INTEGER, contentCount INTEGER }, greaterThanOrEqual [1]
+	//     AssertionValue }, contextID OCTET STRING OPTIONAL }
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := seq.children()
+	if err != nil || len(fields) < 3 {
+		return nil, errors.New("malformed VLV control")
+	}
+	before, err := fields[0].int()
+	if err != nil {
+		return nil, err
+	}
+	after, err := fields[1].int()
+	if err != nil {
+		return nil, err
+	}
+	r := &VLVRequest{BeforeCount: int(before), AfterCount: int(after)}
+	switch target := fields[2]; {
+	case target.is(berClassContext, 0):
+		offset, err := target.children()
+		if err != nil || len(offset) != 2 {
+			return nil, errors.New("malformed VLV offset")
+		}
+		n, err := offset[0].int()
+		if err != nil {
+			return nil, err
+		}
+		count, err := offset[1].int()
+		if err != nil {
+			return nil, err
+		}
+		r.Offset, r.ContentCount = int(n), int(count)
+	case target.is(berClassContext, 1):
+		r.ByValue, r.GreaterThanOrEqual = true, target.value
+	default:
+		return nil, errors.New("malformed VLV target")
+	}
+	if len(fields) > 3 {
+		r.ContextID = fields[3].value
+	}
+	if r.BeforeCount < 0 || r.AfterCount < 0 {
+		return nil, errors.New("malformed VLV control")
+	}
+	return r, nil
+}
+
+// Modes of a Content Synchronization request.
+const (
+	SyncRefreshOnly       = 1
+	SyncRefreshAndPersist = 3
+)
+
+// SyncRequest is the value of a Sync Request control.
+type SyncRequest struct {
+	Mode       int    // SyncRefreshOnly or SyncRefreshAndPersist
+	Cookie     []byte // nil for the initial content
+	ReloadHint bool
+}
+
+// SyncRequest returns the Sync Request control of the request, see
+// SyncProvider.
+func (m *Message) SyncRequest() (*SyncRequest, bool) {
+	c, ok := m.Control(ControlSyncRequest)
+	if !ok || c.Err != nil {
+		return nil, false
+	}
+	return c.Decoded.(*SyncRequest), true
+}
+
+func decodeSyncRequest(value []byte) (any, error) {
+	// syncRequestValue ::= SEQUENCE { mode ENUMERATED, cookie syncCookie
+	//     OPTIONAL, reloadHint BOOLEAN DEFAULT FALSE }
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := seq.children()
+	if err != nil || len(fields) == 0 {
+		return nil, errors.New("malformed sync request control")
+	}
+	mode, err := fields[0].int()
+	if err != nil || (mode != SyncRefreshOnly && mode != SyncRefreshAndPersist) {
+		return nil, errors.New("invalid sync request mode")
+	}
+	r := &SyncRequest{Mode: int(mode)}
+	for _, f := range fields[1:] {
+		switch {
+		case f.is(berClassUniversal, berTagOctetString):
+			r.Cookie = f.value
+		case f.is(berClassUniversal, berTagBoolean):
+			r.ReloadHint = f.bool()
+		}
+	}
+	return r, nil
+}
+
+// Flags of a DirSync request.
+const (
+	DirSyncObjectSecurity      = 0x00000001
+	DirSyncAncestorsFirstOrder = 0x00000800
+	DirSyncPublicDataOnly      = 0x00002000
+	DirSyncIncrementalValues   = -0x80000000 // 0x80000000 as a signed 32 bits integer
+)
+
+// DirSyncRequest is the value of a DirSync request control.
+type DirSyncRequest struct {
+	Flags    int
+	MaxBytes int    // size limit of the response, 0 for the server default
+	Cookie   []byte // nil for the first request
+}
+
+// DirSync returns the DirSync control of the request, see
+// DirSyncProvider.
+func (m *Message) DirSync() (*DirSyncRequest, bool) {
+	c, ok := m.Control(ControlDirSync)
+	if !ok || c.Err != nil {
+		return nil, false
+	}
+	return c.Decoded.(*DirSyncRequest), true
+}
+
+func decodeDirSyncRequest(value []byte) (any, error) {
+	// DirSyncRequestValue ::= SEQUENCE { Flags INTEGER, MaxBytes INTEGER,
+	//     Cookie OCTET STRING }
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := seq.children()
+	if err != nil || len(fields) != 3 {
+		return nil, errors.New("malformed DirSync control")
+	}
+	flags, err := fields[0].int()
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := fields[1].int()
+	if err != nil {
+		return nil, err
+	}
+	r := &DirSyncRequest{Flags: int(int32(flags)), MaxBytes: int(maxBytes)}
+	if len(fields[2].value) > 0 {
+		r.Cookie = fields[2].value
+	}
+	return r, nil
+}
+
+// ProxiedAuthzID returns the authorization identity of the Proxied
+// Authorization control of the request, see Server.ProxyAuthorizer.
+func (m *Message) ProxiedAuthzID() (string, bool) {
+	c, ok := m.Control(ControlProxiedAuthorization)
+	if !ok {
+		return "", false
+	}
+	return c.Decoded.(string), true
+}
+
+// ManageDsaIT reports whether the request carries the ManageDsaIT control:
+// referral objects are to be handled as regular entries.
+func (m *Message) ManageDsaIT() bool {
+	_, ok := m.Control(ControlManageDsaIT)
+	return ok
+}
+
+// newResponseMessage builds the message of a response with response
+// controls, goldap has no setter for them. Values of the controls are sent
+// as is; Decoded and Err are ignored.
+func newResponseMessage(messageID int, po ldap.ProtocolOp, controls []Control) (*ldap.LDAPMessage, error) {
+	m := ldap.NewLDAPMessageWithProtocolOp(po)
+	m.SetMessageID(messageID)
+	if len(controls) == 0 {
+		return m, nil
+	}
+
+	op, err := protocolOpBytes(po)
+	if err != nil {
+		return nil, err
+	}
+	var list [][]byte
+	for _, c := range controls {
+		list = append(list, c.encode())
+	}
+	data := berSequence(berInteger(int64(messageID)), op, berEncode(berClassContext, true, 0, list...))
+	msg, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, data))
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// encode returns the BER encoding of the control.
+func (c Control) encode() []byte {
+	// Control ::= SEQUENCE { controlType, criticality DEFAULT FALSE,
+	//     controlValue OCTET STRING OPTIONAL }
+	fields := [][]byte{berString(string(c.OID))}
+	if c.Critical {
+		fields = append(fields, berBoolean(true))
+	}
+	if c.Value != nil {
+		fields = append(fields, berOctetString(c.Value))
+	}
+	return berSequence(fields...)
+}
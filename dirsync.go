@@ -0,0 +1,114 @@
+package ldapserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// DefaultDirSyncMaxBytes is the size limit of a DirSync response when the
+// request has none.
+const DefaultDirSyncMaxBytes = 1 << 20
+
+// dirSyncCookieMagic starts the cookies of a DirSyncProvider.
+var dirSyncCookieMagic = []byte("DSC1")
+
+// DirSyncSource enumerates the changes served by a DirSyncProvider.
+type DirSyncSource interface {
+	// DirSyncChanges returns the entries matching the search m changed
+	// since state, "" for the whole content, up to about maxBytes of
+	// entries, and the state after them. more reports that changes are
+	// left. Deleted entries are returned with the attribute isDeleted set
+	// to TRUE; with DirSyncIncrementalValues, multi-valued attributes may
+	// hold only the values added since state.
+	DirSyncChanges(ctx context.Context, m *Message, state []byte, flags, maxBytes int) (entries []ldap.SearchResultEntry, next []byte, more bool, err error)
+}
+
+// DirSyncProvider serves the Active Directory DirSync control from a
+// DirSyncSource, for the tools written for AD incremental synchronization:
+// each search returns the changes since the cookie of the request, and a
+// new cookie to continue from.
+//
+// The cookies wrap the state of the source with a digest of the search
+// request, so that a cookie is only accepted for the search it was issued
+// for.
+type DirSyncProvider struct {
+	Source DirSyncSource
+}
+
+// Handler returns a handler serving the searches with the DirSync control,
+// and passing the other requests to next.
+func (p *DirSyncProvider) Handler(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		req, ok := m.DirSync()
+		if _, search := m.ProtocolOp().(ldap.SearchRequest); !ok || !search {
+			next.ServeLDAP(ctx, w, m)
+			return
+		}
+		p.serve(ctx, w, m, req)
+	})
+}
+
+func (p *DirSyncProvider) serve(ctx context.Context, w ResponseWriter, m *Message, req *DirSyncRequest) {
+	digest := dirSyncDigest(m)
+	var state []byte
+	if req.Cookie != nil {
+		var ok bool
+		if state, ok = openDirSyncCookie(req.Cookie, digest); !ok {
+			w.Write(responseFor(m.ProtocolOp(), LDAPResultUnwillingToPerform, "invalid DirSync cookie"))
+			return
+		}
+	}
+
+	maxBytes := req.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultDirSyncMaxBytes
+	}
+	entries, next, more, err := p.Source.DirSyncChanges(ctx, m, state, req.Flags, maxBytes)
+	if err != nil {
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultOperationsError, err.Error()))
+		return
+	}
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		w.Write(e)
+	}
+	w.WriteWithControls(NewSearchResultDoneResponse(LDAPResultSuccess), dirSyncControl(more, sealDirSyncCookie(next, digest)))
+}
+
+// dirSyncDigest returns the digest binding the cookies to the search m.
+func dirSyncDigest(m *Message) []byte {
+	request, _ := protocolOpBytes(m.ProtocolOp())
+	sum := sha256.Sum256(request)
+	return sum[:8]
+}
+
+func sealDirSyncCookie(state, digest []byte) []byte {
+	cookie := append(append([]byte{}, dirSyncCookieMagic...), digest...)
+	return append(cookie, state...)
+}
+
+func openDirSyncCookie(cookie, digest []byte) ([]byte, bool) {
+	prefix := len(dirSyncCookieMagic) + len(digest)
+	if len(cookie) < prefix || !bytes.Equal(cookie[:len(dirSyncCookieMagic)], dirSyncCookieMagic) ||
+		!bytes.Equal(cookie[len(dirSyncCookieMagic):prefix], digest) {
+		return nil, false
+	}
+	return cookie[prefix:], true
+}
+
+// dirSyncControl returns the DirSync response control.
+func dirSyncControl(more bool, cookie []byte) Control {
+	// DirSyncResponseValue ::= SEQUENCE { MoreResults INTEGER,
+	//     unused INTEGER, CookieServer OCTET STRING }
+	var moreResults int64
+	if more {
+		moreResults = 1
+	}
+	value := berSequence(berInteger(moreResults), berInteger(0), berOctetString(cookie))
+	return Control{OID: ControlDirSync, Value: value}
+}
@@ -0,0 +1,477 @@
+// Package disk is a persistent directory for ldapserver, stored in a
+// bbolt database file, for small standalone deployments which must
+// survive restarts without an external database. It serves the same
+// operations as package inmem, maintaining the same operational
+// attributes, with indexes of the DNs and of the values of some attributes
+// for the equality filters:
+//
+//	dir, err := disk.Open("directory.db")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer dir.Close()
+//	if dir.Len() == 0 {
+//		dir.LoadLDIF(seed)
+//	}
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return dir }
+//
+// The dynamic entries (RFC 2589) are deleted unless refreshed, see
+// Directory.Dynamic; their time to live starts again when the directory
+// is opened.
+package disk
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/password"
+)
+
+// ErrExists is returned by Directory.Add for a DN already in the
+// directory.
+var ErrExists = errors.New("disk: entry already exists")
+
+// DefaultIndexes are the attributes indexed by Open when none is given.
+var DefaultIndexes = []string{"objectClass", "uid", "cn", "mail", "member", "uniqueMember", "memberUid"}
+
+var (
+	entriesBucket = []byte("entries") // entries by key
+	indexesBucket = []byte("indexes") // a bucket by indexed attribute
+)
+
+// Directory is a tree of entries stored in a database file. It is safe for
+// concurrent use.
+//
+// The entries are stored by key, the normalized RDNs of their DN from the
+// root, separated by 0 bytes, so that the entries below an entry follow
+// it. An index of an attribute holds the normalized values followed by a
+// 0 byte and the key of the entries with the value.
+type Directory struct {
+	// Schema, when set, validates the entries of the add, modify and
+	// modify DN requests, see ldap.Schema.ValidateEntry. Set it before
+	// serving.
+	Schema *ldap.Schema
+
+	// PasswordQuality, when set, checks the clear text userPassword
+	// values of the add and modify requests, and the passwords set by
+	// the Password Modify extended operation, see
+	// ldap.CheckPasswordQuality. Set it before serving.
+	PasswordQuality password.Quality
+
+	// Notifier, when set, receives the changes of the entries, made by
+	// the requests, Add or LoadLDIF, once committed.
+	Notifier *ldap.Notifier
+
+	writes  sync.Mutex // orders the events of the writes
+	db      *bolt.DB
+	indexes map[string]bool // lower cased
+	mux     *ldap.RouteMux
+	dynamic *ldap.DynamicEntries
+	handler ldap.Handler // mux behind dynamic
+}
+
+// Open opens the directory stored in the file path, created if needed,
+// with indexes of the equality of the values of the attributes, or of
+// DefaultIndexes when none is given. The indexes are built or dropped
+// when they change.
+func Open(path string, indexes ...string) (*Directory, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) == 0 {
+		indexes = DefaultIndexes
+	}
+	d := &Directory{db: db, indexes: make(map[string]bool)}
+	for _, name := range indexes {
+		d.indexes[strings.ToLower(name)] = true
+	}
+	if err := db.Update(d.init); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	d.mux = ldap.BackendMux(backend{d})
+	d.mux.Extended(d.passwordModify).RequestName(ldap.NoticeOfPasswordModify)
+	d.dynamic = &ldap.DynamicEntries{Expire: d.expire, Exists: d.exists}
+	d.handler = d.dynamic.Handler(d.mux)
+	if err := db.View(d.trackAll); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// init creates the buckets, and builds or drops the indexes.
+func (d *Directory) init(tx *bolt.Tx) error {
+	entries, err := tx.CreateBucketIfNotExists(entriesBucket)
+	if err != nil {
+		return err
+	}
+	indexes, err := tx.CreateBucketIfNotExists(indexesBucket)
+	if err != nil {
+		return err
+	}
+	var dropped [][]byte
+	indexes.ForEach(func(name, _ []byte) error {
+		if !d.indexes[string(name)] {
+			dropped = append(dropped, name)
+		}
+		return nil
+	})
+	for _, name := range dropped {
+		if err := indexes.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	for name := range d.indexes {
+		if indexes.Bucket([]byte(name)) != nil {
+			continue
+		}
+		index, err := indexes.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		err = entries.ForEach(func(k, v []byte) error {
+			e, err := decodeEntry(v)
+			if err != nil {
+				return err
+			}
+			for _, value := range indexValues(e, name) {
+				if err := index.Put(indexKey(value, k), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database file.
+func (d *Directory) Close() error {
+	return d.db.Close()
+}
+
+// ServeLDAP serves the request m from the directory. Extended operations
+// other than Password Modify and Refresh are answered with
+// unwillingToPerform.
+func (d *Directory) ServeLDAP(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	d.handler.ServeLDAP(ctx, w, m)
+}
+
+// Dynamic returns the time to live of the dynamic entries of the
+// directory, those of the objectClass dynamicObject, which are deleted
+// unless refreshed. Its bounds and Allowed can be set before serving.
+func (d *Directory) Dynamic() *ldap.DynamicEntries {
+	return d.dynamic
+}
+
+// trackAll starts tracking the dynamic entries stored.
+func (d *Directory) trackAll(tx *bolt.Tx) error {
+	return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		d.track(ldap.ChangeEvent{Type: ldap.ChangeAdd, DN: e.DN, Entry: e})
+		return nil
+	})
+}
+
+// track tracks the dynamic entries through the change e.
+func (d *Directory) track(e ldap.ChangeEvent) {
+	switch e.Type {
+	case ldap.ChangeAdd:
+		if ldap.IsDynamic(e.Entry) {
+			d.dynamic.Add(e.DN)
+		}
+	case ldap.ChangeDelete:
+		d.dynamic.Remove(e.DN)
+	case ldap.ChangeModDN:
+		d.dynamic.Rename(e.OldDN, e.DN)
+	}
+}
+
+// expire deletes the expired dynamic entry dn.
+func (d *Directory) expire(dn string) {
+	d.removeEntry(dn)
+}
+
+// exists reports whether the directory has the entry dn.
+func (d *Directory) exists(dn string) bool {
+	var e *ldap.Entry
+	d.db.View(func(tx *bolt.Tx) error {
+		e, _ = d.get(tx, dn)
+		return nil
+	})
+	return e != nil
+}
+
+// passwordModify serves the Password Modify extended operation, see
+// ldap.PasswordModify.
+func (d *Directory) passwordModify(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	ldap.PasswordModify(backend{d}, d.PasswordQuality)(ctx, w, m)
+}
+
+// Backend returns the directory as an ldap.Backend, e.g. to serve it
+// with other routes or behind a decorator.
+func (d *Directory) Backend() ldap.Backend {
+	return backend{d}
+}
+
+// Add adds an entry to the directory, e.g. to load its content. Unlike an
+// add request, the parent of the entry does not need to exist, so that
+// naming contexts can be added, the entry is not validated against the
+// schema, and the operational attributes it has are kept, e.g. those of a
+// backup.
+func (d *Directory) Add(dn string, attributes map[string][]string) error {
+	return d.addEntry(ldap.NewEntry(strings.TrimSpace(dn), attributes).Clone())
+}
+
+// addEntry adds the entry e, see Add.
+func (d *Directory) addEntry(e *ldap.Entry) error {
+	dn, err := ldap.ParseDN(e.DN)
+	if err != nil {
+		return err
+	}
+	if len(dn) == 0 {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, "empty DN")
+	}
+	addRDN(e)
+	ldap.SetOperationalAttributes(context.Background(), e, nil, time.Now())
+	return d.write(func(tx *bolt.Tx) error {
+		k := key(dn)
+		if tx.Bucket(entriesBucket).Get(k) != nil {
+			return ErrExists
+		}
+		d.notify(tx, ldap.ChangeEvent{Type: ldap.ChangeAdd, DN: e.DN, Entry: e})
+		return d.put(tx, k, e)
+	})
+}
+
+// Entry returns the attributes of the entry dn, keyed by attribute name as
+// added.
+func (d *Directory) Entry(dn string) (attributes map[string][]string, ok bool) {
+	var e *ldap.Entry
+	d.db.View(func(tx *bolt.Tx) error {
+		e, _ = d.get(tx, dn)
+		return nil
+	})
+	if e == nil {
+		return nil, false
+	}
+	attributes = make(map[string][]string, len(e.Attributes))
+	for _, a := range e.Attributes {
+		attributes[a.Name] = a.Values
+	}
+	return attributes, true
+}
+
+// Len returns the number of entries of the directory.
+func (d *Directory) Len() int {
+	n := 0
+	d.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(entriesBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// write runs fn in a read-write transaction. bbolt serializes them
+// already, the lock keeps the events they publish in the same order.
+func (d *Directory) write(fn func(tx *bolt.Tx) error) error {
+	d.writes.Lock()
+	defer d.writes.Unlock()
+	return d.db.Update(fn)
+}
+
+// notify tracks the dynamic entries through the change e and publishes it
+// to the Notifier, if any, once the transaction tx is committed.
+func (d *Directory) notify(tx *bolt.Tx, e ldap.ChangeEvent) {
+	tx.OnCommit(func() {
+		d.track(e)
+		if d.Notifier != nil {
+			d.Notifier.Publish(e)
+		}
+	})
+}
+
+// get returns the entry dn, nil when there is none.
+func (d *Directory) get(tx *bolt.Tx, dn string) (*ldap.Entry, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	v := tx.Bucket(entriesBucket).Get(key(parsed))
+	if v == nil {
+		return nil, nil
+	}
+	return decodeEntry(v)
+}
+
+// put stores the entry e with the key k, replacing and unindexing the
+// entry stored with it.
+func (d *Directory) put(tx *bolt.Tx, k []byte, e *ldap.Entry) error {
+	if err := d.remove(tx, k); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return err
+	}
+	if err := tx.Bucket(entriesBucket).Put(k, buf.Bytes()); err != nil {
+		return err
+	}
+	indexes := tx.Bucket(indexesBucket)
+	for name := range d.indexes {
+		for _, value := range indexValues(e, name) {
+			if err := indexes.Bucket([]byte(name)).Put(indexKey(value, k), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes the entry stored with the key k, if any, and its index
+// values.
+func (d *Directory) remove(tx *bolt.Tx, k []byte) error {
+	entries := tx.Bucket(entriesBucket)
+	v := entries.Get(k)
+	if v == nil {
+		return nil
+	}
+	e, err := decodeEntry(v)
+	if err != nil {
+		return err
+	}
+	indexes := tx.Bucket(indexesBucket)
+	for name := range d.indexes {
+		for _, value := range indexValues(e, name) {
+			if err := indexes.Bucket([]byte(name)).Delete(indexKey(value, k)); err != nil {
+				return err
+			}
+		}
+	}
+	return entries.Delete(k)
+}
+
+// matchedDN returns the DN of the deepest existing entry above dn, for
+// the matchedDN of noSuchObject results.
+func (d *Directory) matchedDN(tx *bolt.Tx, dn string) string {
+	for p := ldap.ParentDN(dn); p != ""; p = ldap.ParentDN(p) {
+		if e, _ := d.get(tx, p); e != nil {
+			return e.DN
+		}
+	}
+	return ""
+}
+
+// noSuchObject returns the error for a missing entry dn.
+func (d *Directory) noSuchObject(tx *bolt.Tx, dn string) error {
+	return &ldap.Error{ResultCode: ldap.LDAPResultNoSuchObject, MatchedDN: d.matchedDN(tx, dn), DiagnosticMessage: "no such entry: " + dn}
+}
+
+// validate checks the new passwords of the entry e, the entry against the
+// schema of the directory, and that a modification of the entry old keeps
+// its structural object class and whether it is dynamic.
+func (d *Directory) validate(e, old *ldap.Entry) error {
+	if err := ldap.CheckPasswordQuality(d.PasswordQuality, e, old); err != nil {
+		return err
+	}
+	if old != nil && ldap.IsDynamic(e) != ldap.IsDynamic(old) {
+		// RFC 2589 section 3
+		return ldap.NewError(ldap.LDAPResultObjectClassModsProhibited, "can not change the dynamicObject object class")
+	}
+	if d.Schema == nil {
+		return nil
+	}
+	if err := d.Schema.ValidateEntry(e); err != nil {
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+	oc, _ := d.Schema.StructuralObjectClass(e)
+	if prev, err := d.Schema.StructuralObjectClass(old); err == nil && prev != oc {
+		return ldap.NewError(ldap.LDAPResultObjectClassModsProhibited, "can not change the structural object class "+prev.Name())
+	}
+	return nil
+}
+
+// hasChildren reports whether entries are below the entry with the key k.
+func hasChildren(tx *bolt.Tx, k []byte) bool {
+	prefix := append(append([]byte(nil), k...), 0)
+	next, _ := tx.Bucket(entriesBucket).Cursor().Seek(prefix)
+	return next != nil && bytes.HasPrefix(next, prefix)
+}
+
+// key returns the key of the entry dn, empty for the root DSE.
+func key(dn ldap.DN) []byte {
+	var b []byte
+	for i := len(dn) - 1; i >= 0; i-- {
+		b = append(b, ldap.DN{dn[i]}.Normalize()...)
+		if i > 0 {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+// indexKey returns the key of the index value of the entry with the key
+// k.
+func indexKey(value string, k []byte) []byte {
+	return append(append([]byte(value), 0), k...)
+}
+
+// indexValues returns the normalized values of the attribute name of e,
+// without the values invalid for its equality rule, which match no
+// equality filter.
+func indexValues(e *ldap.Entry, name string) []string {
+	var values []string
+	for _, v := range e.Get(name) {
+		if n, ok := ldap.NormalizeValue(name, v); ok {
+			values = append(values, n)
+		}
+	}
+	return values
+}
+
+func decodeEntry(v []byte) (*ldap.Entry, error) {
+	e := new(ldap.Entry)
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// addRDN adds the attribute values of the RDN of e, which must be present
+// in the entry.
+func addRDN(e *ldap.Entry) {
+	for _, a := range rdn(e.DN) {
+		if !e.Has(a.Type, a.Value) {
+			e.Add(a.Type, a.Value)
+		}
+	}
+}
+
+// rdn returns the RDN of dn, nil for an invalid or empty dn.
+func rdn(dn string) ldap.RDN {
+	d, err := ldap.ParseDN(dn)
+	if err != nil || len(d) == 0 {
+		return nil
+	}
+	return d[0]
+}
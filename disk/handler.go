@@ -0,0 +1,527 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	goldap "github.com/lor00x/goldap/message"
+	bolt "go.etcd.io/bbolt"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/password"
+)
+
+// backend is the ldap.Backend of a directory, a distinct type since the
+// Add method of Directory loads entries.
+type backend struct{ *Directory }
+
+// Bind checks a simple bind against the userPassword values of the entry,
+// see password.Verify for the supported hashes.
+func (d backend) Bind(ctx context.Context, dn, secret string) error {
+	var hashes []string
+	d.db.View(func(tx *bolt.Tx) error {
+		if e, _ := d.get(tx, dn); e != nil {
+			hashes = e.Get("userPassword")
+		}
+		return nil
+	})
+	for _, h := range hashes {
+		if ok, _ := password.Verify(h, secret); ok {
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, "")
+}
+
+// Search calls f with the entries of the search r, dereferencing the
+// aliases as requested by its derefAliases: the alias base of the search
+// is replaced by the entry it names, and the aliases found in searching by
+// the entries they name, with their subtree for a subtree search. The
+// aliases naming no entry or in a loop are skipped in searching.
+func (d backend) Search(ctx context.Context, r goldap.SearchRequest, f func(*ldap.Entry) error) error {
+	base, err := ldap.ParseDN(string(r.BaseObject()))
+	if err != nil {
+		return err
+	}
+	deref := int(r.DerefAliases())
+	match, err := ldap.NewSearchFilter(r)
+	if err != nil {
+		return err
+	}
+
+	s := &searcher{
+		Directory: d.Directory,
+		filter:    r.Filter(),
+		match:     match,
+		deref:     deref == ldap.DerefInSearching || deref == ldap.DerefAlways,
+		seen:      make(map[string]bool),
+		searched:  make(map[string]bool),
+	}
+	err = d.db.View(func(tx *bolt.Tx) error {
+		s.tx = tx
+		k := key(base)
+		v := tx.Bucket(entriesBucket).Get(k)
+		if len(k) > 0 && v == nil {
+			return d.noSuchObject(tx, string(r.BaseObject()))
+		}
+		if len(k) > 0 && (deref == ldap.DerefFindingBaseObj || deref == ldap.DerefAlways) {
+			e, err := decodeEntry(v)
+			if err != nil {
+				return err
+			}
+			target, err := ldap.DerefAlias(e, s.get)
+			if err != nil {
+				return err
+			}
+			dn, err := ldap.ParseDN(target.DN)
+			if err != nil {
+				return err
+			}
+			k = key(dn)
+		}
+		return s.search(k, int(r.Scope()))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range s.results {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aliasFilter selects the alias entries, with the objectClass index if
+// any.
+var aliasFilter = func() goldap.Filter {
+	r, _ := ldap.NewSearchRequest("", ldap.SearchRequestScopeBaseObject, "(objectClass=alias)")
+	return r.Filter()
+}()
+
+// searcher collects the entries of a search in a transaction.
+type searcher struct {
+	*Directory
+	tx       *bolt.Tx
+	filter   goldap.Filter // planned with the indexes
+	match    ldap.SearchFilter
+	deref    bool            // dereference the aliases in searching
+	seen     map[string]bool // keys of the results
+	searched map[string]bool // scopes and keys of the bases searched
+	results  []*ldap.Entry
+}
+
+func (s *searcher) get(dn string) (*ldap.Entry, error) {
+	return s.Directory.get(s.tx, dn)
+}
+
+// search collects the entries in the scope of the base with the key k, and
+// those in the scopes of the entries named by the aliases found.
+func (s *searcher) search(k []byte, scope int) error {
+	if s.searched[strconv.Itoa(scope)+string(k)] {
+		return nil
+	}
+	s.searched[strconv.Itoa(scope)+string(k)] = true
+	match := func(ck, v []byte) error {
+		if s.seen[string(ck)] {
+			return nil
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		if s.deref && !bytes.Equal(ck, k) && ldap.IsAlias(e) {
+			return nil
+		}
+		ok, err := s.match.Match(*e)
+		if ok {
+			s.seen[string(ck)] = true
+			s.results = append(s.results, e)
+		}
+		return err
+	}
+	if err := s.scan(k, scope, s.filter, match); err != nil {
+		return err
+	}
+	if !s.deref || scope == ldap.SearchRequestScopeBaseObject {
+		return nil
+	}
+
+	var aliases []*ldap.Entry
+	err := s.scan(k, scope, aliasFilter, func(ck, v []byte) error {
+		e, err := decodeEntry(v)
+		if err == nil && !bytes.Equal(ck, k) && ldap.IsAlias(e) {
+			aliases = append(aliases, e)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if scope == ldap.SearchRequestSingleLevel {
+		scope = ldap.SearchRequestScopeBaseObject
+	}
+	for _, a := range aliases {
+		target, err := ldap.DerefAlias(a, s.get)
+		if err != nil {
+			continue
+		}
+		dn, err := ldap.ParseDN(target.DN)
+		if err != nil {
+			continue
+		}
+		if err := s.search(key(dn), scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls f with the entries in the scope of the search of the base
+// with the key k which may match the filter, the candidates of the indexes
+// or all of them.
+func (s *searcher) scan(k []byte, scope int, filter goldap.Filter, f func(k, v []byte) error) error {
+	candidates, ok := s.candidates(s.tx, filter)
+	if !ok {
+		return scan(s.tx, k, scope, f)
+	}
+	entries := s.tx.Bucket(entriesBucket)
+	for _, c := range candidates {
+		if inScope(c, k, scope) {
+			if err := f(c, entries.Get(c)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan calls f with the entries in the scope of the search of the base
+// with the key k, in key order, parents before their children.
+func scan(tx *bolt.Tx, k []byte, scope int, f func(k, v []byte) error) error {
+	c := tx.Bucket(entriesBucket).Cursor()
+	if scope == ldap.SearchRequestScopeBaseObject {
+		if len(k) == 0 {
+			return nil // the root DSE is not stored
+		}
+		return f(k, c.Bucket().Get(k))
+	}
+	var prefix []byte
+	if len(k) > 0 {
+		prefix = append(append([]byte(nil), k...), 0)
+		if scope == ldap.SearchRequestHomeSubtree {
+			if err := f(k, c.Bucket().Get(k)); err != nil {
+				return err
+			}
+		}
+	}
+	for ck, v := c.Seek(prefix); ck != nil && bytes.HasPrefix(ck, prefix); ck, v = c.Next() {
+		if inScope(ck, k, scope) {
+			if err := f(ck, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inScope reports whether the entry with the key k is in the scope of the
+// search of the base with the key base.
+func inScope(k, base []byte, scope int) bool {
+	rest := k
+	if len(base) > 0 {
+		if !bytes.HasPrefix(k, base) {
+			return false
+		}
+		rest = k[len(base):]
+		if len(rest) > 0 {
+			if rest[0] != 0 {
+				return false
+			}
+			rest = rest[1:]
+		}
+	}
+	switch scope {
+	case ldap.SearchRequestScopeBaseObject:
+		return len(rest) == 0 && len(base) > 0
+	case ldap.SearchRequestSingleLevel:
+		return len(rest) > 0 && bytes.IndexByte(rest, 0) < 0
+	case ldap.SearchRequestHomeSubtree:
+		return len(k) > 0
+	}
+	return false
+}
+
+// candidates returns the keys, sorted, of the entries which may match the
+// filter according to the indexes, ok being false when they can not tell:
+// equality filters of indexed attributes, and the and filters with one of
+// them, or the or filters of them.
+func (d *Directory) candidates(tx *bolt.Tx, f goldap.Filter) (keys [][]byte, ok bool) {
+	switch f := f.(type) {
+	case goldap.FilterEqualityMatch:
+		name := strings.ToLower(string(f.AttributeDesc()))
+		if !d.indexes[name] {
+			return nil, false
+		}
+		value, valid := ldap.NormalizeValue(name, string(f.AssertionValue()))
+		if !valid {
+			return nil, true
+		}
+		prefix := append([]byte(value), 0)
+		c := tx.Bucket(indexesBucket).Bucket([]byte(name)).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k[len(prefix):]...))
+		}
+		sortKeys(keys)
+		return keys, true
+	case goldap.FilterAnd:
+		for _, sub := range f {
+			if keys, ok := d.candidates(tx, sub); ok {
+				return keys, true
+			}
+		}
+	case goldap.FilterOr:
+		seen := make(map[string]bool)
+		for _, sub := range f {
+			subKeys, ok := d.candidates(tx, sub)
+			if !ok {
+				return nil, false
+			}
+			for _, k := range subKeys {
+				if !seen[string(k)] {
+					seen[string(k)] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		sortKeys(keys)
+		return keys, true
+	}
+	return nil, false
+}
+
+func sortKeys(keys [][]byte) {
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+}
+
+func (d backend) Add(ctx context.Context, e *ldap.Entry) error {
+	e = e.Clone()
+	e.DN = strings.TrimSpace(e.DN)
+	addRDN(e)
+	ldap.SetOperationalAttributes(ctx, e, nil, time.Now())
+	if err := d.validate(e, nil); err != nil {
+		return err
+	}
+	return d.insert(e)
+}
+
+// insert adds the entry of an add request, below an existing parent.
+func (d *Directory) insert(e *ldap.Entry) error {
+	dn, err := ldap.ParseDN(e.DN)
+	if err != nil {
+		return err
+	}
+	if len(dn) == 0 {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, "empty DN")
+	}
+	return d.write(func(tx *bolt.Tx) error {
+		k := key(dn)
+		entries := tx.Bucket(entriesBucket)
+		if entries.Get(k) != nil {
+			return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, e.DN)
+		}
+		if parent := dn.Parent(); parent != nil && entries.Get(key(parent)) == nil {
+			return d.noSuchObject(tx, parent.String())
+		}
+		d.notify(tx, ldap.ChangeEvent{Type: ldap.ChangeAdd, DN: e.DN, Entry: e})
+		return d.put(tx, k, e)
+	})
+}
+
+func (d backend) Delete(ctx context.Context, dn string) error {
+	return d.removeEntry(dn)
+}
+
+// removeEntry deletes the leaf entry dn.
+func (d *Directory) removeEntry(dn string) error {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return err
+	}
+	return d.write(func(tx *bolt.Tx) error {
+		k := key(parsed)
+		v := tx.Bucket(entriesBucket).Get(k)
+		if len(k) == 0 || v == nil {
+			return d.noSuchObject(tx, dn)
+		}
+		if hasChildren(tx, k) {
+			return ldap.NewError(ldap.LDAPResultNotAllowedOnNonLeaf, dn)
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		d.notify(tx, ldap.ChangeEvent{Type: ldap.ChangeDelete, DN: e.DN, Old: e})
+		return d.remove(tx, k)
+	})
+}
+
+func (d backend) Modify(ctx context.Context, dn string, changes []ldap.Modification) error {
+	return d.update(ctx, dn, func(e *ldap.Entry) error { return e.Modify(changes...) })
+}
+
+// update applies the changes of a modify request to the entry dn, which
+// is stored once they all succeed.
+func (d *Directory) update(ctx context.Context, dn string, apply func(*ldap.Entry) error) error {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return err
+	}
+	return d.write(func(tx *bolt.Tx) error {
+		k := key(parsed)
+		v := tx.Bucket(entriesBucket).Get(k)
+		if len(k) == 0 || v == nil {
+			return d.noSuchObject(tx, dn)
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		c := e.Clone()
+		if err := apply(c); err != nil {
+			return err
+		}
+		ldap.SetOperationalAttributes(ctx, c, e, time.Now())
+		for _, a := range rdn(c.DN) {
+			if !c.Has(a.Type, a.Value) {
+				return ldap.NewError(ldap.LDAPResultNotAllowedOnRDN, "can not remove the RDN value of "+a.Type)
+			}
+		}
+		if err := d.validate(c, e); err != nil {
+			return err
+		}
+		d.notify(tx, ldap.ChangeEvent{Type: ldap.ChangeModify, DN: c.DN, Entry: c, Old: e})
+		return d.put(tx, k, c)
+	})
+}
+
+func (d backend) ModifyDN(ctx context.Context, r ldap.ModifyDNRequest) error {
+	return d.move(ctx, r)
+}
+
+// move renames an entry or moves it under a new superior, with the
+// entries below it.
+func (d *Directory) move(ctx context.Context, r ldap.ModifyDNRequest) error {
+	dn, err := ldap.ParseDN(r.Entry)
+	if err != nil {
+		return err
+	}
+	newDN, err := ldap.ParseDN(r.NewDN())
+	if err != nil {
+		return err
+	}
+	return d.write(func(tx *bolt.Tx) error {
+		k, newKey := key(dn), key(newDN)
+		entries := tx.Bucket(entriesBucket)
+		v := entries.Get(k)
+		if len(k) == 0 || v == nil {
+			return d.noSuchObject(tx, r.Entry)
+		}
+		if entries.Get(newKey) != nil && !bytes.Equal(newKey, k) {
+			return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, r.NewDN())
+		}
+		if r.NewSuperior != nil {
+			superior, err := ldap.ParseDN(*r.NewSuperior)
+			if err != nil {
+				return err
+			}
+			if len(superior) > 0 && entries.Get(key(superior)) == nil {
+				return d.noSuchObject(tx, *r.NewSuperior)
+			}
+			if superior.InScope(dn, ldap.SearchRequestHomeSubtree) {
+				return ldap.NewError(ldap.LDAPResultUnwillingToPerform, "can not move an entry below itself")
+			}
+		}
+
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		c := e.Clone()
+		c.DN = r.NewDN()
+		if r.DeleteOldRDN {
+			for _, a := range rdn(e.DN) {
+				if c.Has(a.Type, a.Value) {
+					c.Delete(a.Type, a.Value)
+				}
+			}
+		}
+		addRDN(c)
+		ldap.SetOperationalAttributes(ctx, c, e, time.Now())
+		if err := d.validate(c, nil); err != nil {
+			return err
+		}
+
+		moved := map[string]*ldap.Entry{string(newKey): c}
+		d.notify(tx, ldap.ChangeEvent{Type: ldap.ChangeModDN, DN: c.DN, OldDN: e.DN, Entry: c, Old: e})
+		var old [][]byte
+		err = scan(tx, k, ldap.SearchRequestHomeSubtree, func(ck, v []byte) error {
+			old = append(old, append([]byte(nil), ck...))
+			if bytes.Equal(ck, k) {
+				return nil
+			}
+			prev, err := decodeEntry(v)
+			if err != nil {
+				return err
+			}
+			childDN, err := ldap.ParseDN(prev.DN)
+			if err != nil {
+				return err
+			}
+			childDN = append(childDN[:len(childDN)-len(dn):len(childDN)-len(dn)], newDN...)
+			child := prev.Clone()
+			child.DN = childDN.String()
+			child.Replace("entryDN", child.DN)
+			moved[string(key(childDN))] = child
+			d.notify(tx, ldap.ChangeEvent{Type: ldap.ChangeModDN, DN: child.DN, OldDN: prev.DN, Entry: child, Old: prev})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, oldKey := range old {
+			if err := d.remove(tx, oldKey); err != nil {
+				return err
+			}
+		}
+		for nk, e := range moved {
+			if err := d.put(tx, []byte(nk), e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Compare reports whether the attribute name of the entry dn has the
+// value, with the equality rule of the attribute in the schema of the
+// directory, see ldap.CompareEntry.
+func (d backend) Compare(ctx context.Context, dn, name, value string) (has bool, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		e, err := d.get(tx, dn)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return d.noSuchObject(tx, dn)
+		}
+		has, err = ldap.CompareEntry(e, name, value, d.Schema)
+		return err
+	})
+	return has, err
+}
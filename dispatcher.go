@@ -0,0 +1,177 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultNotificationQueueSize is the number of events buffered for a
+// subscription when Server.NotificationQueueSize is zero.
+const DefaultNotificationQueueSize = 64
+
+var (
+	// ErrSubscriptionOverflow is reported by Subscription.Err when events
+	// were published faster than the connection consumed them.
+	ErrSubscriptionOverflow = errors.New("ldapserver: subscription queue overflow")
+
+	// ErrTooManySubscriptions is returned by Dispatcher.Subscribe when the
+	// connection already holds Server.MaxSubscriptionsPerConn subscriptions.
+	ErrTooManySubscriptions = errors.New("ldapserver: too many subscriptions on connection")
+)
+
+// Event is a change published through the server Dispatcher, for example
+// by a backend after it modified an entry.
+type Event struct {
+	Type string // kind of change, e.g. "add", "delete", "modify", "moddn"
+	DN   string // DN of the entry the event is about
+	Data any    // publisher specific payload
+}
+
+// Dispatcher fans events published by handlers and backends out to
+// interested connections (persistent searches, sync providers, custom
+// extended subscriptions). Use Server.Dispatcher to get the server's
+// instance.
+type Dispatcher struct {
+	srv  *Server
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives the events matching its interest. It is closed
+// automatically when the request context is done, which happens when the
+// request is abandoned, the client unbinds or the connection drops.
+type Subscription struct {
+	d      *Dispatcher
+	client *client
+	match  func(Event) bool
+	ch     chan Event
+	err    error
+	closed bool
+}
+
+// Dispatcher returns the server's event dispatcher.
+func (s *Server) Dispatcher() *Dispatcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dispatcher == nil {
+		s.dispatcher = &Dispatcher{srv: s, subs: make(map[*Subscription]struct{})}
+	}
+	return s.dispatcher
+}
+
+// Subscribe registers interest in events on behalf of the request m. match
+// selects the events to deliver, nil means every event. ctx is normally the
+// handler's context.
+func (d *Dispatcher) Subscribe(ctx context.Context, m *Message, match func(Event) bool) (*Subscription, error) {
+	size := 0
+	if d.srv != nil {
+		size = d.srv.NotificationQueueSize
+	}
+	return d.subscribe(ctx, m, match, size)
+}
+
+// subscribe is Subscribe with a queue of size events,
+// DefaultNotificationQueueSize if zero.
+func (d *Dispatcher) subscribe(ctx context.Context, m *Message, match func(Event) bool, size int) (*Subscription, error) {
+	if size <= 0 {
+		size = DefaultNotificationQueueSize
+	}
+	sub := &Subscription{
+		d:     d,
+		match: match,
+		ch:    make(chan Event, size),
+	}
+
+	if m != nil && m.Client != nil {
+		c := m.Client
+		c.Lock()
+		if max := d.maxPerConn(); max > 0 && c.subscriptions >= max {
+			c.Unlock()
+			return nil, ErrTooManySubscriptions
+		}
+		c.subscriptions++
+		c.Unlock()
+		sub.client = c
+	}
+
+	d.mu.Lock()
+	if d.subs == nil {
+		d.subs = make(map[*Subscription]struct{})
+	}
+	d.subs[sub] = struct{}{}
+	d.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+	return sub, nil
+}
+
+// maxPerConn returns the Server.MaxSubscriptionsPerConn of d, zero for a
+// dispatcher without a server.
+func (d *Dispatcher) maxPerConn() int {
+	if d.srv == nil {
+		return 0
+	}
+	return d.srv.MaxSubscriptionsPerConn
+}
+
+// Publish delivers e to every matching subscription without blocking. A
+// subscription whose queue is full is closed with ErrSubscriptionOverflow.
+// It returns the number of subscriptions the event was queued for.
+func (d *Dispatcher) Publish(e Event) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for sub := range d.subs {
+		if sub.match != nil && !sub.match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+			n++
+		default:
+			sub.closeLocked(ErrSubscriptionOverflow)
+		}
+	}
+	return n
+}
+
+// Events returns the channel events are delivered on. It is closed when
+// the subscription ends.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.ch
+}
+
+// Err returns the reason the subscription ended abnormally, if any.
+func (sub *Subscription) Err() error {
+	sub.d.mu.Lock()
+	defer sub.d.mu.Unlock()
+	return sub.err
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (sub *Subscription) Close() {
+	sub.d.mu.Lock()
+	sub.closeLocked(nil)
+	sub.d.mu.Unlock()
+}
+
+func (sub *Subscription) closeLocked(err error) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	sub.err = err
+	delete(sub.d.subs, sub)
+	close(sub.ch)
+
+	if sub.client != nil {
+		sub.client.Lock()
+		sub.client.subscriptions--
+		sub.client.Unlock()
+	}
+}
@@ -0,0 +1,328 @@
+package ldapserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DN is a distinguished name (RFC 4514) parsed by ParseDN: its RDNs from
+// the entry up to the root of the tree. The empty DN names the root DSE.
+type DN []RDN
+
+// RDN is a relative distinguished name: one attribute value, or more for
+// a multi-valued RDN such as "cn=John+uid=jdoe".
+type RDN []AttributeTypeAndValue
+
+// AttributeTypeAndValue is an attribute value of an RDN, unescaped.
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+// ParseDN parses the string representation of a DN (RFC 4514). It accepts
+// the spaces around the separators and the ";" separator of RFC 2253, and
+// fails with an *Error with the invalidDNSyntax result code.
+func ParseDN(s string) (DN, error) {
+	var dn DN
+	var rdn RDN
+	p := dnParser{s: s}
+	p.skipSpaces()
+	if p.done() {
+		return nil, nil
+	}
+	for {
+		ava, err := p.attributeTypeAndValue()
+		if err != nil {
+			return nil, err
+		}
+		rdn = append(rdn, ava)
+		if p.done() {
+			return append(dn, rdn), nil
+		}
+		switch p.s[p.i] {
+		case '+':
+		case ',', ';':
+			dn = append(dn, rdn)
+			rdn = nil
+		default:
+			return nil, p.errorf("unexpected %q", p.s[p.i])
+		}
+		p.i++
+		p.skipSpaces()
+	}
+}
+
+// dnParser is the state of ParseDN.
+type dnParser struct {
+	s string
+	i int
+}
+
+func (p *dnParser) done() bool {
+	return p.i == len(p.s)
+}
+
+func (p *dnParser) skipSpaces() {
+	for !p.done() && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *dnParser) errorf(reason string, args ...any) error {
+	return NewError(LDAPResultInvalidDNSyntax, fmt.Sprintf("invalid DN %q: ", p.s)+fmt.Sprintf(reason, args...))
+}
+
+// attributeTypeAndValue parses "type=value" and the spaces after it.
+func (p *dnParser) attributeTypeAndValue() (AttributeTypeAndValue, error) {
+	start := p.i
+	for !p.done() && p.s[p.i] != '=' && p.s[p.i] != ' ' {
+		if c := p.s[p.i]; !isAlnum(c) && c != '-' && c != '.' {
+			return AttributeTypeAndValue{}, p.errorf("invalid attribute type")
+		}
+		p.i++
+	}
+	attrType := p.s[start:p.i]
+	p.skipSpaces()
+	if attrType == "" {
+		return AttributeTypeAndValue{}, p.errorf("missing attribute type")
+	}
+	if p.done() || p.s[p.i] != '=' {
+		return AttributeTypeAndValue{}, p.errorf("missing '=' after %s", attrType)
+	}
+	if len(attrType) > 4 && strings.EqualFold(attrType[:4], "oid.") {
+		attrType = attrType[4:]
+	}
+	p.i++
+	p.skipSpaces()
+
+	if !p.done() && p.s[p.i] == '#' {
+		value, err := p.hexValue()
+		return AttributeTypeAndValue{Type: attrType, Value: value}, err
+	}
+	var b strings.Builder
+	keep := 0 // length of the value without its unescaped trailing spaces
+	for ; !p.done(); p.i++ {
+		c := p.s[p.i]
+		if c == ',' || c == ';' || c == '+' {
+			break
+		}
+		if c == '\\' {
+			c, err := p.escape()
+			if err != nil {
+				return AttributeTypeAndValue{}, err
+			}
+			b.WriteByte(c)
+			keep = b.Len()
+			continue
+		}
+		b.WriteByte(c)
+		if c != ' ' {
+			keep = b.Len()
+		}
+	}
+	return AttributeTypeAndValue{Type: attrType, Value: b.String()[:keep]}, nil
+}
+
+// escape returns the byte escaped at p.i, "\c" or "\XX", leaving p.i on
+// its last character.
+func (p *dnParser) escape() (byte, error) {
+	if p.i+1 == len(p.s) {
+		return 0, p.errorf("trailing backslash")
+	}
+	if p.i+2 < len(p.s) {
+		if c, err := hex.DecodeString(p.s[p.i+1 : p.i+3]); err == nil {
+			p.i += 2
+			return c[0], nil
+		}
+	}
+	p.i++
+	if c := p.s[p.i]; strings.IndexByte(dnSpecial+"=", c) < 0 {
+		return 0, p.errorf("invalid escape \\%c", c)
+	}
+	return p.s[p.i], nil
+}
+
+// hexValue parses "#" followed by the BER encoding of a value in hex,
+// returning the content of the encoded string.
+func (p *dnParser) hexValue() (string, error) {
+	p.i++
+	start := p.i
+	for !p.done() && isHex(p.s[p.i]) {
+		p.i++
+	}
+	b, err := hex.DecodeString(p.s[start:p.i])
+	if err != nil || len(b) == 0 {
+		return "", p.errorf("invalid hex value")
+	}
+	p.skipSpaces()
+	if e, err := berParseAll(b); err == nil && !e.constructed {
+		return string(e.value), nil
+	}
+	return string(b), nil
+}
+
+// dnSpecial are the characters escaped in the values of a DN.
+const dnSpecial = "\"+,;<>\\#= "
+
+// String returns the string representation of the DN, its values escaped
+// as needed (RFC 4514 section 2.4).
+func (d DN) String() string {
+	rdns := make([]string, len(d))
+	for i, rdn := range d {
+		rdns[i] = rdn.String()
+	}
+	return strings.Join(rdns, ",")
+}
+
+// String returns the string representation of the RDN.
+func (r RDN) String() string {
+	avas := make([]string, len(r))
+	for i, ava := range r {
+		avas[i] = ava.Type + "=" + escapeDNValue(ava.Value)
+	}
+	return strings.Join(avas, "+")
+}
+
+// escapeDNValue escapes the characters of a value which can not appear as
+// is in a DN.
+func escapeDNValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+			continue
+		case c == ' ':
+			if i == 0 || i == len(v)-1 {
+				b.WriteByte('\\')
+			}
+		case c == '#':
+			if i == 0 {
+				b.WriteByte('\\')
+			}
+		case c != '=' && strings.IndexByte(dnSpecial, c) >= 0:
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Normalize returns the normalized form of the DN, equal for the equal
+// DNs: the attribute types lower cased, the values normalized with the
+// equality rule of their attribute, case insensitively for most, and
+// the values of the multi-valued RDNs sorted.
+func (d DN) Normalize() string {
+	rdns := make([]string, len(d))
+	for i, rdn := range d {
+		rdns[i] = rdn.normalize()
+	}
+	return strings.Join(rdns, ",")
+}
+
+func (r RDN) normalize() string {
+	avas := make([]string, len(r))
+	for i, ava := range r {
+		attrType := strings.ToLower(ava.Type)
+		value, ok := attributeRule(attrType).normalize(ava.Value)
+		if !ok {
+			value = strings.ToLower(ava.Value)
+		}
+		avas[i] = attrType + "=" + escapeDNValue(value)
+	}
+	sort.Strings(avas)
+	return strings.Join(avas, "+")
+}
+
+// Equal reports whether the DNs are equal, see Normalize.
+func (d DN) Equal(other DN) bool {
+	if len(d) != len(other) {
+		return false
+	}
+	for i := range d {
+		if d[i].normalize() != other[i].normalize() {
+			return false
+		}
+	}
+	return true
+}
+
+// Parent returns the DN of the parent of the entry, nil for a top level
+// entry or the root DSE.
+func (d DN) Parent() DN {
+	if len(d) < 2 {
+		return nil
+	}
+	return d[1:]
+}
+
+// InScope reports whether the DN is in the scope of a search of base:
+// base itself for SearchRequestScopeBaseObject, its children for
+// SearchRequestSingleLevel, base and all the entries below it for
+// SearchRequestHomeSubtree.
+func (d DN) InScope(base DN, scope int) bool {
+	if len(d) < len(base) || !d[len(d)-len(base):].Equal(base) {
+		return false
+	}
+	switch scope {
+	case SearchRequestScopeBaseObject:
+		return len(d) == len(base)
+	case SearchRequestSingleLevel:
+		return len(d) == len(base)+1
+	case SearchRequestHomeSubtree:
+		return true
+	}
+	return false
+}
+
+// NormalizeDN returns the normalized form of dn, see DN.Normalize, for
+// example to index entries by DN. An invalid dn is only lower cased.
+func NormalizeDN(dn string) string {
+	d, err := ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+	return d.Normalize()
+}
+
+// EqualDN reports whether the DNs a and b are equal, see DN.Normalize.
+func EqualDN(a, b string) bool {
+	return NormalizeDN(a) == NormalizeDN(b)
+}
+
+// ParentDN returns the DN of the parent of dn, "" for a top level entry
+// or an invalid dn.
+func ParentDN(dn string) string {
+	d, err := ParseDN(dn)
+	if err != nil {
+		return ""
+	}
+	return d.Parent().String()
+}
+
+// InScope reports whether dn is in the scope of a search of base, see
+// DN.InScope. It is false when one of the DNs is invalid.
+//
+//	ldap.InScope("uid=jdoe,ou=people,dc=example,dc=com", "DC=Example,DC=Com", ldap.SearchRequestHomeSubtree) // true
+func InScope(dn, base string, scope int) bool {
+	d, err := ParseDN(dn)
+	if err != nil {
+		return false
+	}
+	b, err := ParseDN(base)
+	if err != nil {
+		return false
+	}
+	return d.InScope(b, scope)
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
@@ -0,0 +1,302 @@
+package ldapserver
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Default time to live bounds of dynamic entries (RFC 2589 section 5).
+const (
+	DefaultDynamicMaxTTL = 24 * time.Hour
+	DefaultDynamicMinTTL = time.Minute
+)
+
+// DynamicEntries tracks the time to live of dynamic entries (RFC 2589),
+// entries of the objectClass dynamicObject that disappear unless clients
+// refresh them, as in presence and service registration directories.
+// Backends Register the dynamic entries they add; their Handler answers
+// the Refresh extended operation and returns the entryTtl attribute in
+// search results; Expire is called when an entry was not refreshed in
+// time. The inmem and disk directories track their dynamic entries.
+type DynamicEntries struct {
+	MaxTTL time.Duration // DefaultDynamicMaxTTL if zero
+	MinTTL time.Duration // DefaultDynamicMinTTL if zero
+
+	// Expire deletes the expired entry dn from the backend.
+	Expire func(dn string)
+
+	// Exists reports whether the backend has the entry dn, for the
+	// Refresh of an entry which is not dynamic to fail with
+	// objectClassViolation rather than noSuchObject. nil reports that no
+	// entry exists.
+	Exists func(dn string) bool
+
+	// Allowed reports whether the session of ctx may refresh the entry
+	// dn, nil allows everyone.
+	Allowed func(ctx context.Context, dn string) bool
+
+	mu      sync.Mutex
+	entries map[string]*dynamicEntry // by normalized DN
+	queue   dynamicQueue
+	timer   *time.Timer
+}
+
+type dynamicEntry struct {
+	dn      string
+	expires time.Time
+	index   int // in the queue
+}
+
+// dynamicQueue orders the dynamic entries by expiration.
+type dynamicQueue []*dynamicEntry
+
+func (q dynamicQueue) Len() int           { return len(q) }
+func (q dynamicQueue) Less(i, j int) bool { return q[i].expires.Before(q[j].expires) }
+func (q dynamicQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *dynamicQueue) Push(x any) {
+	e := x.(*dynamicEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+func (q *dynamicQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+func (d *DynamicEntries) bounds(ttl time.Duration) time.Duration {
+	maxTTL, minTTL := d.MaxTTL, d.MinTTL
+	if maxTTL <= 0 {
+		maxTTL = DefaultDynamicMaxTTL
+	}
+	if minTTL <= 0 {
+		minTTL = DefaultDynamicMinTTL
+	}
+	return min(max(ttl, minTTL), maxTTL)
+}
+
+// Register starts tracking the dynamic entry dn with a time to live,
+// bounded by MinTTL and MaxTTL. It returns the granted time to live.
+func (d *DynamicEntries) Register(dn string, ttl time.Duration) time.Duration {
+	ttl = d.bounds(ttl)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries == nil {
+		d.entries = make(map[string]*dynamicEntry)
+	}
+	key := NormalizeDN(dn)
+	if e, ok := d.entries[key]; ok {
+		e.expires = time.Now().Add(ttl)
+		heap.Fix(&d.queue, e.index)
+	} else {
+		e := &dynamicEntry{dn: dn, expires: time.Now().Add(ttl)}
+		d.entries[key] = e
+		heap.Push(&d.queue, e)
+	}
+	d.schedule()
+	return ttl
+}
+
+// Add starts tracking the dynamic entry dn added to the backend, with
+// MaxTTL as time to live until it is refreshed. It returns the granted
+// time to live.
+func (d *DynamicEntries) Add(dn string) time.Duration {
+	return d.Register(dn, d.bounds(math.MaxInt64))
+}
+
+// Rename tracks the dynamic entry oldDN, renamed or moved, as newDN,
+// keeping its time to live.
+func (d *DynamicEntries) Rename(oldDN, newDN string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := NormalizeDN(oldDN)
+	if e, ok := d.entries[key]; ok {
+		delete(d.entries, key)
+		e.dn = newDN
+		d.entries[NormalizeDN(newDN)] = e
+	}
+}
+
+// Remove stops tracking the entry dn, when it is deleted.
+func (d *DynamicEntries) Remove(dn string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[NormalizeDN(dn)]; ok {
+		heap.Remove(&d.queue, e.index)
+		delete(d.entries, NormalizeDN(dn))
+		d.schedule()
+	}
+}
+
+// TTL returns the time left before the dynamic entry dn expires, the
+// value of its entryTtl attribute.
+func (d *DynamicEntries) TTL(dn string) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[NormalizeDN(dn)]
+	if !ok {
+		return 0, false
+	}
+	return max(time.Until(e.expires), 0), true
+}
+
+// Refresh extends the time to live of the dynamic entry dn, as the
+// Refresh extended operation does.
+func (d *DynamicEntries) Refresh(dn string, ttl time.Duration) (time.Duration, bool) {
+	if _, ok := d.TTL(dn); !ok {
+		return 0, false
+	}
+	return d.Register(dn, ttl), true
+}
+
+// schedule arms the timer for the next expiration, d.mu held.
+func (d *DynamicEntries) schedule() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if len(d.queue) == 0 {
+		return
+	}
+	d.timer = time.AfterFunc(time.Until(d.queue[0].expires), d.expire)
+}
+
+// expire removes the expired entries and reports them to Expire.
+func (d *DynamicEntries) expire() {
+	var expired []string
+	d.mu.Lock()
+	now := time.Now()
+	for len(d.queue) > 0 && !d.queue[0].expires.After(now) {
+		e := heap.Pop(&d.queue).(*dynamicEntry)
+		delete(d.entries, NormalizeDN(e.dn))
+		expired = append(expired, e.dn)
+	}
+	d.schedule()
+	d.mu.Unlock()
+
+	if d.Expire != nil {
+		for _, dn := range expired {
+			d.Expire(dn)
+		}
+	}
+}
+
+// Handler returns a handler answering the Refresh extended operation and
+// adding the entryTtl attribute to the dynamic entries of search results
+// when it is requested, and passing the requests to next.
+func (d *DynamicEntries) Handler(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		switch r := m.ProtocolOp().(type) {
+		case ldap.ExtendedRequest:
+			if r.RequestName() == NoticeOfRefresh {
+				d.serveRefresh(ctx, w, r)
+				return
+			}
+		case ldap.SearchRequest:
+			for _, a := range r.Attributes() {
+				if strings.EqualFold(string(a), "entryTtl") || string(a) == "+" {
+					w = &dynamicResponseWriter{ResponseWriter: w, d: d}
+					break
+				}
+			}
+		}
+		next.ServeLDAP(ctx, w, m)
+	})
+}
+
+func (d *DynamicEntries) serveRefresh(ctx context.Context, w ResponseWriter, r ldap.ExtendedRequest) {
+	fail := func(code int, diagnostic string) {
+		res := NewExtendedResponse(code)
+		res.SetResponseName(NoticeOfRefresh)
+		res.SetDiagnosticMessage(diagnostic)
+		w.Write(res)
+	}
+	dn, ttl, err := decodeRefreshRequest(r.RequestValue())
+	if err != nil {
+		fail(LDAPResultProtocolError, err.Error())
+		return
+	}
+	if d.Allowed != nil && !d.Allowed(ctx, dn) {
+		fail(LDAPResultInsufficientAccessRights, "refresh not allowed")
+		return
+	}
+	granted, ok := d.Refresh(dn, time.Duration(ttl)*time.Second)
+	switch {
+	case ok:
+	case d.Exists != nil && d.Exists(dn):
+		// RFC 2589 section 4.2: the entry is not a dynamic object
+		fail(LDAPResultObjectClassViolation, "not a dynamic entry")
+		return
+	default:
+		fail(LDAPResultNoSuchObject, "no such entry")
+		return
+	}
+	// responseTtl [11] INTEGER takes the place of the responseValue
+	seconds := int64(granted / time.Second)
+	w.Write(NewExtendedResponseValue(LDAPResultSuccess, NoticeOfRefresh, berIntegerContent(seconds)))
+}
+
+// IsDynamic reports whether the entry e is of the objectClass
+// dynamicObject.
+func IsDynamic(e *Entry) bool {
+	for _, oc := range e.Get("objectClass") {
+		if strings.EqualFold(oc, "dynamicObject") {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeRefreshRequest(value *ldap.OCTETSTRING) (dn string, ttl int64, err error) {
+	// SEQUENCE { entryName [0] LDAPDN, requestTtl [1] INTEGER }
+	if value == nil {
+		return "", 0, errors.New("missing Refresh request value")
+	}
+	seq, err := berParseAll([]byte(*value))
+	if err != nil {
+		return "", 0, err
+	}
+	fields, err := seq.children()
+	if err != nil || len(fields) != 2 || !fields[0].is(berClassContext, 0) || !fields[1].is(berClassContext, 1) {
+		return "", 0, errors.New("malformed Refresh request value")
+	}
+	ttl, err = fields[1].int()
+	if err != nil || ttl < 0 {
+		return "", 0, errors.New("invalid Refresh requestTtl")
+	}
+	return string(fields[0].value), ttl, nil
+}
+
+// dynamicResponseWriter adds the entryTtl attribute to the dynamic entries
+// of search results.
+type dynamicResponseWriter struct {
+	ResponseWriter
+	d *DynamicEntries
+}
+
+func (w *dynamicResponseWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *dynamicResponseWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if e, ok := po.(ldap.SearchResultEntry); ok {
+		dn, _, err := decodeSearchResultEntry(e)
+		if ttl, dynamic := w.d.TTL(dn); err == nil && dynamic {
+			e.AddAttribute("entryTtl", ldap.AttributeValue(strconv.FormatInt(int64(ttl/time.Second), 10)))
+			po = e
+		}
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
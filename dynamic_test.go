@@ -0,0 +1,37 @@
+package ldapserver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRefreshResultCodes(t *testing.T) {
+	d := &DynamicEntries{Exists: func(dn string) bool { return NormalizeDN(dn) == "dc=example,dc=com" }}
+	d.Add("cn=dyn,dc=example,dc=com")
+	defer d.Remove("cn=dyn,dc=example,dc=com")
+	next := HandlerFunc(func(context.Context, ResponseWriter, *Message) { t.Error("the Refresh request was passed on") })
+
+	for _, tt := range []struct {
+		dn   string
+		code int
+	}{
+		{"cn=dyn,dc=example,dc=com", LDAPResultSuccess},
+		{"dc=example,dc=com", LDAPResultObjectClassViolation},
+		{"cn=none,dc=example,dc=com", LDAPResultNoSuchObject},
+	} {
+		value := berSequence(berEncode(berClassContext, false, 0, []byte(tt.dn)), berEncode(berClassContext, false, 1, berIntegerContent(60)))
+		r, err := NewExtendedRequest(NoticeOfRefresh, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, err := newResponseMessage(1, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &recorder{}
+		d.Handler(next).ServeLDAP(context.Background(), w, &Message{LDAPMessage: message})
+		if code, _ := ResultCode(w.responses[0]); code != tt.code {
+			t.Errorf("refresh of %s: result %d, want %d", tt.dn, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package ldapserver
+
+import (
+	"sort"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Entry is a directory entry: its DN and its attributes, for the backends
+// of a server. Attribute names are compared case insensitively, and values
+// with the equality rule of the attribute, see MatchFilter.
+type Entry struct {
+	DN         string
+	Attributes []EntryAttribute
+}
+
+// EntryAttribute is an attribute of an Entry with its values.
+type EntryAttribute struct {
+	Name   string
+	Values []string
+}
+
+// NewEntry returns the entry dn with the attributes, sorted by name.
+func NewEntry(dn string, attributes map[string][]string) *Entry {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	e := &Entry{DN: dn, Attributes: make([]EntryAttribute, len(names))}
+	for i, name := range names {
+		e.Attributes[i] = EntryAttribute{Name: name, Values: attributes[name]}
+	}
+	return e
+}
+
+// EntryFromSearchResult returns the entry of a search result.
+func EntryFromSearchResult(r ldap.SearchResultEntry) (*Entry, error) {
+	dn, attributes, err := decodeSearchResultEntry(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{DN: dn, Attributes: attributes}, nil
+}
+
+// EntryFromAddRequest returns the entry added by an add request.
+func EntryFromAddRequest(r ldap.AddRequest) *Entry {
+	e := &Entry{DN: string(r.Entry())}
+	for _, a := range r.Attributes() {
+		values := make([]string, len(a.Vals()))
+		for i, v := range a.Vals() {
+			values[i] = string(v)
+		}
+		e.Attributes = append(e.Attributes, EntryAttribute{Name: string(a.Type_()), Values: values})
+	}
+	return e
+}
+
+// SearchResultEntry returns the entry as a search result with all its
+// attributes, see SelectAttributes for the attributes requested by a
+// search.
+func (e *Entry) SearchResultEntry() ldap.SearchResultEntry {
+	r := NewSearchResultEntry(e.DN)
+	for _, a := range e.Attributes {
+		values := make([]ldap.AttributeValue, len(a.Values))
+		for i, v := range a.Values {
+			values[i] = ldap.AttributeValue(v)
+		}
+		r.AddAttribute(ldap.AttributeDescription(a.Name), values...)
+	}
+	return r
+}
+
+// Clone returns a deep copy of the entry.
+func (e *Entry) Clone() *Entry {
+	c := &Entry{DN: e.DN, Attributes: make([]EntryAttribute, len(e.Attributes))}
+	for i, a := range e.Attributes {
+		c.Attributes[i] = EntryAttribute{Name: a.Name, Values: append([]string(nil), a.Values...)}
+	}
+	return c
+}
+
+// Get returns the values of the attribute name, nil when the entry has no
+// such attribute.
+func (e *Entry) Get(name string) []string {
+	if a := e.attribute(name); a != nil {
+		return a.Values
+	}
+	return nil
+}
+
+// Has reports whether the attribute name has the value.
+func (e *Entry) Has(name, value string) bool {
+	a := e.attribute(name)
+	return a != nil && a.index(value) >= 0
+}
+
+// Add adds the values to the attribute name, creating it if needed. It
+// fails with attributeOrValueExists, adding none of them, when the
+// attribute has one of them.
+func (e *Entry) Add(name string, values ...string) error {
+	a := e.attribute(name)
+	if a == nil {
+		e.Attributes = append(e.Attributes, EntryAttribute{Name: name})
+		a = &e.Attributes[len(e.Attributes)-1]
+	}
+	n := len(a.Values)
+	for _, v := range values {
+		if a.index(v) >= 0 {
+			a.Values = a.Values[:n]
+			if n == 0 {
+				e.remove(name)
+			}
+			return NewError(LDAPResultAttributeOrValueExists, name+": "+v)
+		}
+		a.Values = append(a.Values, v)
+	}
+	return nil
+}
+
+// Replace replaces the values of the attribute name, removing the
+// attribute when there are none.
+func (e *Entry) Replace(name string, values ...string) {
+	if len(values) == 0 {
+		e.remove(name)
+		return
+	}
+	if a := e.attribute(name); a != nil {
+		a.Values = append([]string(nil), values...)
+		return
+	}
+	e.Attributes = append(e.Attributes, EntryAttribute{Name: name, Values: append([]string(nil), values...)})
+}
+
+// Delete deletes the values of the attribute name, or the attribute when
+// no value is given. It fails with noSuchAttribute when the entry has no
+// such attribute or one of the values, deleting none of them. The
+// attribute is removed with its last value.
+func (e *Entry) Delete(name string, values ...string) error {
+	a := e.attribute(name)
+	if a == nil {
+		return NewError(LDAPResultNoSuchAttribute, name)
+	}
+	for _, v := range values {
+		if a.index(v) < 0 {
+			return NewError(LDAPResultNoSuchAttribute, name+": "+v)
+		}
+	}
+	for _, v := range values {
+		if i := a.index(v); i >= 0 {
+			a.Values = append(a.Values[:i], a.Values[i+1:]...)
+		}
+	}
+	if len(values) == 0 || len(a.Values) == 0 {
+		e.remove(name)
+	}
+	return nil
+}
+
+// Modification is a change of a modify request (RFC 4511 section 4.6).
+type Modification struct {
+	Operation int // ModifyRequestChangeOperationAdd, Delete or Replace
+	Attribute string
+	Values    []string
+}
+
+// Modifications returns the changes of a modify request.
+func Modifications(r ldap.ModifyRequest) []Modification {
+	changes := make([]Modification, len(r.Changes()))
+	for i, change := range r.Changes() {
+		mod := change.Modification()
+		values := make([]string, len(mod.Vals()))
+		for j, v := range mod.Vals() {
+			values[j] = string(v)
+		}
+		changes[i] = Modification{Operation: int(change.Operation()), Attribute: string(mod.Type_()), Values: values}
+	}
+	return changes
+}
+
+// Apply applies the changes of a modify request in order, see Modify.
+func (e *Entry) Apply(r ldap.ModifyRequest) error {
+	return e.Modify(Modifications(r)...)
+}
+
+// Modify applies the changes in order. They are applied atomically: on
+// error, the entry is left unchanged and the error is an *Error with the
+// result code of the request, e.g. noSuchAttribute.
+func (e *Entry) Modify(changes ...Modification) error {
+	c := e.Clone()
+	for _, change := range changes {
+		var err error
+		switch change.Operation {
+		case ModifyRequestChangeOperationAdd:
+			err = c.Add(change.Attribute, change.Values...)
+		case ModifyRequestChangeOperationDelete:
+			err = c.Delete(change.Attribute, change.Values...)
+		case ModifyRequestChangeOperationReplace:
+			c.Replace(change.Attribute, change.Values...)
+		default:
+			err = NewError(LDAPResultUnwillingToPerform, "unsupported modify operation")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	e.Attributes = c.Attributes
+	return nil
+}
+
+// attribute returns the attribute name of the entry, compared case
+// insensitively, nil when the entry has no such attribute.
+func (e *Entry) attribute(name string) *EntryAttribute {
+	for i := range e.Attributes {
+		if strings.EqualFold(e.Attributes[i].Name, name) {
+			return &e.Attributes[i]
+		}
+	}
+	return nil
+}
+
+// remove removes the attribute name.
+func (e *Entry) remove(name string) {
+	for i := range e.Attributes {
+		if strings.EqualFold(e.Attributes[i].Name, name) {
+			e.Attributes = append(e.Attributes[:i], e.Attributes[i+1:]...)
+			return
+		}
+	}
+}
+
+// index returns the position of value in the attribute, compared with its
+// equality rule, -1 when it has no such value.
+func (a *EntryAttribute) index(value string) int {
+	rule := attributeRule(a.Name)
+	for i, v := range a.Values {
+		switch rule.equal(v, value) {
+		case matchTrue:
+			return i
+		case matchUndefined:
+			if v == value {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,102 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Error is an LDAP result returned as an error by the handlers of
+// ErrorHandler.
+type Error struct {
+	ResultCode        int
+	MatchedDN         string
+	DiagnosticMessage string
+}
+
+// NewError returns an error answered with the result code.
+func NewError(resultCode int, diagnostic string) *Error {
+	return &Error{ResultCode: resultCode, DiagnosticMessage: diagnostic}
+}
+
+func (e *Error) Error() string {
+	if e.DiagnosticMessage == "" {
+		return fmt.Sprintf("ldap: result code %d", e.ResultCode)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.ResultCode, e.DiagnosticMessage)
+}
+
+// ErrorResponse returns the response to the request po reporting err: an
+// *Error in the chain of err gives its result, context.DeadlineExceeded
+// timeLimitExceeded and other errors operationsError. It returns nil for
+// context.Canceled, the request being abandoned, and for requests without
+// response.
+func ErrorResponse(po ldap.ProtocolOp, err error) ldap.ProtocolOp {
+	var res ldap.LDAPResult
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		res.SetResultCode(e.ResultCode)
+		res.SeMatchedDN(e.MatchedDN)
+		res.SetDiagnosticMessage(e.DiagnosticMessage)
+	case errors.Is(err, context.Canceled):
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		res.SetResultCode(LDAPResultTimeLimitExceeded)
+		res.SetDiagnosticMessage("operation timed out")
+	default:
+		res.SetResultCode(LDAPResultOperationsError)
+		res.SetDiagnosticMessage(err.Error())
+	}
+	return responseWithResult(po, res)
+}
+
+// ErrorHandler adapts a handler returning an error: unless the handler
+// already wrote the final response, a non-nil error is answered with
+// ErrorResponse.
+//
+//	routes.Delete(ldap.ErrorHandler(func(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) error {
+//		dn := string(m.GetDeleteRequest())
+//		if !exists(dn) {
+//			return ldap.NewError(ldap.LDAPResultNoSuchObject, dn)
+//		}
+//		...
+//	}))
+func ErrorHandler(f func(ctx context.Context, w ResponseWriter, m *Message) error) HandlerFunc {
+	return func(ctx context.Context, w ResponseWriter, m *Message) {
+		rw := &respondedWriter{ResponseWriter: w}
+		err := f(ctx, rw, m)
+		if err == nil {
+			return
+		}
+		if rw.responded.Load() {
+			if m.Client != nil {
+				m.Client.srv.logf("client %d message %d: error after response: %s", m.Client.Numero, m.MessageID().Int(), err)
+			}
+			return
+		}
+		if res := ErrorResponse(m.ProtocolOp(), err); res != nil {
+			w.Write(res)
+		}
+	}
+}
+
+// respondedWriter records whether the final response was written.
+type respondedWriter struct {
+	ResponseWriter
+	responded atomic.Bool
+}
+
+func (w *respondedWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *respondedWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if isFinalResponse(po) {
+		w.responded.Store(true)
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
@@ -3,12 +3,12 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	ldap "github.com/nolta/ldapserver"
 )
@@ -17,6 +17,13 @@ func main() {
 	//Create a new LDAP Server
 	server := &ldap.Server{}
 
+	// StartTLS is handled by the server itself
+	tlsConfig, err := getTLSconfig()
+	if err != nil {
+		log.Fatalf("LDAP Server failed to get TLS config: %s", err)
+	}
+	server.TLSConfig = tlsConfig
+
 	//Create routes bindings
 	routes := ldap.NewRouteMux()
 	routes.NotFound(handleNotFound)
@@ -26,20 +33,11 @@ func main() {
 	routes.Delete(handleDelete)
 	routes.Modify(handleModify)
 
-	routes.Extended(handleStartTLS).
-		RequestName(ldap.NoticeOfStartTLS).Label("StartTLS")
-
 	routes.Extended(handleWhoAmI).
 		RequestName(ldap.NoticeOfWhoAmI).Label("Ext - WhoAmI")
 
 	routes.Extended(handleExtended).Label("Ext - Generic")
 
-	routes.Search(handleSearchDSE).
-		BaseDn("").
-		Scope(ldap.SearchRequestScopeBaseObject).
-		Filter("(objectclass=*)").
-		Label("Search - ROOT DSE")
-
 	routes.Search(handleSearchMyCompany).
 		BaseDn("o=My Company, c=US").
 		Scope(ldap.SearchRequestScopeBaseObject).
@@ -47,9 +45,18 @@ func main() {
 
 	routes.Search(handleSearch).Label("Search - Generic")
 
+	// The root DSE advertises the extended operations of the routes
+	dse := &ldap.RootDSE{
+		NamingContexts: []string{"o=My Company, c=US"},
+		Server:         server,
+		Mux:            routes,
+		VendorName:     "Valère JEANTET",
+		VendorVersion:  "0.0.1",
+	}
+
 	//Attach routes to server
 	server.HandleConnection = func(net.Conn) ldap.Handler {
-		return routes
+		return dse.Handler(routes)
 	}
 
 	// listen on 10389 and serve
@@ -62,7 +69,9 @@ func main() {
 	<-ch
 	close(ch)
 
-	server.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
 }
 
 func handleNotFound(ctx context.Context, w ldap.ResponseWriter, r *ldap.Message) {
@@ -179,34 +188,6 @@ func handleWhoAmI(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
 	w.Write(res)
 }
 
-func handleSearchDSE(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
-	r := m.GetSearchRequest()
-
-	log.Printf("Request BaseDn=%s", r.BaseObject())
-	log.Printf("Request Filter=%s", r.Filter())
-	log.Printf("Request FilterString=%s", r.FilterString())
-	log.Printf("Request Attributes=%s", r.Attributes())
-	log.Printf("Request TimeLimit=%d", r.TimeLimit().Int())
-
-	e := ldap.NewSearchResultEntry("")
-	e.AddAttribute("vendorName", "Valère JEANTET")
-	e.AddAttribute("vendorVersion", "0.0.1")
-	e.AddAttribute("objectClass", "top", "extensibleObject")
-	e.AddAttribute("supportedLDAPVersion", "3")
-	e.AddAttribute("namingContexts", "o=My Company, c=US")
-	// e.AddAttribute("subschemaSubentry", "cn=schema")
-	// e.AddAttribute("namingContexts", "ou=system", "ou=schema", "dc=example,dc=com", "ou=config")
-	// e.AddAttribute("supportedFeatures", "1.3.6.1.4.1.4203.1.5.1")
-	// e.AddAttribute("supportedControl", "2.16.840.1.113730.3.4.3", "1.3.6.1.4.1.4203.1.10.1", "2.16.840.1.113730.3.4.2", "1.3.6.1.4.1.4203.1.9.1.4", "1.3.6.1.4.1.42.2.27.8.5.1", "1.3.6.1.4.1.4203.1.9.1.1", "1.3.6.1.4.1.4203.1.9.1.3", "1.3.6.1.4.1.4203.1.9.1.2", "1.3.6.1.4.1.18060.0.0.1", "2.16.840.1.113730.3.4.7", "1.2.840.113556.1.4.319")
-	// e.AddAttribute("supportedExtension", "1.3.6.1.4.1.1466.20036", "1.3.6.1.4.1.4203.1.11.1", "1.3.6.1.4.1.18060.0.1.5", "1.3.6.1.4.1.18060.0.1.3", "1.3.6.1.4.1.1466.20037")
-	// e.AddAttribute("supportedSASLMechanisms", "NTLM", "GSSAPI", "GSS-SPNEGO", "CRAM-MD5", "SIMPLE", "DIGEST-MD5")
-	// e.AddAttribute("entryUUID", "f290425c-8272-4e62-8a67-92b06f38dbf5")
-	w.Write(e)
-
-	res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess)
-	w.Write(res)
-}
-
 func handleSearchMyCompany(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
 	r := m.GetSearchRequest()
 	log.Printf("handleSearchMyCompany - Request BaseDn=%s", r.BaseObject())
@@ -294,22 +275,3 @@ func getTLSconfig() (*tls.Config, error) {
 		ServerName:   "127.0.0.1",
 	}, nil
 }
-
-func handleStartTLS(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
-	tlsconfig, _ := getTLSconfig()
-	tlsConn := tls.Server(m.Client.GetConn(), tlsconfig)
-	res := ldap.NewExtendedResponse(ldap.LDAPResultSuccess)
-	res.SetResponseName(ldap.NoticeOfStartTLS)
-	w.Write(res)
-
-	if err := tlsConn.Handshake(); err != nil {
-		log.Printf("StartTLS Handshake error %v", err)
-		res.SetDiagnosticMessage(fmt.Sprintf("StartTLS Handshake error : \"%s\"", err.Error()))
-		res.SetResultCode(ldap.LDAPResultOperationsError)
-		w.Write(res)
-		return
-	}
-
-	m.Client.SetConn(tlsConn)
-	log.Println("StartTLS OK")
-}
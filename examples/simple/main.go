@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	ldap "github.com/nolta/ldapserver"
 )
@@ -37,7 +38,9 @@ func main() {
 	<-ch
 	close(ch)
 
-	server.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
 }
 
 // handleBind return Success if login == mysql
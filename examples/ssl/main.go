@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	ldap "github.com/nolta/ldapserver"
 )
@@ -64,17 +65,15 @@ func main() {
 	}
 
 	// listen for TLS on 10389
-	listener, err := net.Listen("tcp", ":10389")
-	if err != nil {
-		log.Fatalf("LDAP Server failed to listen: %s", err)
-	}
-	defer listener.Close()
 	tlsConfig, err := getTLSconfig()
 	if err != nil {
 		log.Fatalf("LDAP Server failed to get TLS config: %s", err)
 	}
-	listener = tls.NewListener(listener, tlsConfig)
-	go server.Serve(listener)
+	go func() {
+		if err := server.ListenAndServeTLS(":10389", tlsConfig); err != nil {
+			log.Printf("LDAP Server stopped: %s", err)
+		}
+	}()
 
 	// When CTRL+C, SIGINT and SIGTERM signal occurs
 	// Then stop server gracefully
@@ -84,7 +83,9 @@ func main() {
 	// Wait for signal
 	<-ch
 	close(ch)
-	server.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
 }
 
 // handleBind return Success if login == mysql
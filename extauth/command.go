@@ -0,0 +1,54 @@
+package extauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Command checks the credentials with a command, e.g. a script calling
+// the API of an identity provider. The command reads the DN, the password
+// and the IP address of the client on its standard input, one per line,
+// and exits with status 0 for valid credentials, 1 for invalid ones; the
+// other statuses are failures of the check. The credentials are not
+// passed in the arguments or the environment, which other users can see.
+//
+// A DN or password holding a newline is rejected without running the
+// command. The command is killed when the check times out.
+type Command struct {
+	// Path is the path of the command, and Args its arguments.
+	Path string
+	Args []string
+
+	// Env is the environment of the command, that of the server if nil.
+	Env []string
+}
+
+// Check runs the command with the credentials c.
+func (cmd *Command) Check(ctx context.Context, c Credentials) error {
+	if strings.ContainsAny(c.DN, "\r\n") || strings.ContainsAny(c.Password, "\r\n") {
+		return ErrRejected
+	}
+	x := exec.CommandContext(ctx, cmd.Path, cmd.Args...)
+	x.Env = cmd.Env
+	x.Stdin = strings.NewReader(c.DN + "\n" + c.Password + "\n" + c.RemoteAddr + "\n")
+	var stderr bytes.Buffer
+	x.Stderr = &stderr
+	err := x.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		return ErrRejected
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("extauth: %s: %w: %s", cmd.Path, err, msg)
+	}
+	return fmt.Errorf("extauth: %s: %w", cmd.Path, err)
+}
@@ -0,0 +1,273 @@
+// Package extauth delegates the checking of the passwords of the simple
+// binds to an external HTTP endpoint or command, for the server to front
+// an SSO service or an identity provider without Go code of its own:
+//
+//	auth := &extauth.Authenticator{
+//		Checker:  &extauth.Webhook{URL: "https://idp.example.com/ldap/bind"},
+//		Retries:  2,
+//		CacheTTL: time.Minute,
+//	}
+//	routes.Bind(auth.ServeLDAP)
+//
+// Authenticator.Bind has the signature of ldap.Backend.Bind, for backends
+// checking their binds externally.
+package extauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// Defaults of the Authenticator.
+const (
+	DefaultTimeout    = 5 * time.Second
+	DefaultRetryDelay = 100 * time.Millisecond
+	DefaultCacheSize  = 10000
+)
+
+// ErrRejected is returned by a Checker refusing the credentials, the bind
+// failing with invalidCredentials. The other errors are failures of the
+// check, which is retried.
+var ErrRejected = errors.New("extauth: credentials rejected")
+
+// Credentials are the credentials of a simple bind.
+type Credentials struct {
+	DN         string `json:"dn"`
+	Password   string `json:"password"`
+	RemoteAddr string `json:"remote_addr,omitempty"` // IP address of the client, if known
+}
+
+// Checker checks the credentials of the binds, returning nil when they
+// are valid and ErrRejected when they are not.
+type Checker interface {
+	Check(ctx context.Context, c Credentials) error
+}
+
+// CheckerFunc is a function used as a Checker.
+type CheckerFunc func(ctx context.Context, c Credentials) error
+
+func (f CheckerFunc) Check(ctx context.Context, c Credentials) error {
+	return f(ctx, c)
+}
+
+// Authenticator answers the simple binds with the verdict of its Checker.
+// The anonymous binds succeed, and the other binds fail with
+// authMethodNotSupported. A check failing, e.g. the endpoint being down,
+// is retried; the bind fails with unavailable when the retries fail too.
+//
+// It is safe for concurrent use.
+type Authenticator struct {
+	// Checker checks the credentials, e.g. a Webhook or a Command.
+	Checker Checker
+
+	// Timeout bounds each attempt to check the credentials,
+	// DefaultTimeout if zero.
+	Timeout time.Duration
+
+	// Retries is the number of attempts made after a failed check.
+	// RetryDelay is the wait before the first retry, doubled for each
+	// further retry, DefaultRetryDelay if zero.
+	Retries    int
+	RetryDelay time.Duration
+
+	// CacheTTL is how long valid credentials are accepted without being
+	// checked again; they are not cached if zero. NegativeCacheTTL is the
+	// same for the rejected credentials.
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+
+	// MaxCacheEntries is the number of cached credentials,
+	// DefaultCacheSize if zero; those expiring first are evicted.
+	MaxCacheEntries int
+
+	mu    sync.Mutex
+	salt  []byte
+	cache map[string]cachedVerdict
+}
+
+// cachedVerdict is the verdict of the Checker on credentials.
+type cachedVerdict struct {
+	valid   bool
+	expires time.Time
+}
+
+// ServeLDAP answers the bind request m, routes.Bind(auth.ServeLDAP).
+func (a *Authenticator) ServeLDAP(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	ldap.ErrorHandler(a.serveBind)(ctx, w, m)
+}
+
+func (a *Authenticator) serveBind(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) error {
+	r := m.GetBindRequest()
+	if r.AuthenticationChoice() != "simple" {
+		return ldap.NewError(ldap.LDAPResultAuthMethodNotSupported, "only simple binds are supported")
+	}
+	name, password := string(r.Name()), string(r.AuthenticationSimple())
+	if password == "" {
+		if name != "" {
+			// RFC 4513 section 5.1.2
+			return ldap.NewError(ldap.LDAPResultUnwillingToPerform, "unauthenticated bind")
+		}
+		return w.Write(ldap.NewBindResponse(ldap.LDAPResultSuccess))
+	}
+	c := Credentials{DN: name, Password: password}
+	if m.Client != nil {
+		if host, _, err := net.SplitHostPort(m.Client.Addr().String()); err == nil {
+			c.RemoteAddr = host
+		}
+	}
+	if err := a.check(ctx, c); err != nil {
+		return err
+	}
+	return w.Write(ldap.NewBindResponse(ldap.LDAPResultSuccess))
+}
+
+// Bind checks the password of a simple bind of dn, failing with
+// invalidCredentials, as ldap.Backend.Bind does.
+func (a *Authenticator) Bind(ctx context.Context, dn, password string) error {
+	return a.check(ctx, Credentials{DN: dn, Password: password})
+}
+
+// check checks the credentials c with the cache, then with the Checker,
+// returning an *ldap.Error when they are not accepted.
+func (a *Authenticator) check(ctx context.Context, c Credentials) error {
+	key := a.cacheKey(c)
+	if valid, ok := a.cached(key); ok {
+		return verdictError(valid)
+	}
+	err := a.retry(ctx, c)
+	switch {
+	case err == nil:
+		a.store(key, true, a.CacheTTL)
+	case errors.Is(err, ErrRejected):
+		a.store(key, false, a.NegativeCacheTTL)
+	default:
+		return ldap.NewError(ldap.LDAPResultUnavailable, err.Error())
+	}
+	return verdictError(err == nil)
+}
+
+func verdictError(valid bool) error {
+	if valid {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, "")
+}
+
+// retry checks the credentials c with the Checker, retrying the failed
+// checks.
+func (a *Authenticator) retry(ctx context.Context, c Credentials) error {
+	delay := a.retryDelay()
+	for attempt := 0; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, a.timeout())
+		err := a.Checker.Check(actx, c)
+		cancel()
+		if err == nil || errors.Is(err, ErrRejected) || attempt >= a.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (a *Authenticator) timeout() time.Duration {
+	if a.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return a.Timeout
+}
+
+func (a *Authenticator) retryDelay() time.Duration {
+	if a.RetryDelay <= 0 {
+		return DefaultRetryDelay
+	}
+	return a.RetryDelay
+}
+
+func (a *Authenticator) maxCacheEntries() int {
+	if a.MaxCacheEntries <= 0 {
+		return DefaultCacheSize
+	}
+	return a.MaxCacheEntries
+}
+
+// cacheKey returns the key of the credentials c in the cache, a salted
+// hash of the DN and the password.
+func (a *Authenticator) cacheKey(c Credentials) string {
+	a.mu.Lock()
+	if a.salt == nil {
+		a.salt = make([]byte, 16)
+		rand.Read(a.salt)
+	}
+	salt := a.salt
+	a.mu.Unlock()
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(ldap.NormalizeDN(c.DN)))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Password))
+	return string(h.Sum(nil))
+}
+
+// cached returns the cached verdict of the credentials of the key, ok
+// being false when there is none.
+func (a *Authenticator) cached(key string) (valid, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v, ok := a.cache[key]
+	if !ok || time.Now().After(v.expires) {
+		return false, false
+	}
+	return v.valid, true
+}
+
+// store caches the verdict of the credentials of the key for ttl.
+func (a *Authenticator) store(key string, valid bool, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cache == nil {
+		a.cache = make(map[string]cachedVerdict)
+	}
+	a.cache[key] = cachedVerdict{valid: valid, expires: time.Now().Add(ttl)}
+	a.evict()
+}
+
+// evict drops the expired verdicts, then the one expiring first while the
+// cache holds more than MaxCacheEntries. a.mu must be held.
+func (a *Authenticator) evict() {
+	if len(a.cache) <= a.maxCacheEntries() {
+		return
+	}
+	now := time.Now()
+	oldest, oldestKey := time.Time{}, ""
+	for key, v := range a.cache {
+		if now.After(v.expires) {
+			delete(a.cache, key)
+		} else if oldestKey == "" || v.expires.Before(oldest) {
+			oldest, oldestKey = v.expires, key
+		}
+	}
+	if len(a.cache) > a.maxCacheEntries() {
+		delete(a.cache, oldestKey)
+	}
+}
+
+// Purge drops the cached verdicts, e.g. after passwords were changed.
+func (a *Authenticator) Purge() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cache = nil
+}
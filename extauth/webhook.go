@@ -0,0 +1,62 @@
+package extauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Webhook checks the credentials with an HTTP endpoint: it posts them as
+// a JSON object,
+//
+//	{"dn": "uid=john,ou=people,dc=example,dc=com", "password": "secret", "remote_addr": "192.0.2.1"}
+//
+// and the endpoint answers with a 2xx status for valid credentials, 401
+// or 403 for invalid ones; the other statuses are failures of the check.
+type Webhook struct {
+	// URL is the URL of the endpoint, preferably https.
+	URL string
+
+	// Header is added to the requests, e.g. an Authorization header
+	// authenticating the server to the endpoint.
+	Header http.Header
+
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Check posts the credentials c to the endpoint.
+func (h *Webhook) Check(ctx context.Context, c Credentials) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range h.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return ErrRejected
+	}
+	return fmt.Errorf("extauth: webhook answered %s", res.Status)
+}
@@ -0,0 +1,224 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ExtendedCodec converts the values of an extended operation between BER
+// and Go types.
+type ExtendedCodec struct {
+	// DecodeRequest decodes the requestValue, nil when absent.
+	DecodeRequest func(value []byte) (any, error)
+
+	// EncodeResponse encodes the responseValue; a nil value is omitted.
+	EncodeResponse func(value any) ([]byte, error)
+
+	// ResponseName is the responseName of the responses, "" for none.
+	ResponseName ldap.LDAPOID
+}
+
+var (
+	extendedCodecsMu sync.RWMutex
+	extendedCodecs   = map[ldap.LDAPOID]ExtendedCodec{
+		NoticeOfWhoAmI: {
+			DecodeRequest:  decodeNoValue,
+			EncodeResponse: func(v any) ([]byte, error) { return []byte(v.(string)), nil },
+		},
+		NoticeOfPasswordModify: {
+			DecodeRequest:  decodePasswordModifyRequest,
+			EncodeResponse: encodePasswordModifyResponse,
+		},
+		NoticeOfCancel: {
+			DecodeRequest: decodeCancelRequest,
+		},
+		NoticeOfRefresh: {
+			DecodeRequest: func(value []byte) (any, error) {
+				if value == nil {
+					return nil, errors.New("missing Refresh request value")
+				}
+				v := ldap.OCTETSTRING(value)
+				dn, ttl, err := decodeRefreshRequest(&v)
+				return &RefreshRequest{EntryName: dn, TTL: int(ttl)}, err
+			},
+			EncodeResponse: func(v any) ([]byte, error) { return berIntegerContent(int64(v.(int))), nil },
+			ResponseName:   NoticeOfRefresh,
+		},
+	}
+)
+
+// RegisterExtended registers the codec of the extended operation with the
+// given OID, replacing any previous one.
+func RegisterExtended(oid ldap.LDAPOID, codec ExtendedCodec) {
+	extendedCodecsMu.Lock()
+	defer extendedCodecsMu.Unlock()
+	extendedCodecs[oid] = codec
+}
+
+func extendedCodec(oid ldap.LDAPOID) (ExtendedCodec, bool) {
+	extendedCodecsMu.RLock()
+	defer extendedCodecsMu.RUnlock()
+	codec, ok := extendedCodecs[oid]
+	return codec, ok
+}
+
+// ExtendedValue returns the requestValue of an extended request decoded by
+// the codec registered for its OID, the raw value without codec.
+func (m *Message) ExtendedValue() (any, error) {
+	r, ok := m.ProtocolOp().(ldap.ExtendedRequest)
+	if !ok {
+		return nil, errors.New("not an extended request")
+	}
+	var value []byte
+	if v := r.RequestValue(); v != nil {
+		value = []byte(*v)
+	}
+	codec, ok := extendedCodec(r.RequestName())
+	if !ok || codec.DecodeRequest == nil {
+		return value, nil
+	}
+	return codec.DecodeRequest(value)
+}
+
+// NewExtendedResponseFor returns the response to the extended request m,
+// its value encoded by the codec registered for the OID. The value must be
+// []byte without codec.
+func NewExtendedResponseFor(m *Message, resultCode int, value any) (ldap.ExtendedResponse, error) {
+	r, ok := m.ProtocolOp().(ldap.ExtendedRequest)
+	if !ok {
+		return NewExtendedResponse(resultCode), errors.New("not an extended request")
+	}
+	codec, _ := extendedCodec(r.RequestName())
+	if value == nil {
+		res := NewExtendedResponse(resultCode)
+		res.SetResponseName(codec.ResponseName)
+		return res, nil
+	}
+
+	var data []byte
+	switch {
+	case codec.EncodeResponse != nil:
+		var err error
+		if data, err = codec.EncodeResponse(value); err != nil {
+			return NewExtendedResponse(LDAPResultOperationsError), err
+		}
+	default:
+		b, ok := value.([]byte)
+		if !ok {
+			return NewExtendedResponse(LDAPResultOperationsError), fmt.Errorf("no codec to encode the %T response of %s", value, r.RequestName())
+		}
+		data = b
+	}
+	return NewExtendedResponseValue(resultCode, codec.ResponseName, data), nil
+}
+
+// ExtendedByOID routes the extended requests with the given OID to
+// handler. Requests whose value the registered codec can not decode are
+// answered with protocolError; the handler gets the decoded value with
+// Message.ExtendedValue.
+func (h *RouteMux) ExtendedByOID(oid string, handler HandlerFunc) *route {
+	return h.Extended(func(ctx context.Context, w ResponseWriter, m *Message) {
+		if _, err := m.ExtendedValue(); err != nil {
+			res := NewExtendedResponse(LDAPResultProtocolError)
+			res.SetDiagnosticMessage(err.Error())
+			w.Write(res)
+			return
+		}
+		handler(ctx, w, m)
+	}).RequestName(ldap.LDAPOID(oid))
+}
+
+func decodeNoValue(value []byte) (any, error) {
+	if value != nil {
+		return nil, errors.New("unexpected request value")
+	}
+	return nil, nil
+}
+
+// PasswordModifyRequest is the value of a Password Modify request (RFC
+// 3062), fields are nil when absent.
+type PasswordModifyRequest struct {
+	UserIdentity []byte
+	OldPassword  []byte
+	NewPassword  []byte
+}
+
+// PasswordModifyResponse is the value of a Password Modify response.
+type PasswordModifyResponse struct {
+	GeneratedPassword []byte // nil when the client chose the password
+}
+
+func decodePasswordModifyRequest(value []byte) (any, error) {
+	// PasswdModifyRequestValue ::= SEQUENCE { userIdentity [0] OPTIONAL,
+	//     oldPasswd [1] OPTIONAL, newPasswd [2] OPTIONAL }
+	r := &PasswordModifyRequest{}
+	if value == nil {
+		return r, nil
+	}
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := seq.children()
+	if err != nil {
+		return nil, errors.New("malformed Password Modify request value")
+	}
+	for _, f := range fields {
+		switch {
+		case f.is(berClassContext, 0):
+			r.UserIdentity = f.value
+		case f.is(berClassContext, 1):
+			r.OldPassword = f.value
+		case f.is(berClassContext, 2):
+			r.NewPassword = f.value
+		default:
+			return nil, errors.New("malformed Password Modify request value")
+		}
+	}
+	return r, nil
+}
+
+func encodePasswordModifyResponse(v any) ([]byte, error) {
+	// PasswdModifyResponseValue ::= SEQUENCE { genPasswd [0] OPTIONAL }
+	r, ok := v.(*PasswordModifyResponse)
+	if !ok {
+		return nil, fmt.Errorf("invalid Password Modify response %T", v)
+	}
+	if r.GeneratedPassword == nil {
+		return berSequence(), nil
+	}
+	return berSequence(berEncode(berClassContext, false, 0, r.GeneratedPassword)), nil
+}
+
+// CancelRequest is the value of a Cancel request (RFC 3909).
+type CancelRequest struct {
+	MessageID int
+}
+
+func decodeCancelRequest(value []byte) (any, error) {
+	// cancelRequestValue ::= SEQUENCE { cancelID MessageID }
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := seq.children()
+	if err != nil || len(fields) != 1 {
+		return nil, errors.New("malformed Cancel request value")
+	}
+	id, err := fields[0].int()
+	if err != nil {
+		return nil, err
+	}
+	return &CancelRequest{MessageID: int(id)}, nil
+}
+
+// RefreshRequest is the value of a Refresh request (RFC 2589), see
+// DynamicEntries.
+type RefreshRequest struct {
+	EntryName string
+	TTL       int // requested time to live in seconds
+}
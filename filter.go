@@ -0,0 +1,584 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// FeatureAbsoluteFilters is the supportedFeatures OID of the absolute True
+// and False filters (RFC 4526).
+const FeatureAbsoluteFilters = "1.3.6.1.4.1.4203.1.5.3"
+
+// SupportedFeatures lists the supportedFeatures the package implements,
+// for the root DSE.
+var SupportedFeatures = []string{FeatureAbsoluteFilters}
+
+// Filter choices of the SearchRequest (RFC 4511 section 4.5.1.7).
+const (
+	filterAnd = iota
+	filterOr
+	filterNot
+	filterEqualityMatch
+	filterSubstrings
+	filterGreaterOrEqual
+	filterLessOrEqual
+	filterPresent
+	filterApproxMatch
+	filterExtensibleMatch
+)
+
+// goldap rejects the empty and/or filters (&) and (|), they are replaced
+// before decoding by an extensible match with the feature OID as matching
+// rule and "TRUE" or "FALSE" as value.
+var (
+	absoluteTrue  = absoluteFilter(true)
+	absoluteFalse = absoluteFilter(false)
+)
+
+func absoluteFilter(value bool) []byte {
+	v := "FALSE"
+	if value {
+		v = "TRUE"
+	}
+	// goldap fails to decode an extensible match without dnAttributes
+	return berEncode(berClassContext, true, filterExtensibleMatch,
+		berEncode(berClassContext, false, 1, []byte(FeatureAbsoluteFilters)),
+		berEncode(berClassContext, false, 3, []byte(v)),
+		berEncode(berClassContext, false, 4, []byte{0}))
+}
+
+// rewriteAbsoluteFilters replaces the absolute filters of a search request
+// message, data is returned as is when it has none.
+func rewriteAbsoluteFilters(data []byte) []byte {
+	// LDAPMessage ::= SEQUENCE { messageID, protocolOp, controls }
+	msg, err := berParseAll(data)
+	if err != nil {
+		return data
+	}
+	fields, err := msg.children()
+	if err != nil || len(fields) < 2 || !fields[1].is(berClassApplication, 3) {
+		return data
+	}
+	// SearchRequest ::= [APPLICATION 3] SEQUENCE { baseObject, scope,
+	//     derefAliases, sizeLimit, timeLimit, typesOnly, filter, attributes }
+	op, err := fields[1].children()
+	if err != nil || len(op) != 8 {
+		return data
+	}
+	filter, changed := rewriteFilter(op[6])
+	if !changed {
+		return data
+	}
+
+	parts := make([][]byte, len(op))
+	for i, e := range op {
+		parts[i] = e.encode()
+	}
+	parts[6] = filter
+	message := make([][]byte, len(fields))
+	for i, e := range fields {
+		message[i] = e.encode()
+	}
+	message[1] = berEncode(berClassApplication, true, 3, parts...)
+	return berSequence(message...)
+}
+
+// rewriteFilter returns the encoding of the filter with its absolute
+// filters replaced, and whether there were any.
+func rewriteFilter(f berElement) ([]byte, bool) {
+	if f.class != berClassContext || f.tag > filterNot {
+		return f.encode(), false
+	}
+	if len(f.value) == 0 && f.tag != filterNot {
+		if f.tag == filterAnd {
+			return absoluteTrue, true
+		}
+		return absoluteFalse, true
+	}
+	children, err := f.children()
+	if err != nil {
+		return f.encode(), false
+	}
+	parts := make([][]byte, len(children))
+	changed := false
+	for i, child := range children {
+		var c bool
+		parts[i], c = rewriteFilter(child)
+		changed = changed || c
+	}
+	if !changed {
+		return f.encode(), false
+	}
+	return berEncode(f.class, true, f.tag, parts...), true
+}
+
+// searchFilter returns the filter element of a search request.
+func searchFilter(r ldap.SearchRequest) (berElement, error) {
+	data, err := protocolOpBytes(r)
+	if err != nil {
+		return berElement{}, err
+	}
+	op, err := berParseAll(data)
+	if err != nil {
+		return berElement{}, err
+	}
+	fields, err := op.children()
+	if err != nil || len(fields) != 8 {
+		return berElement{}, fmt.Errorf("malformed search request")
+	}
+	return fields[6], nil
+}
+
+// AbsoluteFilter reports whether the filter of the search request is the
+// absolute True filter (&) or the absolute False filter (|), ok being
+// false for other filters.
+func AbsoluteFilter(r ldap.SearchRequest) (value, ok bool) {
+	f, err := searchFilter(r)
+	if err != nil {
+		return false, false
+	}
+	return f.absolute()
+}
+
+// absolute reports whether the filter element is an absolute filter.
+func (e berElement) absolute() (value, ok bool) {
+	if !e.is(berClassContext, filterExtensibleMatch) {
+		return false, false
+	}
+	children, err := e.children()
+	if err != nil || len(children) < 2 || !children[0].is(berClassContext, 1) || !children[1].is(berClassContext, 3) ||
+		string(children[0].value) != FeatureAbsoluteFilters {
+		return false, false
+	}
+	switch string(children[1].value) {
+	case "TRUE":
+		return true, true
+	case "FALSE":
+		return false, true
+	}
+	return false, false
+}
+
+// FilterString returns the string representation (RFC 4515) of the filter
+// of the search request, with the absolute filters as "(&)" and "(|)".
+// Unlike goldap's SearchRequest.FilterString, assertion values are
+// escaped.
+func FilterString(r ldap.SearchRequest) string {
+	f, err := searchFilter(r)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	writeFilter(&b, f)
+	return b.String()
+}
+
+func writeFilter(b *strings.Builder, f berElement) {
+	if value, ok := f.absolute(); ok {
+		if value {
+			b.WriteString("(&)")
+		} else {
+			b.WriteString("(|)")
+		}
+		return
+	}
+
+	b.WriteByte('(')
+	defer b.WriteByte(')')
+	children, _ := f.children()
+	switch f.tag {
+	case filterAnd, filterOr, filterNot:
+		b.WriteByte("&|!"[f.tag])
+		for _, child := range children {
+			writeFilter(b, child)
+		}
+	case filterEqualityMatch, filterGreaterOrEqual, filterLessOrEqual, filterApproxMatch:
+		if len(children) != 2 {
+			return
+		}
+		b.Write(children[0].value)
+		b.WriteString(map[int]string{filterEqualityMatch: "=", filterGreaterOrEqual: ">=",
+			filterLessOrEqual: "<=", filterApproxMatch: "~="}[f.tag])
+		b.WriteString(escapeFilterValue(children[1].value))
+	case filterSubstrings:
+		// SubstringFilter ::= SEQUENCE { type, substrings SEQUENCE OF
+		//     CHOICE { initial [0], any [1], final [2] } }
+		if len(children) != 2 {
+			return
+		}
+		b.Write(children[0].value)
+		b.WriteByte('=')
+		substrings, _ := children[1].children()
+		last := -1
+		for _, s := range substrings {
+			if s.tag != 0 || last >= 0 {
+				b.WriteByte('*')
+			}
+			b.WriteString(escapeFilterValue(s.value))
+			last = s.tag
+		}
+		if last != 2 {
+			b.WriteByte('*')
+		}
+	case filterPresent:
+		b.Write(f.value)
+		b.WriteString("=*")
+	case filterExtensibleMatch:
+		// MatchingRuleAssertion ::= SEQUENCE { matchingRule [1] OPTIONAL,
+		//     type [2] OPTIONAL, matchValue [3], dnAttributes [4] DEFAULT FALSE }
+		var rule, typ, value []byte
+		dn := false
+		for _, c := range children {
+			switch c.tag {
+			case 1:
+				rule = c.value
+			case 2:
+				typ = c.value
+			case 3:
+				value = c.value
+			case 4:
+				dn = c.bool()
+			}
+		}
+		b.Write(typ)
+		if dn {
+			b.WriteString(":dn")
+		}
+		if rule != nil {
+			b.WriteByte(':')
+			b.Write(rule)
+		}
+		b.WriteString(":=")
+		b.WriteString(escapeFilterValue(value))
+	}
+}
+
+// escapeFilterValue escapes an assertion value for a filter string.
+func escapeFilterValue(v []byte) string {
+	var b strings.Builder
+	for _, c := range v {
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseFilter parses the string representation (RFC 4515) of a filter into
+// its BER encoding, with the absolute filters replaced as on the wire.
+func parseFilter(s string) (berElement, error) {
+	p := &filterParser{s: s}
+	data, err := p.filter()
+	if err != nil {
+		return berElement{}, err
+	}
+	if p.i != len(s) {
+		return berElement{}, fmt.Errorf("invalid filter %q: trailing characters", s)
+	}
+	return berParseAll(data)
+}
+
+type filterParser struct {
+	s string
+	i int
+}
+
+func (p *filterParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("invalid filter %q at %d: %s", p.s, p.i, fmt.Sprintf(format, a...))
+}
+
+func (p *filterParser) filter() ([]byte, error) {
+	if p.i >= len(p.s) || p.s[p.i] != '(' {
+		return nil, p.errorf("expected '('")
+	}
+	p.i++
+	var data []byte
+	var err error
+	switch {
+	case p.i < len(p.s) && (p.s[p.i] == '&' || p.s[p.i] == '|'):
+		tag := filterAnd
+		if p.s[p.i] == '|' {
+			tag = filterOr
+		}
+		p.i++
+		var parts [][]byte
+		for p.i < len(p.s) && p.s[p.i] == '(' {
+			part, err := p.filter()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		switch {
+		case len(parts) > 0:
+			data = berEncode(berClassContext, true, tag, parts...)
+		case tag == filterAnd:
+			data = absoluteTrue
+		default:
+			data = absoluteFalse
+		}
+	case p.i < len(p.s) && p.s[p.i] == '!':
+		p.i++
+		part, err := p.filter()
+		if err != nil {
+			return nil, err
+		}
+		data = berEncode(berClassContext, true, filterNot, part)
+	default:
+		if data, err = p.item(); err != nil {
+			return nil, err
+		}
+	}
+	if p.i >= len(p.s) || p.s[p.i] != ')' {
+		return nil, p.errorf("expected ')'")
+	}
+	p.i++
+	return data, nil
+}
+
+// item parses a simple, present, substring or extensible filter.
+func (p *filterParser) item() ([]byte, error) {
+	end := strings.IndexByte(p.s[p.i:], ')')
+	if end < 0 {
+		return nil, p.errorf("missing ')'")
+	}
+	item := p.s[p.i : p.i+end]
+	eq := strings.IndexByte(item, '=')
+	switch {
+	case eq < 0:
+		return nil, p.errorf("missing '='")
+	case eq == 0:
+		return nil, p.errorf("missing attribute description")
+	}
+	attr, raw := item[:eq], item[eq+1:]
+	p.i += end
+
+	tag := filterEqualityMatch
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = filterApproxMatch
+	case '>':
+		tag = filterGreaterOrEqual
+	case '<':
+		tag = filterLessOrEqual
+	case ':':
+		tag = filterExtensibleMatch
+	}
+	if tag != filterEqualityMatch {
+		attr = attr[:len(attr)-1]
+	}
+	if attr == "" && tag != filterExtensibleMatch {
+		return nil, p.errorf("missing attribute description")
+	}
+
+	switch {
+	case tag == filterExtensibleMatch:
+		// attr [":dn"] [":" matchingrule] ":=" value
+		value, err := unescapeFilterValue(raw)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		fields := strings.Split(attr, ":")
+		typ, dn, rule := fields[0], false, ""
+		for _, f := range fields[1:] {
+			switch {
+			case strings.EqualFold(f, "dn") && !dn && rule == "":
+				dn = true
+			case f != "" && rule == "":
+				rule = f
+			default:
+				return nil, p.errorf("malformed extensible match")
+			}
+		}
+		if typ == "" && rule == "" {
+			return nil, p.errorf("extensible match without type nor rule")
+		}
+		var parts [][]byte
+		if rule != "" {
+			parts = append(parts, berEncode(berClassContext, false, 1, []byte(rule)))
+		}
+		if typ != "" {
+			parts = append(parts, berEncode(berClassContext, false, 2, []byte(typ)))
+		}
+		parts = append(parts, berEncode(berClassContext, false, 3, value))
+		// always present, see absoluteFilter
+		dnAttributes := []byte{0}
+		if dn {
+			dnAttributes[0] = 0xff
+		}
+		parts = append(parts, berEncode(berClassContext, false, 4, dnAttributes))
+		return berEncode(berClassContext, true, filterExtensibleMatch, parts...), nil
+
+	case tag == filterEqualityMatch && raw == "*":
+		return berEncode(berClassContext, false, filterPresent, []byte(attr)), nil
+
+	case tag == filterEqualityMatch && strings.Contains(raw, "*"):
+		// initial *any* final, each piece may be empty
+		pieces := strings.Split(raw, "*")
+		var parts [][]byte
+		for i, piece := range pieces {
+			if piece == "" {
+				if i > 0 && i < len(pieces)-1 {
+					return nil, p.errorf("empty substring")
+				}
+				continue
+			}
+			value, err := unescapeFilterValue(piece)
+			if err != nil {
+				return nil, p.errorf("%s", err)
+			}
+			choice := 1
+			switch i {
+			case 0:
+				choice = 0
+			case len(pieces) - 1:
+				choice = 2
+			}
+			parts = append(parts, berEncode(berClassContext, false, choice, value))
+		}
+		return berEncode(berClassContext, true, filterSubstrings, berOctetString([]byte(attr)), berSequence(parts...)), nil
+	}
+
+	value, err := unescapeFilterValue(raw)
+	if err != nil {
+		return nil, p.errorf("%s", err)
+	}
+	return berEncode(berClassContext, true, tag, berOctetString([]byte(attr)), berOctetString(value)), nil
+}
+
+// unescapeFilterValue decodes the \XX escapes of an assertion value.
+func unescapeFilterValue(s string) ([]byte, error) {
+	v := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("truncated escape in %q", s)
+			}
+			b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid escape in %q", s)
+			}
+			v = append(v, byte(b))
+			i += 2
+		case '(', ')', '*', 0:
+			return nil, fmt.Errorf("unescaped %q in %q", c, s)
+		default:
+			v = append(v, c)
+		}
+	}
+	return v, nil
+}
+
+// filterMatchesPattern reports whether the filter f has the same structure
+// as the pattern p: attribute descriptions, matching rules and values
+// compare case-insensitively and the order of the components of & and |
+// filters is irrelevant.
+func filterMatchesPattern(f, p berElement) bool {
+	if f.class != p.class || f.tag != p.tag || f.constructed != p.constructed {
+		return false
+	}
+	if !f.constructed {
+		return strings.EqualFold(string(f.value), string(p.value))
+	}
+	fc, err1 := f.children()
+	pc, err2 := p.children()
+	if f.tag == filterExtensibleMatch {
+		fc, pc = withoutDefaultDNAttributes(fc), withoutDefaultDNAttributes(pc)
+	}
+	if err1 != nil || err2 != nil || len(fc) != len(pc) {
+		return false
+	}
+	if f.tag != filterAnd && f.tag != filterOr {
+		for i := range fc {
+			if !filterMatchesPattern(fc[i], pc[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	used := make([]bool, len(fc))
+	for _, pchild := range pc {
+		found := false
+		for i, fchild := range fc {
+			if !used[i] && filterMatchesPattern(fchild, pchild) {
+				used[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutDefaultDNAttributes drops the dnAttributes field of a matching
+// rule assertion when it has its default value FALSE.
+func withoutDefaultDNAttributes(fields []berElement) []berElement {
+	kept := fields[:0:0]
+	for _, f := range fields {
+		if f.tag != 4 || f.bool() {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// hasAttribute reports whether an item of the filter f asserts the
+// attribute name, e.g. to evaluate the filters of computed attributes.
+func (f berElement) hasAttribute(name string) bool {
+	children, _ := f.children()
+	switch f.tag {
+	case filterAnd, filterOr, filterNot:
+		for _, child := range children {
+			if child.hasAttribute(name) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return strings.EqualFold(string(f.value), name)
+	case filterExtensibleMatch:
+		for _, c := range children {
+			if c.tag == 2 {
+				return strings.EqualFold(string(c.value), name)
+			}
+		}
+		return false
+	}
+	return len(children) > 0 && strings.EqualFold(string(children[0].value), name)
+}
+
+// attributes returns the attributes asserted by the items of the filter,
+// untyped being true when an extensible match asserts any attribute.
+func (f berElement) attributes() (names []string, untyped bool) {
+	children, _ := f.children()
+	switch f.tag {
+	case filterAnd, filterOr, filterNot:
+		for _, child := range children {
+			n, u := child.attributes()
+			names, untyped = append(names, n...), untyped || u
+		}
+		return names, untyped
+	case filterPresent:
+		return []string{string(f.value)}, false
+	case filterExtensibleMatch:
+		for _, c := range children {
+			if c.tag == 2 {
+				return []string{string(c.value)}, false
+			}
+		}
+		return nil, true
+	}
+	if len(children) > 0 {
+		return []string{string(children[0].value)}, false
+	}
+	return nil, false
+}
@@ -2,4 +2,21 @@ module github.com/nolta/ldapserver
 
 go 1.21
 
-require github.com/lor00x/goldap v0.0.0-20240304151906-8d785c64d1c8
+require (
+	github.com/jcmturner/gofork v1.7.6
+	github.com/jcmturner/gokrb5/v8 v8.4.4
+	github.com/lor00x/goldap v0.0.0-20240304151906-8d785c64d1c8
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/crypto v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/hashicorp/go-uuid v1.0.3 // indirect
+	github.com/jcmturner/aescts/v2 v2.0.0 // indirect
+	github.com/jcmturner/dnsutils/v2 v2.0.0 // indirect
+	github.com/jcmturner/goidentity/v6 v6.0.1 // indirect
+	github.com/jcmturner/rpc/v2 v2.0.3 // indirect
+	golang.org/x/net v0.7.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+)
@@ -0,0 +1,363 @@
+// Package inmem is an in-memory directory for ldapserver: a tree of entries
+// indexed by DN, served by a ready-made Handler supporting the bind,
+// search, add, delete, modify, modify DN and compare operations. It is
+// meant for tests and mock directories:
+//
+//	dir := inmem.New()
+//	dir.Add("dc=example,dc=com", map[string][]string{
+//		"objectClass": {"top", "domain"},
+//		"dc":          {"example"},
+//	})
+//	dir.Add("uid=jdoe,dc=example,dc=com", map[string][]string{
+//		"objectClass":  {"inetOrgPerson"},
+//		"cn":           {"John Doe"},
+//		"userPassword": {"{SSHA}..."},
+//	})
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return dir }
+//
+// A directory can also be seeded from an LDIF file with LoadLDIF, and
+// snapshot with WriteLDIF.
+//
+// The directory maintains the operational attributes entryUUID,
+// createTimestamp, modifyTimestamp, creatorsName, modifiersName and
+// entryDN of the entries, see ldap.SetOperationalAttributes, returned by
+// the searches requesting them by name or with "+". The references of
+// the entries to the entries moved or deleted, e.g. the members of the
+// groups, follow them, see Directory.ReferenceAttributes. The dynamic
+// entries (RFC 2589) are deleted unless refreshed, see Directory.Dynamic.
+package inmem
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/password"
+)
+
+// DefaultReferenceAttributes are the references maintained when
+// Directory.ReferenceAttributes is nil.
+var DefaultReferenceAttributes = []string{"member", "uniqueMember", "manager", "owner", "roleOccupant", "secretary", "seeAlso"}
+
+// ErrExists is returned by Directory.Add for a DN already in the
+// directory.
+var ErrExists = errors.New("inmem: entry already exists")
+
+// Directory is a tree of entries kept in memory. It is safe for concurrent
+// use.
+type Directory struct {
+	// Schema, when set, validates the entries of the add, modify and
+	// modify DN requests, see ldap.Schema.ValidateEntry. Set it before
+	// serving.
+	Schema *ldap.Schema
+
+	// PasswordQuality, when set, checks the clear text userPassword
+	// values of the add and modify requests, and the passwords set by
+	// the Password Modify extended operation, see
+	// ldap.CheckPasswordQuality. Set it before serving.
+	PasswordQuality password.Quality
+
+	// Notifier, when set, receives the changes of the entries, made by
+	// the requests, Add or LoadLDIF.
+	Notifier *ldap.Notifier
+
+	// ReferenceAttributes are the DN-valued attributes kept pointing at
+	// their entries: the values naming an entry moved by a modify DN
+	// request, or below it, are renamed, and those naming a deleted entry
+	// are removed. DefaultReferenceAttributes if nil; an empty slice
+	// leaves the references alone.
+	ReferenceAttributes []string
+
+	mu      sync.RWMutex
+	entries map[string]*ldap.Entry // by normalized DN
+	mux     *ldap.RouteMux
+	dynamic *ldap.DynamicEntries
+	handler ldap.Handler // mux behind dynamic
+}
+
+// New returns an empty directory.
+func New() *Directory {
+	d := &Directory{entries: make(map[string]*ldap.Entry)}
+	d.mux = ldap.BackendMux(backend{d})
+	d.mux.Extended(d.passwordModify).RequestName(ldap.NoticeOfPasswordModify)
+	d.dynamic = &ldap.DynamicEntries{Expire: d.expire, Exists: d.exists}
+	d.handler = d.dynamic.Handler(d.mux)
+	return d
+}
+
+// ServeLDAP serves the request m from the directory. Extended operations
+// other than Password Modify and Refresh are answered with
+// unwillingToPerform.
+func (d *Directory) ServeLDAP(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	d.handler.ServeLDAP(ctx, w, m)
+}
+
+// Dynamic returns the time to live of the dynamic entries of the
+// directory, those of the objectClass dynamicObject, which are deleted
+// unless refreshed. Its bounds and Allowed can be set before serving.
+func (d *Directory) Dynamic() *ldap.DynamicEntries {
+	return d.dynamic
+}
+
+// expire deletes the expired dynamic entry dn.
+func (d *Directory) expire(dn string) {
+	d.remove(context.Background(), dn)
+}
+
+// exists reports whether the directory has the entry dn.
+func (d *Directory) exists(dn string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.entries[ldap.NormalizeDN(dn)]
+	return ok
+}
+
+// passwordModify serves the Password Modify extended operation, see
+// ldap.PasswordModify.
+func (d *Directory) passwordModify(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	ldap.PasswordModify(backend{d}, d.PasswordQuality)(ctx, w, m)
+}
+
+// Backend returns the directory as an ldap.Backend, e.g. to serve it
+// with other routes or behind a decorator.
+func (d *Directory) Backend() ldap.Backend {
+	return backend{d}
+}
+
+// Add adds an entry to the directory, e.g. to load its content. Unlike an
+// add request, the parent of the entry does not need to exist, so that
+// naming contexts can be added, the entry is not validated against the
+// schema, and the operational attributes it has are kept, e.g. those of a
+// backup.
+func (d *Directory) Add(dn string, attributes map[string][]string) error {
+	return d.addEntry(ldap.NewEntry(strings.TrimSpace(dn), attributes).Clone())
+}
+
+// addEntry adds the entry e, see Add.
+func (d *Directory) addEntry(e *ldap.Entry) error {
+	addRDN(e)
+	ldap.SetOperationalAttributes(context.Background(), e, nil, time.Now())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := ldap.NormalizeDN(e.DN)
+	if _, ok := d.entries[key]; ok {
+		return ErrExists
+	}
+	d.entries[key] = e
+	d.track(e)
+	d.notify(ldap.ChangeEvent{Type: ldap.ChangeAdd, DN: e.DN, Entry: e})
+	return nil
+}
+
+// track starts tracking the entry e, when dynamic.
+func (d *Directory) track(e *ldap.Entry) {
+	if ldap.IsDynamic(e) {
+		d.dynamic.Add(e.DN)
+	}
+}
+
+// Entry returns the attributes of the entry dn, keyed by attribute name as
+// added.
+func (d *Directory) Entry(dn string) (attributes map[string][]string, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	e, ok := d.entries[ldap.NormalizeDN(dn)]
+	if !ok {
+		return nil, false
+	}
+	attributes = make(map[string][]string, len(e.Attributes))
+	for _, a := range e.Attributes {
+		attributes[a.Name] = append([]string(nil), a.Values...)
+	}
+	return attributes, true
+}
+
+// get returns the entry dn, nil when there is none. d.mu must be held.
+func (d *Directory) get(dn string) (*ldap.Entry, error) {
+	return d.entries[ldap.NormalizeDN(dn)], nil
+}
+
+// Len returns the number of entries of the directory.
+func (d *Directory) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.entries)
+}
+
+// matchedDN returns the DN of the deepest existing entry above dn, for
+// the matchedDN of noSuchObject results. d.mu must be held.
+func (d *Directory) matchedDN(dn string) string {
+	for p := ldap.ParentDN(dn); p != ""; p = ldap.ParentDN(p) {
+		if e, ok := d.entries[ldap.NormalizeDN(p)]; ok {
+			return e.DN
+		}
+	}
+	return ""
+}
+
+// noSuchObject returns the error for a missing entry dn.
+func (d *Directory) noSuchObject(dn string) error {
+	return &ldap.Error{ResultCode: ldap.LDAPResultNoSuchObject, MatchedDN: d.matchedDN(dn), DiagnosticMessage: "no such entry: " + dn}
+}
+
+// validate checks the new passwords of the entry e, the entry against the
+// schema of the directory, and that a modification of the entry old keeps
+// its structural object class and whether it is dynamic.
+func (d *Directory) validate(e, old *ldap.Entry) error {
+	if err := ldap.CheckPasswordQuality(d.PasswordQuality, e, old); err != nil {
+		return err
+	}
+	if old != nil && ldap.IsDynamic(e) != ldap.IsDynamic(old) {
+		// RFC 2589 section 3
+		return ldap.NewError(ldap.LDAPResultObjectClassModsProhibited, "can not change the dynamicObject object class")
+	}
+	if d.Schema == nil {
+		return nil
+	}
+	if err := d.Schema.ValidateEntry(e); err != nil {
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+	oc, _ := d.Schema.StructuralObjectClass(e)
+	if prev, err := d.Schema.StructuralObjectClass(old); err == nil && prev != oc {
+		return ldap.NewError(ldap.LDAPResultObjectClassModsProhibited, "can not change the structural object class "+prev.Name())
+	}
+	return nil
+}
+
+// notify publishes the change e to the Notifier, if any, with copies of
+// its entries. d.mu must be held, so that the changes are published in
+// order.
+func (d *Directory) notify(e ldap.ChangeEvent) {
+	if d.Notifier == nil {
+		return
+	}
+	if e.Entry != nil {
+		e.Entry = e.Entry.Clone()
+	}
+	if e.Old != nil {
+		e.Old = e.Old.Clone()
+	}
+	d.Notifier.Publish(e)
+}
+
+// updateReferences updates the references to the entries renamed, keyed
+// by normalized DN, in the entries of the directory but those skipped, see
+// renameReferences, publishing their changes. d.mu must be held.
+func (d *Directory) updateReferences(ctx context.Context, renamed map[string]string, skip map[string]*ldap.Entry) {
+	now := time.Now()
+	for _, k := range sortedKeys(d.entries) {
+		if _, ok := skip[k]; ok {
+			continue
+		}
+		e := d.entries[k]
+		if !d.refersTo(e, renamed) {
+			continue
+		}
+		c := e.Clone()
+		d.renameReferences(c, renamed)
+		ldap.SetOperationalAttributes(ctx, c, e, now)
+		d.entries[k] = c
+		d.notify(ldap.ChangeEvent{Type: ldap.ChangeModify, DN: c.DN, Entry: c, Old: e})
+	}
+}
+
+// refersTo reports whether e has references to the entries renamed.
+func (d *Directory) refersTo(e *ldap.Entry, renamed map[string]string) bool {
+	for _, name := range d.referenceAttributes() {
+		for _, v := range e.Get(name) {
+			if _, ok := renamed[ldap.NormalizeDN(v)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renameReferences replaces the references of e to the entries renamed by
+// their new DN, removing them when it is empty.
+func (d *Directory) renameReferences(e *ldap.Entry, renamed map[string]string) {
+	for _, name := range d.referenceAttributes() {
+		values, changed := e.Get(name), false
+		kept := make([]string, 0, len(values))
+		for _, v := range values {
+			newDN, ok := renamed[ldap.NormalizeDN(v)]
+			switch {
+			case !ok:
+				kept = append(kept, v)
+			case newDN != "":
+				kept = append(kept, newDN)
+			}
+			changed = changed || ok
+		}
+		if changed {
+			e.Replace(name, kept...)
+		}
+	}
+}
+
+func (d *Directory) referenceAttributes() []string {
+	if d.ReferenceAttributes == nil {
+		return DefaultReferenceAttributes
+	}
+	return d.ReferenceAttributes
+}
+
+// hasChildren reports whether entries are below the normalized dn. d.mu
+// must be held.
+func (d *Directory) hasChildren(key string) bool {
+	for k := range d.entries {
+		if k != key && ldap.InScope(k, key, ldap.SearchRequestHomeSubtree) {
+			return true
+		}
+	}
+	return false
+}
+
+// addRDN adds the attribute values of the RDN of e, which must be present
+// in the entry.
+func addRDN(e *ldap.Entry) {
+	for _, a := range rdn(e.DN) {
+		if !e.Has(a.Type, a.Value) {
+			e.Add(a.Type, a.Value)
+		}
+	}
+}
+
+// rdn returns the RDN of dn, nil for an invalid or empty dn.
+func rdn(dn string) ldap.RDN {
+	d, err := ldap.ParseDN(dn)
+	if err != nil || len(d) == 0 {
+		return nil
+	}
+	return d[0]
+}
+
+// depth returns the number of RDNs of dn.
+func depth(dn string) int {
+	d, _ := ldap.ParseDN(dn)
+	return len(d)
+}
+
+// sortedKeys returns the normalized DNs of the entries, parents before
+// their children.
+func sortedKeys(entries map[string]*ldap.Entry) []string {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		di, dj := depth(keys[i]), depth(keys[j])
+		if di != dj {
+			return di < dj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,318 @@
+package inmem
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/password"
+)
+
+// backend is the ldap.Backend of a directory, a distinct type since the
+// Add method of Directory loads entries.
+type backend struct{ *Directory }
+
+// Bind checks a simple bind against the userPassword values of the entry,
+// see password.Verify for the supported hashes.
+func (d backend) Bind(ctx context.Context, dn, secret string) error {
+	var hashes []string
+	d.mu.RLock()
+	if e, ok := d.entries[ldap.NormalizeDN(dn)]; ok {
+		hashes = append(hashes, e.Get("userPassword")...)
+	}
+	d.mu.RUnlock()
+	for _, h := range hashes {
+		if ok, _ := password.Verify(h, secret); ok {
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, "")
+}
+
+// Search calls f with the entries of the search r, dereferencing the
+// aliases as requested by its derefAliases: the alias base of the search
+// is replaced by the entry it names, and the aliases found in searching by
+// the entries they name, with their subtree for a subtree search. The
+// aliases naming no entry or in a loop are skipped in searching.
+func (d backend) Search(ctx context.Context, r goldap.SearchRequest, f func(*ldap.Entry) error) error {
+	base := ldap.NormalizeDN(string(r.BaseObject()))
+	deref := int(r.DerefAliases())
+	filter, err := ldap.NewSearchFilter(r)
+	if err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	e, ok := d.entries[base]
+	if !ok && base != "" {
+		err := d.noSuchObject(string(r.BaseObject()))
+		d.mu.RUnlock()
+		return err
+	}
+	if ok && (deref == ldap.DerefFindingBaseObj || deref == ldap.DerefAlways) {
+		target, err := ldap.DerefAlias(e, d.get)
+		if err != nil {
+			d.mu.RUnlock()
+			return err
+		}
+		base = ldap.NormalizeDN(target.DN)
+	}
+	s := &searcher{
+		Directory: d.Directory,
+		keys:      sortedKeys(d.entries),
+		filter:    filter,
+		deref:     deref == ldap.DerefInSearching || deref == ldap.DerefAlways,
+		seen:      make(map[string]bool),
+		searched:  make(map[string]bool),
+	}
+	err = s.search(base, int(r.Scope()))
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range s.results {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searcher collects the entries of a search, d.mu being held.
+type searcher struct {
+	*Directory
+	keys     []string // sorted keys of the entries
+	filter   ldap.SearchFilter
+	deref    bool            // dereference the aliases in searching
+	seen     map[string]bool // keys of the results
+	searched map[string]bool // scopes and keys of the bases searched
+	results  []*ldap.Entry
+}
+
+// search collects the entries in the scope of the base with the key base,
+// and those in the scopes of the entries named by the aliases found.
+func (s *searcher) search(base string, scope int) error {
+	if s.searched[strconv.Itoa(scope)+base] {
+		return nil
+	}
+	s.searched[strconv.Itoa(scope)+base] = true
+	var aliases []*ldap.Entry
+	for _, k := range s.keys {
+		e := s.entries[k]
+		if !ldap.InScope(k, base, scope) || s.seen[k] {
+			continue
+		}
+		if s.deref && k != base && ldap.IsAlias(e) {
+			aliases = append(aliases, e)
+			continue
+		}
+		ok, err := s.filter.Match(*e)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.seen[k] = true
+			s.results = append(s.results, e.Clone())
+		}
+	}
+	if scope == ldap.SearchRequestSingleLevel {
+		scope = ldap.SearchRequestScopeBaseObject
+	}
+	for _, a := range aliases {
+		if target, err := ldap.DerefAlias(a, s.get); err == nil {
+			if err := s.search(ldap.NormalizeDN(target.DN), scope); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d backend) Add(ctx context.Context, e *ldap.Entry) error {
+	e = e.Clone()
+	e.DN = strings.TrimSpace(e.DN)
+	addRDN(e)
+	ldap.SetOperationalAttributes(ctx, e, nil, time.Now())
+	if err := d.validate(e, nil); err != nil {
+		return err
+	}
+	return d.insert(e)
+}
+
+// insert adds the entry of an add request, below an existing parent.
+func (d *Directory) insert(e *ldap.Entry) error {
+	dn, err := ldap.ParseDN(e.DN)
+	if err != nil {
+		return err
+	}
+	if len(dn) == 0 {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, "empty DN")
+	}
+	key := dn.Normalize()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.entries[key]; ok {
+		return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, e.DN)
+	}
+	if parent := dn.Parent(); parent != nil {
+		if _, ok := d.entries[parent.Normalize()]; !ok {
+			return d.noSuchObject(parent.String())
+		}
+	}
+	d.entries[key] = e
+	d.track(e)
+	d.notify(ldap.ChangeEvent{Type: ldap.ChangeAdd, DN: e.DN, Entry: e})
+	return nil
+}
+
+func (d backend) Delete(ctx context.Context, dn string) error {
+	return d.remove(ctx, dn)
+}
+
+// remove deletes the leaf entry dn, and the references to it.
+func (d *Directory) remove(ctx context.Context, dn string) error {
+	key := ldap.NormalizeDN(dn)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[key]
+	if !ok {
+		return d.noSuchObject(dn)
+	}
+	if d.hasChildren(key) {
+		return ldap.NewError(ldap.LDAPResultNotAllowedOnNonLeaf, dn)
+	}
+	delete(d.entries, key)
+	d.dynamic.Remove(e.DN)
+	d.notify(ldap.ChangeEvent{Type: ldap.ChangeDelete, DN: e.DN, Old: e})
+	d.updateReferences(ctx, map[string]string{key: ""}, nil)
+	return nil
+}
+
+func (d backend) Modify(ctx context.Context, dn string, changes []ldap.Modification) error {
+	return d.update(ctx, dn, func(e *ldap.Entry) error { return e.Modify(changes...) })
+}
+
+// update applies the changes of a modify request to a copy of the entry
+// dn, which replaces it once they all succeed.
+func (d *Directory) update(ctx context.Context, dn string, apply func(*ldap.Entry) error) error {
+	key := ldap.NormalizeDN(dn)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[key]
+	if !ok {
+		return d.noSuchObject(dn)
+	}
+	c := e.Clone()
+	if err := apply(c); err != nil {
+		return err
+	}
+	ldap.SetOperationalAttributes(ctx, c, e, time.Now())
+	for _, a := range rdn(c.DN) {
+		if !c.Has(a.Type, a.Value) {
+			return ldap.NewError(ldap.LDAPResultNotAllowedOnRDN, "can not remove the RDN value of "+a.Type)
+		}
+	}
+	if err := d.validate(c, e); err != nil {
+		return err
+	}
+	d.entries[key] = c
+	d.notify(ldap.ChangeEvent{Type: ldap.ChangeModify, DN: c.DN, Entry: c, Old: e})
+	return nil
+}
+
+func (d backend) ModifyDN(ctx context.Context, r ldap.ModifyDNRequest) error {
+	return d.move(ctx, r)
+}
+
+// move renames an entry or moves it under a new superior, with the
+// entries below it, and the references to them.
+func (d *Directory) move(ctx context.Context, r ldap.ModifyDNRequest) error {
+	key := ldap.NormalizeDN(r.Entry)
+	newDN := r.NewDN()
+	if _, err := ldap.ParseDN(newDN); err != nil {
+		return err
+	}
+	newKey := ldap.NormalizeDN(newDN)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[key]
+	if !ok {
+		return d.noSuchObject(r.Entry)
+	}
+	if _, ok := d.entries[newKey]; ok && newKey != key {
+		return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, newDN)
+	}
+	if r.NewSuperior != nil {
+		superior := ldap.NormalizeDN(*r.NewSuperior)
+		if _, ok := d.entries[superior]; !ok && superior != "" {
+			return d.noSuchObject(*r.NewSuperior)
+		}
+		if ldap.InScope(superior, key, ldap.SearchRequestHomeSubtree) {
+			return ldap.NewError(ldap.LDAPResultUnwillingToPerform, "can not move an entry below itself")
+		}
+	}
+
+	c := e.Clone()
+	c.DN = newDN
+	if r.DeleteOldRDN {
+		for _, a := range rdn(e.DN) {
+			if c.Has(a.Type, a.Value) {
+				c.Delete(a.Type, a.Value)
+			}
+		}
+	}
+	addRDN(c)
+	ldap.SetOperationalAttributes(ctx, c, e, time.Now())
+	if err := d.validate(c, nil); err != nil {
+		return err
+	}
+
+	n := depth(e.DN)
+	moved := map[string]*ldap.Entry{newKey: c}
+	renamed := map[string]string{key: newDN}
+	events := []ldap.ChangeEvent{{Type: ldap.ChangeModDN, DN: c.DN, OldDN: e.DN, Entry: c, Old: e}}
+	for _, k := range sortedKeys(d.entries) {
+		if k == key || !ldap.InScope(k, key, ldap.SearchRequestHomeSubtree) {
+			continue
+		}
+		old := d.entries[k]
+		dn, _ := ldap.ParseDN(old.DN)
+		child := old.Clone()
+		child.DN = dn[:len(dn)-n].String() + "," + newDN
+		child.Replace("entryDN", child.DN)
+		moved[ldap.NormalizeDN(child.DN)] = child
+		renamed[k] = child.DN
+		events = append(events, ldap.ChangeEvent{Type: ldap.ChangeModDN, DN: child.DN, OldDN: old.DN, Entry: child, Old: old})
+		delete(d.entries, k)
+	}
+	delete(d.entries, key)
+	for k, e := range moved {
+		d.entries[k] = e
+		d.renameReferences(e, renamed)
+	}
+	for _, e := range events {
+		d.dynamic.Rename(e.OldDN, e.DN)
+		d.notify(e)
+	}
+	d.updateReferences(ctx, renamed, moved)
+	return nil
+}
+
+// Compare reports whether the attribute name of the entry dn has the
+// value, with the equality rule of the attribute in the schema of the
+// directory, see ldap.CompareEntry.
+func (d backend) Compare(ctx context.Context, dn, name, value string) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	e, ok := d.entries[ldap.NormalizeDN(dn)]
+	if !ok {
+		return false, d.noSuchObject(dn)
+	}
+	return ldap.CompareEntry(e, name, value, d.Schema)
+}
@@ -0,0 +1,57 @@
+package inmem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nolta/ldapserver/ldif"
+)
+
+// LoadLDIF loads an LDIF file, e.g. to seed the directory from a file
+// written by WriteLDIF. Its content and add records are added as by Add,
+// its delete, modify and modrdn records are applied as the requests. It
+// stops at the first error, the records before it being loaded.
+func (d *Directory) LoadLDIF(r io.Reader) error {
+	records := ldif.NewReader(r)
+	for {
+		rec, err := records.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch rec.ChangeType {
+		case "", ldif.ChangeAdd:
+			e := rec.Entry()
+			e.DN = strings.TrimSpace(e.DN)
+			err = d.addEntry(e)
+		case ldif.ChangeDelete:
+			err = d.remove(context.Background(), rec.DN)
+		case ldif.ChangeModify:
+			err = d.update(context.Background(), rec.DN, rec.Apply)
+		case ldif.ChangeModRDN:
+			err = d.move(context.Background(), rec.ModifyDNRequest())
+		}
+		if err != nil {
+			return fmt.Errorf("inmem: %s: %w", rec.DN, err)
+		}
+	}
+}
+
+// WriteLDIF writes the entries of the directory as LDIF content records,
+// parents before their children, e.g. to snapshot the directory.
+func (d *Directory) WriteLDIF(w io.Writer) error {
+	out := ldif.NewWriter(w)
+	d.mu.RLock()
+	for _, k := range sortedKeys(d.entries) {
+		if err := out.WriteEntry(d.entries[k]); err != nil {
+			d.mu.RUnlock()
+			return err
+		}
+	}
+	d.mu.RUnlock()
+	return out.Flush()
+}
@@ -0,0 +1,299 @@
+// Package krb5 accepts Kerberos GSS-API security contexts with a keytab,
+// for the GSSAPI and GSS-SPNEGO SASL mechanisms of ldapserver:
+//
+//	acceptor, err := krb5.NewAcceptor("/etc/ldap.keytab", "ldap/ldap.example.com")
+//	server.SaslMechanisms = map[string]ldap.SaslMechanism{
+//		ldap.SaslGSSAPI:    ldap.SaslGSSAPIMechanism(acceptor.Config()),
+//		ldap.SaslGSSSPNEGO: ldap.SaslGSSSPNEGOMechanism(acceptor.Config()),
+//	}
+package krb5
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// GSS-API context flags of the authenticator checksum (RFC 4121 section
+// 4.1.1.1).
+const (
+	flagMutual = 2
+)
+
+// Acceptor verifies Kerberos AP-REQ tokens against a keytab.
+type Acceptor struct {
+	// Keytab holds the keys of the service principal.
+	Keytab *keytab.Keytab
+
+	// ServicePrincipal, e.g. "ldap/ldap.example.com", selects the keytab
+	// entry. If empty, the service name of the ticket is used.
+	ServicePrincipal string
+
+	// MaxClockSkew is the tolerated clock difference with clients, 5
+	// minutes if zero.
+	MaxClockSkew time.Duration
+
+	// RequireChannelBinding rejects clients sending no channel bindings
+	// when the mechanism provides them, see ldap.GSSConfig.ChannelBinding.
+	// Clients sending bindings are always verified.
+	RequireChannelBinding bool
+}
+
+// NewAcceptor loads a keytab file.
+func NewAcceptor(keytabPath, servicePrincipal string) (*Acceptor, error) {
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Acceptor{Keytab: kt, ServicePrincipal: servicePrincipal}, nil
+}
+
+// Config returns the mechanism configuration using the acceptor.
+func (a *Acceptor) Config() ldap.GSSConfig {
+	return ldap.GSSConfig{NewContext: a.NewContext}
+}
+
+// NewContext returns a new acceptor security context. It accepts raw
+// Kerberos tokens (GSSAPI) and SPNEGO tokens (GSS-SPNEGO).
+func (a *Acceptor) NewContext() (ldap.GSSContext, error) {
+	if a.Keytab == nil {
+		return nil, errors.New("krb5: no keytab")
+	}
+	return &secContext{a: a}, nil
+}
+
+func (a *Acceptor) settings() *service.Settings {
+	opts := []func(*service.Settings){service.DecodePAC(false)}
+	if a.ServicePrincipal != "" {
+		opts = append(opts, service.KeytabPrincipal(a.ServicePrincipal))
+	}
+	if a.MaxClockSkew > 0 {
+		opts = append(opts, service.MaxClockSkew(a.MaxClockSkew))
+	}
+	return service.NewSettings(a.Keytab, opts...)
+}
+
+// secContext is an established, or being established, security context.
+type secContext struct {
+	a         *Acceptor
+	principal string
+	key       types.EncryptionKey // key protecting the wrap tokens
+	bindings  []byte              // expected channel bindings hash
+
+	mu      sync.Mutex
+	sendSeq uint64
+	recvSeq uint64 // expected sequence number of the next wrap token
+}
+
+func (c *secContext) Accept(token []byte) ([]byte, bool, error) {
+	if len(token) == 0 {
+		return nil, false, errors.New("krb5: empty context token")
+	}
+
+	// SPNEGO NegTokenInit, or a raw Kerberos token
+	var spnegoMech asn1.ObjectIdentifier
+	var neg spnego.SPNEGOToken
+	if err := neg.Unmarshal(token); err == nil && neg.Init {
+		spnegoMech = gssapi.OIDKRB5.OID()
+		for _, mech := range neg.NegTokenInit.MechTypes {
+			if mech.Equal(gssapi.OIDMSLegacyKRB5.OID()) || mech.Equal(gssapi.OIDKRB5.OID()) {
+				spnegoMech = mech
+				break
+			}
+		}
+		token = neg.NegTokenInit.MechTokenBytes
+	}
+
+	var krb spnego.KRB5Token
+	if err := krb.Unmarshal(token); err != nil {
+		return nil, false, fmt.Errorf("krb5: %w", err)
+	}
+	if !krb.IsAPReq() {
+		return nil, false, errors.New("krb5: context token is not an AP-REQ")
+	}
+	ok, creds, err := service.VerifyAPREQ(&krb.APReq, c.a.settings())
+	if err != nil {
+		return nil, false, fmt.Errorf("krb5: %w", err)
+	}
+	if !ok {
+		return nil, false, errors.New("krb5: AP-REQ verification failed")
+	}
+	c.principal = creds.CName().PrincipalNameString() + "@" + creds.Realm()
+
+	if err := c.verifyBindings(krb.APReq); err != nil {
+		return nil, false, err
+	}
+
+	auth := krb.APReq.Authenticator
+	c.key = krb.APReq.Ticket.DecryptedEncPart.Key
+	if auth.SubKey.KeyType != 0 {
+		c.key = auth.SubKey
+	}
+	c.recvSeq = uint64(auth.SeqNumber)
+	c.sendSeq = uint64(auth.SeqNumber)
+
+	var output []byte
+	if mutualRequested(krb.APReq) {
+		output, err = c.apRep(krb.APReq)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if spnegoMech != nil {
+		resp := spnego.NegTokenResp{
+			NegState:      asn1.Enumerated(0), // accept-completed
+			SupportedMech: spnegoMech,
+			ResponseToken: output,
+		}
+		output, err = resp.Marshal()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return output, true, nil
+}
+
+// SetChannelBindings sets the application data of the channel bindings
+// the initiator must have used (RFC 4121 section 4.1.1.2).
+func (c *secContext) SetChannelBindings(applicationData []byte) {
+	// gss_channel_bindings_struct with no addresses
+	b := make([]byte, 16, 20+len(applicationData))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(applicationData)))
+	b = append(b, applicationData...)
+	h := md5.Sum(b)
+	c.bindings = h[:]
+}
+
+// verifyBindings checks the Bnd field of the authenticator checksum.
+func (c *secContext) verifyBindings(req messages.APReq) error {
+	if c.bindings == nil {
+		return nil
+	}
+	cksum := req.Authenticator.Cksum.Checksum
+	var bnd []byte
+	if req.Authenticator.Cksum.CksumType == 0x8003 && len(cksum) >= 20 {
+		bnd = cksum[4:20]
+	}
+	if bnd == nil || bytes.Equal(bnd, make([]byte, 16)) {
+		if c.a.RequireChannelBinding {
+			return errors.New("krb5: missing channel bindings")
+		}
+		return nil
+	}
+	if !bytes.Equal(bnd, c.bindings) {
+		return errors.New("krb5: channel bindings mismatch")
+	}
+	return nil
+}
+
+func mutualRequested(req messages.APReq) bool {
+	cksum := req.Authenticator.Cksum.Checksum
+	if req.Authenticator.Cksum.CksumType == 0x8003 && len(cksum) >= 24 {
+		return binary.LittleEndian.Uint32(cksum[20:24])&flagMutual != 0
+	}
+	return types.IsFlagSet(&req.APOptions, 2) // mutual-required
+}
+
+// apRep builds the GSS-API token carrying the AP-REP of mutual
+// authentication (RFC 4121 section 4.1).
+func (c *secContext) apRep(req messages.APReq) ([]byte, error) {
+	part := messages.EncAPRepPart{
+		CTime:          req.Authenticator.CTime,
+		Cusec:          req.Authenticator.Cusec,
+		SequenceNumber: int64(c.sendSeq),
+	}
+	b, err := asn1.Marshal(part)
+	if err != nil {
+		return nil, err
+	}
+	b = asn1tools.AddASNAppTag(b, asnAppTag.EncAPRepPart)
+	enc, err := crypto.GetEncryptedData(b, req.Ticket.DecryptedEncPart.Key, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		return nil, err
+	}
+	rep := messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: enc}
+	b, err = asn1.Marshal(rep)
+	if err != nil {
+		return nil, err
+	}
+	b = asn1tools.AddASNAppTag(b, asnAppTag.APREP)
+
+	oid, _ := asn1.Marshal(gssapi.OIDKRB5.OID())
+	token := append(oid, 0x02, 0x00) // TOK_ID KRB_AP_REP
+	token = append(token, b...)
+	return asn1tools.AddASNAppTag(token, 0), nil
+}
+
+func (c *secContext) Wrap(message []byte, confidential bool) ([]byte, error) {
+	if confidential {
+		return nil, errors.New("krb5: confidentiality is not supported")
+	}
+	c.mu.Lock()
+	seq := c.sendSeq
+	c.sendSeq++
+	c.mu.Unlock()
+
+	etype, err := crypto.GetEtype(c.key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	wt := gssapi.WrapToken{
+		Flags:     0x01, // sent by acceptor
+		EC:        uint16(etype.GetHMACBitLength() / 8),
+		SndSeqNum: seq,
+		Payload:   message,
+	}
+	if err := wt.SetCheckSum(c.key, keyusage.GSSAPI_ACCEPTOR_SEAL); err != nil {
+		return nil, err
+	}
+	return wt.Marshal()
+}
+
+func (c *secContext) Unwrap(token []byte) ([]byte, bool, error) {
+	var wt gssapi.WrapToken
+	if err := wt.Unmarshal(token, false); err != nil {
+		return nil, false, fmt.Errorf("krb5: %w", err)
+	}
+	if wt.Flags&0x01 != 0 {
+		return nil, false, errors.New("krb5: wrap token sent by the acceptor")
+	}
+	if wt.Flags&0x02 != 0 {
+		return nil, false, errors.New("krb5: confidentiality is not supported")
+	}
+	if ok, err := wt.Verify(c.key, keyusage.GSSAPI_INITIATOR_SEAL); !ok {
+		return nil, false, fmt.Errorf("krb5: invalid wrap token: %v", err)
+	}
+	// RFC 4121 section 4.2.6.2: the tokens are neither replayed nor
+	// reordered
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wt.SndSeqNum != c.recvSeq {
+		return nil, false, fmt.Errorf("krb5: wrap token out of sequence: %d, expected %d", wt.SndSeqNum, c.recvSeq)
+	}
+	c.recvSeq++
+	return wt.Payload, false, nil
+}
+
+func (c *secContext) SourceName() string {
+	return c.principal
+}
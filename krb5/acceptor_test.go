@@ -0,0 +1,58 @@
+package krb5
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+var testKey = types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: bytes.Repeat([]byte{7}, 32)}
+
+// wrapToken returns a wrap token of the initiator, or of the acceptor.
+func wrapToken(t *testing.T, seq uint64, payload string, acceptor bool) []byte {
+	wt := gssapi.WrapToken{EC: 12, SndSeqNum: seq, Payload: []byte(payload)}
+	usage := uint32(keyusage.GSSAPI_INITIATOR_SEAL)
+	if acceptor {
+		wt.Flags, usage = 0x01, keyusage.GSSAPI_ACCEPTOR_SEAL
+	}
+	if err := wt.SetCheckSum(testKey, usage); err != nil {
+		t.Fatal(err)
+	}
+	b, err := wt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUnwrapSequence(t *testing.T) {
+	c := &secContext{key: testKey, recvSeq: 100}
+	first, second, third := wrapToken(t, 100, "first", false), wrapToken(t, 101, "second", false), wrapToken(t, 102, "third", false)
+
+	if p, _, err := c.Unwrap(first); err != nil || string(p) != "first" {
+		t.Fatalf("first token: %q, %v", p, err)
+	}
+	if _, _, err := c.Unwrap(first); err == nil {
+		t.Error("replayed token accepted")
+	}
+	if _, _, err := c.Unwrap(third); err == nil {
+		t.Error("reordered token accepted")
+	}
+	if p, _, err := c.Unwrap(second); err != nil || string(p) != "second" {
+		t.Fatalf("second token: %q, %v", p, err)
+	}
+	if p, _, err := c.Unwrap(third); err != nil || string(p) != "third" {
+		t.Fatalf("third token: %q, %v", p, err)
+	}
+}
+
+func TestUnwrapAcceptorToken(t *testing.T) {
+	c := &secContext{key: testKey, recvSeq: 100}
+	if _, _, err := c.Unwrap(wrapToken(t, 100, "reflected", true)); err == nil {
+		t.Error("token of the acceptor accepted")
+	}
+}
@@ -0,0 +1,70 @@
+package ldapserver
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultLDAPISocket is the socket path used for a bare "ldapi://" address.
+const DefaultLDAPISocket = "/var/run/ldapi"
+
+// PeerCredentials are the credentials of the process on the other end of
+// an ldapi:// (unix socket) connection, as reported by SO_PEERCRED.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// ldapiPath returns the socket path of an "ldapi://" address. As in
+// OpenLDAP, the path is URL encoded in the host part, e.g.
+// ldapi://%2Fvar%2Frun%2Fslapd.sock.
+func ldapiPath(addr string) (path string, ok bool, err error) {
+	rest, ok := strings.CutPrefix(addr, "ldapi://")
+	if !ok {
+		return "", false, nil
+	}
+	rest = strings.TrimSuffix(rest, "/")
+	if rest == "" {
+		return DefaultLDAPISocket, true, nil
+	}
+	path, err = url.PathUnescape(rest)
+	return path, true, err
+}
+
+// listenUnix listens on a unix socket, replacing a stale socket file left
+// by a previous run, and applies Server.UnixSocketMode.
+func (s *Server) listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, errors.New("ldapserver: socket " + path + " is in use")
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if s.UnixSocketMode != 0 {
+		if err := os.Chmod(path, s.UnixSocketMode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// PeerCredentials returns the credentials of the peer process of an
+// ldapi:// connection. ok is false for network connections and on
+// platforms without SO_PEERCRED.
+func (c *client) PeerCredentials() (cred *PeerCredentials, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	return c.peerCred, c.peerCred != nil
+}
@@ -0,0 +1,125 @@
+// Package ldif reads and writes LDIF (RFC 2849), the text format of
+// directory entries and of changes to a directory, e.g. to seed a mock
+// directory or to snapshot it:
+//
+//	r := ldif.NewReader(f)
+//	for {
+//		rec, err := r.Read()
+//		if err == io.EOF {
+//			break
+//		}
+//		if err != nil {
+//			return err
+//		}
+//		if rec.ChangeType == "" {
+//			log.Printf("entry %s", rec.DN)
+//		}
+//	}
+package ldif
+
+import (
+	"strconv"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// Change types of the change records.
+const (
+	ChangeAdd    = "add"
+	ChangeDelete = "delete"
+	ChangeModify = "modify"
+	ChangeModRDN = "modrdn" // "moddn" is read as ChangeModRDN
+)
+
+// Increment is the operation of the increment changes of modify records
+// (RFC 4525), next to ldap.ModifyRequestChangeOperationAdd, Delete and
+// Replace.
+const Increment = 3
+
+// Record is an LDIF record: the content of an entry when ChangeType is
+// empty, or a change to a directory.
+type Record struct {
+	DN         string
+	ChangeType string
+	Controls   []ldap.Control // change records only
+
+	// Attributes are the attributes of a content or add record.
+	Attributes []ldap.EntryAttribute
+
+	// Changes are the changes of a modify record.
+	Changes []Change
+
+	// NewRDN, DeleteOldRDN and NewSuperior are the fields of a modrdn
+	// record, see ModifyDNRequest.
+	NewRDN       string
+	DeleteOldRDN bool
+	NewSuperior  *string // nil when the entry stays under its parent
+}
+
+// Change is a change of a modify record.
+type Change struct {
+	Operation int // ldap.ModifyRequestChangeOperationAdd, Delete, Replace or Increment
+	Attribute string
+	Values    []string
+}
+
+// Entry returns the entry of a content or add record.
+func (r *Record) Entry() *ldap.Entry {
+	e := &ldap.Entry{DN: r.DN}
+	e.Attributes = make([]ldap.EntryAttribute, len(r.Attributes))
+	for i, a := range r.Attributes {
+		e.Attributes[i] = ldap.EntryAttribute{Name: a.Name, Values: append([]string(nil), a.Values...)}
+	}
+	return e
+}
+
+// ModifyDNRequest returns the request of a modrdn record.
+func (r *Record) ModifyDNRequest() ldap.ModifyDNRequest {
+	return ldap.ModifyDNRequest{Entry: r.DN, NewRDN: r.NewRDN, DeleteOldRDN: r.DeleteOldRDN, NewSuperior: r.NewSuperior}
+}
+
+// Apply applies the changes of a modify record to the entry e in order,
+// atomically: on error, e is left unchanged and the error is an
+// *ldap.Error with the result code of a modify request.
+func (r *Record) Apply(e *ldap.Entry) error {
+	c := e.Clone()
+	for _, change := range r.Changes {
+		var err error
+		if change.Operation == Increment {
+			err = increment(c, change.Attribute, change.Values)
+		} else {
+			err = c.Modify(ldap.Modification{Operation: change.Operation, Attribute: change.Attribute, Values: change.Values})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	e.Attributes = c.Attributes
+	return nil
+}
+
+// increment adds the single value of an increment change to the integer
+// values of the attribute name (RFC 4525 section 3).
+func increment(e *ldap.Entry, name string, values []string) error {
+	if len(values) != 1 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, "increment needs a single value")
+	}
+	delta, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, name+": "+values[0])
+	}
+	current := e.Get(name)
+	if current == nil {
+		return ldap.NewError(ldap.LDAPResultNoSuchAttribute, name)
+	}
+	incremented := make([]string, len(current))
+	for i, v := range current {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return ldap.NewError(ldap.LDAPResultConstraintViolation, name+" is not an integer")
+		}
+		incremented[i] = strconv.FormatInt(n+delta, 10)
+	}
+	e.Replace(name, incremented...)
+	return nil
+}
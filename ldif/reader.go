@@ -0,0 +1,358 @@
+package ldif
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// Reader reads the records of an LDIF file.
+type Reader struct {
+	// ReadURL returns the value of the "attr:< url" lines. When nil, only
+	// file URLs are read.
+	ReadURL func(url string) ([]byte, error)
+
+	lines *bufio.Scanner
+	n     int // line number of the scanner
+	next  *line
+	first bool
+}
+
+// line is a logical line, its continuations unfolded.
+type line struct {
+	text string
+	n    int // line number of its first physical line
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, 1<<20)
+	return &Reader{lines: lines, first: true}
+}
+
+// ReadAll reads the remaining records.
+func (r *Reader) ReadAll() ([]*Record, error) {
+	var records []*Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// Read reads the next record, io.EOF after the last one. The errors give
+// the line of the record in error.
+func (r *Reader) Read() (*Record, error) {
+	lines, err := r.record()
+	if err != nil {
+		return nil, err
+	}
+	if r.first {
+		r.first = false
+		if len(lines) > 0 && strings.HasPrefix(strings.ToLower(lines[0].text), "version:") {
+			if _, value, _ := strings.Cut(lines[0].text, ":"); strings.TrimSpace(value) != "1" {
+				return nil, errorf(lines[0], "unsupported version %s", strings.TrimSpace(value))
+			}
+			lines = lines[1:]
+			if len(lines) == 0 {
+				return r.Read()
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil, io.EOF
+	}
+	return r.parse(lines)
+}
+
+// record returns the lines of the next record, without the comments,
+// none at the end of the input.
+func (r *Reader) record() ([]line, error) {
+	var lines []line
+	comment := false
+	for {
+		l, err := r.line()
+		if err != nil {
+			return nil, err
+		}
+		if l == nil {
+			return lines, nil
+		}
+		switch {
+		case l.text == "":
+			if len(lines) > 0 {
+				return lines, nil
+			}
+		case l.text[0] == ' ':
+			if comment {
+				continue
+			}
+			if len(lines) == 0 {
+				return nil, errorf(*l, "continuation line without a line to continue")
+			}
+			lines[len(lines)-1].text += l.text[1:]
+		case l.text[0] == '#':
+			comment = true
+			continue
+		default:
+			lines = append(lines, *l)
+		}
+		comment = false
+	}
+}
+
+// line returns the next physical line, nil at the end of the input.
+func (r *Reader) line() (*line, error) {
+	if !r.lines.Scan() {
+		return nil, r.lines.Err()
+	}
+	r.n++
+	return &line{text: strings.TrimSuffix(r.lines.Text(), "\r"), n: r.n}, nil
+}
+
+// parse parses the lines of a record.
+func (r *Reader) parse(lines []line) (*Record, error) {
+	name, dn, err := r.attributeValue(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(name, "dn") {
+		return nil, errorf(lines[0], "record without dn")
+	}
+	rec := &Record{DN: dn}
+	lines = lines[1:]
+
+	for len(lines) > 0 && strings.HasPrefix(strings.ToLower(lines[0].text), "control:") {
+		control, err := r.control(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		rec.Controls = append(rec.Controls, control)
+		lines = lines[1:]
+	}
+	if len(lines) > 0 && strings.HasPrefix(strings.ToLower(lines[0].text), "changetype:") {
+		_, changeType, err := r.attributeValue(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		switch changeType = strings.ToLower(changeType); changeType {
+		case ChangeAdd, ChangeDelete, ChangeModify, ChangeModRDN:
+		case "moddn":
+			changeType = ChangeModRDN
+		default:
+			return nil, errorf(lines[0], "unknown changetype %s", changeType)
+		}
+		rec.ChangeType = changeType
+		lines = lines[1:]
+	} else if len(rec.Controls) > 0 {
+		return nil, errorf(lines[0], "control without changetype")
+	}
+
+	switch rec.ChangeType {
+	case "", ChangeAdd:
+		err = r.parseAttributes(rec, lines)
+	case ChangeDelete:
+		if len(lines) > 0 {
+			err = errorf(lines[0], "unexpected line in a delete record")
+		}
+	case ChangeModify:
+		err = r.parseChanges(rec, lines)
+	case ChangeModRDN:
+		err = r.parseModRDN(rec, lines)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *Reader) parseAttributes(rec *Record, lines []line) error {
+	for _, l := range lines {
+		name, value, err := r.attributeValue(l)
+		if err != nil {
+			return err
+		}
+		i := 0
+		for i < len(rec.Attributes) && !strings.EqualFold(rec.Attributes[i].Name, name) {
+			i++
+		}
+		if i == len(rec.Attributes) {
+			rec.Attributes = append(rec.Attributes, ldap.EntryAttribute{Name: name})
+		}
+		rec.Attributes[i].Values = append(rec.Attributes[i].Values, value)
+	}
+	if len(rec.Attributes) == 0 && rec.ChangeType == ChangeAdd {
+		return fmt.Errorf("ldif: add record %s without attributes", rec.DN)
+	}
+	return nil
+}
+
+// parseChanges parses the changes of a modify record, each one being an
+// "add:", "delete:", "replace:" or "increment:" line followed by the
+// values and a "-" line.
+func (r *Reader) parseChanges(rec *Record, lines []line) error {
+	for len(lines) > 0 {
+		op, attribute, err := r.attributeValue(lines[0])
+		if err != nil {
+			return err
+		}
+		change := Change{Attribute: attribute}
+		switch strings.ToLower(op) {
+		case "add":
+			change.Operation = ldap.ModifyRequestChangeOperationAdd
+		case "delete":
+			change.Operation = ldap.ModifyRequestChangeOperationDelete
+		case "replace":
+			change.Operation = ldap.ModifyRequestChangeOperationReplace
+		case "increment":
+			change.Operation = Increment
+		default:
+			return errorf(lines[0], "unknown modify operation %s", op)
+		}
+		start := lines[0]
+		lines = lines[1:]
+		for {
+			if len(lines) == 0 {
+				return errorf(start, "missing '-' after the %s change", op)
+			}
+			if strings.TrimRight(lines[0].text, " ") == "-" {
+				lines = lines[1:]
+				break
+			}
+			name, value, err := r.attributeValue(lines[0])
+			if err != nil {
+				return err
+			}
+			if !strings.EqualFold(name, attribute) {
+				return errorf(lines[0], "value of %s in the %s change of %s", name, op, attribute)
+			}
+			change.Values = append(change.Values, value)
+			lines = lines[1:]
+		}
+		rec.Changes = append(rec.Changes, change)
+	}
+	return nil
+}
+
+func (r *Reader) parseModRDN(rec *Record, lines []line) error {
+	var newRDN, deleteOldRDN bool
+	for _, l := range lines {
+		name, value, err := r.attributeValue(l)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(name) {
+		case "newrdn":
+			rec.NewRDN, newRDN = value, true
+		case "deleteoldrdn":
+			if value != "0" && value != "1" {
+				return errorf(l, "invalid deleteoldrdn %q", value)
+			}
+			rec.DeleteOldRDN, deleteOldRDN = value == "1", true
+		case "newsuperior":
+			rec.NewSuperior = &value
+		default:
+			return errorf(l, "unexpected %s in a modrdn record", name)
+		}
+	}
+	if !newRDN || !deleteOldRDN {
+		return fmt.Errorf("ldif: modrdn record %s without newrdn and deleteoldrdn", rec.DN)
+	}
+	return nil
+}
+
+// control parses "control: oid [criticality] [value]".
+func (r *Reader) control(l line) (ldap.Control, error) {
+	_, spec, _ := strings.Cut(l.text, ":")
+	spec, rest, hasValue := strings.Cut(spec, ":")
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return ldap.Control{}, errorf(l, "invalid control")
+	}
+	control := ldap.Control{OID: goldap.LDAPOID(fields[0])}
+	if len(fields) == 2 {
+		switch fields[1] {
+		case "true":
+			control.Critical = true
+		case "false":
+		default:
+			return ldap.Control{}, errorf(l, "invalid control criticality %s", fields[1])
+		}
+	}
+	if hasValue {
+		value, err := r.value(l, rest)
+		if err != nil {
+			return ldap.Control{}, err
+		}
+		control.Value = []byte(value)
+	}
+	return control, nil
+}
+
+// attributeValue parses "name: value", "name:: base64" or "name:< url".
+func (r *Reader) attributeValue(l line) (name, value string, err error) {
+	name, rest, ok := strings.Cut(l.text, ":")
+	if !ok {
+		return "", "", errorf(l, "missing ':'")
+	}
+	if name == "" || strings.ContainsRune(name, ' ') {
+		return "", "", errorf(l, "invalid attribute description %q", name)
+	}
+	value, err = r.value(l, rest)
+	return name, value, err
+}
+
+// value decodes the value spec after the first ':' of a line.
+func (r *Reader) value(l line, spec string) (string, error) {
+	switch {
+	case strings.HasPrefix(spec, ":"):
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(spec[1:]))
+		if err != nil {
+			return "", errorf(l, "%v", err)
+		}
+		return string(b), nil
+	case strings.HasPrefix(spec, "<"):
+		u := strings.TrimSpace(spec[1:])
+		readURL := r.ReadURL
+		if readURL == nil {
+			readURL = readFileURL
+		}
+		b, err := readURL(u)
+		if err != nil {
+			return "", errorf(l, "%v", err)
+		}
+		return string(b), nil
+	}
+	return strings.TrimLeft(spec, " "), nil
+}
+
+// readFileURL reads the file of a file URL.
+func readFileURL(s string) ([]byte, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		return nil, errors.New("unsupported URL " + s)
+	}
+	return os.ReadFile(u.Path)
+}
+
+func errorf(l line, format string, args ...any) error {
+	return fmt.Errorf("ldif: line %d: %s", l.n, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,168 @@
+package ldif
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// lineWidth is the width at which the lines are folded.
+const lineWidth = 76
+
+// Writer writes LDIF records, preceded by the version line. Values which
+// are not safe strings, such as binary or non-ASCII values, are base64
+// encoded.
+type Writer struct {
+	w       *bufio.Writer
+	written bool
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteEntry writes the content record of the entry e.
+func (w *Writer) WriteEntry(e *ldap.Entry) error {
+	return w.Write(&Record{DN: e.DN, Attributes: e.Attributes})
+}
+
+// Write writes the record rec.
+func (w *Writer) Write(rec *Record) error {
+	if !w.written {
+		w.written = true
+		w.w.WriteString("version: 1\n")
+	}
+	w.w.WriteString("\n")
+	w.line("dn", rec.DN)
+	for _, c := range rec.Controls {
+		spec := string(c.OID)
+		if c.Critical {
+			spec += " true"
+		}
+		if c.Value != nil {
+			spec += w.spec(string(c.Value))
+		}
+		w.fold("control: " + spec)
+	}
+	if rec.ChangeType != "" {
+		w.line("changetype", rec.ChangeType)
+	}
+	return w.body(rec)
+}
+
+// Changes returns the LDIF of the attributes of a content or add record,
+// or of the changes of a change record, without its dn and changetype
+// lines, e.g. for the changes attribute of changelog entries
+// (draft-good-ldap-changelog).
+func Changes(rec *Record) (string, error) {
+	var b strings.Builder
+	w := NewWriter(&b)
+	if err := w.body(rec); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// body writes the lines of the record after its changetype line.
+func (w *Writer) body(rec *Record) error {
+	switch rec.ChangeType {
+	case "", ChangeAdd:
+		for _, a := range rec.Attributes {
+			for _, v := range a.Values {
+				w.line(a.Name, v)
+			}
+		}
+	case ChangeDelete:
+	case ChangeModify:
+		for _, c := range rec.Changes {
+			var op string
+			switch c.Operation {
+			case ldap.ModifyRequestChangeOperationAdd:
+				op = "add"
+			case ldap.ModifyRequestChangeOperationDelete:
+				op = "delete"
+			case ldap.ModifyRequestChangeOperationReplace:
+				op = "replace"
+			case Increment:
+				op = "increment"
+			default:
+				return fmt.Errorf("ldif: unknown modify operation %d", c.Operation)
+			}
+			w.line(op, c.Attribute)
+			for _, v := range c.Values {
+				w.line(c.Attribute, v)
+			}
+			w.w.WriteString("-\n")
+		}
+	case ChangeModRDN:
+		w.line("newrdn", rec.NewRDN)
+		if rec.DeleteOldRDN {
+			w.line("deleteoldrdn", "1")
+		} else {
+			w.line("deleteoldrdn", "0")
+		}
+		if rec.NewSuperior != nil {
+			w.line("newsuperior", *rec.NewSuperior)
+		}
+	default:
+		return fmt.Errorf("ldif: unknown changetype %s", rec.ChangeType)
+	}
+	return nil
+}
+
+// Flush writes the buffered data to the underlying io.Writer, and returns
+// the first error of the previous writes.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// line writes the line of the value of name.
+func (w *Writer) line(name, value string) {
+	w.fold(name + w.spec(value))
+}
+
+// spec returns the value spec of value: ": value", or ":: base64" when
+// the value is not a safe string (RFC 2849).
+func (w *Writer) spec(value string) string {
+	if safe(value) {
+		return ": " + value
+	}
+	return ":: " + base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+// fold writes the line s, folded in lines of lineWidth. The lines are
+// ASCII, see spec.
+func (w *Writer) fold(s string) {
+	width := lineWidth
+	for len(s) > width {
+		w.w.WriteString(s[:width] + "\n ")
+		s = s[width:]
+		width = lineWidth - 1 // after the leading space
+	}
+	w.w.WriteString(s + "\n")
+}
+
+// safe reports whether the value can be written as is: printable ASCII,
+// not starting with a space, ':' or '<', not ending with a space.
+func safe(value string) bool {
+	if value == "" {
+		return true
+	}
+	if strings.IndexByte(" :<", value[0]) >= 0 || value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
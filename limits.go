@@ -0,0 +1,73 @@
+package ldapserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// searchLimits enforces the sizeLimit and timeLimit of search requests
+// when Server.EnforceSearchLimits is set: the handler context expires
+// after timeLimit seconds and the search ends with sizeLimitExceeded when
+// the handler writes more than sizeLimit entries.
+func searchLimits(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		r, ok := m.ProtocolOp().(ldap.SearchRequest)
+		if !ok {
+			next.ServeLDAP(ctx, w, m)
+			return
+		}
+
+		handler := next
+		if sizeLimit := int(r.SizeLimit()); sizeLimit > 0 {
+			handler = HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+				ctx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				m.ctx = ctx
+				next.ServeLDAP(ctx, &sizeLimitWriter{ResponseWriter: w, limit: sizeLimit, cancel: cancel}, m)
+			})
+		}
+		if timeLimit := int(r.TimeLimit()); timeLimit > 0 {
+			handler = TimeoutHandler(handler, time.Duration(timeLimit)*time.Second)
+		}
+		handler.ServeLDAP(ctx, w, m)
+	})
+}
+
+// sizeLimitWriter ends a search with sizeLimitExceeded in place of the
+// entry beyond the limit, and cancels the handler context.
+type sizeLimitWriter struct {
+	ResponseWriter
+	limit  int
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	count    int
+	exceeded bool
+}
+
+func (w *sizeLimitWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *sizeLimitWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exceeded {
+		return context.Canceled
+	}
+	if _, ok := po.(ldap.SearchResultEntry); ok {
+		w.count++
+		if w.count > w.limit {
+			w.exceeded = true
+			w.cancel()
+			if err := w.ResponseWriter.Write(NewSearchResultDoneResponse(LDAPResultSizeLimitExceeded)); err != nil {
+				return err
+			}
+			return context.Canceled
+		}
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
@@ -0,0 +1,153 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ListenerConfig describes one endpoint of a server serving several
+// listeners with ListenAndServeAll.
+type ListenerConfig struct {
+	// Name identifies the listener in logs and to handlers, see
+	// Client.ListenerName.
+	Name string
+
+	// Addr is a TCP address ("host:port") or an "ldapi://" unix socket.
+	Addr string
+
+	// TLSConfig, when set, makes this an LDAPS listener.
+	TLSConfig *tls.Config
+
+	// ProxyProtocol expects a PROXY protocol header on TCP connections,
+	// like Server.AcceptProxyProtocol.
+	ProxyProtocol bool
+
+	// MinSecurityStrength is the minimum security strength factor (see
+	// Client.SecurityStrength) a connection needs for its requests to
+	// reach the handler. Other requests, except StartTLS, are answered
+	// with confidentialityRequired.
+	MinSecurityStrength int
+}
+
+// ListenAndServeAll listens on every configured endpoint and serves them
+// concurrently until they all stop, e.g. on Shutdown. If one listener can
+// not be created, the ones already created are closed and the error is
+// returned. Otherwise it returns the first error returned by Serve.
+func (s *Server) ListenAndServeAll(configs ...ListenerConfig) error {
+	listeners := make([]net.Listener, 0, len(configs))
+	for i := range configs {
+		l, err := s.listen(&configs[i])
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(listeners))
+	for i, l := range listeners {
+		wg.Add(1)
+		go func(i int, l net.Listener) {
+			defer wg.Done()
+			defer l.Close()
+			errs[i] = s.serve(l, &configs[i])
+		}(i, l)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listen creates the listener described by cfg.
+func (s *Server) listen(cfg *ListenerConfig) (net.Listener, error) {
+	path, isLDAPI, err := ldapiPath(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if isLDAPI {
+		return s.listenUnix(path)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	// the PROXY header comes before the TLS handshake
+	if cfg.ProxyProtocol {
+		listener = ProxyProtocolListener(listener, s.ProxyHeaderTimeout)
+	}
+	if cfg.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig(cfg.TLSConfig))
+	}
+	return listener, nil
+}
+
+// ListenerName returns the name of the ListenerConfig the connection was
+// accepted on, "" for listeners given to Serve.
+func (c *client) ListenerName() string {
+	if c.listener == nil {
+		return ""
+	}
+	return c.listener.Name
+}
+
+// SecurityStrength returns the security strength factor (SSF) of the
+// connection, in the spirit of OpenLDAP: the symmetric key size of the TLS
+// cipher, 71 for ldapi:// sockets, 0 for plaintext, or the strength of the
+// SASL security layer when it is higher.
+func (c *client) SecurityStrength() int {
+	ssf := c.transportStrength()
+	if layer := c.securityLayer(); layer != nil && layer.SecurityStrength() > ssf {
+		ssf = layer.SecurityStrength()
+	}
+	return ssf
+}
+
+func (c *client) transportStrength() int {
+	if state, ok := c.TLSConnectionState(); ok {
+		name := tls.CipherSuiteName(state.CipherSuite)
+		switch {
+		case strings.Contains(name, "AES_256"), strings.Contains(name, "CHACHA20"):
+			return 256
+		case strings.Contains(name, "3DES"):
+			return 112
+		default:
+			return 128
+		}
+	}
+	if _, isUnix := c.transport().(*net.UnixConn); isUnix {
+		return 71
+	}
+	return 0
+}
+
+// checkSecurityStrength answers requests received on a connection weaker
+// than its listener requires. It returns true when the request was
+// answered.
+func (c *client) checkSecurityStrength(w ResponseWriter, message *ldap.LDAPMessage) bool {
+	if c.listener == nil || c.listener.MinSecurityStrength <= 0 {
+		return false
+	}
+	if r, ok := message.ProtocolOp().(ldap.ExtendedRequest); ok && r.RequestName() == NoticeOfStartTLS {
+		return false
+	}
+	if c.SecurityStrength() >= c.listener.MinSecurityStrength {
+		return false
+	}
+	if res := responseFor(message.ProtocolOp(), LDAPResultConfidentialityRequired, "confidentiality required"); res != nil {
+		w.Write(res)
+	}
+	return true
+}
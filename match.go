@@ -0,0 +1,562 @@
+package ldapserver
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// MatchFilter reports whether the entry matches the search filter (RFC
+// 4511 section 4.5.1.7).
+//
+// Without a schema, values are compared with caseIgnoreMatch except for
+// well known attributes of other syntaxes, e.g. integerMatch for
+// uidNumber or distinguishedNameMatch for member; the ordering filters
+// compare integers numerically. Extensible matches support the matching
+// rules of RFC 4517 for strings, integers and DNs, and the bitwise rules
+// of Active Directory. Items the server can not evaluate, like an unknown
+// matching rule, are Undefined and do not match. An error is returned for
+// malformed filters, and for extensible matches, goldap having no getters
+// for them: the filters of search requests are matched with a
+// SearchFilter.
+func MatchFilter(filter ldap.Filter, entry Entry) (bool, error) {
+	data, err := encodeFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	f, err := berParseAll(data)
+	if err != nil {
+		return false, err
+	}
+	r, err := matchFilter(f, &entry)
+	return r == matchTrue, err
+}
+
+// SearchFilter is the filter of a search request, read from the encoding
+// of the request, to match the entries as MatchFilter does, e.g.:
+//
+//	filter, err := ldap.NewSearchFilter(r)
+//	if err != nil {
+//		return err
+//	}
+//	for _, e := range entries {
+//		if ok, _ := filter.Match(e); ok {
+//			...
+//		}
+//	}
+type SearchFilter struct {
+	f berElement
+}
+
+// NewSearchFilter returns the filter of the search request r.
+func NewSearchFilter(r ldap.SearchRequest) (SearchFilter, error) {
+	f, err := searchFilter(r)
+	return SearchFilter{f: f}, err
+}
+
+// Match reports whether the entry matches the filter, see MatchFilter.
+func (f SearchFilter) Match(entry Entry) (bool, error) {
+	r, err := matchFilter(f.f, &entry)
+	return r == matchTrue, err
+}
+
+// MatchFilterString is MatchFilter for the string representation (RFC
+// 4515) of a filter.
+func MatchFilterString(filter string, entry Entry) (bool, error) {
+	f, err := parseFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	r, err := matchFilter(f, &entry)
+	return r == matchTrue, err
+}
+
+// encodeFilter returns the BER encoding of a filter decoded by goldap.
+func encodeFilter(f ldap.Filter) ([]byte, error) {
+	ava := func(tag int, attr ldap.AttributeDescription, value ldap.AssertionValue) []byte {
+		return berEncode(berClassContext, true, tag, berString(string(attr)), berString(string(value)))
+	}
+	switch f := f.(type) {
+	case ldap.FilterAnd:
+		return encodeFilterSet(filterAnd, f)
+	case ldap.FilterOr:
+		return encodeFilterSet(filterOr, f)
+	case ldap.FilterNot:
+		inner, err := encodeFilter(f.Filter)
+		if err != nil {
+			return nil, err
+		}
+		return berEncode(berClassContext, true, filterNot, inner), nil
+	case ldap.FilterEqualityMatch:
+		return ava(filterEqualityMatch, f.AttributeDesc(), f.AssertionValue()), nil
+	case ldap.FilterGreaterOrEqual:
+		return ava(filterGreaterOrEqual, f.AttributeDesc(), f.AssertionValue()), nil
+	case ldap.FilterLessOrEqual:
+		return ava(filterLessOrEqual, f.AttributeDesc(), f.AssertionValue()), nil
+	case ldap.FilterApproxMatch:
+		return ava(filterApproxMatch, f.AttributeDesc(), f.AssertionValue()), nil
+	case ldap.FilterPresent:
+		return berEncode(berClassContext, false, filterPresent, []byte(f)), nil
+	case ldap.FilterSubstrings:
+		var parts [][]byte
+		for _, s := range f.Substrings() {
+			switch s := s.(type) {
+			case ldap.SubstringInitial:
+				parts = append(parts, berEncode(berClassContext, false, 0, []byte(s)))
+			case ldap.SubstringAny:
+				parts = append(parts, berEncode(berClassContext, false, 1, []byte(s)))
+			case ldap.SubstringFinal:
+				parts = append(parts, berEncode(berClassContext, false, 2, []byte(s)))
+			}
+		}
+		return berEncode(berClassContext, true, filterSubstrings, berString(string(f.Type_())), berSequence(parts...)), nil
+	}
+	return nil, fmt.Errorf("unsupported filter %T", f)
+}
+
+func encodeFilterSet(tag int, filters []ldap.Filter) ([]byte, error) {
+	parts := make([][]byte, len(filters))
+	for i, c := range filters {
+		var err error
+		if parts[i], err = encodeFilter(c); err != nil {
+			return nil, err
+		}
+	}
+	return berEncode(berClassContext, true, tag, parts...), nil
+}
+
+// matchResult is the value of a filter: TRUE, FALSE or Undefined.
+type matchResult int
+
+const (
+	matchFalse matchResult = iota
+	matchTrue
+	matchUndefined
+)
+
+func matchBool(b bool) matchResult {
+	if b {
+		return matchTrue
+	}
+	return matchFalse
+}
+
+// matchFilter evaluates the filter element f for the entry.
+func matchFilter(f berElement, e *Entry) (matchResult, error) {
+	if value, ok := f.absolute(); ok {
+		return matchBool(value), nil
+	}
+	if f.class != berClassContext {
+		return matchUndefined, fmt.Errorf("malformed filter")
+	}
+	if f.tag == filterPresent {
+		a := e.attribute(string(f.value))
+		return matchBool(a != nil && len(a.Values) > 0), nil
+	}
+	children, err := f.children()
+	if err != nil {
+		return matchUndefined, err
+	}
+
+	switch f.tag {
+	case filterAnd, filterOr:
+		// the first child deciding the result wins, Undefined otherwise
+		decisive := matchBool(f.tag == filterOr)
+		res := matchBool(f.tag == filterAnd)
+		for _, c := range children {
+			r, err := matchFilter(c, e)
+			if err != nil {
+				return matchUndefined, err
+			}
+			if r == decisive {
+				return r, nil
+			}
+			if r == matchUndefined {
+				res = matchUndefined
+			}
+		}
+		return res, nil
+	case filterNot:
+		if len(children) != 1 {
+			return matchUndefined, fmt.Errorf("malformed not filter")
+		}
+		r, err := matchFilter(children[0], e)
+		switch r {
+		case matchTrue:
+			return matchFalse, err
+		case matchFalse:
+			return matchTrue, err
+		}
+		return r, err
+	case filterEqualityMatch, filterApproxMatch, filterGreaterOrEqual, filterLessOrEqual:
+		if len(children) != 2 {
+			return matchUndefined, fmt.Errorf("malformed filter item")
+		}
+		attr, value := string(children[0].value), string(children[1].value)
+		rule := attributeRule(attr)
+		return matchValues(e.Get(attr), func(v string) matchResult {
+			switch f.tag {
+			case filterGreaterOrEqual:
+				return rule.order(v, value, func(c int) bool { return c >= 0 })
+			case filterLessOrEqual:
+				return rule.order(v, value, func(c int) bool { return c <= 0 })
+			}
+			return rule.equal(v, value)
+		}), nil
+	case filterSubstrings:
+		if len(children) != 2 {
+			return matchUndefined, fmt.Errorf("malformed substrings filter")
+		}
+		substrings, err := children[1].children()
+		if err != nil {
+			return matchUndefined, err
+		}
+		attr := string(children[0].value)
+		rule := attributeRule(attr)
+		return matchValues(e.Get(attr), func(v string) matchResult {
+			return rule.substrings(v, substrings)
+		}), nil
+	case filterExtensibleMatch:
+		return matchExtensible(children, e), nil
+	}
+	return matchUndefined, fmt.Errorf("unknown filter choice %d", f.tag)
+}
+
+// matchValues returns TRUE when a value matches, Undefined when none
+// does and a value can not be evaluated.
+func matchValues(values []string, match func(string) matchResult) matchResult {
+	res := matchFalse
+	for _, v := range values {
+		switch match(v) {
+		case matchTrue:
+			return matchTrue
+		case matchUndefined:
+			res = matchUndefined
+		}
+	}
+	return res
+}
+
+// matchExtensible evaluates the fields of a MatchingRuleAssertion.
+func matchExtensible(fields []berElement, e *Entry) matchResult {
+	// MatchingRuleAssertion ::= SEQUENCE { matchingRule [1] OPTIONAL,
+	//     type [2] OPTIONAL, matchValue [3], dnAttributes [4] DEFAULT FALSE }
+	var ruleID, attr, value string
+	dnAttributes := false
+	for _, c := range fields {
+		switch c.tag {
+		case 1:
+			ruleID = string(c.value)
+		case 2:
+			attr = string(c.value)
+		case 3:
+			value = string(c.value)
+		case 4:
+			dnAttributes = c.bool()
+		}
+	}
+
+	var rule *matchingRule
+	switch {
+	case ruleID != "":
+		if rule = matchingRules[strings.ToLower(ruleID)]; rule == nil {
+			return matchUndefined
+		}
+	case attr != "":
+		rule = attributeRule(attr)
+	default:
+		return matchUndefined
+	}
+
+	var values []string
+	for _, a := range e.Attributes {
+		if attr == "" || strings.EqualFold(a.Name, attr) {
+			values = append(values, a.Values...)
+		}
+	}
+	if dnAttributes {
+		dn, _ := ParseDN(e.DN)
+		for _, rdn := range dn {
+			for _, ava := range rdn {
+				if attr == "" || strings.EqualFold(ava.Type, attr) {
+					values = append(values, ava.Value)
+				}
+			}
+		}
+	}
+	return matchValues(values, func(v string) matchResult {
+		return rule.equal(v, value)
+	})
+}
+
+// matchingRule compares the values of an attribute (RFC 4517 section 4).
+type matchingRule struct {
+	// normalize returns the form of a value compared by the rule, ok is
+	// false when the value is invalid for the rule
+	normalize func(v string) (n string, ok bool)
+	// compare orders two normalized values, nil for the rules without
+	// ordering
+	compare func(a, b string) int
+	// substring returns the form of a value and of the substrings of a
+	// substrings filter, nil for the rules without substrings matching
+	substring func(v string) string
+	// match replaces the equality of the normalized values, for the
+	// bitwise rules
+	match func(v, assertion string) bool
+}
+
+// equal evaluates the equality of the value v with the assertion.
+func (r *matchingRule) equal(v, assertion string) matchResult {
+	if r.match != nil {
+		return matchBool(r.match(v, assertion))
+	}
+	a, ok := r.normalize(assertion)
+	if !ok {
+		return matchUndefined
+	}
+	n, ok := r.normalize(v)
+	if !ok {
+		return matchUndefined
+	}
+	return matchBool(n == a)
+}
+
+// order evaluates the ordering of the value v and the assertion with
+// test, applied to their comparison.
+func (r *matchingRule) order(v, assertion string, test func(int) bool) matchResult {
+	if r.compare == nil {
+		return matchUndefined
+	}
+	a, ok := r.normalize(assertion)
+	if !ok {
+		return matchUndefined
+	}
+	n, ok := r.normalize(v)
+	if !ok {
+		return matchUndefined
+	}
+	return matchBool(test(r.compare(n, a)))
+}
+
+// substrings evaluates a substrings filter, substrings being its initial,
+// any and final elements.
+func (r *matchingRule) substrings(v string, substrings []berElement) matchResult {
+	if r.substring == nil {
+		return matchUndefined
+	}
+	v = r.substring(v)
+	for _, s := range substrings {
+		p := r.substring(string(s.value))
+		switch s.tag {
+		case 0:
+			if !strings.HasPrefix(v, p) {
+				return matchFalse
+			}
+			v = v[len(p):]
+		case 1:
+			i := strings.Index(v, p)
+			if i < 0 {
+				return matchFalse
+			}
+			v = v[i+len(p):]
+		case 2:
+			if !strings.HasSuffix(v, p) {
+				return matchFalse
+			}
+			v = ""
+		}
+	}
+	return matchTrue
+}
+
+var (
+	caseIgnoreMatch = &matchingRule{
+		normalize: func(v string) (string, bool) { return strings.ToLower(foldSpaces(v)), true },
+		compare:   strings.Compare,
+		substring: strings.ToLower,
+	}
+	caseExactMatch = &matchingRule{
+		normalize: func(v string) (string, bool) { return foldSpaces(v), true },
+		compare:   strings.Compare,
+		substring: func(v string) string { return v },
+	}
+	octetStringMatch = &matchingRule{
+		normalize: func(v string) (string, bool) { return v, true },
+		compare:   strings.Compare,
+		substring: func(v string) string { return v },
+	}
+	integerMatch = &matchingRule{
+		normalize: normalizeInteger,
+		compare:   compareIntegers,
+	}
+	numericStringMatch = &matchingRule{
+		normalize: func(v string) (string, bool) { return strings.ReplaceAll(v, " ", ""), true },
+		compare:   strings.Compare,
+		substring: func(v string) string { return strings.ReplaceAll(v, " ", "") },
+	}
+	// distinguishedNameMatch is set by init, DN.Normalize using the
+	// rules of the attributes.
+	distinguishedNameMatch = &matchingRule{}
+
+	booleanMatch = &matchingRule{
+		normalize: func(v string) (string, bool) {
+			v = strings.ToUpper(strings.TrimSpace(v))
+			return v, v == "TRUE" || v == "FALSE"
+		},
+	}
+	bitAndMatch = &matchingRule{match: func(v, assertion string) bool {
+		x, y, ok := parseBits(v, assertion)
+		return ok && x&y == y
+	}}
+	bitOrMatch = &matchingRule{match: func(v, assertion string) bool {
+		x, y, ok := parseBits(v, assertion)
+		return ok && x&y != 0
+	}}
+
+	// defaultMatch compares the attributes without a known rule: case
+	// insensitively, the integers being ordered numerically.
+	defaultMatch = &matchingRule{
+		normalize: caseIgnoreMatch.normalize,
+		compare: func(a, b string) int {
+			if c, ok := compareNumbers(a, b); ok {
+				return c
+			}
+			return strings.Compare(a, b)
+		},
+		substring: strings.ToLower,
+	}
+)
+
+func init() {
+	distinguishedNameMatch.normalize = func(v string) (string, bool) {
+		d, err := ParseDN(v)
+		return d.Normalize(), err == nil
+	}
+}
+
+// matchingRules indexes the matching rules of extensible matches by
+// lower-cased OID and name.
+var matchingRules = map[string]*matchingRule{
+	"2.5.13.1": distinguishedNameMatch, "distinguishednamematch": distinguishedNameMatch,
+	"2.5.13.2": caseIgnoreMatch, "caseignorematch": caseIgnoreMatch,
+	"2.5.13.3": caseIgnoreMatch, "caseignoreorderingmatch": caseIgnoreMatch,
+	"2.5.13.4": caseIgnoreMatch, "caseignoresubstringsmatch": caseIgnoreMatch,
+	"2.5.13.5": caseExactMatch, "caseexactmatch": caseExactMatch,
+	"2.5.13.6": caseExactMatch, "caseexactorderingmatch": caseExactMatch,
+	"2.5.13.7": caseExactMatch, "caseexactsubstringsmatch": caseExactMatch,
+	"2.5.13.8": numericStringMatch, "numericstringmatch": numericStringMatch,
+	"2.5.13.13": booleanMatch, "booleanmatch": booleanMatch,
+	"2.5.13.14": integerMatch, "integermatch": integerMatch,
+	"2.5.13.15": integerMatch, "integerorderingmatch": integerMatch,
+	"2.5.13.17": octetStringMatch, "octetstringmatch": octetStringMatch,
+	"1.3.6.1.4.1.1466.109.114.1": caseExactMatch, "caseexactia5match": caseExactMatch,
+	"1.3.6.1.4.1.1466.109.114.2": caseIgnoreMatch, "caseignoreia5match": caseIgnoreMatch,
+	"1.2.840.113556.1.4.803": bitAndMatch,
+	"1.2.840.113556.1.4.804": bitOrMatch,
+}
+
+// attributeRules holds the equality rules of well known attributes which
+// are not directory strings, by lower-cased name.
+var attributeRules = map[string]*matchingRule{
+	"userpassword":       octetStringMatch,
+	"uidnumber":          integerMatch,
+	"gidnumber":          integerMatch,
+	"shadowlastchange":   integerMatch,
+	"shadowmin":          integerMatch,
+	"shadowmax":          integerMatch,
+	"shadowwarning":      integerMatch,
+	"shadowinactive":     integerMatch,
+	"shadowexpire":       integerMatch,
+	"useraccountcontrol": integerMatch,
+	"member":             distinguishedNameMatch,
+	"uniquemember":       distinguishedNameMatch,
+	"memberof":           distinguishedNameMatch,
+	"owner":              distinguishedNameMatch,
+	"manager":            distinguishedNameMatch,
+	"secretary":          distinguishedNameMatch,
+	"seealso":            distinguishedNameMatch,
+	"roleoccupant":       distinguishedNameMatch,
+	"aliasedobjectname":  distinguishedNameMatch,
+	"creatorsname":       distinguishedNameMatch,
+	"modifiersname":      distinguishedNameMatch,
+	"entrydn":            distinguishedNameMatch,
+	"telephonenumber":    numericStringMatch,
+}
+
+// attributeRule returns the equality rule of the attribute description
+// attr, its options being ignored.
+func attributeRule(attr string) *matchingRule {
+	name, _, _ := strings.Cut(attr, ";")
+	if r := attributeRules[strings.ToLower(name)]; r != nil {
+		return r
+	}
+	return defaultMatch
+}
+
+// NormalizeValue returns the form of a value of the attribute compared by
+// its equality rule, two values being equal for MatchFilter when their
+// normalized forms are, e.g. to index the entries of a backend. ok is false
+// when the value is invalid for the rule, and then equal to none.
+func NormalizeValue(attribute, value string) (normalized string, ok bool) {
+	return attributeRule(attribute).normalize(value)
+}
+
+// foldSpaces removes the leading and trailing spaces of v and folds its
+// inner runs of spaces into one (RFC 4518 section 2.6.1).
+func foldSpaces(v string) string {
+	return strings.Join(strings.Fields(v), " ")
+}
+
+func normalizeInteger(v string) (string, bool) {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(v), 10)
+	if !ok {
+		return "", false
+	}
+	return n.String(), true
+}
+
+func compareIntegers(a, b string) int {
+	x, _ := new(big.Int).SetString(a, 10)
+	y, _ := new(big.Int).SetString(b, 10)
+	if x == nil || y == nil {
+		return strings.Compare(a, b)
+	}
+	return x.Cmp(y)
+}
+
+// compareNumbers compares a and b as integers, ok is false unless both
+// are.
+func compareNumbers(a, b string) (int, bool) {
+	x, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	y, err := strconv.ParseInt(b, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// parseBits parses the operands of the bitwise rules, 32-bit signed or
+// unsigned integers as stored by Active Directory.
+func parseBits(v, assertion string) (x, y uint64, ok bool) {
+	a, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	b, err := strconv.ParseInt(strings.TrimSpace(assertion), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint64(a), uint64(b), true
+}
@@ -0,0 +1,29 @@
+package ldapserver
+
+import "testing"
+
+func TestSearchFilterExtensibleMatch(t *testing.T) {
+	e := NewEntry("cn=John Doe,dc=example,dc=com", map[string][]string{
+		"cn":                 {"John Doe"},
+		"userAccountControl": {"514"},
+	})
+	for filter, want := range map[string]bool{
+		"(cn:caseExactMatch:=John Doe)":                  true,
+		"(cn:caseExactMatch:=john doe)":                  false,
+		"(userAccountControl:1.2.840.113556.1.4.803:=2)": true,
+		"(userAccountControl:1.2.840.113556.1.4.803:=8)": false,
+		"(&(cn=*)(cn:unknownMatch:=John Doe))":           false,
+	} {
+		r, err := NewSearchRequest("dc=example,dc=com", SearchRequestHomeSubtree, filter)
+		if err != nil {
+			t.Fatalf("%s: %v", filter, err)
+		}
+		f, err := NewSearchFilter(r)
+		if err != nil {
+			t.Fatalf("%s: %v", filter, err)
+		}
+		if ok, err := f.Match(*e); err != nil || ok != want {
+			t.Errorf("%s: got %v, %v, want %v", filter, ok, err, want)
+		}
+	}
+}
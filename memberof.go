@@ -0,0 +1,199 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// DefaultMemberOfTTL is the time the memberships are cached by MemberOf
+// when its TTL is zero.
+const DefaultMemberOfTTL = time.Minute
+
+// DefaultMemberAttributes are the attributes of the members of the groups
+// used by MemberOf when its MemberAttributes are nil.
+var DefaultMemberAttributes = []string{"member", "uniqueMember"}
+
+// MemberOf is a Backend decorator computing the memberOf attribute of the
+// entries from the member and uniqueMember values of the groups, as Active
+// Directory and the memberOf overlay of OpenLDAP do, for the many clients
+// relying on it, e.g. Grafana, Gitea or Jenkins:
+//
+//	backend := &ldap.MemberOf{Backend: dir.Backend(), GroupBase: "ou=groups,dc=example,dc=com"}
+//	routes := ldap.BackendMux(backend)
+//
+// memberOf is not stored: it replaces the values of the entries returned
+// by the backend, can be used in search filters, e.g.
+// (memberOf=cn=admins,ou=groups,dc=example,dc=com), and in compare
+// requests. The memberships are cached for TTL, and reloaded after the
+// updates made through the decorator.
+type MemberOf struct {
+	Backend
+
+	// GroupBase is the base DN of the groups, the whole directory when
+	// empty.
+	GroupBase string
+
+	// MemberAttributes are the attributes of the member DNs of the
+	// groups, DefaultMemberAttributes if nil.
+	MemberAttributes []string
+
+	// TTL is the time the memberships are cached, DefaultMemberOfTTL if
+	// zero. A negative TTL disables the cache.
+	TTL time.Duration
+
+	mu     sync.Mutex
+	groups map[string][]string // group DNs by normalized member DN
+	loaded time.Time
+}
+
+// Search calls f with the entries of the backend, with their memberOf
+// values. Filters with memberOf items are evaluated on the entries, the
+// backend being searched for all the entries in the scope of the request.
+func (m *MemberOf) Search(ctx context.Context, r ldap.SearchRequest, f func(e *Entry) error) error {
+	groups, err := m.memberships(ctx)
+	if err != nil {
+		return err
+	}
+	filter, err := NewSearchFilter(r)
+	if err != nil {
+		return err
+	}
+	computed := filter.f.hasAttribute("memberOf")
+	if computed {
+		if r, err = NewSearchRequest(string(r.BaseObject()), int(r.Scope()), "(objectClass=*)"); err != nil {
+			return err
+		}
+	}
+	return m.Backend.Search(ctx, r, func(e *Entry) error {
+		if e.Get("memberOf") != nil || groups[NormalizeDN(e.DN)] != nil {
+			e = e.Clone()
+			e.Replace("memberOf", groups[NormalizeDN(e.DN)]...)
+		}
+		if computed {
+			if ok, err := filter.Match(*e); err != nil || !ok {
+				return err
+			}
+		}
+		return f(e)
+	})
+}
+
+// Compare compares memberOf with the memberships of the entry dn, and the
+// other attributes with the backend.
+func (m *MemberOf) Compare(ctx context.Context, dn, attribute, value string) (bool, error) {
+	if !strings.EqualFold(attribute, "memberOf") {
+		return m.Backend.Compare(ctx, dn, attribute, value)
+	}
+	r, err := NewSearchRequest(dn, SearchRequestScopeBaseObject, "(objectClass=*)", "1.1")
+	if err != nil {
+		return false, NewError(LDAPResultInvalidDNSyntax, err.Error())
+	}
+	if err := m.Backend.Search(ctx, r, func(*Entry) error { return nil }); err != nil {
+		return false, err
+	}
+	groups, err := m.memberships(ctx)
+	if err != nil {
+		return false, err
+	}
+	dns := groups[NormalizeDN(dn)]
+	if dns == nil {
+		return false, NewError(LDAPResultNoSuchAttribute, attribute)
+	}
+	for _, g := range dns {
+		if NormalizeDN(g) == NormalizeDN(value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemberOf) Add(ctx context.Context, e *Entry) error {
+	defer m.Invalidate()
+	return m.Backend.Add(ctx, e)
+}
+
+func (m *MemberOf) Delete(ctx context.Context, dn string) error {
+	defer m.Invalidate()
+	return m.Backend.Delete(ctx, dn)
+}
+
+func (m *MemberOf) Modify(ctx context.Context, dn string, changes []Modification) error {
+	defer m.Invalidate()
+	return m.Backend.Modify(ctx, dn, changes)
+}
+
+func (m *MemberOf) ModifyDN(ctx context.Context, r ModifyDNRequest) error {
+	defer m.Invalidate()
+	return m.Backend.ModifyDN(ctx, r)
+}
+
+// Invalidate drops the cached memberships, e.g. after the groups were
+// updated directly in the backend.
+func (m *MemberOf) Invalidate() {
+	m.mu.Lock()
+	m.groups = nil
+	m.mu.Unlock()
+}
+
+// memberships returns the DNs of the groups by normalized member DN,
+// searching the groups when the cache expired.
+func (m *MemberOf) memberships(ctx context.Context) (map[string][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ttl := m.TTL
+	if ttl == 0 {
+		ttl = DefaultMemberOfTTL
+	}
+	if m.groups != nil && time.Since(m.loaded) < ttl {
+		return m.groups, nil
+	}
+
+	attributes := m.MemberAttributes
+	if attributes == nil {
+		attributes = DefaultMemberAttributes
+	}
+	filter := "(|"
+	for _, a := range attributes {
+		filter += "(" + a + "=*)"
+	}
+	filter += ")"
+	r, err := NewSearchRequest(m.GroupBase, SearchRequestHomeSubtree, filter, attributes...)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]string)
+	err = m.Backend.Search(ctx, r, func(e *Entry) error {
+		for _, a := range attributes {
+			for _, v := range e.Get(a) {
+				k := NormalizeDN(memberDN(v))
+				if dns := groups[k]; len(dns) == 0 || dns[len(dns)-1] != e.DN {
+					groups[k] = append(dns, e.DN)
+				}
+			}
+		}
+		return nil
+	})
+	var lerr *Error
+	if errors.As(err, &lerr) && lerr.ResultCode == LDAPResultNoSuchObject {
+		err = nil // no groups yet
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.groups, m.loaded = groups, time.Now()
+	return groups, nil
+}
+
+// memberDN returns the DN of a member value, without the optional unique
+// identifier of the uniqueMember values (RFC 4517 section 3.3.21).
+func memberDN(v string) string {
+	if i := strings.LastIndex(v, "#'"); i >= 0 && strings.HasSuffix(v, "'B") {
+		return v[:i]
+	}
+	return v
+}
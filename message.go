@@ -1,6 +1,7 @@
 package ldapserver
 
 import (
+	"context"
 	"fmt"
 
 	ldap "github.com/lor00x/goldap/message"
@@ -9,6 +10,26 @@ import (
 type Message struct {
 	*ldap.LDAPMessage
 	Client *client
+
+	ctx               context.Context         // passed to the handler
+	preRead, postRead *ldap.SearchResultEntry // see SetPreReadEntry
+	boundDN           *string                 // see SetBoundDN
+}
+
+// Context returns the context the request is served with, the one passed
+// to the handler: it is cancelled when the request is abandoned, the
+// client unbinds or the connection drops.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Done returns a channel closed when the request is cancelled, shorthand
+// for m.Context().Done().
+func (m *Message) Done() <-chan struct{} {
+	return m.Context().Done()
 }
 
 func (m *Message) String() string {
@@ -42,3 +63,96 @@ func (m *Message) GetCompareRequest() ldap.CompareRequest {
 func (m *Message) GetExtendedRequest() ldap.ExtendedRequest {
 	return m.ProtocolOp().(ldap.ExtendedRequest)
 }
+
+func (m *Message) GetAbandonRequest() ldap.AbandonRequest {
+	return m.ProtocolOp().(ldap.AbandonRequest)
+}
+
+func (m *Message) GetModifyDNRequest() ldap.ModifyDNRequest {
+	return m.ProtocolOp().(ldap.ModifyDNRequest)
+}
+
+// ModifyDN returns the fields of a ModifyDN request.
+func (m *Message) ModifyDN() (ModifyDNRequest, error) {
+	r, ok := m.ProtocolOp().(ldap.ModifyDNRequest)
+	if !ok {
+		return ModifyDNRequest{}, fmt.Errorf("%s is not a ModifyDNRequest", m.ProtocolOpName())
+	}
+	return decodeModifyDNRequest(r)
+}
+
+// ModifyDNRequest holds the fields of a ModifyDN request, goldap has no
+// getters for them, see Message.ModifyDN.
+type ModifyDNRequest struct {
+	Entry        string
+	NewRDN       string
+	DeleteOldRDN bool
+	NewSuperior  *string // nil when the entry stays under its parent
+}
+
+// NewDN returns the DN of the entry after the operation.
+func (r ModifyDNRequest) NewDN() string {
+	parent := ""
+	if r.NewSuperior != nil {
+		parent = *r.NewSuperior
+	} else {
+		parent = ParentDN(r.Entry)
+	}
+	if parent == "" {
+		return r.NewRDN
+	}
+	return r.NewRDN + "," + parent
+}
+
+// NewSearchRequest returns a request searching the entries in the scope of
+// base matching the filter (RFC 4515), with the attributes, e.g. for the
+// searches of a backend decorator; goldap has no setters for its fields.
+func NewSearchRequest(base string, scope int, filter string, attributes ...string) (ldap.SearchRequest, error) {
+	f, err := parseFilter(filter)
+	if err != nil {
+		return ldap.SearchRequest{}, err
+	}
+	// SearchRequest ::= [APPLICATION 3] SEQUENCE { baseObject, scope,
+	//     derefAliases, sizeLimit, timeLimit, typesOnly, filter,
+	//     attributes AttributeSelection }
+	selection := make([][]byte, len(attributes))
+	for i, a := range attributes {
+		selection[i] = berString(a)
+	}
+	po, err := decodeProtocolOp(berEncode(berClassApplication, true, 3,
+		berString(base), berEnumerated(int64(scope)), berEnumerated(0), berInteger(0), berInteger(0),
+		berBoolean(false), f.encode(), berSequence(selection...)))
+	if err != nil {
+		return ldap.SearchRequest{}, err
+	}
+	return po.(ldap.SearchRequest), nil
+}
+
+// NewSimpleBindRequest returns a simple bind request (RFC 4511 section
+// 4.2) of the user name with the password, e.g. for the binds of a proxy.
+func NewSimpleBindRequest(name, password string) (ldap.BindRequest, error) {
+	// BindRequest ::= [APPLICATION 0] SEQUENCE { version, name,
+	//     authentication AuthenticationChoice }
+	po, err := decodeProtocolOp(berEncode(berClassApplication, true, 0,
+		berInteger(3), berString(name), berEncode(berClassContext, false, 0, []byte(password))))
+	if err != nil {
+		return ldap.BindRequest{}, err
+	}
+	return po.(ldap.BindRequest), nil
+}
+
+// NewExtendedRequest returns the extended request (RFC 4511 section 4.12)
+// name with the value, nil for none.
+func NewExtendedRequest(name ldap.LDAPOID, value []byte) (ldap.ExtendedRequest, error) {
+	// ExtendedRequest ::= [APPLICATION 23] SEQUENCE { requestName [0],
+	//     requestValue [1] OPTIONAL }
+	fields := [][]byte{berEncode(berClassContext, false, 0, []byte(name))}
+	if value != nil {
+		fields = append(fields, berEncode(berClassContext, false, 1, value))
+	}
+	po, err := decodeProtocolOp(berEncode(berClassApplication, true, 23, fields...))
+	if err != nil {
+		return ldap.ExtendedRequest{}, err
+	}
+	return po.(ldap.ExtendedRequest), nil
+}
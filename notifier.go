@@ -0,0 +1,128 @@
+package ldapserver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Types of the ChangeEvents, as the Types of the Dispatcher Events.
+const (
+	ChangeAdd    = "add"
+	ChangeDelete = "delete"
+	ChangeModify = "modify"
+	ChangeModDN  = "moddn"
+)
+
+// ChangeEvent is a change of an entry published by a backend through a
+// Notifier.
+type ChangeEvent struct {
+	Type  string // ChangeAdd, ChangeDelete, ChangeModify or ChangeModDN
+	DN    string // DN of the entry, its new DN for ChangeModDN
+	OldDN string // DN of the entry before a ChangeModDN, "" otherwise
+	Entry *Entry // entry after the change, nil for ChangeDelete
+	Old   *Entry // entry before the change, nil for ChangeAdd
+	Time  time.Time
+}
+
+// Event returns the event as a Dispatcher Event, its Data being the
+// ChangeEvent, e.g. to publish it to the subscriptions of the connections.
+func (e ChangeEvent) Event() Event {
+	return Event{Type: e.Type, DN: e.DN, Data: e}
+}
+
+// Notifier publishes the changes of the entries of a backend through a
+// Dispatcher, to the subscribers watching a part of the directory, so
+// that persistent searches, sync providers and application hooks, e.g.
+// webhooks, are fed by one mechanism:
+//
+//	notifier := &ldap.Notifier{Dispatcher: server.Dispatcher()}
+//	dir := inmem.New()
+//	dir.Notifier = notifier
+//	sub, err := notifier.Subscribe(ctx, "ou=people,dc=example,dc=com", ldap.SearchRequestHomeSubtree, "(objectClass=inetOrgPerson)")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	for ev := range sub.Events() {
+//		e := ev.Data.(ldap.ChangeEvent)
+//		log.Printf("%s %s", e.Type, e.DN)
+//	}
+//
+// It is safe for concurrent use. The zero Notifier is ready to use.
+type Notifier struct {
+	// Dispatcher is the dispatcher the changes are published to, e.g.
+	// Server.Dispatcher() so that the subscriptions of the connections
+	// receive them too. The Notifier has its own if nil. Set it before
+	// use.
+	Dispatcher *Dispatcher
+
+	// QueueSize is the number of events buffered for each subscriber of
+	// Subscribe, DefaultNotificationQueueSize if zero.
+	QueueSize int
+
+	once sync.Once
+	d    *Dispatcher
+}
+
+// dispatcher returns the Dispatcher of n.
+func (n *Notifier) dispatcher() *Dispatcher {
+	n.once.Do(func() {
+		n.d = n.Dispatcher
+		if n.d == nil {
+			n.d = &Dispatcher{}
+		}
+	})
+	return n.d
+}
+
+// Subscribe returns a subscription receiving the changes of the entries in
+// the scope of baseDN matching the filter (RFC 4515), before or after the
+// change, so that the entries leaving the scope or no longer matching are
+// notified too. The Data of its events are the ChangeEvents. It is closed
+// when ctx is done, or with ErrSubscriptionOverflow when the subscriber
+// falls QueueSize events behind.
+func (n *Notifier) Subscribe(ctx context.Context, baseDN string, scope int, filter string) (*Subscription, error) {
+	base, err := ParseDN(baseDN)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	w := &changeWatch{base: base.Normalize(), scope: scope, filter: f}
+	return n.dispatcher().subscribe(ctx, nil, w.match, n.QueueSize)
+}
+
+// Publish publishes e to the Dispatcher without blocking, and returns the
+// number of subscriptions it was queued for. The Time of e is set if zero.
+func (n *Notifier) Publish(e ChangeEvent) int {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	return n.dispatcher().Publish(e.Event())
+}
+
+// changeWatch selects the ChangeEvents of a Notifier subscription.
+type changeWatch struct {
+	base   string // normalized
+	scope  int
+	filter berElement
+}
+
+// match reports whether ev is a ChangeEvent whose entry, before or after
+// the change, is watched.
+func (w *changeWatch) match(ev Event) bool {
+	e, ok := ev.Data.(ChangeEvent)
+	return ok && (w.matchEntry(e.Entry) || w.matchEntry(e.Old))
+}
+
+// matchEntry reports whether the entry e is in the scope of the watch and
+// matches its filter.
+func (w *changeWatch) matchEntry(e *Entry) bool {
+	if e == nil || !InScope(e.DN, w.base, w.scope) {
+		return false
+	}
+	r, err := matchFilter(w.filter, e)
+	return err == nil && r == matchTrue
+}
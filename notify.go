@@ -0,0 +1,96 @@
+package ldapserver
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ErrClientClosed is returned by Client.Notify when the connection is
+// closed or was not accepted by a listener.
+var ErrClientClosed = errors.New("ldapserver: client connection closed")
+
+// ErrNotifyTimeout is returned by Client.Notify when the connection does
+// not take the notification in time, the client not reading its
+// responses.
+var ErrNotifyTimeout = errors.New("ldapserver: notification timed out")
+
+// DefaultNotifyTimeout bounds the wait of Client.Notify when
+// Server.NotifyTimeout is zero.
+const DefaultNotifyTimeout = 5 * time.Second
+
+// NewUnsolicitedNotification returns an unsolicited notification (RFC 4511
+// section 4.4), an ExtendedResponse identified by its responseName.
+func NewUnsolicitedNotification(name ldap.LDAPOID, resultCode int, diagnostic string) ldap.ExtendedResponse {
+	r := NewExtendedResponse(resultCode)
+	r.SetDiagnosticMessage(diagnostic)
+	r.SetResponseName(name)
+	return r
+}
+
+// Notify sends po to the client with message ID 0, as an unsolicited
+// notification. Unless it is a Notice of Disconnection, the connection
+// stays open. Notify can be called from any goroutine; it waits for the
+// writer to accept the message for Server.NotifyTimeout, then fails with
+// ErrNotifyTimeout.
+func (c *client) Notify(po ldap.ProtocolOp, controls ...Control) error {
+	m, err := newResponseMessage(0, po, controls)
+	if err != nil {
+		return err
+	}
+
+	c.outMu.RLock()
+	defer c.outMu.RUnlock()
+	if c.outClosed || c.chanOut == nil {
+		return ErrClientClosed
+	}
+	if err := c.writeError(); err != nil {
+		return err
+	}
+	timeout := c.srv.NotifyTimeout
+	if timeout <= 0 {
+		timeout = DefaultNotifyTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case c.chanOut <- &outMessage{LDAPMessage: m}:
+		return nil
+	case <-c.closing:
+		return ErrClientClosed
+	case <-timer.C:
+		return ErrNotifyTimeout
+	}
+}
+
+// Broadcast sends po as an unsolicited notification to every connected
+// client, see Client.Notify, concurrently so that the clients not reading
+// their responses do not delay the others. It returns the number of
+// clients notified.
+func (s *Server) Broadcast(po ldap.ProtocolOp, controls ...Control) int {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	var n atomic.Int64
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			if err := c.Notify(po, controls...); err == nil {
+				n.Add(1)
+			} else {
+				s.logf("client %d notification not sent: %s", c.Numero, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+	return int(n.Load())
+}
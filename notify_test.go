@@ -0,0 +1,38 @@
+package ldapserver
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestBroadcastStuckClient checks that a client not reading its responses
+// does not block the broadcasts.
+func TestBroadcastStuckClient(t *testing.T) {
+	s := &Server{NotifyTimeout: 100 * time.Millisecond, HandleConnection: func(net.Conn) Handler { return NewRouteMux() }}
+	stuck, conn := net.Pipe() // nobody reads stuck
+	defer stuck.Close()
+	go s.ServeConn(conn)
+	defer s.Close()
+	for len(s.Clients()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan int)
+	go func() {
+		notice := NewUnsolicitedNotification("1.2.3.4", LDAPResultSuccess, "")
+		n := 0
+		for i := 0; i < 3; i++ {
+			n += s.Broadcast(notice)
+		}
+		done <- n
+	}()
+	select {
+	case n := <-done:
+		if n != 1 {
+			t.Errorf("%d notifications taken, want 1", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the broadcasts are blocked by the client")
+	}
+}
@@ -0,0 +1,61 @@
+package ldapserver
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// GeneralizedTimeFormat is the layout of the GeneralizedTime values (RFC
+// 4517 section 3.3.13) of the timestamps, in UTC.
+const GeneralizedTimeFormat = "20060102150405Z"
+
+// NewEntryUUID returns a random (version 4) UUID, in the string form of
+// the entryUUID values (RFC 4530).
+func NewEntryUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// SetOperationalAttributes maintains the operational attributes of the
+// entry e stored by a backend at t, old being the entry it replaces, nil
+// for a new entry:
+//
+//   - entryUUID, createTimestamp and creatorsName are those of old, or are
+//     set for a new entry which does not have them, e.g. unlike an entry
+//     imported from LDIF;
+//   - modifyTimestamp and modifiersName are set to t and the DN bound by
+//     the connection of ctx, under the same condition for a new entry;
+//   - entryDN (RFC 5020) is set to the DN of e.
+//
+// creatorsName and modifiersName are left out for the anonymous sessions.
+// The entryUUID of an entry is kept through its renames, so that the sync
+// consumers can follow it.
+func SetOperationalAttributes(ctx context.Context, e, old *Entry, t time.Time) {
+	var bound []string
+	if state, ok := AuthStateFromContext(ctx); ok && !state.Anonymous() {
+		bound = []string{state.BoundDN}
+	}
+	timestamp := []string{t.UTC().Format(GeneralizedTimeFormat)}
+	set := func(name string, values []string) {
+		if old != nil || e.Get(name) == nil {
+			e.Replace(name, values...)
+		}
+	}
+	if old != nil {
+		for _, name := range []string{"entryUUID", "createTimestamp", "creatorsName"} {
+			e.Replace(name, old.Get(name)...)
+		}
+	} else {
+		set("entryUUID", []string{NewEntryUUID()})
+		set("createTimestamp", timestamp)
+		set("creatorsName", bound)
+	}
+	set("modifyTimestamp", timestamp)
+	set("modifiersName", bound)
+	e.Replace("entryDN", e.DN)
+}
@@ -3,11 +3,15 @@ package ldapserver
 import (
 	"bufio"
 	"fmt"
+	"io"
 
 	ldap "github.com/lor00x/goldap/message"
 )
 
-func readMessage(br *bufio.Reader) (*ldap.LDAPMessage, error) {
+// ReadMessage reads an LDAP message from br, e.g. a request of a client or
+// a response of an upstream server. The absolute True and False filters
+// (RFC 4526) of the requests are accepted.
+func ReadMessage(br *bufio.Reader) (msg *ldap.LDAPMessage, err error) {
 	bytes, err := readLdapMessageBytes(br)
 	if err != nil {
 		return nil, err
@@ -15,12 +19,15 @@ func readMessage(br *bufio.Reader) (*ldap.LDAPMessage, error) {
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("invalid packet received hex=%x, %#v", bytes, r)
+			msg, err = nil, fmt.Errorf("invalid packet received hex=%x, %#v", bytes, r)
 		}
 	}()
 
-	msg, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, *bytes))
-	return &msg, err
+	m, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, rewriteAbsoluteFilters(*bytes)))
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
 }
 
 // BELLOW SHOULD BE IN ROOX PACKAGE
@@ -114,7 +121,8 @@ func readTagAndLength(conn *bufio.Reader, bytes *[]byte) (ret ldap.TagAndLength,
 // Return the last read byte
 func readBytes(conn *bufio.Reader, bytes *[]byte, length int) (b byte, err error) {
 	newbytes := make([]byte, length)
-	n, err := conn.Read(newbytes)
+	// a single Read returns at most one SASL buffer or TLS record
+	n, err := io.ReadFull(conn, newbytes)
 	if n != length {
 		err = fmt.Errorf("%d bytes read instead of %d", n, length)
 		return
@@ -0,0 +1,24 @@
+package ldapserver
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// TestReadMessagePanic checks that a request making goldap panic is
+// reported as an error instead of a nil message.
+func TestReadMessagePanic(t *testing.T) {
+	// searchRequest with the filter (cn:=John Doe), whose extensible match
+	// goldap fails to decode
+	filter := berEncode(berClassContext, true, 9, berEncode(berClassContext, false, 2, []byte("cn")), berEncode(berClassContext, false, 3, []byte("John Doe")))
+	search := berEncode(berClassApplication, true, 3,
+		berString(""), berEnumerated(2), berEnumerated(0), berInteger(0), berInteger(0), berBoolean(false),
+		filter, berSequence())
+	packet := berSequence(berInteger(1), search)
+
+	m, err := ReadMessage(bufio.NewReader(bytes.NewReader(packet)))
+	if err == nil || m != nil {
+		t.Fatalf("got %v, %v, want an error", m, err)
+	}
+}
@@ -0,0 +1,154 @@
+package ldapserver
+
+import (
+	"bytes"
+	"strconv"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// MaxPagedSearchesPerConn is the number of paged searches a connection can
+// leave unfinished; starting another one drops the oldest.
+const MaxPagedSearchesPerConn = 8
+
+// pagedSearch holds the entries of a paged search not returned yet.
+type pagedSearch struct {
+	request  []byte // encoding of the search request
+	entries  []ldap.SearchResultEntry
+	done     ldap.SearchResultDone
+	controls []Control // response controls of every page, e.g. sortResult
+	total    int
+}
+
+// PagedSearchWriter implements the server side of the Simple Paged Results
+// control (RFC 2696) for a search handler: the handler writes all its
+// results to it, the first page is sent and the remaining entries are held
+// by the connection until the client asks for the next page.
+//
+//	pw := ldap.NewPagedSearchWriter(w, m)
+//	if pw.Resume() {
+//		return // next page sent from the held entries
+//	}
+//	for _, e := range entries {
+//		pw.Write(e)
+//	}
+//	pw.Write(ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess))
+//
+// Without the control, the results go straight to the ResponseWriter.
+type PagedSearchWriter struct {
+	ResponseWriter
+	m       *Message
+	paged   *PagedResults
+	request []byte
+	sent    int
+	held    []ldap.SearchResultEntry
+}
+
+// NewPagedSearchWriter returns the writer of the results of the search
+// request m.
+func NewPagedSearchWriter(w ResponseWriter, m *Message) *PagedSearchWriter {
+	p := &PagedSearchWriter{ResponseWriter: w, m: m}
+	if paged, ok := m.PagedResults(); ok {
+		p.paged = paged
+		p.request, _ = protocolOpBytes(m.ProtocolOp())
+	}
+	return p
+}
+
+// Resume answers a request continuing a paged search with the next page,
+// or abandons the search when the client asks for a page size of 0. It
+// returns false for the first request of a search, which the handler must
+// run.
+func (p *PagedSearchWriter) Resume() bool {
+	if p.paged == nil || len(p.paged.Cookie) == 0 {
+		return false
+	}
+
+	c := p.m.Client
+	c.Lock()
+	search := c.pagedSearches[string(p.paged.Cookie)]
+	delete(c.pagedSearches, string(p.paged.Cookie))
+	c.Unlock()
+
+	if search == nil || !bytes.Equal(search.request, p.request) {
+		p.ResponseWriter.Write(responseFor(p.m.ProtocolOp(), LDAPResultUnwillingToPerform, "invalid paged results cookie"))
+		return true
+	}
+	if p.paged.Size <= 0 {
+		p.ResponseWriter.WriteWithControls(NewSearchResultDoneResponse(LDAPResultSuccess), pagedResultsControl(0, nil))
+		return true
+	}
+
+	n := min(p.paged.Size, len(search.entries))
+	for _, e := range search.entries[:n] {
+		p.ResponseWriter.Write(e)
+	}
+	search.entries = search.entries[n:]
+	p.finish(search)
+	return true
+}
+
+// Write sends the entries of the current page and holds the next ones.
+// The SearchResultDone ends the page, with the cookie of the next page
+// when entries are held.
+func (p *PagedSearchWriter) Write(po ldap.ProtocolOp) error {
+	return p.WriteWithControls(po)
+}
+
+func (p *PagedSearchWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if p.paged == nil {
+		return p.ResponseWriter.WriteWithControls(po, controls...)
+	}
+	switch r := po.(type) {
+	case ldap.SearchResultEntry:
+		if p.paged.Size > 0 && p.sent >= p.paged.Size {
+			p.held = append(p.held, r)
+			return nil
+		}
+		p.sent++
+	case ldap.SearchResultDone:
+		return p.finish(&pagedSearch{request: p.request, entries: p.held, done: r, controls: controls, total: p.sent + len(p.held)})
+	}
+	return p.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// finish ends a page, holding the remaining entries of search.
+func (p *PagedSearchWriter) finish(search *pagedSearch) error {
+	if len(search.entries) == 0 {
+		return p.ResponseWriter.WriteWithControls(search.done, append(append([]Control{}, search.controls...), pagedResultsControl(search.total, nil))...)
+	}
+
+	c := p.m.Client
+	c.Lock()
+	if c.pagedSearches == nil {
+		c.pagedSearches = make(map[string]*pagedSearch)
+	}
+	if len(c.pagedSearches) >= MaxPagedSearchesPerConn {
+		oldest := ""
+		for cookie := range c.pagedSearches {
+			if oldest == "" || cookieOrder(cookie) < cookieOrder(oldest) {
+				oldest = cookie
+			}
+		}
+		delete(c.pagedSearches, oldest)
+	}
+	c.pagedCookie++
+	cookie := strconv.FormatUint(c.pagedCookie, 10)
+	c.pagedSearches[cookie] = search
+	c.Unlock()
+
+	// the result of the search comes with its last page
+	return p.ResponseWriter.WriteWithControls(NewSearchResultDoneResponse(LDAPResultSuccess),
+		append(append([]Control{}, search.controls...), pagedResultsControl(search.total, []byte(cookie)))...)
+}
+
+func cookieOrder(cookie string) uint64 {
+	n, _ := strconv.ParseUint(cookie, 10, 64)
+	return n
+}
+
+// pagedResultsControl returns the response control of a page.
+func pagedResultsControl(size int, cookie []byte) Control {
+	value := berSequence(berInteger(int64(size)), berOctetString(cookie))
+	return Control{OID: ControlPagedResults, Value: value}
+}
@@ -0,0 +1,74 @@
+package ldapserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// errorf reports an error to Server.ErrorLogger, or to the standard
+// logger when it is not set.
+func (s *Server) errorf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if s.ErrorLogger != nil {
+		s.ErrorLogger(msg)
+		return
+	}
+	log.Print(msg)
+}
+
+// recoverHandler recovers from a panic of the handler of a request: the
+// stack is logged, the request is answered with other unless it already
+// was, and the connection is dropped when Server.DropOnPanic is set. It
+// must be deferred.
+func (c *client) recoverHandler(w responseWriterImpl) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	c.srv.errorf("ldapserver: panic serving client %d message %d: %v\n%s", c.Numero, w.messageID, v, debug.Stack())
+	if !w.responded.Load() {
+		if res := responseFor(w.request, LDAPResultOther, "internal server error"); res != nil {
+			w.Write(res)
+		}
+	}
+	if c.srv.DropOnPanic {
+		c.disconnect(LDAPResultUnavailable, "internal server error")
+	}
+}
+
+// recoverNoResponse recovers from a panic of the handler of a request
+// without response, which runs on the read loop.
+func (c *client) recoverNoResponse(messageID int) {
+	if v := recover(); v != nil {
+		c.srv.errorf("ldapserver: panic serving client %d message %d: %v\n%s", c.Numero, messageID, v, debug.Stack())
+	}
+}
+
+// recoverReader recovers from a panic of the read loop of the client c:
+// the stack is logged and the loop ends, closing the connection as a read
+// error does. It must be deferred.
+func (c *client) recoverReader() {
+	if v := recover(); v != nil {
+		c.srv.errorf("ldapserver: panic reading client %d: %v\n%s", c.Numero, v, debug.Stack())
+	}
+}
+
+// recoverDatagram recovers from a panic of the handler of a CLDAP request
+// from addr: the stack is logged and the request is answered with other
+// unless it already was. It must be deferred.
+func (s *Server) recoverDatagram(addr net.Addr, w *datagramWriter, request ldap.ProtocolOp) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	s.errorf("ldapserver: panic serving cldap %s message %d: %v\n%s", addr, w.messageID, v, debug.Stack())
+	if !w.responded {
+		if res := responseFor(request, LDAPResultOther, "internal server error"); res != nil {
+			w.Write(res)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package password contains password helpers for LDAP servers: quality
+// checks for new passwords and verification of userPassword hashes.
+package password
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// QualityError is returned when a password is rejected by a quality rule.
+// Servers should answer it with a constraintViolation result and use
+// Error() as the diagnostic message.
+type QualityError struct {
+	Reason string
+}
+
+func (e *QualityError) Error() string {
+	return "password quality: " + e.Reason
+}
+
+func qualityErrorf(format string, a ...any) error {
+	return &QualityError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// Attributes holds the attributes of the entry whose password is being
+// set, keyed by lowercased attribute name. It is used by rules comparing
+// the password with the user's own data.
+type Attributes map[string][]string
+
+// Rule checks one aspect of a candidate password.
+type Rule func(password string, user Attributes) error
+
+// Quality is a pipeline of rules. The first failing rule stops the
+// pipeline.
+type Quality []Rule
+
+// Check runs every rule against the password.
+func (q Quality) Check(password string, user Attributes) error {
+	for _, rule := range q {
+		if err := rule(password, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultQuality is a reasonable pipeline for most deployments.
+var DefaultQuality = Quality{
+	MinLength(8),
+	CharacterClasses(3),
+	NotCommon(),
+	NotSimilarToAttributes("uid", "cn", "sn", "givenname", "mail"),
+}
+
+// MinLength rejects passwords shorter than n characters.
+func MinLength(n int) Rule {
+	return func(password string, _ Attributes) error {
+		if l := len([]rune(password)); l < n {
+			return qualityErrorf("password must be at least %d characters long", n)
+		}
+		return nil
+	}
+}
+
+// CharacterClasses rejects passwords using fewer than n of the character
+// classes lower case, upper case, digit and symbol.
+func CharacterClasses(n int) Rule {
+	return func(password string, _ Attributes) error {
+		if c := countClasses(password); c < n {
+			return qualityErrorf("password must contain at least %d of: lower case, upper case, digit, symbol", n)
+		}
+		return nil
+	}
+}
+
+func countClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, b := range []bool{lower, upper, digit, other} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// Dictionary rejects passwords found (case-insensitively) in words.
+func Dictionary(words []string) Rule {
+	set := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		set[strings.ToLower(w)] = struct{}{}
+	}
+	return func(password string, _ Attributes) error {
+		if _, ok := set[strings.ToLower(password)]; ok {
+			return qualityErrorf("password is too common")
+		}
+		return nil
+	}
+}
+
+// NotCommon rejects passwords from a small built-in list of the most
+// common passwords. Use Dictionary for a real list.
+func NotCommon() Rule {
+	return Dictionary(commonPasswords)
+}
+
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "password", "qwerty", "12345",
+	"1234567", "111111", "123123", "abc123", "password1", "1234567890",
+	"iloveyou", "000000", "admin", "welcome", "monkey", "letmein",
+	"dragon", "sunshine", "princess", "football", "qwerty123", "passw0rd",
+	"Password1", "Password123", "changeme", "secret", "master", "login",
+}
+
+// MinEntropy rejects passwords whose estimated entropy is below bits. The
+// estimate is length * log2(size of the character pool in use), which
+// over-estimates for dictionary words; combine it with Dictionary.
+func MinEntropy(bits float64) Rule {
+	return func(password string, _ Attributes) error {
+		if e := Entropy(password); e < bits {
+			return qualityErrorf("password is too weak (%.0f bits of entropy, %.0f required)", e, bits)
+		}
+		return nil
+	}
+}
+
+// Entropy returns a rough entropy estimate for the password, in bits.
+func Entropy(password string) float64 {
+	var pool int
+	var lower, upper, digit, symbol, nonASCII bool
+	for _, r := range password {
+		switch {
+		case r > unicode.MaxASCII:
+			nonASCII = true
+		case 'a' <= r && r <= 'z':
+			lower = true
+		case 'A' <= r && r <= 'Z':
+			upper = true
+		case '0' <= r && r <= '9':
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if nonASCII {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(len([]rune(password))) * math.Log2(float64(pool))
+}
+
+// NotSimilarToAttributes rejects passwords which contain, or are contained
+// in, the value of one of the named user attributes. Values shorter than
+// three characters are ignored. For mail, only the local part is used.
+func NotSimilarToAttributes(names ...string) Rule {
+	return func(password string, user Attributes) error {
+		pw := strings.ToLower(password)
+		for _, name := range names {
+			name = strings.ToLower(name)
+			for _, v := range user[name] {
+				v = strings.ToLower(v)
+				if name == "mail" {
+					v, _, _ = strings.Cut(v, "@")
+				}
+				if len(v) < 3 {
+					continue
+				}
+				if strings.Contains(pw, v) || strings.Contains(v, pw) {
+					return qualityErrorf("password must not contain the %s of the user", name)
+				}
+			}
+		}
+		return nil
+	}
+}
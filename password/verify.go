@@ -0,0 +1,342 @@
+package password
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownScheme is returned for userPassword values with a scheme
+// Verify does not support.
+var ErrUnknownScheme = errors.New("password: unknown scheme")
+
+// ErrMalformed is returned for userPassword values that can not be
+// parsed.
+var ErrMalformed = errors.New("password: malformed hash")
+
+// Verify checks a candidate password against a userPassword value in the
+// RFC 2307 "{SCHEME}data" form, as stored by OpenLDAP and 389-ds:
+//
+//   - {SHA}, {SSHA}, {SHA256}, {SSHA256}, {SHA512}, {SSHA512}, {MD5},
+//     {SMD5}: base64 of the hash followed by the salt for the salted forms
+//   - {CRYPT}: crypt(3) strings for SHA-256 ($5$), SHA-512 ($6$) and
+//     bcrypt ($2a$, $2b$, $2y$)
+//   - {ARGON2}: PHC strings for argon2i and argon2id
+//
+// A value without scheme is a clear text password. Hashes are compared in
+// constant time.
+func Verify(hashed, password string) (bool, error) {
+	scheme, data, ok := splitScheme(hashed)
+	if !ok {
+		return subtle.ConstantTimeCompare([]byte(hashed), []byte(password)) == 1, nil
+	}
+
+	switch scheme {
+	case "SHA", "SSHA":
+		return verifyDigest(sha1.New, sha1.Size, data, password)
+	case "SHA256", "SSHA256":
+		return verifyDigest(sha256.New, sha256.Size, data, password)
+	case "SHA512", "SSHA512":
+		return verifyDigest(sha512.New, sha512.Size, data, password)
+	case "MD5", "SMD5":
+		return verifyDigest(md5.New, md5.Size, data, password)
+	case "CRYPT":
+		return verifyCrypt(data, password)
+	case "ARGON2":
+		return verifyArgon2(data, password)
+	case "CLEARTEXT":
+		return subtle.ConstantTimeCompare([]byte(data), []byte(password)) == 1, nil
+	}
+	return false, fmt.Errorf("%w %s", ErrUnknownScheme, scheme)
+}
+
+// splitScheme splits "{SCHEME}data", the scheme being upper cased.
+func splitScheme(hashed string) (scheme, data string, ok bool) {
+	if !strings.HasPrefix(hashed, "{") {
+		return "", "", false
+	}
+	end := strings.IndexByte(hashed, '}')
+	if end < 0 {
+		return "", "", false
+	}
+	return strings.ToUpper(hashed[1:end]), hashed[end+1:], true
+}
+
+// Cleartext returns the clear text password of a userPassword value, ok
+// being false for a hash: the value itself without scheme, or the data of
+// the {CLEARTEXT} scheme.
+func Cleartext(value string) (password string, ok bool) {
+	scheme, data, hashed := splitScheme(value)
+	switch {
+	case !hashed:
+		return value, true
+	case scheme == "CLEARTEXT":
+		return data, true
+	}
+	return "", false
+}
+
+func verifyDigest(h func() hash.Hash, size int, data, password string) (bool, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) < size {
+		return false, ErrMalformed
+	}
+	sum, salt := raw[:size], raw[size:]
+	d := h()
+	d.Write([]byte(password))
+	d.Write(salt)
+	return subtle.ConstantTimeCompare(d.Sum(nil), sum) == 1, nil
+}
+
+func verifyCrypt(data, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(data, "$2a$"), strings.HasPrefix(data, "$2b$"), strings.HasPrefix(data, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(data), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(data, "$5$"), strings.HasPrefix(data, "$6$"):
+		computed, err := shaCrypt(password, data)
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(data)) == 1, nil
+	}
+	return false, fmt.Errorf("%w CRYPT %.3s", ErrUnknownScheme, data)
+}
+
+func verifyArgon2(data, password string) (bool, error) {
+	// $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+	fields := strings.Split(data, "$")
+	if len(fields) != 6 || fields[0] != "" {
+		return false, ErrMalformed
+	}
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrMalformed
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrMalformed
+	}
+	if memory == 0 || time == 0 || threads == 0 {
+		// argon2 panics on them
+		return false, ErrMalformed
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return false, ErrMalformed
+	}
+	sum, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(sum) == 0 {
+		return false, ErrMalformed
+	}
+
+	var computed []byte
+	switch fields[1] {
+	case "argon2id":
+		computed = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(sum)))
+	case "argon2i":
+		computed = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(sum)))
+	default:
+		return false, fmt.Errorf("%w ARGON2 %s", ErrUnknownScheme, fields[1])
+	}
+	return subtle.ConstantTimeCompare(computed, sum) == 1, nil
+}
+
+// Hash returns the userPassword value of a password with one of the
+// schemes "SSHA", "SSHA256", "SSHA512", "CRYPT" (SHA-512 crypt), "BCRYPT"
+// (stored as {CRYPT}) or "ARGON2" (argon2id).
+func Hash(scheme, password string) (string, error) {
+	switch strings.ToUpper(scheme) {
+	case "SSHA":
+		return saltedDigest("SSHA", sha1.New, password), nil
+	case "SSHA256":
+		return saltedDigest("SSHA256", sha256.New, password), nil
+	case "SSHA512":
+		return saltedDigest("SSHA512", sha512.New, password), nil
+	case "CRYPT":
+		s, err := shaCrypt(password, "$6$"+cryptSalt(16))
+		return "{CRYPT}" + s, err
+	case "BCRYPT":
+		b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return "{CRYPT}" + string(b), err
+	case "ARGON2":
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		const memory, time, threads = 64 * 1024, 3, 4
+		sum := argon2.IDKey([]byte(password), salt, time, memory, threads, 32)
+		return fmt.Sprintf("{ARGON2}$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, memory, time, threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum)), nil
+	}
+	return "", fmt.Errorf("%w %s", ErrUnknownScheme, scheme)
+}
+
+func saltedDigest(scheme string, h func() hash.Hash, password string) string {
+	salt := make([]byte, 8)
+	rand.Read(salt)
+	d := h()
+	d.Write([]byte(password))
+	d.Write(salt)
+	return "{" + scheme + "}" + base64.StdEncoding.EncodeToString(append(d.Sum(nil), salt...))
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func cryptSalt(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = cryptAlphabet[b[i]&0x3f]
+	}
+	return string(b)
+}
+
+// Byte orders of the final encoding of SHA-crypt.
+var (
+	sha256CryptOrder = [][3]int{{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29}}
+	sha512CryptOrder = [][3]int{{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10},
+		{53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35}, {15, 36, 57}, {37, 58, 16},
+		{59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41}}
+)
+
+// shaCrypt computes the SHA-256 or SHA-512 crypt string of a password
+// with the settings ("$6$[rounds=N$]salt[$...]") of setting.
+func shaCrypt(password, setting string) (string, error) {
+	var h func() hash.Hash
+	var order [][3]int
+	switch {
+	case strings.HasPrefix(setting, "$5$"):
+		h, order = sha256.New, sha256CryptOrder
+	case strings.HasPrefix(setting, "$6$"):
+		h, order = sha512.New, sha512CryptOrder
+	default:
+		return "", ErrMalformed
+	}
+	prefix := setting[:3]
+	rest := setting[3:]
+
+	rounds, custom := 5000, false
+	if strings.HasPrefix(rest, "rounds=") {
+		end := strings.IndexByte(rest, '$')
+		if end < 0 {
+			return "", ErrMalformed
+		}
+		n, err := strconv.Atoi(rest[len("rounds="):end])
+		if err != nil {
+			return "", ErrMalformed
+		}
+		rounds, custom = min(max(n, 1000), 999999999), true
+		rest = rest[end+1:]
+	}
+	salt := rest
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	p, s := []byte(password), []byte(salt)
+
+	sum := func(parts ...[]byte) []byte {
+		d := h()
+		for _, part := range parts {
+			d.Write(part)
+		}
+		return d.Sum(nil)
+	}
+	repeat := func(b []byte, n int) []byte {
+		out := make([]byte, 0, n)
+		for len(out) < n {
+			out = append(out, b[:min(len(b), n-len(out))]...)
+		}
+		return out
+	}
+
+	b := sum(p, s, p)
+	d := h()
+	d.Write(p)
+	d.Write(s)
+	d.Write(repeat(b, len(p)))
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			d.Write(b)
+		} else {
+			d.Write(p)
+		}
+	}
+	a := d.Sum(nil)
+
+	dp := h()
+	for range p {
+		dp.Write(p)
+	}
+	pBytes := repeat(dp.Sum(nil), len(p))
+
+	ds := h()
+	for i := 0; i < 16+int(a[0]); i++ {
+		ds.Write(s)
+	}
+	sBytes := repeat(ds.Sum(nil), len(s))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		d := h()
+		if i&1 != 0 {
+			d.Write(pBytes)
+		} else {
+			d.Write(c)
+		}
+		if i%3 != 0 {
+			d.Write(sBytes)
+		}
+		if i%7 != 0 {
+			d.Write(pBytes)
+		}
+		if i&1 != 0 {
+			d.Write(c)
+		} else {
+			d.Write(pBytes)
+		}
+		c = d.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(prefix)
+	if custom {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	encode := func(w uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for _, o := range order {
+		encode(uint32(c[o[0]])<<16|uint32(c[o[1]])<<8|uint32(c[o[2]]), 4)
+	}
+	if len(c) == sha512.Size {
+		encode(uint32(c[63]), 2)
+	} else {
+		encode(uint32(c[31])<<8|uint32(c[30]), 3)
+	}
+	return out.String(), nil
+}
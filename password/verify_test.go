@@ -0,0 +1,17 @@
+package password
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestVerifyArgon2Malformed checks that the ARGON2 values with zero
+// parameters are rejected instead of making argon2 panic.
+func TestVerifyArgon2Malformed(t *testing.T) {
+	for _, params := range []string{"m=0,t=1,p=1", "m=65536,t=0,p=1", "m=65536,t=1,p=0"} {
+		hashed := "{ARGON2}$argon2id$v=19$" + params + "$c2FsdHNhbHQ$aGFzaGhhc2g"
+		if _, err := Verify(hashed, "secret"); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: got %v, want ErrMalformed", params, err)
+		}
+	}
+}
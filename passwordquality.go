@@ -0,0 +1,115 @@
+package ldapserver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nolta/ldapserver/password"
+)
+
+// CheckPasswordQuality checks the clear text userPassword values of the
+// entry e which the entry old, nil for an added entry, did not have,
+// against the quality q, the rules comparing them with the attributes of
+// e. It returns an *Error with constraintViolation for a rejected value.
+// The hashed values can not be checked: clients should set the passwords
+// with the Password Modify extended operation, see PasswordModify.
+func CheckPasswordQuality(q password.Quality, e, old *Entry) error {
+	if q == nil {
+		return nil
+	}
+	for _, v := range e.Get("userPassword") {
+		if old != nil && old.Has("userPassword", v) {
+			continue
+		}
+		if pw, ok := password.Cleartext(v); ok {
+			if err := checkPassword(q, pw, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPassword checks the password of the user e against the quality q.
+func checkPassword(q password.Quality, pw string, e *Entry) error {
+	user := make(password.Attributes, len(e.Attributes))
+	for _, a := range e.Attributes {
+		name := strings.ToLower(a.Name)
+		user[name] = append(user[name], a.Values...)
+	}
+	if err := q.Check(pw, user); err != nil {
+		return NewError(LDAPResultConstraintViolation, err.Error())
+	}
+	return nil
+}
+
+// PasswordModify returns a handler of the Password Modify extended
+// operation (RFC 3062) setting the userPassword of the entries of the
+// backend b, hashed with SSHA256, once checked against the quality q, if
+// not nil:
+//
+//	routes.Extended(ldap.PasswordModify(backend, password.DefaultQuality)).RequestName(ldap.NoticeOfPasswordModify)
+//
+// The user is the userIdentity of the request, a DN or a "dn:" authzId,
+// the bound user when absent. The old password must be supplied to change
+// the password of another user. The server does not generate passwords.
+func PasswordModify(b Backend, q password.Quality) HandlerFunc {
+	return ErrorHandler(func(ctx context.Context, w ResponseWriter, m *Message) error {
+		v, err := m.ExtendedValue()
+		if err != nil {
+			return NewError(LDAPResultProtocolError, err.Error())
+		}
+		r, ok := v.(*PasswordModifyRequest)
+		if !ok {
+			return NewError(LDAPResultProtocolError, "not a Password Modify request")
+		}
+		auth, _ := AuthStateFromContext(ctx)
+		dn := auth.BoundDN
+		if r.UserIdentity != nil {
+			dn = strings.TrimPrefix(string(r.UserIdentity), "dn:")
+		}
+		switch {
+		case dn == "":
+			return NewError(LDAPResultUnwillingToPerform, "no user to change the password of")
+		case r.NewPassword == nil:
+			return NewError(LDAPResultUnwillingToPerform, "the new password must be supplied")
+		case r.OldPassword == nil && NormalizeDN(dn) != NormalizeDN(auth.BoundDN):
+			return NewError(LDAPResultInsufficientAccessRights, "the old password must be supplied")
+		}
+		if r.OldPassword != nil {
+			if err := b.Bind(ctx, dn, string(r.OldPassword)); err != nil {
+				return err
+			}
+		}
+
+		if q != nil {
+			s, err := NewSearchRequest(dn, SearchRequestScopeBaseObject, "(objectClass=*)")
+			if err != nil {
+				return NewError(LDAPResultInvalidDNSyntax, err.Error())
+			}
+			var user *Entry
+			if err := b.Search(ctx, s, func(e *Entry) error { user = e; return nil }); err != nil {
+				return err
+			}
+			if user == nil {
+				return NewError(LDAPResultNoSuchObject, dn)
+			}
+			if err := checkPassword(q, string(r.NewPassword), user); err != nil {
+				return err
+			}
+		}
+		hashed, err := password.Hash("SSHA256", string(r.NewPassword))
+		if err != nil {
+			return err
+		}
+		change := Modification{Operation: ModifyRequestChangeOperationReplace, Attribute: "userPassword", Values: []string{hashed}}
+		if err := b.Modify(ctx, dn, []Modification{change}); err != nil {
+			return err
+		}
+		res, err := NewExtendedResponseFor(m, LDAPResultSuccess, nil)
+		if err != nil {
+			return err
+		}
+		return w.Write(res)
+	})
+}
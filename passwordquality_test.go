@@ -0,0 +1,33 @@
+package ldapserver
+
+import (
+	"testing"
+
+	"github.com/nolta/ldapserver/password"
+)
+
+func TestCheckPasswordQuality(t *testing.T) {
+	old := NewEntry("uid=jdoe,dc=example,dc=com", map[string][]string{"uid": {"jdoe"}, "userPassword": {"weak"}})
+	for _, tt := range []struct {
+		name      string
+		passwords []string
+		old       *Entry
+		ok        bool
+	}{
+		{"short", []string{"Ab1!"}, nil, false},
+		{"similar", []string{"{CLEARTEXT}jdoe-Pass1!"}, nil, false},
+		{"strong", []string{"Correct#Horse9"}, nil, true},
+		{"hashed", []string{"{SSHA}c2VjcmV0"}, nil, true},
+		{"kept", []string{"weak", "Correct#Horse9"}, old, true},
+		{"added", []string{"weak", "weaker"}, old, false},
+	} {
+		e := NewEntry(old.DN, map[string][]string{"uid": {"jdoe"}, "userPassword": tt.passwords})
+		err := CheckPasswordQuality(password.DefaultQuality, e, tt.old)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+		if e, ok := err.(*Error); err != nil && (!ok || e.ResultCode != LDAPResultConstraintViolation) {
+			t.Errorf("%s: got %v, want constraintViolation", tt.name, err)
+		}
+	}
+}
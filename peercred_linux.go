@@ -0,0 +1,25 @@
+package ldapserver
+
+import (
+	"net"
+	"syscall"
+)
+
+func peerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PeerCredentials{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package ldapserver
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	return nil, errors.New("ldapserver: peer credentials are not supported on this platform")
+}
@@ -0,0 +1,285 @@
+package ldapserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+
+	"github.com/nolta/ldapserver/password"
+)
+
+// ControlPasswordPolicy is the OID of the Password Policy request and
+// response controls (draft-behera-ldap-password-policy).
+const ControlPasswordPolicy ldap.LDAPOID = "1.3.6.1.4.1.42.2.27.8.5.1"
+
+// PasswordPolicyError is an error of the Password Policy response control.
+type PasswordPolicyError int
+
+const (
+	PolicyNoError PasswordPolicyError = iota - 1
+	PolicyPasswordExpired
+	PolicyAccountLocked
+	PolicyChangeAfterReset
+	PolicyPasswordModNotAllowed
+	PolicyMustSupplyOldPassword
+	PolicyInsufficientPasswordQuality
+	PolicyPasswordTooShort
+	PolicyPasswordTooYoung
+	PolicyPasswordInHistory
+)
+
+// PasswordPolicy reports whether the request carries the Password Policy
+// control: the client wants the warnings and errors of the policy in the
+// response.
+func (m *Message) PasswordPolicy() bool {
+	_, ok := m.Control(ControlPasswordPolicy)
+	return ok
+}
+
+// PasswordPolicyResponse is the value of the Password Policy response
+// control.
+type PasswordPolicyResponse struct {
+	TimeBeforeExpiration int // seconds, -1 for none
+	GraceAuthNsRemaining int // -1 for none
+	Error                PasswordPolicyError
+}
+
+// noPolicyResponse is a response without warning nor error.
+var noPolicyResponse = PasswordPolicyResponse{TimeBeforeExpiration: -1, GraceAuthNsRemaining: -1, Error: PolicyNoError}
+
+// Control returns the response control, to be sent when the request
+// carries the Password Policy control.
+func (r PasswordPolicyResponse) Control() Control {
+	// PasswordPolicyResponseValue ::= SEQUENCE {
+	//     warning [0] CHOICE { timeBeforeExpiration [0] INTEGER,
+	//         graceAuthNsRemaining [1] INTEGER } OPTIONAL,
+	//     error [1] ENUMERATED OPTIONAL }
+	var fields [][]byte
+	switch {
+	case r.TimeBeforeExpiration >= 0:
+		fields = append(fields, berEncode(berClassContext, true, 0,
+			berEncode(berClassContext, false, 0, berIntegerContent(int64(r.TimeBeforeExpiration)))))
+	case r.GraceAuthNsRemaining >= 0:
+		fields = append(fields, berEncode(berClassContext, true, 0,
+			berEncode(berClassContext, false, 1, berIntegerContent(int64(r.GraceAuthNsRemaining)))))
+	}
+	if r.Error != PolicyNoError {
+		fields = append(fields, berEncode(berClassContext, false, 1, berIntegerContent(int64(r.Error))))
+	}
+	return Control{OID: ControlPasswordPolicy, Value: berSequence(fields...)}
+}
+
+// PasswordState is the policy state of an account.
+type PasswordState struct {
+	ChangedTime  time.Time   // last password change, zero if unknown
+	FailureTimes []time.Time // recent bind failures
+	LockedTime   time.Time   // zero when not locked
+	GraceUses    int         // grace binds used since the password expired
+	History      []string    // hashes of the last passwords, newest last
+	Reset        bool        // set by an administrator, must be changed
+}
+
+// PasswordPolicyStore keeps the PasswordState of the accounts, for
+// example in the operational attributes of the entries.
+type PasswordPolicyStore interface {
+	LoadPasswordState(ctx context.Context, dn string) (PasswordState, error)
+	SavePasswordState(ctx context.Context, dn string, state PasswordState) error
+}
+
+// PasswordPolicy is a password policy engine after
+// draft-behera-ldap-password-policy. Handlers drive it from their bind and
+// password change code and send the returned response control when the
+// request carries the Password Policy control:
+//
+//	ok := checkPassword(dn, pw)
+//	code, res, err := policy.Bind(ctx, dn, ok)
+//	if err != nil { ... }
+//	r := ldap.NewBindResponse(code)
+//	if m.PasswordPolicy() {
+//		w.WriteWithControls(r, res.Control())
+//	} else {
+//		w.Write(r)
+//	}
+//
+// Zero fields disable the matching checks. The state of the accounts is
+// kept in memory unless Store is set.
+type PasswordPolicy struct {
+	MaxAge        time.Duration // password expiration
+	MinAge        time.Duration // minimum time between changes by the user
+	ExpireWarning time.Duration // warn this long before expiration
+	GraceLogins   int           // binds allowed with an expired password
+
+	MaxFailure           int           // bind failures before lockout
+	FailureCountInterval time.Duration // failures older than this are forgotten
+	LockoutDuration      time.Duration // 0 locks until an administrator resets
+
+	InHistory       int              // passwords that can not be reused
+	MinLength       int              // minimum length in characters
+	Quality         password.Quality // checks of the new passwords
+	MustChange      bool             // passwords set by an administrator must be changed
+	AllowUserChange bool             // users can change their own password
+	SafeModify      bool             // users must supply their old password
+
+	Store PasswordPolicyStore
+
+	mu     sync.Mutex
+	states map[string]PasswordState
+}
+
+func (p *PasswordPolicy) load(ctx context.Context, dn string) (PasswordState, error) {
+	if p.Store != nil {
+		return p.Store.LoadPasswordState(ctx, dn)
+	}
+	return p.states[NormalizeDN(dn)], nil
+}
+
+func (p *PasswordPolicy) save(ctx context.Context, dn string, state PasswordState) error {
+	if p.Store != nil {
+		return p.Store.SavePasswordState(ctx, dn, state)
+	}
+	if p.states == nil {
+		p.states = make(map[string]PasswordState)
+	}
+	p.states[NormalizeDN(dn)] = state
+	return nil
+}
+
+// locked reports whether the account is locked at now.
+func (p *PasswordPolicy) locked(state PasswordState, now time.Time) bool {
+	if state.LockedTime.IsZero() {
+		return false
+	}
+	return p.LockoutDuration == 0 || now.Before(state.LockedTime.Add(p.LockoutDuration))
+}
+
+// Bind applies the policy to a bind as dn, verified telling whether the
+// credentials were valid. It returns the result code of the bind and the
+// response control: binds with a locked account or an expired password
+// fail with invalidCredentials.
+func (p *PasswordPolicy) Bind(ctx context.Context, dn string, verified bool) (int, PasswordPolicyResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res := noPolicyResponse
+	state, err := p.load(ctx, dn)
+	if err != nil {
+		return LDAPResultOperationsError, res, err
+	}
+	now := time.Now()
+
+	if p.locked(state, now) {
+		res.Error = PolicyAccountLocked
+		return LDAPResultInvalidCredentials, res, nil
+	}
+	state.LockedTime = time.Time{}
+
+	if !verified {
+		if p.MaxFailure > 0 {
+			recent := state.FailureTimes[:0]
+			for _, t := range state.FailureTimes {
+				if p.FailureCountInterval == 0 || now.Sub(t) < p.FailureCountInterval {
+					recent = append(recent, t)
+				}
+			}
+			state.FailureTimes = append(recent, now)
+			if len(state.FailureTimes) >= p.MaxFailure {
+				state.LockedTime = now
+			}
+		}
+		return LDAPResultInvalidCredentials, res, p.save(ctx, dn, state)
+	}
+	state.FailureTimes = nil
+
+	if p.MaxAge > 0 && !state.ChangedTime.IsZero() {
+		expires := state.ChangedTime.Add(p.MaxAge)
+		switch left := expires.Sub(now); {
+		case left <= 0 && state.GraceUses < p.GraceLogins:
+			state.GraceUses++
+			res.GraceAuthNsRemaining = p.GraceLogins - state.GraceUses
+		case left <= 0:
+			res.Error = PolicyPasswordExpired
+			return LDAPResultInvalidCredentials, res, p.save(ctx, dn, state)
+		case left < p.ExpireWarning:
+			res.TimeBeforeExpiration = int(left / time.Second)
+		}
+	}
+	if state.Reset && p.MustChange {
+		res.Error = PolicyChangeAfterReset
+	}
+	return LDAPResultSuccess, res, p.save(ctx, dn, state)
+}
+
+// ChangePassword applies the policy to a password change of the account
+// dn, by the user or by an administrator. oldSupplied tells whether the
+// user gave the old password. On success, the new password is recorded in
+// the history; the caller stores it.
+func (p *PasswordPolicy) ChangePassword(ctx context.Context, dn string, newPassword string, oldSupplied, byAdmin bool) (int, PasswordPolicyResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res := noPolicyResponse
+	state, err := p.load(ctx, dn)
+	if err != nil {
+		return LDAPResultOperationsError, res, err
+	}
+	now := time.Now()
+
+	if !byAdmin {
+		switch {
+		case !p.AllowUserChange:
+			res.Error = PolicyPasswordModNotAllowed
+			return LDAPResultInsufficientAccessRights, res, nil
+		case p.SafeModify && !oldSupplied:
+			res.Error = PolicyMustSupplyOldPassword
+			return LDAPResultConstraintViolation, res, nil
+		case p.MinAge > 0 && !state.Reset && now.Before(state.ChangedTime.Add(p.MinAge)):
+			res.Error = PolicyPasswordTooYoung
+			return LDAPResultConstraintViolation, res, nil
+		}
+	}
+	if len([]rune(newPassword)) < p.MinLength {
+		res.Error = PolicyPasswordTooShort
+		return LDAPResultConstraintViolation, res, nil
+	}
+	if p.Quality != nil {
+		if err := p.Quality.Check(newPassword, nil); err != nil {
+			res.Error = PolicyInsufficientPasswordQuality
+			return LDAPResultConstraintViolation, res, nil
+		}
+	}
+	for _, hashed := range state.History {
+		if ok, _ := password.Verify(hashed, newPassword); ok {
+			res.Error = PolicyPasswordInHistory
+			return LDAPResultConstraintViolation, res, nil
+		}
+	}
+
+	if p.InHistory > 0 {
+		hashed, err := password.Hash("SSHA256", newPassword)
+		if err != nil {
+			return LDAPResultOperationsError, res, err
+		}
+		state.History = append(state.History, hashed)
+		if len(state.History) > p.InHistory {
+			state.History = state.History[len(state.History)-p.InHistory:]
+		}
+	}
+	state.ChangedTime = now
+	state.GraceUses = 0
+	state.Reset = byAdmin && p.MustChange
+	return LDAPResultSuccess, res, p.save(ctx, dn, state)
+}
+
+// Unlock clears the lockout and bind failures of the account dn.
+func (p *PasswordPolicy) Unlock(ctx context.Context, dn string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state, err := p.load(ctx, dn)
+	if err != nil {
+		return err
+	}
+	state.LockedTime, state.FailureTimes = time.Time{}, nil
+	return p.save(ctx, dn, state)
+}
@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// Defaults of the Cache.
+const (
+	DefaultCacheTTL         = time.Minute
+	DefaultNegativeCacheTTL = 10 * time.Second
+	DefaultCacheSize        = 10000
+)
+
+// maxCachedEntries is the number of entries above which the results of a
+// search are not cached.
+const maxCachedEntries = 1000
+
+// Cache caches the results of the searches and simple binds forwarded by a
+// Proxy, set in Proxy.Cache, to shield a slow upstream server from the
+// clients repeating them, e.g. applications checking passwords in a loop.
+//
+// Searches are cached by bound DN, base, scope, filter, attributes and
+// limits, and binds by DN and a salted hash of the password; requests with
+// controls are not cached. A successful bind served from the cache is sent
+// upstream with the next request of the client, if any. Binds failing with
+// invalidCredentials and searches of a missing base are cached for
+// NegativeTTL. The writes forwarded by the proxy invalidate the results
+// involving their DNs, and the extended operations, e.g. password
+// modifies, the binds; the changes made elsewhere must be reported with
+// Invalidate.
+//
+// It is safe for concurrent use. The zero Cache is ready to use.
+type Cache struct {
+	// TTL is how long the results are served from the cache,
+	// DefaultCacheTTL if zero.
+	TTL time.Duration
+
+	// NegativeTTL is how long the failures are served from the cache,
+	// DefaultNegativeCacheTTL if zero; they are not cached if negative.
+	NegativeTTL time.Duration
+
+	// MaxEntries is the number of cached results, DefaultCacheSize if
+	// zero; those expiring first are evicted.
+	MaxEntries int
+
+	mu       sync.Mutex
+	salt     []byte
+	searches map[string]*cachedSearch
+	binds    map[string]*cachedBind
+}
+
+// cachedSearch is the responses to a search, the final one last.
+type cachedSearch struct {
+	base      string // normalized
+	scope     int
+	responses []*goldap.LDAPMessage
+	expires   time.Time
+}
+
+// cachedBind is the response to a simple bind.
+type cachedBind struct {
+	dn       string // normalized
+	response *goldap.LDAPMessage
+	expires  time.Time
+}
+
+func (c *Cache) ttl(code int) time.Duration {
+	switch {
+	case code == ldap.LDAPResultSuccess && c.TTL == 0:
+		return DefaultCacheTTL
+	case code == ldap.LDAPResultSuccess:
+		return c.TTL
+	case c.NegativeTTL == 0:
+		return DefaultNegativeCacheTTL
+	}
+	return c.NegativeTTL
+}
+
+func (c *Cache) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return DefaultCacheSize
+	}
+	return c.MaxEntries
+}
+
+// searchKey returns the key of the search m of the identity boundDN, ok
+// being false when it can not be cached.
+func searchKey(m *ldap.Message, boundDN string) (key string, ok bool) {
+	if m.LDAPMessage.Controls() != nil {
+		return "", false
+	}
+	r := m.GetSearchRequest()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%q %q %d %d %d %d %t %q", ldap.NormalizeDN(boundDN), ldap.NormalizeDN(string(r.BaseObject())),
+		r.Scope(), r.DerefAliases(), r.SizeLimit(), r.TimeLimit(), r.TypesOnly(), ldap.FilterString(r))
+	for _, a := range r.Attributes() {
+		fmt.Fprintf(&b, " %q", strings.ToLower(string(a)))
+	}
+	return b.String(), true
+}
+
+// bindKey returns the key of the simple bind m, ok being false when it can
+// not be cached.
+func (c *Cache) bindKey(m *ldap.Message) (key string, ok bool) {
+	r := m.GetBindRequest()
+	name, password := string(r.Name()), r.AuthenticationSimple()
+	if m.LDAPMessage.Controls() != nil || name == "" || len(password) == 0 {
+		return "", false
+	}
+	if c.salt == nil {
+		c.salt = make([]byte, 16)
+		rand.Read(c.salt)
+	}
+	h := sha256.New()
+	h.Write(c.salt)
+	h.Write([]byte(ldap.NormalizeDN(name)))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	return string(h.Sum(nil)), true
+}
+
+// search returns the cached responses to the search m.
+func (c *Cache) search(m *ldap.Message, boundDN string) ([]*goldap.LDAPMessage, bool) {
+	key, ok := searchKey(m, boundDN)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.searches[key]
+	if e == nil || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.responses, true
+}
+
+// storeSearch caches the responses to the search m, when its result can
+// be cached.
+func (c *Cache) storeSearch(m *ldap.Message, boundDN string, responses []*goldap.LDAPMessage) {
+	key, ok := searchKey(m, boundDN)
+	if !ok || len(responses) == 0 || len(responses) > maxCachedEntries {
+		return
+	}
+	code, ok := ldap.ResultCode(responses[len(responses)-1].ProtocolOp())
+	if !ok || !c.cacheable(code, ldap.LDAPResultNoSuchObject, responses[len(responses)-1]) {
+		return
+	}
+	r := m.GetSearchRequest()
+	e := &cachedSearch{
+		base:      ldap.NormalizeDN(string(r.BaseObject())),
+		scope:     int(r.Scope()),
+		responses: responses,
+		expires:   time.Now().Add(c.ttl(code)),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.searches == nil {
+		c.searches = make(map[string]*cachedSearch)
+	}
+	c.searches[key] = e
+	c.evict()
+}
+
+// bind returns the cached response to the bind m.
+func (c *Cache) bind(m *ldap.Message) (*goldap.LDAPMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.bindKey(m)
+	if !ok {
+		return nil, false
+	}
+	e := c.binds[key]
+	if e == nil || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.response, true
+}
+
+// storeBind caches the response res to the bind m, when its result can be
+// cached.
+func (c *Cache) storeBind(m *ldap.Message, res *goldap.LDAPMessage) {
+	code, ok := ldap.ResultCode(res.ProtocolOp())
+	if !ok || !c.cacheable(code, ldap.LDAPResultInvalidCredentials, res) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.bindKey(m)
+	if !ok {
+		return
+	}
+	if c.binds == nil {
+		c.binds = make(map[string]*cachedBind)
+	}
+	r := m.GetBindRequest()
+	c.binds[key] = &cachedBind{dn: ldap.NormalizeDN(string(r.Name())), response: res, expires: time.Now().Add(c.ttl(code))}
+	c.evict()
+}
+
+// cacheable reports whether the final response res with the result code
+// can be cached, negative being the code of the failures cached.
+func (c *Cache) cacheable(code, negative int, res *goldap.LDAPMessage) bool {
+	if res.Controls() != nil {
+		return false // e.g. a password policy warning
+	}
+	return code == ldap.LDAPResultSuccess || code == negative && c.NegativeTTL >= 0
+}
+
+// evict drops the expired results, then those expiring first while the
+// cache holds more than MaxEntries. c.mu must be held.
+func (c *Cache) evict() {
+	n := len(c.searches) + len(c.binds)
+	if n <= c.maxEntries() {
+		return
+	}
+	now := time.Now()
+	oldest, oldestKey, search := time.Time{}, "", false
+	for key, e := range c.searches {
+		if now.After(e.expires) {
+			delete(c.searches, key)
+		} else if oldestKey == "" || e.expires.Before(oldest) {
+			oldest, oldestKey, search = e.expires, key, true
+		}
+	}
+	for key, e := range c.binds {
+		if now.After(e.expires) {
+			delete(c.binds, key)
+		} else if oldestKey == "" || e.expires.Before(oldest) {
+			oldest, oldestKey, search = e.expires, key, false
+		}
+	}
+	if len(c.searches)+len(c.binds) <= c.maxEntries() {
+		return
+	}
+	if search {
+		delete(c.searches, oldestKey)
+	} else {
+		delete(c.binds, oldestKey)
+	}
+}
+
+// Invalidate drops the cached results involving the entry dn, changed
+// elsewhere than through the proxy: the searches whose scope includes it
+// or which are below it, and its binds.
+func (c *Cache) Invalidate(dn string) {
+	dn = ldap.NormalizeDN(dn)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.searches {
+		if ldap.InScope(dn, e.base, e.scope) || ldap.InScope(e.base, dn, ldap.SearchRequestHomeSubtree) {
+			delete(c.searches, key)
+		}
+	}
+	for key, e := range c.binds {
+		if ldap.InScope(e.dn, dn, ldap.SearchRequestHomeSubtree) {
+			delete(c.binds, key)
+		}
+	}
+}
+
+// InvalidateBinds drops the cached binds, e.g. when passwords were
+// changed elsewhere than through the proxy.
+func (c *Cache) InvalidateBinds() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.binds)
+}
+
+// Purge drops all the cached results.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.searches)
+	clear(c.binds)
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// Defaults of the pool of upstream connections, see Proxy.
+const (
+	DefaultHealthCheckInterval = 30 * time.Second
+	DefaultMaxFailures         = 3
+	DefaultRetryAfter          = 30 * time.Second
+)
+
+// errNoServer is the error of the requests when the circuits of all the
+// upstream servers are open.
+var errNoServer = errors.New("no upstream server available")
+
+// server is an upstream server with its circuit breaker: the circuit opens
+// after MaxFailures consecutive failed dials or probes, and the server is
+// skipped for RetryAfter, after which it is tried again.
+type server struct {
+	url string
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// available reports whether the circuit of the server is closed, or open
+// for longer than RetryAfter.
+func (s *server) available(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !now.Before(s.openUntil)
+}
+
+func (s *server) succeeded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = 0
+	s.openUntil = time.Time{}
+}
+
+func (s *server) failed(maxFailures int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures++
+	if s.failures >= maxFailures {
+		s.openUntil = time.Now().Add(retryAfter)
+	}
+}
+
+// pool holds the upstream servers and the idle connections of a Proxy.
+type pool struct {
+	servers []*server
+	stop    chan struct{} // closed by Proxy.Close
+
+	mu   sync.Mutex
+	idle []*upstream // anonymous, most recently released last
+}
+
+// init builds the pool of the proxy and starts its health checks, on
+// first use.
+func (p *Proxy) init() *pool {
+	p.once.Do(func() {
+		p.pool = &pool{stop: make(chan struct{})}
+		for _, u := range append([]string{p.URL}, p.FailoverURLs...) {
+			p.pool.servers = append(p.pool.servers, &server{url: u})
+		}
+		if p.HealthCheckInterval >= 0 {
+			go p.checkHealth()
+		}
+	})
+	return p.pool
+}
+
+// get returns an idle connection, or dials the first available upstream
+// server.
+func (p *Proxy) get(ctx context.Context) (*upstream, error) {
+	pool := p.init()
+	pool.mu.Lock()
+	for len(pool.idle) > 0 {
+		up := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		if !up.failed() {
+			pool.mu.Unlock()
+			return up, nil
+		}
+	}
+	pool.mu.Unlock()
+	return p.dialServer(ctx)
+}
+
+// dialServer dials the first upstream server whose circuit is closed, or
+// half-open.
+func (p *Proxy) dialServer(ctx context.Context) (*upstream, error) {
+	err := errNoServer
+	now := time.Now()
+	for _, s := range p.pool.servers {
+		if !s.available(now) {
+			continue
+		}
+		var up *upstream
+		if up, err = p.dial(ctx, s); err == nil {
+			s.succeeded()
+			return up, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		s.failed(p.maxFailures(), p.retryAfter())
+	}
+	return nil, err
+}
+
+// put releases the connection up of a client to the idle connections,
+// closing it when PoolSize are already idle. bound is true when the client
+// bound on it, its session being made anonymous again first.
+func (p *Proxy) put(up *upstream, bound bool) {
+	pool := p.init()
+	if bound {
+		ctx, cancel := context.WithTimeout(context.Background(), p.dialTimeout())
+		defer cancel()
+		op, err := ldap.NewSimpleBindRequest("", "")
+		if err == nil {
+			var code int
+			code, err = up.roundTrip(ctx, op)
+			if err == nil && code != ldap.LDAPResultSuccess {
+				err = errors.New("anonymous bind failed")
+			}
+		}
+		if err != nil {
+			up.Close()
+			return
+		}
+	}
+	pool.mu.Lock()
+	select {
+	case <-pool.stop:
+	default:
+		if !up.failed() && len(pool.idle) < p.PoolSize {
+			pool.idle = append(pool.idle, up)
+			up = nil
+		}
+	}
+	pool.mu.Unlock()
+	if up != nil {
+		up.Close()
+	}
+}
+
+// Close stops the health checks and closes the idle upstream connections.
+// The connections of the clients are closed with them.
+func (p *Proxy) Close() error {
+	pool := p.init()
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	select {
+	case <-pool.stop:
+	default:
+		close(pool.stop)
+	}
+	for _, up := range pool.idle {
+		up.Close()
+	}
+	pool.idle = nil
+	return nil
+}
+
+// checkHealth probes the idle connections and the servers whose circuit
+// is open every HealthCheckInterval, and dials the idle connections
+// missing from PoolSize, until the proxy is closed.
+func (p *Proxy) checkHealth() {
+	interval := p.HealthCheckInterval
+	if interval == 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.pool.stop:
+			return
+		case <-ticker.C:
+		}
+		p.probeIdle()
+		p.probeServers()
+		p.fill()
+	}
+}
+
+// probe pings the upstream server of up with a search of the root DSE.
+func (p *Proxy) probe(up *upstream) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.dialTimeout())
+	defer cancel()
+	r, err := ldap.NewSearchRequest("", ldap.SearchRequestScopeBaseObject, "(objectClass=*)", "1.1")
+	if err != nil {
+		return err
+	}
+	code, err := up.roundTrip(ctx, r)
+	if err == nil && code != ldap.LDAPResultSuccess {
+		err = ldap.NewError(code, "root DSE search failed")
+	}
+	return err
+}
+
+// probeIdle closes the idle connections failing their probe.
+func (p *Proxy) probeIdle() {
+	pool := p.pool
+	pool.mu.Lock()
+	idle := append([]*upstream(nil), pool.idle...)
+	pool.mu.Unlock()
+	for _, up := range idle {
+		if err := p.probe(up); err != nil {
+			up.Close()
+			up.server.failed(p.maxFailures(), p.retryAfter())
+		}
+	}
+	pool.mu.Lock()
+	alive := pool.idle[:0]
+	for _, up := range pool.idle {
+		if !up.failed() {
+			alive = append(alive, up)
+		}
+	}
+	pool.idle = alive
+	pool.mu.Unlock()
+}
+
+// probeServers dials and probes the servers whose circuit is open, closing
+// it when they answer. The idle connections to the servers after a server
+// back up are closed, so that the new clients fail back to it.
+func (p *Proxy) probeServers() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.dialTimeout())
+	defer cancel()
+	for i, s := range p.pool.servers {
+		s.mu.Lock()
+		open := !s.openUntil.IsZero()
+		s.mu.Unlock()
+		if !open {
+			continue
+		}
+		up, err := p.dial(ctx, s)
+		if err == nil {
+			err = p.probe(up)
+		}
+		if err != nil {
+			s.failed(p.maxFailures(), p.retryAfter())
+			continue
+		}
+		s.succeeded()
+		p.dropIdle(p.pool.servers[i+1:])
+		p.put(up, false)
+	}
+}
+
+// dropIdle closes the idle connections to the servers.
+func (p *Proxy) dropIdle(servers []*server) {
+	pool := p.pool
+	pool.mu.Lock()
+	kept := pool.idle[:0]
+	var dropped []*upstream
+	for _, up := range pool.idle {
+		if slices.Contains(servers, up.server) {
+			dropped = append(dropped, up)
+		} else {
+			kept = append(kept, up)
+		}
+	}
+	pool.idle = kept
+	pool.mu.Unlock()
+	for _, up := range dropped {
+		up.Close()
+	}
+}
+
+// fill dials the idle connections missing from PoolSize.
+func (p *Proxy) fill() {
+	pool := p.pool
+	pool.mu.Lock()
+	missing := p.PoolSize - len(pool.idle)
+	pool.mu.Unlock()
+	for i := 0; i < missing; i++ {
+		up, err := p.dialServer(context.Background())
+		if err != nil {
+			return
+		}
+		p.put(up, false)
+	}
+}
+
+func (p *Proxy) dialTimeout() time.Duration {
+	if p.DialTimeout == 0 {
+		return DefaultDialTimeout
+	}
+	return p.DialTimeout
+}
+
+func (p *Proxy) maxFailures() int {
+	if p.MaxFailures == 0 {
+		return DefaultMaxFailures
+	}
+	return p.MaxFailures
+}
+
+func (p *Proxy) retryAfter() time.Duration {
+	if p.RetryAfter == 0 {
+		return DefaultRetryAfter
+	}
+	return p.RetryAfter
+}
@@ -0,0 +1,424 @@
+// Package proxy forwards the requests of the clients to an upstream LDAP
+// server, so that the server acts as a protocol-level gateway in front of
+// Active Directory or OpenLDAP, e.g. adding its own listeners, limits or
+// logging:
+//
+//	p := &proxy.Proxy{
+//		URL:          "ldaps://dc1.example.com",
+//		FailoverURLs: []string{"ldaps://dc2.example.com"},
+//		PoolSize:     8,
+//	}
+//	defer p.Close()
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return p }
+//
+// Each client connection has its own upstream connection, dialed on its
+// first request, on which it binds as the client binds. The requests are
+// sent with their controls, their message IDs being translated to those of
+// the upstream connection, and the responses are written back as they
+// arrive, search entries and references included. A request abandoned by
+// the client is abandoned upstream, and the upstream connection is closed
+// with the client connection.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// DefaultDialTimeout bounds the connection to the upstream server, TLS
+// handshake included, when Proxy.DialTimeout is zero.
+const DefaultDialTimeout = 10 * time.Second
+
+// Proxy is a Handler forwarding the requests to the upstream server at
+// URL, or to the first of FailoverURLs available when it is down. Only
+// simple binds are forwarded; SASL binds are answered with
+// authMethodNotSupported, the security layers they may negotiate being
+// those of the client connection. StartTLS requests are not forwarded
+// either, see Server.TLSConfig.
+//
+// The upstream connections are released to a pool of PoolSize idle
+// connections when the clients unbind or disconnect, their sessions made
+// anonymous again, and the pool is filled up and probed with root DSE
+// searches every HealthCheckInterval. An upstream server whose dials or
+// probes fail MaxFailures times in a row is skipped for RetryAfter, its
+// circuit being open, so that failing over does not wait for it.
+//
+// With ChaseReferrals, the referrals of the upstream server are followed
+// on a new connection to the server of each URL: the entries of the search
+// result references are returned in place of the references, before the
+// final response of the search, and the responses to a request referred
+// elsewhere in place of its referral. The referrals and references which
+// can not be followed are returned as is.
+//
+// When the upstream connection of a client fails, e.g. on a restart of
+// the server, the client connection is kept and its next requests are
+// sent on a new upstream connection. The requests of a client which bound
+// are answered with unavailable until it binds again, so that they are
+// never served with another identity, unless RebindAsUser is set.
+type Proxy struct {
+	// URL is the upstream server, ldap://host[:port] or
+	// ldaps://host[:port].
+	URL string
+
+	// FailoverURLs are the upstream servers tried in order when the
+	// servers before them are down.
+	FailoverURLs []string
+
+	// TLSConfig is the TLS configuration of the ldaps URLs and of
+	// StartTLS, its ServerName being the host of the URL if empty.
+	TLSConfig *tls.Config
+
+	// StartTLS upgrades the connections to an ldap URL with StartTLS.
+	StartTLS bool
+
+	// DialTimeout bounds the connection to the upstream server, and the
+	// probes, DefaultDialTimeout if zero.
+	DialTimeout time.Duration
+
+	// PoolSize is the number of idle upstream connections kept ready for
+	// new clients, none if zero.
+	PoolSize int
+
+	// HealthCheckInterval is the interval of the health checks,
+	// DefaultHealthCheckInterval if zero; they are disabled if negative.
+	HealthCheckInterval time.Duration
+
+	// MaxFailures is the number of consecutive failures opening the
+	// circuit of an upstream server, DefaultMaxFailures if zero.
+	MaxFailures int
+
+	// RetryAfter is the time an upstream server whose circuit is open is
+	// skipped, DefaultRetryAfter if zero.
+	RetryAfter time.Duration
+
+	// RebindAsUser keeps the credentials of the last successful bind of
+	// each client, to bind again as the client on a new upstream
+	// connection when its connection fails.
+	RebindAsUser bool
+
+	// Cache, if set, caches the results of the searches and binds.
+	Cache *Cache
+
+	// ChaseReferrals follows the referrals and search result references
+	// of the upstream servers, for the clients which can not, unless the
+	// request has the ManageDsaIT control.
+	ChaseReferrals bool
+
+	// MaxReferralHops is the number of referrals followed in a row for a
+	// request, DefaultMaxReferralHops if zero; the referrals beyond it
+	// are returned to the client.
+	MaxReferralHops int
+
+	// ReferralCredentials, if set, returns the DN and password binding to
+	// the server of the referral url for the client bound as dn, "" if
+	// anonymous; an empty DN binds anonymously and an error returns the
+	// referral to the client. The referrals are followed anonymously when
+	// it is nil, the passwords of the clients not being kept.
+	ReferralCredentials func(ctx context.Context, url, dn string) (bindDN, password string, err error)
+
+	mu   sync.Mutex // serializes the creation of the sessions
+	once sync.Once
+	pool *pool
+}
+
+// sessionKey is the key of the session of a client, see Client.Store.
+type sessionKey struct{}
+
+// session holds the upstream connection of a client, released with the
+// client connection.
+type session struct {
+	p  *Proxy
+	mu sync.Mutex
+	up *upstream // nil until the first request
+
+	upDN     string // DN bound on up, "" while anonymous
+	dn       string // DN of the last successful bind of the client
+	password string // of dn, while up is to be bound or with RebindAsUser
+}
+
+func (s *session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.up != nil {
+		s.p.put(s.up, s.upDN != "")
+		s.up = nil
+	}
+	s.upDN, s.dn, s.password = "", "", ""
+	return nil
+}
+
+// ServeLDAP forwards the request m to the upstream server, or answers it
+// from the Cache.
+func (p *Proxy) ServeLDAP(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	switch r := m.ProtocolOp().(type) {
+	case goldap.AbandonRequest:
+		return // the abandoned request is cancelled, see forward
+	case goldap.UnbindRequest:
+		if s, ok := m.Client.Load(sessionKey{}); ok {
+			s.(*session).Close()
+		}
+		return
+	case goldap.BindRequest:
+		if r.AuthenticationChoice() != "simple" {
+			w.Write(ldap.NewBindResponse(ldap.LDAPResultAuthMethodNotSupported, ldap.DiagnosticMessage("only simple binds are supported")))
+			return
+		}
+	case goldap.ExtendedRequest:
+		if r.RequestName() == ldap.NoticeOfStartTLS {
+			w.Write(ldap.NewExtendedResponse(ldap.LDAPResultUnwillingToPerform, ldap.DiagnosticMessage("StartTLS is not supported")))
+			return
+		}
+	}
+	w = p.referralWriter(ctx, w, m)
+	if p.serveCached(ctx, w, m) {
+		return
+	}
+
+	var responses []*goldap.LDAPMessage
+	observe := func(res *goldap.LDAPMessage) {
+		if len(responses) <= maxCachedEntries {
+			responses = append(responses, res)
+		}
+	}
+	if _, ok := m.ProtocolOp().(goldap.SearchRequest); !ok || p.Cache == nil {
+		observe = nil
+	}
+
+	var res *goldap.LDAPMessage
+	if info, _ := ldap.RequestInfoFromContext(ctx); info.Numero == 0 {
+		// CLDAP requests have no connection to keep a session with
+		up, err := p.get(ctx)
+		if err != nil {
+			w.Write(ldap.ErrorResponse(m.ProtocolOp(), ldap.NewError(ldap.LDAPResultUnavailable, err.Error())))
+			return
+		}
+		res = forward(ctx, w, m, up, observe)
+		_, bind := m.ProtocolOp().(goldap.BindRequest)
+		p.put(up, bind)
+	} else {
+		s := p.session(m)
+		up, err := s.upstream(ctx, m)
+		if err != nil {
+			w.Write(ldap.ErrorResponse(m.ProtocolOp(), ldap.NewError(ldap.LDAPResultUnavailable, err.Error())))
+			return
+		}
+		res = forward(ctx, w, m, up, observe)
+		if r, ok := m.ProtocolOp().(goldap.BindRequest); ok {
+			if res == nil {
+				// the identity of the upstream session is unknown
+				up.Close()
+			}
+			s.observeBind(r, res, false)
+		}
+	}
+	if p.Cache != nil && res != nil {
+		p.record(ctx, m, res, responses)
+	}
+}
+
+// serveCached answers the request m from the Cache. It returns true when
+// the request was answered.
+func (p *Proxy) serveCached(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) bool {
+	if p.Cache == nil {
+		return false
+	}
+	var responses []*goldap.LDAPMessage
+	switch r := m.ProtocolOp().(type) {
+	case goldap.SearchRequest:
+		state, _ := ldap.AuthStateFromContext(ctx)
+		cached, ok := p.Cache.search(m, state.BoundDN)
+		if !ok {
+			return false
+		}
+		responses = cached
+	case goldap.BindRequest:
+		res, ok := p.Cache.bind(m)
+		if !ok {
+			return false
+		}
+		if info, _ := ldap.RequestInfoFromContext(ctx); info.Numero != 0 {
+			p.session(m).observeBind(r, res, true)
+		}
+		responses = []*goldap.LDAPMessage{res}
+	default:
+		return false
+	}
+	for _, res := range responses {
+		if w.WriteWithControls(res.ProtocolOp(), responseControls(res)...) != nil {
+			break
+		}
+	}
+	return true
+}
+
+// record caches the responses to the request m, res being the final one,
+// and invalidates the results its writes change.
+func (p *Proxy) record(ctx context.Context, m *ldap.Message, res *goldap.LDAPMessage, responses []*goldap.LDAPMessage) {
+	switch r := m.ProtocolOp().(type) {
+	case goldap.SearchRequest:
+		state, _ := ldap.AuthStateFromContext(ctx)
+		p.Cache.storeSearch(m, state.BoundDN, responses)
+	case goldap.BindRequest:
+		p.Cache.storeBind(m, res)
+	case goldap.AddRequest:
+		p.Cache.Invalidate(string(r.Entry()))
+	case goldap.DelRequest:
+		p.Cache.Invalidate(string(r))
+	case goldap.ModifyRequest:
+		p.Cache.Invalidate(string(r.Object()))
+	case goldap.ModifyDNRequest:
+		if r, err := m.ModifyDN(); err == nil {
+			p.Cache.Invalidate(r.Entry)
+			p.Cache.Invalidate(r.NewDN())
+		}
+	case goldap.ExtendedRequest:
+		if r.RequestName() != ldap.NoticeOfWhoAmI {
+			p.Cache.InvalidateBinds()
+		}
+	}
+}
+
+// session returns the session of the client of m.
+func (p *Proxy) session(m *ldap.Message) *session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := m.Client.Load(sessionKey{})
+	if !ok {
+		v = &session{p: p}
+		m.Client.Store(sessionKey{}, v)
+	}
+	return v.(*session)
+}
+
+// upstream returns the upstream connection of the session for the request
+// m, taking one on its first request or once it failed, and binding it as
+// the client when its bind was served from the cache or, with
+// RebindAsUser, on a new connection.
+func (s *session) upstream(ctx context.Context, m *ldap.Message) (*upstream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.up != nil && s.up.failed() {
+		s.up, s.upDN = nil, ""
+	}
+	_, bind := m.ProtocolOp().(goldap.BindRequest)
+	if !bind && s.dn != s.upDN && s.dn != "" && s.password == "" {
+		return nil, errors.New("upstream connection lost, bind again")
+	}
+	if s.up == nil {
+		up, err := s.p.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.up = up
+	}
+	if bind || s.dn == s.upDN {
+		return s.up, nil
+	}
+
+	op, err := ldap.NewSimpleBindRequest(s.dn, s.password)
+	if err != nil {
+		return nil, err
+	}
+	code, err := s.up.roundTrip(ctx, op)
+	if err != nil {
+		s.up.Close()
+		s.up, s.upDN = nil, ""
+		return nil, err
+	}
+	if code != ldap.LDAPResultSuccess {
+		// e.g. the password changed since it was cached
+		s.upDN, s.password = "", ""
+		return nil, errors.New("upstream bind failed, bind again")
+	}
+	s.upDN = s.dn
+	if !s.p.RebindAsUser {
+		s.password = ""
+	}
+	return s.up, nil
+}
+
+// observeBind records the response res to the bind request r, nil if it
+// failed, cached when it was served from the Cache rather than forwarded
+// on the upstream connection of the session.
+func (s *session) observeBind(r goldap.BindRequest, res *goldap.LDAPMessage, cached bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := false
+	if res != nil {
+		code, known := ldap.ResultCode(res.ProtocolOp())
+		ok = known && code == ldap.LDAPResultSuccess
+	}
+	s.dn, s.password = "", ""
+	if name := string(r.Name()); ok && name != "" {
+		s.dn = name
+		if cached || s.p.RebindAsUser {
+			s.password = string(r.AuthenticationSimple())
+		}
+	}
+	if !cached {
+		s.upDN = s.dn
+	}
+}
+
+// forward sends the request m on the upstream connection, and writes its
+// responses until the final one, which it returns; nil when the request
+// failed or was abandoned. observe, if not nil, is called with each
+// response written.
+func forward(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message, up *upstream, observe func(*goldap.LDAPMessage)) *goldap.LDAPMessage {
+	r, err := up.send(*m.LDAPMessage)
+	if err != nil {
+		w.Write(ldap.ErrorResponse(m.ProtocolOp(), ldap.NewError(ldap.LDAPResultUnavailable, err.Error())))
+		return nil
+	}
+	defer up.finish(r)
+	for {
+		select {
+		case <-ctx.Done():
+			if _, bind := m.ProtocolOp().(goldap.BindRequest); !bind {
+				up.abandon(r)
+			}
+			if res := ldap.ErrorResponse(m.ProtocolOp(), ctx.Err()); res != nil {
+				w.Write(res)
+			}
+			return nil
+		case <-up.done:
+			w.Write(ldap.ErrorResponse(m.ProtocolOp(), ldap.NewError(ldap.LDAPResultUnavailable, up.err.Error())))
+			return nil
+		case res := <-r.responses:
+			po := res.ProtocolOp()
+			if err := w.WriteWithControls(po, responseControls(res)...); err != nil {
+				up.abandon(r)
+				return nil
+			}
+			if observe != nil {
+				observe(res)
+			}
+			if final(po) {
+				return res
+			}
+		}
+	}
+}
+
+// responseControls returns the controls of the response m.
+func responseControls(m *goldap.LDAPMessage) []ldap.Control {
+	if m.Controls() == nil {
+		return nil
+	}
+	var controls []ldap.Control
+	for _, c := range *m.Controls() {
+		control := ldap.Control{OID: c.ControlType(), Critical: bool(c.Criticality())}
+		if v := c.ControlValue(); v != nil {
+			control.Value = []byte(*v)
+		}
+		controls = append(controls, control)
+	}
+	return controls
+}
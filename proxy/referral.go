@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// DefaultMaxReferralHops is the number of referrals followed in a row for
+// a request when Proxy.MaxReferralHops is zero.
+const DefaultMaxReferralHops = 5
+
+// referralWriter follows the referrals and the search result references
+// of the responses to a request, writing the responses of their servers
+// instead. The references are followed once the search is done, before
+// its final response; those which can not be followed are written as is.
+type referralWriter struct {
+	ldap.ResponseWriter
+	p       *Proxy
+	ctx     context.Context
+	request goldap.ProtocolOp
+	hops    int // referrals followed to get the responses
+
+	references []goldap.SearchResultReference
+}
+
+// referralWriter returns the writer of the responses to the request m,
+// following their referrals when ChaseReferrals is set.
+func (p *Proxy) referralWriter(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) ldap.ResponseWriter {
+	switch m.ProtocolOp().(type) {
+	case goldap.SearchRequest, goldap.CompareRequest, goldap.AddRequest, goldap.DelRequest,
+		goldap.ModifyRequest, goldap.ModifyDNRequest:
+		if p.ChaseReferrals && !m.ManageDsaIT() {
+			return &referralWriter{ResponseWriter: w, p: p, ctx: ctx, request: m.ProtocolOp()}
+		}
+	}
+	return w
+}
+
+func (w *referralWriter) Write(po goldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *referralWriter) WriteWithControls(po goldap.ProtocolOp, controls ...ldap.Control) error {
+	if w.hops >= w.p.maxReferralHops() {
+		return w.ResponseWriter.WriteWithControls(po, controls...)
+	}
+	if r, ok := po.(goldap.SearchResultReference); ok {
+		w.references = append(w.references, r)
+		return nil
+	}
+	if !final(po) {
+		return w.ResponseWriter.WriteWithControls(po, controls...)
+	}
+	w.followReferences()
+	if urls := ldap.ResultReferral(po); len(urls) > 0 {
+		for _, ref := range urls {
+			if err := w.follow(ref, false); err == nil {
+				return nil
+			}
+		}
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// followReferences follows the search result references, writing those
+// which can not be followed.
+func (w *referralWriter) followReferences() {
+	references := w.references
+	w.references = nil
+	for _, r := range references {
+		followed := false
+		for _, ref := range r {
+			if w.follow(string(ref), true) == nil {
+				followed = true
+				break
+			}
+		}
+		if !followed {
+			w.ResponseWriter.Write(r)
+		}
+	}
+}
+
+// follow sends the request to the server of the referral ref, and writes
+// its responses; its final response too, unless continuation is set. It
+// fails when the request could not be sent, nothing being written.
+func (w *referralWriter) follow(ref string, continuation bool) error {
+	po, err := ldap.ReferralRequest(w.request, ref, continuation)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return err
+	}
+	up, err := w.p.dial(w.ctx, &server{url: u.Scheme + "://" + u.Host})
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+	if err := w.bind(up, ref); err != nil {
+		return err
+	}
+	r, err := up.send(*goldap.NewLDAPMessageWithProtocolOp(po))
+	if err != nil {
+		return err
+	}
+	defer up.finish(r)
+
+	next := &referralWriter{ResponseWriter: w.ResponseWriter, p: w.p, ctx: w.ctx, request: po, hops: w.hops + 1}
+	for {
+		select {
+		case <-w.ctx.Done():
+			up.abandon(r)
+			return nil
+		case <-up.done:
+			if !continuation {
+				w.ResponseWriter.Write(ldap.ErrorResponse(po, ldap.NewError(ldap.LDAPResultUnavailable, up.err.Error())))
+			}
+			return nil
+		case res := <-r.responses:
+			switch {
+			case !final(res.ProtocolOp()):
+				if err := next.WriteWithControls(res.ProtocolOp(), responseControls(res)...); err != nil {
+					up.abandon(r)
+					return nil
+				}
+			case continuation:
+				next.followReferences()
+				return nil
+			default:
+				next.WriteWithControls(res.ProtocolOp(), responseControls(res)...)
+				return nil
+			}
+		}
+	}
+}
+
+// bind binds the connection to the server of the referral ref with the
+// ReferralCredentials of the client.
+func (w *referralWriter) bind(up *upstream, ref string) error {
+	if w.p.ReferralCredentials == nil {
+		return nil
+	}
+	state, _ := ldap.AuthStateFromContext(w.ctx)
+	dn, password, err := w.p.ReferralCredentials(w.ctx, ref, state.BoundDN)
+	if err != nil || dn == "" {
+		return err
+	}
+	op, err := ldap.NewSimpleBindRequest(dn, password)
+	if err != nil {
+		return err
+	}
+	code, err := up.roundTrip(w.ctx, op)
+	if err == nil && code != ldap.LDAPResultSuccess {
+		err = fmt.Errorf("bind to the referral server failed with result code %d", code)
+	}
+	return err
+}
+
+func (p *Proxy) maxReferralHops() int {
+	if p.MaxReferralHops == 0 {
+		return DefaultMaxReferralHops
+	}
+	return p.MaxReferralHops
+}
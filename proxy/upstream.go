@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// errClosed is the error of the requests sent on a closed upstream
+// connection.
+var errClosed = errors.New("upstream connection closed")
+
+// upstream is a connection to the upstream server, multiplexing the
+// requests of a client by message ID.
+type upstream struct {
+	conn   net.Conn
+	server *server
+
+	writeMu sync.Mutex // serializes the writes of messages
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]*request
+	err     error         // set once the connection failed or was closed
+	done    chan struct{} // closed with err
+}
+
+// request is a request in progress on the upstream connection.
+type request struct {
+	id        int
+	responses chan *goldap.LDAPMessage
+	done      chan struct{} // closed when the request is finished
+}
+
+// dial connects to the upstream server s.
+func (p *Proxy) dial(ctx context.Context, s *server) (*upstream, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	timeout := p.dialTimeout()
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{}
+	if p.TLSConfig != nil {
+		config = p.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	br := bufio.NewReader(conn)
+	nextID := 1
+	switch {
+	case u.Scheme == "ldaps":
+		conn, err = handshake(conn, config)
+		br = bufio.NewReader(conn)
+	case p.StartTLS:
+		err = startTLS(conn, br)
+		if err == nil {
+			conn, err = handshake(conn, config)
+			br = bufio.NewReader(conn)
+		}
+		nextID++
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	up := &upstream{conn: conn, server: s, nextID: nextID, pending: make(map[int]*request), done: make(chan struct{})}
+	go up.readLoop(br)
+	return up, nil
+}
+
+func handshake(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	return tlsConn, tlsConn.Handshake()
+}
+
+// startTLS sends a StartTLS request (RFC 4511 section 4.14) with message
+// ID 1, and waits for its response.
+func startTLS(conn net.Conn, br *bufio.Reader) error {
+	op, err := ldap.NewExtendedRequest(ldap.NoticeOfStartTLS, nil)
+	if err != nil {
+		return err
+	}
+	m := goldap.NewLDAPMessageWithProtocolOp(op)
+	m.SetMessageID(1)
+	data, err := m.Write()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(data.Bytes()); err != nil {
+		return err
+	}
+	res, err := ldap.ReadMessage(br)
+	if err != nil {
+		return err
+	}
+	if code, _ := ldap.ResultCode(res.ProtocolOp()); code != ldap.LDAPResultSuccess {
+		return fmt.Errorf("StartTLS failed with result code %d", code)
+	}
+	return nil
+}
+
+// send sends the request m, its message ID being replaced by the next one
+// of the connection not in use. The request must be finished once its
+// final response is received.
+func (u *upstream) send(m goldap.LDAPMessage) (*request, error) {
+	u.mu.Lock()
+	if u.err != nil {
+		u.mu.Unlock()
+		return nil, u.err
+	}
+	id := u.nextID
+	for u.pending[id] != nil {
+		// wrapped around to a request still in progress
+		id = nextMessageID(id)
+	}
+	r := &request{id: id, responses: make(chan *goldap.LDAPMessage), done: make(chan struct{})}
+	u.pending[id] = r
+	u.nextID = nextMessageID(id)
+	u.mu.Unlock()
+
+	m.SetMessageID(r.id)
+	if err := u.write(&m); err != nil {
+		u.finish(r)
+		return nil, err
+	}
+	return r, nil
+}
+
+// nextMessageID returns the message ID following id, wrapping around to 1
+// after math.MaxInt32 - 1.
+func nextMessageID(id int) int {
+	if id >= math.MaxInt32-1 {
+		return 1
+	}
+	return id + 1
+}
+
+// roundTrip sends the request op and returns the result code of its
+// final response, dropping the others.
+func (u *upstream) roundTrip(ctx context.Context, op goldap.ProtocolOp) (int, error) {
+	r, err := u.send(*goldap.NewLDAPMessageWithProtocolOp(op))
+	if err != nil {
+		return 0, err
+	}
+	defer u.finish(r)
+	for {
+		select {
+		case <-ctx.Done():
+			u.abandon(r)
+			return 0, ctx.Err()
+		case <-u.done:
+			return 0, u.err
+		case res := <-r.responses:
+			if final(res.ProtocolOp()) {
+				if code, ok := ldap.ResultCode(res.ProtocolOp()); ok {
+					return code, nil
+				}
+				return 0, errors.New("response without result code")
+			}
+		}
+	}
+}
+
+// finish forgets the request r, dropping its remaining responses.
+func (u *upstream) finish(r *request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending[r.id] == r {
+		delete(u.pending, r.id)
+		close(r.done)
+	}
+}
+
+// abandon abandons the request r upstream (RFC 4511 section 4.11).
+func (u *upstream) abandon(r *request) {
+	u.finish(r)
+	u.write(goldap.NewLDAPMessageWithProtocolOp(goldap.AbandonRequest(r.id)))
+}
+
+func (u *upstream) write(m *goldap.LDAPMessage) error {
+	data, err := m.Write()
+	if err != nil {
+		return err
+	}
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
+	if _, err := u.conn.Write(data.Bytes()); err != nil {
+		u.fail(err)
+		return err
+	}
+	return nil
+}
+
+// readLoop dispatches the responses of the upstream server to the
+// requests, until the connection fails.
+func (u *upstream) readLoop(br *bufio.Reader) {
+	for {
+		m, err := ldap.ReadMessage(br)
+		if err != nil {
+			u.fail(err)
+			return
+		}
+		id := m.MessageID().Int()
+		if id == 0 {
+			// RFC 4511 section 4.4.1: the server is closing the connection
+			u.fail(errors.New("notice of disconnection from the upstream server"))
+			return
+		}
+		u.mu.Lock()
+		r := u.pending[id]
+		u.mu.Unlock()
+		if r == nil {
+			continue // abandoned
+		}
+		select {
+		case r.responses <- m:
+		case <-r.done:
+		}
+	}
+}
+
+// fail closes the connection, failing the requests in progress with err.
+func (u *upstream) fail(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.err != nil {
+		return
+	}
+	u.err = err
+	close(u.done)
+	u.conn.Close()
+}
+
+// Close sends an unbind request and closes the connection.
+func (u *upstream) Close() error {
+	u.write(goldap.NewLDAPMessageWithProtocolOp(goldap.UnbindRequest{}))
+	u.fail(errClosed)
+	return nil
+}
+
+// failed reports whether the connection failed or was closed.
+func (u *upstream) failed() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err != nil
+}
+
+// final reports whether the response po is the last one of its request.
+func final(po goldap.ProtocolOp) bool {
+	switch po.(type) {
+	case goldap.SearchResultEntry, goldap.SearchResultReference, goldap.IntermediateResponse:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"io"
+	"math"
+	"net"
+	"testing"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// TestSendSkipsPendingIDs checks that the message IDs wrapping around skip
+// those of the requests still in progress.
+func TestSendSkipsPendingIDs(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	go io.Copy(io.Discard, peer)
+
+	u := &upstream{conn: conn, nextID: math.MaxInt32 - 1, pending: make(map[int]*request), done: make(chan struct{})}
+	u.pending[1] = &request{id: 1, done: make(chan struct{})}
+	op, err := ldap.NewSimpleBindRequest("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{math.MaxInt32 - 1, 2} {
+		r, err := u.send(*goldap.NewLDAPMessageWithProtocolOp(op))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.id != want {
+			t.Errorf("request sent with ID %d, want %d", r.id, want)
+		}
+	}
+}
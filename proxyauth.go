@@ -0,0 +1,93 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ControlProxiedAuthorization is the OID of the Proxied Authorization
+// control (RFC 4370).
+const ControlProxiedAuthorization ldap.LDAPOID = "2.16.840.1.113730.3.4.18"
+
+// ErrProxyAuthorizationDenied may be returned by a ProxyAuthorizer to deny
+// the impersonation.
+var ErrProxyAuthorizationDenied = errors.New("proxied authorization denied")
+
+// ProxyAuthorizer decides whether the session of m may act as authzID,
+// "dn:<dn>", "u:<user>" or "" for the anonymous identity. It returns the
+// identity the operation is performed as; returning an error answers the
+// request with authorizationDenied.
+type ProxyAuthorizer func(ctx context.Context, m *Message, authzID string) (identity string, err error)
+
+// ProxiedAuthorization is the outcome of the Proxied Authorization
+// control of a request, see ProxiedAuthorizationFromContext.
+type ProxiedAuthorization struct {
+	AuthzID  string // as requested by the client
+	Identity string // as returned by the ProxyAuthorizer
+}
+
+type proxiedAuthorizationKey struct{}
+
+// ProxiedAuthorizationFromContext returns the identity a request is
+// performed as when it carried a Proxied Authorization control accepted
+// by Server.ProxyAuthorizer.
+func ProxiedAuthorizationFromContext(ctx context.Context) (ProxiedAuthorization, bool) {
+	p, ok := ctx.Value(proxiedAuthorizationKey{}).(ProxiedAuthorization)
+	return p, ok
+}
+
+// findControl returns the control of the message with the given type.
+func findControl(message *ldap.LDAPMessage, oid ldap.LDAPOID) (ldap.Control, bool) {
+	if message.Controls() == nil {
+		return ldap.Control{}, false
+	}
+	for _, c := range *message.Controls() {
+		if c.ControlType() == oid {
+			return c, true
+		}
+	}
+	return ldap.Control{}, false
+}
+
+// proxyAuthorization applies Server.ProxyAuthorizer to the Proxied
+// Authorization control of a request. It returns the context of the
+// request, and false when the request was answered.
+func (c *client) proxyAuthorization(ctx context.Context, w ResponseWriter, m *Message) (context.Context, bool) {
+	if c.srv.ProxyAuthorizer == nil {
+		return ctx, true
+	}
+	control, ok := findControl(m.LDAPMessage, ControlProxiedAuthorization)
+	if !ok {
+		return ctx, true
+	}
+
+	deny := func(code int, diagnostic string) (context.Context, bool) {
+		if res := responseFor(m.ProtocolOp(), code, diagnostic); res != nil {
+			w.Write(res)
+		}
+		return ctx, false
+	}
+	switch r := m.ProtocolOp().(type) {
+	case ldap.BindRequest:
+		return deny(LDAPResultProtocolError, "proxied authorization is not allowed with bind")
+	case ldap.ExtendedRequest:
+		if r.RequestName() == NoticeOfStartTLS {
+			return deny(LDAPResultProtocolError, "proxied authorization is not allowed with StartTLS")
+		}
+	}
+	// RFC 4370 section 3: the control is critical and has a value
+	if !control.Criticality() || control.ControlValue() == nil {
+		return deny(LDAPResultProtocolError, "malformed proxied authorization control")
+	}
+
+	authzID := string(*control.ControlValue())
+	identity, err := c.srv.ProxyAuthorizer(ctx, m, authzID)
+	if err != nil {
+		c.srv.logf("client %d proxied authorization as %q denied: %s", c.Numero, authzID, err)
+		return deny(LDAPResultAuthorizationDenied, err.Error())
+	}
+	p := ProxiedAuthorization{AuthzID: authzID, Identity: identity}
+	return context.WithValue(ctx, proxiedAuthorizationKey{}, p), true
+}
@@ -0,0 +1,225 @@
+package ldapserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout bounds the time allowed to receive the PROXY
+// protocol header when Server.ProxyHeaderTimeout is zero.
+const DefaultProxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("ldapserver: invalid PROXY protocol header")
+
+// ProxyProtocolListener wraps a listener whose connections start with a
+// HAProxy PROXY protocol header (version 1 or 2). The header is consumed
+// before the first LDAP message and RemoteAddr reports the real client.
+//
+// Server.AcceptProxyProtocol wraps the listeners of ListenAndServe and
+// ListenAndServeTLS. When passing your own listener to Serve, wrap it
+// yourself; for TLS, wrap the TCP listener before tls.NewListener.
+func ProxyProtocolListener(l net.Listener, headerTimeout time.Duration) net.Listener {
+	if headerTimeout <= 0 {
+		headerTimeout = DefaultProxyHeaderTimeout
+	}
+	return &proxyListener{Listener: l, timeout: headerTimeout}
+}
+
+type proxyListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, br: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyConn reads the PROXY header lazily, on the first Read or address
+// lookup, so a slow client does not block the accept loop: the server
+// reads it in the goroutine of the connection, see proxyHeader.
+type proxyConn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.local, c.err = readProxyHeader(c.br)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// proxyHeader reads the PROXY header of an accepted connection, looking
+// through TLS, and fails when it is invalid, the connection being closed.
+// Connections without PROXY protocol pass.
+func proxyHeader(conn net.Conn) error {
+	if c, ok := conn.(*tls.Conn); ok {
+		conn = c.NetConn()
+	}
+	if c, ok := conn.(*proxyConn); ok {
+		c.init()
+		return c.err
+	}
+	return nil
+}
+
+// readProxyHeader parses a PROXY protocol header. Nil addresses mean the
+// proxy sent a LOCAL/UNKNOWN header and the connection addresses apply.
+func readProxyHeader(br *bufio.Reader) (remote, local net.Addr, err error) {
+	// a v1 header can be shorter than the v2 signature, so look at the
+	// first byte before peeking further
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch first[0] {
+	case proxyV2Signature[0]:
+		sig, err := br.Peek(len(proxyV2Signature))
+		if err == nil && !bytes.Equal(sig, proxyV2Signature) {
+			err = errProxyHeader
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return readProxyHeaderV2(br)
+	case 'P':
+		prefix, err := br.Peek(6)
+		if err == nil && string(prefix) != "PROXY " {
+			err = errProxyHeader
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return readProxyHeaderV1(br)
+	}
+	return nil, nil, errProxyHeader
+}
+
+// readProxyHeaderV1 parses the text header, e.g.
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 389\r\n".
+func readProxyHeaderV1(br *bufio.Reader) (remote, local net.Addr, err error) {
+	var line []byte
+	for len(line) < 107 { // maximum v1 header length
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, errProxyHeader
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errProxyHeader
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	sport, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dport, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: src, Port: int(sport)}, &net.TCPAddr{IP: dst, Port: int(dport)}, nil
+}
+
+// readProxyHeaderV2 parses the binary header.
+func readProxyHeaderV2(br *bufio.Reader) (remote, local net.Addr, err error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := hdr[12], hdr[13]
+	length := int(binary.BigEndian.Uint16(hdr[14:16]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, nil, err
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("ldapserver: unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	if verCmd&0x0f == 0 { // LOCAL: health check from the proxy itself
+		return nil, nil, nil
+	}
+
+	switch family >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))},
+			&net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))},
+			&net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}, nil
+	case 3: // AF_UNIX
+		if len(payload) < 216 {
+			return nil, nil, errProxyHeader
+		}
+		name := func(b []byte) string { return string(bytes.TrimRight(b, "\x00")) }
+		return &net.UnixAddr{Name: name(payload[0:108]), Net: "unix"},
+			&net.UnixAddr{Name: name(payload[108:216]), Net: "unix"}, nil
+	}
+	// AF_UNSPEC
+	return nil, nil, nil
+}
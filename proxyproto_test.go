@@ -0,0 +1,52 @@
+package ldapserver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestProxyHeaderDoesNotStallAccept checks that a client which never sends
+// its PROXY header does not delay the connections accepted after it.
+func TestProxyHeaderDoesNotStallAccept(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan net.Conn, 2)
+	s := &Server{
+		MaxConnectionsPerIP: 10,
+		HandleConnection:    func(net.Conn) Handler { return NewRouteMux() },
+		ConnState: func(conn net.Conn, state ConnState) {
+			if state == StateNew {
+				states <- conn
+			}
+		},
+	}
+	go s.Serve(ProxyProtocolListener(l, 5*time.Second))
+	defer s.Close()
+
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond) // the silent connection is accepted first
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "PROXY TCP4 192.0.2.1 192.0.2.2 40000 389\r\n")
+
+	select {
+	case c := <-states:
+		if ip := remoteIP(c); ip != "192.0.2.1" {
+			t.Errorf("connection from %s, want 192.0.2.1", ip)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the connection was not served while another waited for its PROXY header")
+	}
+}
@@ -0,0 +1,104 @@
+package ldapserver
+
+import (
+	"errors"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Read Entry control OIDs (RFC 4527), used for requests and responses.
+const (
+	ControlPreRead  ldap.LDAPOID = "1.3.6.1.1.13.1"
+	ControlPostRead ldap.LDAPOID = "1.3.6.1.1.13.2"
+)
+
+func decodeAttributeSelection(value []byte) (any, error) {
+	// AttributeSelection ::= SEQUENCE OF selector LDAPString
+	seq, err := berParseAll(value)
+	if err != nil {
+		return nil, err
+	}
+	list, err := seq.children()
+	if err != nil {
+		return nil, errors.New("malformed attribute selection")
+	}
+	attributes := make([]string, len(list))
+	for i, a := range list {
+		attributes[i] = string(a.value)
+	}
+	return attributes, nil
+}
+
+// PreRead returns the attributes requested by the Pre-Read control of an
+// add, delete, modify or modify DN request.
+func (m *Message) PreRead() ([]string, bool) {
+	return m.readEntryAttributes(ControlPreRead)
+}
+
+// PostRead returns the attributes requested by the Post-Read control of
+// the request.
+func (m *Message) PostRead() ([]string, bool) {
+	return m.readEntryAttributes(ControlPostRead)
+}
+
+func (m *Message) readEntryAttributes(oid ldap.LDAPOID) ([]string, bool) {
+	c, ok := m.Control(oid)
+	if !ok || c.Err != nil {
+		return nil, false
+	}
+	return c.Decoded.([]string), true
+}
+
+// SetPreReadEntry supplies the entry as it was before the update, returned
+// in the Pre-Read response control when the request asks for it and the
+// update succeeds. The attributes are selected by the framework.
+func (m *Message) SetPreReadEntry(e ldap.SearchResultEntry) {
+	m.preRead = &e
+}
+
+// SetPostReadEntry supplies the entry as it is after the update, for the
+// Post-Read control.
+func (m *Message) SetPostReadEntry(e ldap.SearchResultEntry) {
+	m.postRead = &e
+}
+
+// readEntryControls returns the Read Entry response controls of the
+// response po to the request.
+func (m *Message) readEntryControls(po ldap.ProtocolOp) []Control {
+	switch po.(type) {
+	case ldap.AddResponse, ldap.DelResponse, ldap.ModifyResponse, ldap.ModifyDNResponse:
+	default:
+		return nil
+	}
+	if m.preRead == nil && m.postRead == nil {
+		return nil
+	}
+	if code, _ := ResultCode(po); code != LDAPResultSuccess {
+		return nil
+	}
+
+	var controls []Control
+	for _, read := range []struct {
+		oid   ldap.LDAPOID
+		entry *ldap.SearchResultEntry
+	}{{ControlPreRead, m.preRead}, {ControlPostRead, m.postRead}} {
+		attributes, ok := m.readEntryAttributes(read.oid)
+		if !ok || read.entry == nil {
+			continue
+		}
+		e, err := EntryFromSearchResult(*read.entry)
+		if err != nil {
+			continue
+		}
+		selection := make(ldap.AttributeSelection, len(attributes))
+		for i, name := range attributes {
+			selection[i] = ldap.LDAPString(name)
+		}
+		value, err := protocolOpBytes(SelectAttributes(e, selection, false))
+		if err != nil {
+			continue
+		}
+		controls = append(controls, Control{OID: read.oid, Value: value})
+	}
+	return controls
+}
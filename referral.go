@@ -0,0 +1,297 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ReferralURLs returns the URIs of the ref attribute of an entry of the
+// objectClass referral (RFC 3296), ok is false for other entries.
+func ReferralURLs(e ldap.SearchResultEntry) (urls []string, ok bool) {
+	_, attributes, err := decodeSearchResultEntry(e)
+	if err != nil {
+		return nil, false
+	}
+	for _, attr := range attributes {
+		switch strings.ToLower(attr.Name) {
+		case "objectclass":
+			for _, v := range attr.Values {
+				ok = ok || strings.EqualFold(v, "referral")
+			}
+		case "ref":
+			urls = append(urls, attr.Values...)
+		}
+	}
+	if !ok || len(urls) == 0 {
+		return nil, false
+	}
+	return urls, true
+}
+
+// NewReferralResponse returns the response to the request po with the
+// resultCode referral, sent when the target of an operation is a referral
+// object or below one:
+//
+//	if urls, ok := ldap.ReferralURLs(entry); ok && !m.ManageDsaIT() {
+//		w.Write(ldap.NewReferralResponse(m.ProtocolOp(), urls...))
+//		return
+//	}
+func NewReferralResponse(po ldap.ProtocolOp, urls ...string) ldap.ProtocolOp {
+	var res ldap.LDAPResult
+	res.SetResultCode(LDAPResultReferral)
+	res.SetReferral(NewReferral(urls...))
+	return responseWithResult(po, res)
+}
+
+// NewReferral returns the referral field of an LDAP result (RFC 4511
+// section 4.1.10) listing the urls, e.g. ldap://other/dc=example.
+func NewReferral(urls ...string) *ldap.Referral {
+	referral := make(ldap.Referral, len(urls))
+	for i, u := range urls {
+		referral[i] = ldap.URI(u)
+	}
+	return &referral
+}
+
+// WithReferral returns the result po with its referral set to the urls,
+// for the response types goldap gives no SetReferral method. The result
+// code of po should be LDAPResultReferral; po is returned unchanged when
+// it is not a result.
+//
+//	res := ldap.NewModifyResponse(ldap.LDAPResultReferral)
+//	w.Write(ldap.WithReferral(res, "ldap://other/dc=example"))
+func WithReferral(po ldap.ProtocolOp, urls ...string) ldap.ProtocolOp {
+	referral := NewReferral(urls...)
+	switch r := po.(type) {
+	case ldap.LDAPResult:
+		r.SetReferral(referral)
+		return r
+	case ldap.BindResponse:
+		r.SetReferral(referral)
+		return r
+	case ldap.ExtendedResponse:
+		r.SetReferral(referral)
+		return r
+	case ldap.SearchResultDone:
+		res := ldap.LDAPResult(r)
+		res.SetReferral(referral)
+		return ldap.SearchResultDone(res)
+	case ldap.ModifyResponse:
+		res := ldap.LDAPResult(r)
+		res.SetReferral(referral)
+		return ldap.ModifyResponse(res)
+	case ldap.AddResponse:
+		res := ldap.LDAPResult(r)
+		res.SetReferral(referral)
+		return ldap.AddResponse(res)
+	case ldap.DelResponse:
+		res := ldap.LDAPResult(r)
+		res.SetReferral(referral)
+		return ldap.DelResponse(res)
+	case ldap.ModifyDNResponse:
+		res := ldap.LDAPResult(r)
+		res.SetReferral(referral)
+		return ldap.ModifyDNResponse(res)
+	case ldap.CompareResponse:
+		res := ldap.LDAPResult(r)
+		res.SetReferral(referral)
+		return ldap.CompareResponse(res)
+	}
+	return po
+}
+
+// ReferralSearchWriter handles the referral objects in the results of a
+// search (RFC 3296 section 5.3): the base entry of the search being a
+// referral object ends the search with a referral result, and the other
+// referral objects are returned as search result references. With the
+// ManageDsaIT control, they are returned as ordinary entries.
+type ReferralSearchWriter struct {
+	ResponseWriter
+	m        *Message
+	base     string
+	scope    int
+	referral []string // referral of the base entry
+}
+
+// NewReferralSearchWriter returns the writer of the results of the search
+// request m.
+func NewReferralSearchWriter(w ResponseWriter, m *Message) ResponseWriter {
+	r, ok := m.ProtocolOp().(ldap.SearchRequest)
+	if !ok || m.ManageDsaIT() {
+		return w
+	}
+	return &ReferralSearchWriter{ResponseWriter: w, m: m, base: NormalizeDN(string(r.BaseObject())), scope: int(r.Scope())}
+}
+
+func (p *ReferralSearchWriter) Write(po ldap.ProtocolOp) error {
+	return p.WriteWithControls(po)
+}
+
+func (p *ReferralSearchWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	switch r := po.(type) {
+	case ldap.SearchResultEntry:
+		if p.referral != nil {
+			return nil // below the referral of the base
+		}
+		urls, ok := ReferralURLs(r)
+		if !ok {
+			break
+		}
+		dn, _, _ := decodeSearchResultEntry(r)
+		if NormalizeDN(dn) == p.base {
+			p.referral = urls
+			return nil
+		}
+		for i, u := range urls {
+			urls[i] = continuationURL(u, dn, p.scope)
+		}
+		return p.ResponseWriter.WriteWithControls(NewSearchResultReference(urls...), controls...)
+	case ldap.SearchResultReference:
+		if p.referral != nil {
+			return nil
+		}
+	case ldap.SearchResultDone:
+		if p.referral != nil {
+			return p.ResponseWriter.WriteWithControls(NewReferralResponse(p.m.ProtocolOp(), p.referral...), controls...)
+		}
+	}
+	return p.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// continuationURL returns the URI of a search result reference to the
+// referral object dn: LDAP URLs get the DN of the object when they have
+// none, and the base scope when the search is one level.
+func continuationURL(ref, dn string, scope int) string {
+	u, err := url.Parse(ref)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps" && u.Scheme != "ldapi") {
+		return ref
+	}
+	if strings.TrimPrefix(u.Path, "/") == "" {
+		u.Path = "/" + dn
+		u.RawPath = ""
+	}
+	if scope == ldap.SearchRequestSingleLevel && u.RawQuery == "" {
+		u.RawQuery = "?base"
+	}
+	return u.String()
+}
+
+// ResultReferral returns the URIs of the referral of the result po, nil
+// when it has none; goldap has no getter for it.
+func ResultReferral(po ldap.ProtocolOp) []string {
+	data, err := protocolOpBytes(po)
+	if err != nil {
+		return nil
+	}
+	// LDAPResult ::= SEQUENCE { resultCode, matchedDN, diagnosticMessage,
+	//     referral [3] Referral OPTIONAL }
+	op, err := berParseAll(data)
+	if err != nil {
+		return nil
+	}
+	fields, err := op.children()
+	if err != nil {
+		return nil
+	}
+	for _, f := range fields {
+		if !f.is(berClassContext, 3) || !f.constructed {
+			continue
+		}
+		list, err := f.children()
+		if err != nil {
+			return nil
+		}
+		urls := make([]string, len(list))
+		for i, u := range list {
+			urls[i] = string(u.value)
+		}
+		return urls
+	}
+	return nil
+}
+
+// ReferralRequest returns the request po retargeted at the LDAP URL ref of
+// a referral (RFC 4511 section 4.1.10), or of a search result reference
+// when continuation is set (section 4.5.3), for the clients and proxies
+// following them. The DN of the URL, if any, replaces the DN of the
+// request; the scope and filter of the URL, if any, those of a search. A
+// continuation of a one level search without scope is a base search.
+// Binds and extended operations can not be retargeted.
+func ReferralRequest(po ldap.ProtocolOp, ref string, continuation bool) (ldap.ProtocolOp, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("unsupported referral %q", ref)
+	}
+	// ldapurl = scheme "://" [host [":" port]] ["/" dn ["?" [attributes]
+	//     ["?" [scope] ["?" [filter] ["?" extensions]]]]]
+	dn := strings.TrimPrefix(u.Path, "/")
+	var query [4]string
+	for i, part := range strings.SplitN(u.RawQuery, "?", 4) {
+		if query[i], err = url.PathUnescape(part); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := protocolOpBytes(po)
+	if err != nil {
+		return nil, err
+	}
+	op, err := berParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	switch r := po.(type) {
+	case ldap.DelRequest:
+		if dn != "" {
+			op.value = []byte(dn)
+		}
+		return decodeProtocolOp(op.encode())
+	case ldap.SearchRequest:
+		fields, err := op.children()
+		if err != nil || len(fields) != 8 {
+			return nil, errors.New("malformed search request")
+		}
+		if dn != "" {
+			fields[0].value = []byte(dn)
+		}
+		scope := int(r.Scope())
+		switch query[1] {
+		case "base":
+			scope = SearchRequestScopeBaseObject
+		case "one":
+			scope = SearchRequestSingleLevel
+		case "sub":
+			scope = SearchRequestHomeSubtree
+		case "":
+			if continuation && scope == SearchRequestSingleLevel {
+				scope = SearchRequestScopeBaseObject
+			}
+		default:
+			return nil, fmt.Errorf("unsupported scope %q", query[1])
+		}
+		fields[1].value = berIntegerContent(int64(scope))
+		if query[2] != "" {
+			if fields[6], err = parseFilter(query[2]); err != nil {
+				return nil, err
+			}
+		}
+		return decodeProtocolOp(op.with(fields).encode())
+	case ldap.AddRequest, ldap.ModifyRequest, ldap.ModifyDNRequest, ldap.CompareRequest:
+		fields, err := op.children()
+		if err != nil || len(fields) == 0 {
+			return nil, errors.New("malformed request")
+		}
+		if dn != "" {
+			fields[0].value = []byte(dn)
+		}
+		return decodeProtocolOp(op.with(fields).encode())
+	}
+	return nil, fmt.Errorf("%T can not be referred", po)
+}
@@ -0,0 +1,49 @@
+package ldapserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// RequestInfo describes a request and the connection it was received on,
+// for middlewares and handlers logging or authorizing requests.
+//
+//	info, _ := ldap.RequestInfoFromContext(ctx)
+//	log.Printf("client %d %s message %d as %q", info.Numero, info.RemoteAddr, info.MessageID, info.BoundDN)
+type RequestInfo struct {
+	Numero     int // connection number, 0 for CLDAP requests
+	RemoteAddr net.Addr
+	Listener   string               // see Client.ListenerName
+	BoundDN    string               // "" for anonymous sessions and bind requests
+	TLS        *tls.ConnectionState // nil without TLS
+	MessageID  int
+	ReceivedAt time.Time
+}
+
+type requestInfoKey struct{}
+
+// RequestInfoFromContext returns the description of the request carried by
+// ctx.
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// requestInfo describes the request messageID received by the client at
+// receivedAt, under the authentication state.
+func (c *client) requestInfo(messageID int, receivedAt time.Time, state AuthState) RequestInfo {
+	info := RequestInfo{
+		Numero:     c.Numero,
+		RemoteAddr: c.rwc.RemoteAddr(),
+		Listener:   c.ListenerName(),
+		BoundDN:    state.BoundDN,
+		MessageID:  messageID,
+		ReceivedAt: receivedAt,
+	}
+	if cs, ok := c.TLSConnectionState(); ok {
+		info.TLS = cs
+	}
+	return info
+}
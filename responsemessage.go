@@ -2,52 +2,73 @@ package ldapserver
 
 import ldap "github.com/lor00x/goldap/message"
 
-func NewBindResponse(resultCode int) ldap.BindResponse {
-	r := ldap.BindResponse{}
-	r.SetResultCode(resultCode)
-	return r
+// ResultOption sets a field of the LDAPResult of a response built by the
+// response constructors. Response controls are not part of the result,
+// they are passed to ResponseWriter.WriteWithControls.
+//
+//	w.Write(ldap.NewDeleteResponse(ldap.LDAPResultNoSuchObject,
+//		ldap.MatchedDN("ou=people,dc=example"), ldap.DiagnosticMessage("no such entry")))
+type ResultOption func(*ldap.LDAPResult)
+
+// MatchedDN sets the matchedDN of the result, the deepest existing entry
+// above the target of a noSuchObject operation.
+func MatchedDN(dn string) ResultOption {
+	return func(r *ldap.LDAPResult) { r.SeMatchedDN(dn) }
+}
+
+// DiagnosticMessage sets the diagnostic message of the result.
+func DiagnosticMessage(msg string) ResultOption {
+	return func(r *ldap.LDAPResult) { r.SetDiagnosticMessage(msg) }
 }
 
-func NewResponse(resultCode int) ldap.LDAPResult {
+// Referral sets the referral of the result to the urls, see NewReferral.
+func Referral(urls ...string) ResultOption {
+	return func(r *ldap.LDAPResult) { r.SetReferral(NewReferral(urls...)) }
+}
+
+func newResult(resultCode int, opts []ResultOption) ldap.LDAPResult {
 	r := ldap.LDAPResult{}
 	r.SetResultCode(resultCode)
+	for _, opt := range opts {
+		opt(&r)
+	}
 	return r
 }
 
-func NewExtendedResponse(resultCode int) ldap.ExtendedResponse {
-	r := ldap.ExtendedResponse{}
-	r.SetResultCode(resultCode)
-	return r
+func NewBindResponse(resultCode int, opts ...ResultOption) ldap.BindResponse {
+	return ldap.BindResponse{LDAPResult: newResult(resultCode, opts)}
 }
 
-func NewCompareResponse(resultCode int) ldap.CompareResponse {
-	r := ldap.CompareResponse{}
-	r.SetResultCode(resultCode)
-	return r
+func NewResponse(resultCode int, opts ...ResultOption) ldap.LDAPResult {
+	return newResult(resultCode, opts)
 }
 
-func NewModifyResponse(resultCode int) ldap.ModifyResponse {
-	r := ldap.ModifyResponse{}
-	r.SetResultCode(resultCode)
-	return r
+func NewExtendedResponse(resultCode int, opts ...ResultOption) ldap.ExtendedResponse {
+	return ldap.ExtendedResponse{LDAPResult: newResult(resultCode, opts)}
 }
 
-func NewDeleteResponse(resultCode int) ldap.DelResponse {
-	r := ldap.DelResponse{}
-	r.SetResultCode(resultCode)
-	return r
+func NewCompareResponse(resultCode int, opts ...ResultOption) ldap.CompareResponse {
+	return ldap.CompareResponse(newResult(resultCode, opts))
 }
 
-func NewAddResponse(resultCode int) ldap.AddResponse {
-	r := ldap.AddResponse{}
-	r.SetResultCode(resultCode)
-	return r
+func NewModifyResponse(resultCode int, opts ...ResultOption) ldap.ModifyResponse {
+	return ldap.ModifyResponse(newResult(resultCode, opts))
 }
 
-func NewSearchResultDoneResponse(resultCode int) ldap.SearchResultDone {
-	r := ldap.SearchResultDone{}
-	r.SetResultCode(resultCode)
-	return r
+func NewDeleteResponse(resultCode int, opts ...ResultOption) ldap.DelResponse {
+	return ldap.DelResponse(newResult(resultCode, opts))
+}
+
+func NewAddResponse(resultCode int, opts ...ResultOption) ldap.AddResponse {
+	return ldap.AddResponse(newResult(resultCode, opts))
+}
+
+func NewModifyDNResponse(resultCode int, opts ...ResultOption) ldap.ModifyDNResponse {
+	return ldap.ModifyDNResponse(newResult(resultCode, opts))
+}
+
+func NewSearchResultDoneResponse(resultCode int, opts ...ResultOption) ldap.SearchResultDone {
+	return ldap.SearchResultDone(newResult(resultCode, opts))
 }
 
 func NewSearchResultEntry(objectname string) ldap.SearchResultEntry {
@@ -55,3 +76,96 @@ func NewSearchResultEntry(objectname string) ldap.SearchResultEntry {
 	r.SetObjectName(objectname)
 	return r
 }
+
+// NewSearchResultReference returns a search result reference (RFC 4511
+// section 4.5.3) to the urls, continuing the search in other servers.
+func NewSearchResultReference(urls ...string) ldap.SearchResultReference {
+	r := make(ldap.SearchResultReference, len(urls))
+	for i, u := range urls {
+		r[i] = ldap.URI(u)
+	}
+	return r
+}
+
+// responseFor builds the response type matching the request op. It
+// returns nil for requests without a response (abandon, unbind).
+func responseFor(po ldap.ProtocolOp, resultCode int, diagnostic string) ldap.ProtocolOp {
+	var res ldap.LDAPResult
+	res.SetResultCode(resultCode)
+	res.SetDiagnosticMessage(diagnostic)
+	return responseWithResult(po, res)
+}
+
+// responseWithResult wraps res in the response type matching the request
+// op, nil for requests without a response.
+func responseWithResult(po ldap.ProtocolOp, res ldap.LDAPResult) ldap.ProtocolOp {
+	switch po.(type) {
+	case ldap.BindRequest:
+		return ldap.BindResponse{LDAPResult: res}
+	case ldap.SearchRequest:
+		return ldap.SearchResultDone(res)
+	case ldap.ModifyRequest:
+		return ldap.ModifyResponse(res)
+	case ldap.AddRequest:
+		return ldap.AddResponse(res)
+	case ldap.DelRequest:
+		return ldap.DelResponse(res)
+	case ldap.ModifyDNRequest:
+		return ldap.ModifyDNResponse(res)
+	case ldap.CompareRequest:
+		return ldap.CompareResponse(res)
+	case ldap.ExtendedRequest:
+		return ldap.ExtendedResponse{LDAPResult: res}
+	}
+	return nil
+}
+
+// isFinalResponse reports whether po ends the operation it answers, as
+// opposed to search results and intermediate responses.
+func isFinalResponse(po ldap.ProtocolOp) bool {
+	switch po.(type) {
+	case ldap.BindResponse, ldap.SearchResultDone, ldap.ModifyResponse, ldap.AddResponse, ldap.DelResponse,
+		ldap.ModifyDNResponse, ldap.CompareResponse, ldap.ExtendedResponse, ldap.LDAPResult:
+		return true
+	}
+	return false
+}
+
+// NewIntermediateResponse returns an intermediate response (RFC 4511
+// section 4.13), value is omitted when nil.
+func NewIntermediateResponse(name ldap.LDAPOID, value []byte) ldap.IntermediateResponse {
+	// IntermediateResponse ::= [APPLICATION 25] SEQUENCE {
+	//     responseName [0] LDAPOID OPTIONAL,
+	//     responseValue [1] OCTET STRING OPTIONAL }
+	fields := [][]byte{berEncode(berClassContext, false, 0, []byte(name))}
+	if value != nil {
+		fields = append(fields, berEncode(berClassContext, false, 1, value))
+	}
+	po, err := decodeProtocolOp(berEncode(berClassApplication, true, 25, fields...))
+	if err != nil {
+		return ldap.IntermediateResponse{}
+	}
+	return po.(ldap.IntermediateResponse)
+}
+
+// NewExtendedResponseValue returns an extended response with a response
+// name and value, goldap has no setter for the value.
+func NewExtendedResponseValue(resultCode int, name ldap.LDAPOID, value []byte, opts ...ResultOption) ldap.ExtendedResponse {
+	// ExtendedResponse ::= [APPLICATION 24] SEQUENCE { COMPONENTS OF
+	//     LDAPResult, responseName [10] LDAPOID OPTIONAL,
+	//     responseValue [11] OCTET STRING OPTIONAL }
+	fields := [][]byte{berEnumerated(int64(resultCode)), berString(""), berString("")}
+	if name != "" {
+		fields = append(fields, berEncode(berClassContext, false, 10, []byte(name)))
+	}
+	fields = append(fields, berEncode(berClassContext, false, 11, value))
+	po, err := decodeProtocolOp(berEncode(berClassApplication, true, 24, fields...))
+	if err != nil {
+		r := NewExtendedResponse(resultCode, opts...)
+		r.SetResponseName(name)
+		return r
+	}
+	r := po.(ldap.ExtendedResponse)
+	r.LDAPResult = newResult(resultCode, opts)
+	return r
+}
@@ -0,0 +1,391 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Rewrite maps the DNs and attribute names of the requests to those of the
+// directory behind it, and back in the responses, like the rwm overlay of
+// slapd: a Proxy can present dc=corp while the upstream server uses
+// DC=ad,DC=internal, and uid for its sAMAccountName.
+//
+//	rw := &ldap.Rewrite{
+//		Suffixes:   map[string]string{"dc=corp": "DC=ad,DC=internal"},
+//		Attributes: map[string]string{"uid": "sAMAccountName"},
+//	}
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return rw.Handler(p) }
+//
+// The DNs of the requests, their attribute names, search filters and
+// attribute lists included, and the values of DN syntax are rewritten; so
+// are the DNs, attribute names and values of the search result entries,
+// the matched DNs of the results and the identity returned by Who am I?.
+// The DNs outside the suffixes, the referrals and the values of the other
+// extended operations are left as is.
+type Rewrite struct {
+	// Suffixes maps the suffixes presented to the clients to those of the
+	// directory, the longest one matching a DN being rewritten.
+	Suffixes map[string]string
+
+	// Attributes maps the attribute names presented to the clients to
+	// those of the directory, case insensitively. The attribute options,
+	// e.g. ";binary", are kept.
+	Attributes map[string]string
+
+	// Schema is the schema presented to the clients: when set, only the
+	// values of the attributes of DN syntax are rewritten. Otherwise any
+	// value parsing as a DN below a suffix is.
+	Schema *Schema
+}
+
+// rewriter rewrites the DNs and attribute names in one direction.
+type rewriter struct {
+	suffixes   []suffixMapping
+	attributes map[string]string // by lower-cased name
+	schema     *Schema
+	reverse    bool // from the directory to the clients
+}
+
+type suffixMapping struct {
+	from, to DN
+}
+
+// rewriters returns the rewriters of the requests and of the responses.
+func (rw *Rewrite) rewriters() (requests, responses *rewriter, err error) {
+	requests = &rewriter{attributes: make(map[string]string), schema: rw.Schema}
+	responses = &rewriter{attributes: make(map[string]string), schema: rw.Schema, reverse: true}
+	for from, to := range rw.Suffixes {
+		fromDN, err := ParseDN(from)
+		if err != nil {
+			return nil, nil, err
+		}
+		toDN, err := ParseDN(to)
+		if err != nil {
+			return nil, nil, err
+		}
+		requests.suffixes = append(requests.suffixes, suffixMapping{fromDN, toDN})
+		responses.suffixes = append(responses.suffixes, suffixMapping{toDN, fromDN})
+	}
+	for from, to := range rw.Attributes {
+		requests.attributes[strings.ToLower(from)] = to
+		responses.attributes[strings.ToLower(to)] = from
+	}
+	return requests, responses, nil
+}
+
+// Handler returns a handler rewriting the requests before calling next,
+// and its responses. It panics when a suffix is not a valid DN.
+func (rw *Rewrite) Handler(next Handler) Handler {
+	requests, responses, err := rw.rewriters()
+	if err != nil {
+		panic("ldapserver: invalid Rewrite suffix: " + err.Error())
+	}
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		switch m.ProtocolOp().(type) {
+		case ldap.BindRequest, ldap.SearchRequest, ldap.ModifyRequest, ldap.AddRequest,
+			ldap.DelRequest, ldap.ModifyDNRequest, ldap.CompareRequest:
+			rewritten, err := requests.message(m.LDAPMessage)
+			if err != nil {
+				w.Write(responseFor(m.ProtocolOp(), LDAPResultProtocolError, err.Error()))
+				return
+			}
+			m.LDAPMessage = rewritten
+		}
+		r, ok := m.ProtocolOp().(ldap.ExtendedRequest)
+		whoAmI := ok && r.RequestName() == NoticeOfWhoAmI
+		next.ServeLDAP(ctx, &rewriteResponseWriter{ResponseWriter: w, rewriter: responses, whoAmI: whoAmI}, m)
+	})
+}
+
+// rewriteResponseWriter maps the responses of the directory back to the
+// names presented to the clients.
+type rewriteResponseWriter struct {
+	ResponseWriter
+	rewriter *rewriter
+	whoAmI   bool // the authorization identity of the response is a DN
+}
+
+func (w *rewriteResponseWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *rewriteResponseWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if _, ok := po.(ldap.SearchResultReference); !ok {
+		if rewritten, err := w.rewriter.protocolOpOf(po, w.whoAmI); err == nil {
+			po = rewritten
+		}
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// message returns the message m with its protocol op rewritten.
+func (r *rewriter) message(m *ldap.LDAPMessage) (*ldap.LDAPMessage, error) {
+	data, err := protocolOpBytes(m.ProtocolOp())
+	if err != nil {
+		return nil, err
+	}
+	op, err := berParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if op, err = r.protocolOp(op); err != nil {
+		return nil, err
+	}
+	return messageWithProtocolOp(m, op.encode())
+}
+
+// protocolOpOf returns the protocol op po rewritten, including the
+// "dn:" authorization identity of a Who am I? response when whoAmI is set
+// (RFC 4532).
+func (r *rewriter) protocolOpOf(po ldap.ProtocolOp, whoAmI bool) (ldap.ProtocolOp, error) {
+	data, err := protocolOpBytes(po)
+	if err != nil {
+		return nil, err
+	}
+	op, err := berParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if op, err = r.protocolOp(op); err != nil {
+		return nil, err
+	}
+	if _, ok := po.(ldap.ExtendedResponse); ok && whoAmI {
+		// ExtendedResponse ::= { LDAPResult, responseName [10] OPTIONAL,
+		//     responseValue [11] OPTIONAL }
+		fields, err := op.children()
+		if err != nil {
+			return nil, err
+		}
+		for i, f := range fields {
+			if id, ok := strings.CutPrefix(string(f.value), "dn:"); ok && f.is(berClassContext, 11) {
+				fields[i].value = []byte("dn:" + r.dn(id))
+			}
+		}
+		op = op.with(fields)
+	}
+	return decodeProtocolOp(op.encode())
+}
+
+// protocolOp rewrites the encoded protocol op.
+func (r *rewriter) protocolOp(op berElement) (berElement, error) {
+	if op.class != berClassApplication {
+		return op, nil
+	}
+	if op.tag == 10 { // DelRequest ::= [APPLICATION 10] LDAPDN
+		return r.dnElement(op), nil
+	}
+	fields, err := op.children()
+	if err != nil {
+		return op, err
+	}
+	malformed := errors.New("malformed request")
+	switch op.tag {
+	case 0: // BindRequest ::= { version, name, authentication }
+		if len(fields) != 3 {
+			return op, malformed
+		}
+		fields[1] = r.dnElement(fields[1])
+	case 3: // SearchRequest ::= { baseObject, scope, derefAliases, sizeLimit,
+		// timeLimit, typesOnly, filter, attributes }
+		if len(fields) != 8 {
+			return op, malformed
+		}
+		fields[0] = r.dnElement(fields[0])
+		if fields[6], err = r.filter(fields[6]); err != nil {
+			return op, err
+		}
+		fields[7], err = each(fields[7], func(e berElement) (berElement, error) {
+			e.value = []byte(r.attributeName(string(e.value)))
+			return e, nil
+		})
+	case 4, 8: // SearchResultEntry, AddRequest ::= { objectName, attributes }
+		if len(fields) != 2 {
+			return op, malformed
+		}
+		fields[0] = r.dnElement(fields[0])
+		fields[1], err = each(fields[1], r.attribute)
+	case 6: // ModifyRequest ::= { object, changes SEQUENCE OF SEQUENCE {
+		// operation, modification PartialAttribute } }
+		if len(fields) != 2 {
+			return op, malformed
+		}
+		fields[0] = r.dnElement(fields[0])
+		fields[1], err = each(fields[1], func(change berElement) (berElement, error) {
+			parts, err := change.children()
+			if err != nil || len(parts) != 2 {
+				return change, malformed
+			}
+			if parts[1], err = r.attribute(parts[1]); err != nil {
+				return change, err
+			}
+			return change.with(parts), nil
+		})
+	case 12: // ModifyDNRequest ::= { entry, newrdn, deleteoldrdn,
+		// newSuperior [0] LDAPDN OPTIONAL }
+		if len(fields) < 3 {
+			return op, malformed
+		}
+		fields[0] = r.dnElement(fields[0])
+		for i := 3; i < len(fields); i++ {
+			fields[i] = r.dnElement(fields[i])
+		}
+	case 14: // CompareRequest ::= { entry, ava }
+		if len(fields) != 2 {
+			return op, malformed
+		}
+		fields[0] = r.dnElement(fields[0])
+		fields[1], err = r.assertion(fields[1])
+	case 1, 5, 7, 9, 11, 13, 15, 24: // LDAPResult ::= { resultCode, matchedDN, ... }
+		if len(fields) < 3 {
+			return op, malformed
+		}
+		fields[1] = r.dnElement(fields[1])
+	default:
+		return op, nil
+	}
+	if err != nil {
+		return op, err
+	}
+	return op.with(fields), nil
+}
+
+// filter rewrites the attribute names of the filter f, and its assertion
+// values of DN syntax.
+func (r *rewriter) filter(f berElement) (berElement, error) {
+	switch f.tag {
+	case filterAnd, filterOr, filterNot:
+		return each(f, r.filter)
+	case filterEqualityMatch, filterGreaterOrEqual, filterLessOrEqual, filterApproxMatch:
+		return r.assertion(f)
+	case filterPresent:
+		f.value = []byte(r.attributeName(string(f.value)))
+		return f, nil
+	case filterSubstrings:
+		parts, err := f.children()
+		if err != nil || len(parts) != 2 {
+			return f, errors.New("malformed substrings filter")
+		}
+		parts[0].value = []byte(r.attributeName(string(parts[0].value)))
+		return f.with(parts), nil
+	case filterExtensibleMatch:
+		// MatchingRuleAssertion ::= { matchingRule [1] OPTIONAL,
+		//     type [2] OPTIONAL, matchValue [3], dnAttributes [4] }
+		parts, err := f.children()
+		if err != nil {
+			return f, err
+		}
+		name := ""
+		for i, p := range parts {
+			switch p.tag {
+			case 2:
+				name = string(p.value)
+				parts[i].value = []byte(r.attributeName(name))
+			case 3:
+				if name != "" {
+					parts[i].value = []byte(r.value(name, string(p.value)))
+				}
+			}
+		}
+		return f.with(parts), nil
+	}
+	return f, nil
+}
+
+// assertion rewrites an AttributeValueAssertion ::= { attributeDesc,
+// assertionValue }.
+func (r *rewriter) assertion(ava berElement) (berElement, error) {
+	parts, err := ava.children()
+	if err != nil || len(parts) != 2 {
+		return ava, errors.New("malformed attribute value assertion")
+	}
+	name := string(parts[0].value)
+	parts[0].value = []byte(r.attributeName(name))
+	parts[1].value = []byte(r.value(name, string(parts[1].value)))
+	return ava.with(parts), nil
+}
+
+// attribute rewrites an Attribute ::= { type, vals SET OF value }.
+func (r *rewriter) attribute(a berElement) (berElement, error) {
+	parts, err := a.children()
+	if err != nil || len(parts) != 2 {
+		return a, errors.New("malformed attribute")
+	}
+	name := string(parts[0].value)
+	parts[0].value = []byte(r.attributeName(name))
+	parts[1], err = each(parts[1], func(v berElement) (berElement, error) {
+		v.value = []byte(r.value(name, string(v.value)))
+		return v, nil
+	})
+	return a.with(parts), err
+}
+
+// attributeName maps the attribute description name, keeping its options.
+func (r *rewriter) attributeName(name string) string {
+	base, options, _ := strings.Cut(name, ";")
+	mapped, ok := r.attributes[strings.ToLower(base)]
+	if !ok {
+		return name
+	}
+	if options != "" {
+		return mapped + ";" + options
+	}
+	return mapped
+}
+
+// value rewrites the value of the attribute name, when of DN syntax.
+func (r *rewriter) value(name, v string) string {
+	if r.schema != nil {
+		if r.reverse {
+			name = r.attributeName(name)
+		}
+		base, _, _ := strings.Cut(name, ";")
+		at, ok := r.schema.AttributeType(base)
+		if !ok || r.schema.syntaxOf(at) != "1.3.6.1.4.1.1466.115.121.1.12" {
+			return v
+		}
+	}
+	return r.dn(v)
+}
+
+// dn maps the suffix of the DN s, returned as is when outside the
+// suffixes or invalid.
+func (r *rewriter) dn(s string) string {
+	d, err := ParseDN(s)
+	if err != nil || len(d) == 0 {
+		return s
+	}
+	var match *suffixMapping
+	for i, m := range r.suffixes {
+		if d.InScope(m.from, SearchRequestHomeSubtree) && (match == nil || len(m.from) > len(match.from)) {
+			match = &r.suffixes[i]
+		}
+	}
+	if match == nil {
+		return s
+	}
+	rewritten := append(append(DN(nil), d[:len(d)-len(match.from)]...), match.to...)
+	return rewritten.String()
+}
+
+func (r *rewriter) dnElement(e berElement) berElement {
+	e.value = []byte(r.dn(string(e.value)))
+	return e
+}
+
+// each returns the constructed element e with its children rewritten by
+// f.
+func each(e berElement, f func(berElement) (berElement, error)) (berElement, error) {
+	children, err := e.children()
+	if err != nil {
+		return e, err
+	}
+	for i, c := range children {
+		if children[i], err = f(c); err != nil {
+			return e, err
+		}
+	}
+	return e.with(children), nil
+}
@@ -0,0 +1,151 @@
+package ldapserver
+
+import (
+	"context"
+	"sort"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// RootDSE publishes the root DSE (RFC 4512 section 5.1), the entry with
+// the empty DN which clients read to discover the naming contexts and the
+// capabilities of a server. Its Handler answers the base searches of the
+// empty DN and passes the other requests to next; the capabilities are
+// derived from the server and the routes:
+//
+//   - supportedControl lists the controls registered with RegisterControl,
+//     and those handled by the server,
+//   - supportedExtension lists the extended operations of the routes named
+//     with RequestName, and those handled by the server,
+//   - supportedSASLMechanisms lists Server.SaslMechanisms,
+//   - supportedFeatures lists SupportedFeatures.
+//
+// Its attributes are operational: searches get them when they name them
+// or request "+".
+//
+//	dse := &ldap.RootDSE{NamingContexts: []string{"dc=example,dc=com"}, Server: server, Mux: routes}
+//	server.Handle(dse.Handler(routes))
+type RootDSE struct {
+	NamingContexts []string
+
+	// Server, if set, adds the SASL mechanisms, extended operations and
+	// controls handled by the server.
+	Server *Server
+
+	// Mux, if set, adds the extended operations of its routes.
+	Mux *RouteMux
+
+	// SubschemaSubentry is the DN of the subschema subentry, see
+	// Subschema, none if empty.
+	SubschemaSubentry string
+
+	VendorName    string
+	VendorVersion string
+
+	// Attributes are added to the entry, e.g. altServer.
+	Attributes map[string][]string
+}
+
+// Entry returns the root DSE.
+func (d *RootDSE) Entry() *Entry {
+	e := &Entry{}
+	e.Replace("objectClass", "top")
+	e.Replace("namingContexts", d.NamingContexts...)
+	e.Replace("subschemaSubentry", optional(d.SubschemaSubentry)...)
+	e.Replace("supportedLDAPVersion", "3")
+	e.Replace("supportedControl", d.controls()...)
+	e.Replace("supportedExtension", d.extensions()...)
+	e.Replace("supportedFeatures", SupportedFeatures...)
+	e.Replace("supportedSASLMechanisms", d.saslMechanisms()...)
+	e.Replace("vendorName", optional(d.VendorName)...)
+	e.Replace("vendorVersion", optional(d.VendorVersion)...)
+	for _, a := range NewEntry("", d.Attributes).Attributes {
+		e.Replace(a.Name, a.Values...)
+	}
+	return e
+}
+
+func (d *RootDSE) controls() []string {
+	oids := registeredControls()
+	if d.Server != nil && d.Server.Transactions != nil {
+		oids = append(oids, ControlTransaction)
+	}
+	return sortedOIDs(oids)
+}
+
+func (d *RootDSE) extensions() []string {
+	var oids []ldap.LDAPOID
+	if d.Mux != nil {
+		oids = d.Mux.extendedNames()
+	}
+	if s := d.Server; s != nil {
+		if s.TLSConfig != nil || s.CertManager != nil {
+			oids = append(oids, NoticeOfStartTLS)
+		}
+		if s.WhoAmI {
+			oids = append(oids, NoticeOfWhoAmI)
+		}
+		if s.Transactions != nil {
+			oids = append(oids, NoticeOfStartTransaction, NoticeOfEndTransaction)
+		}
+	}
+	return sortedOIDs(oids)
+}
+
+func (d *RootDSE) saslMechanisms() []string {
+	if d.Server == nil {
+		return nil
+	}
+	var names []string
+	for name := range d.Server.SaslMechanisms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedOIDs returns the OIDs as sorted strings, without duplicates.
+func sortedOIDs(oids []ldap.LDAPOID) []string {
+	seen := make(map[ldap.LDAPOID]bool)
+	var values []string
+	for _, oid := range oids {
+		if !seen[oid] {
+			seen[oid] = true
+			values = append(values, string(oid))
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Handler returns a Handler answering the base searches of the root DSE,
+// and passing the other requests to next.
+func (d *RootDSE) Handler(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		r, ok := m.ProtocolOp().(ldap.SearchRequest)
+		if !ok || r.BaseObject() != "" || int(r.Scope()) != SearchRequestScopeBaseObject {
+			next.ServeLDAP(ctx, w, m)
+			return
+		}
+		ErrorHandler(d.serveSearch).ServeLDAP(ctx, w, m)
+	})
+}
+
+func (d *RootDSE) serveSearch(ctx context.Context, w ResponseWriter, m *Message) error {
+	r := m.GetSearchRequest()
+	e := d.Entry()
+	filter, err := NewSearchFilter(r)
+	if err != nil {
+		return NewError(LDAPResultProtocolError, err.Error())
+	}
+	ok, err := filter.Match(*e)
+	if err != nil {
+		return NewError(LDAPResultProtocolError, err.Error())
+	}
+	if ok {
+		if err := w.Write(SelectAttributes(e, r.Attributes(), bool(r.TypesOnly()))); err != nil {
+			return err
+		}
+	}
+	return w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+}
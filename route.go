@@ -3,6 +3,7 @@ package ldapserver
 import (
 	"context"
 	"strings"
+	"time"
 
 	ldap "github.com/lor00x/goldap/message"
 )
@@ -15,8 +16,10 @@ const (
 	ADD      = "AddRequest"
 	MODIFY   = "ModifyRequest"
 	DELETE   = "DelRequest"
+	MODIFYDN = "ModifyDNRequest"
 	EXTENDED = "ExtendedRequest"
 	ABANDON  = "AbandonRequest"
+	UNBIND   = "UnbindRequest"
 )
 
 // HandlerFunc type is an adapter to allow the use of
@@ -25,10 +28,29 @@ const (
 // Handler object that calls f.
 type HandlerFunc func(context.Context, ResponseWriter, *Message)
 
+// ServeLDAP calls f(ctx, w, r).
+func (f HandlerFunc) ServeLDAP(ctx context.Context, w ResponseWriter, r *Message) {
+	f(ctx, w, r)
+}
+
+// WithoutContext adapts a handler with the former func(w, m) signature,
+// which reaches the request context with m.Context() or m.Done().
+func WithoutContext(f func(w ResponseWriter, m *Message)) HandlerFunc {
+	return func(ctx context.Context, w ResponseWriter, m *Message) {
+		f(w, m)
+	}
+}
+
+// Middleware wraps a handler with a cross-cutting concern such as
+// logging, metrics or access checks.
+type Middleware func(HandlerFunc) HandlerFunc
+
 // RouteMux manages all routes
 type RouteMux struct {
 	routes        []*route
 	notFoundRoute *route
+	notFoundOps   map[string]*route // by operation
+	middlewares   []Middleware
 }
 
 type route struct {
@@ -36,14 +58,19 @@ type route struct {
 	operation   string
 	handler     HandlerFunc
 	exoName     string
+	uExoName    bool
 	sBasedn     string
 	uBasedn     bool
-	sFilter     string
+	sFilter     berElement
 	uFilter     bool
 	sScope      int
 	uScope      bool
 	sAuthChoice string
 	uAuthChoice bool
+	sMechanism  string
+	uMechanism  bool
+	middlewares []Middleware
+	timeout     time.Duration
 }
 
 // Match return true when the *Message matches the route
@@ -60,23 +87,32 @@ func (r *route) Match(m *Message) bool {
 				return false
 			}
 		}
+
+		if r.uMechanism {
+			mechanism, _, ok := saslCredentials(v)
+			if !ok || strings.ToUpper(mechanism) != r.sMechanism {
+				return false
+			}
+		}
 		return true
 
 	case ldap.ExtendedRequest:
-		if string(v.RequestName()) != r.exoName {
+		if r.uExoName && string(v.RequestName()) != r.exoName {
 			return false
 		}
 		return true
 
 	case ldap.SearchRequest:
 		if r.uBasedn {
-			if strings.ToLower(string(v.BaseObject())) != r.sBasedn {
+			base := NormalizeDN(string(v.BaseObject()))
+			if r.sBasedn == "" && base != "" || !InScope(base, r.sBasedn, SearchRequestHomeSubtree) {
 				return false
 			}
 		}
 
 		if r.uFilter {
-			if strings.ToLower(v.FilterString()) != r.sFilter {
+			f, err := searchFilter(v)
+			if err != nil || !filterMatchesPattern(f, r.sFilter) {
 				return false
 			}
 		}
@@ -96,35 +132,119 @@ func (r *route) Label(label string) *route {
 	return r
 }
 
+// BaseDn restricts a Search route to the searches whose base object is dn
+// or an entry below it, comparing normalized DNs. When several routes
+// match, the one with the longest base DN wins. BaseDn("") only matches
+// searches of the root DSE.
 func (r *route) BaseDn(dn string) *route {
-	r.sBasedn = strings.ToLower(dn)
+	r.sBasedn = NormalizeDN(dn)
 	r.uBasedn = true
 	return r
 }
 
+// AuthenticationChoice restricts a Bind route to the binds using choice,
+// "simple" or "sasl", so that simple and SASL binds go to different
+// handlers. SASL binds with a mechanism of Server.SaslMechanisms are
+// answered by the server before the routes.
 func (r *route) AuthenticationChoice(choice string) *route {
 	r.sAuthChoice = strings.ToLower(choice)
 	r.uAuthChoice = true
 	return r
 }
 
+// Mechanism restricts a Bind route to the SASL binds with the mechanism,
+// e.g. "PLAIN"; the mechanism names ignore case.
+func (r *route) Mechanism(mechanism string) *route {
+	r.sMechanism = strings.ToUpper(mechanism)
+	r.uMechanism = true
+	return r
+}
+
+// Filter restricts a Search route to the searches whose filter has the
+// structure of pattern, a filter string (RFC 4515): attribute descriptions
+// and values ignore case and the order of the components of & and |
+// filters does not matter, so "(objectClass=*)" matches "(objectclass=*)".
+// Filter panics if pattern is not a valid filter.
 func (r *route) Filter(pattern string) *route {
-	r.sFilter = strings.ToLower(pattern)
+	f, err := parseFilter(pattern)
+	if err != nil {
+		panic("ldapserver: " + err.Error())
+	}
+	r.sFilter = f
 	r.uFilter = true
 	return r
 }
 
+// Scope restricts a Search route to the searches with the scope, e.g.
+// SearchRequestScopeBaseObject for the root DSE and subschema entries.
 func (r *route) Scope(scope int) *route {
 	r.sScope = scope
 	r.uScope = true
 	return r
 }
 
+// RequestName restricts an Extended route to the extended operation name;
+// an Extended route without RequestName serves every extended operation
+// no named route matches. StartTLS and, when Server.WhoAmI is set, Who Am
+// I? are answered by the server before the routes.
 func (r *route) RequestName(name ldap.LDAPOID) *route {
 	r.exoName = string(name)
+	r.uExoName = true
+	return r
+}
+
+// Use adds middlewares to the route, run inside the middlewares of the
+// RouteMux.
+func (r *route) Use(middlewares ...Middleware) *route {
+	r.middlewares = append(r.middlewares, middlewares...)
 	return r
 }
 
+// Timeout bounds the time the route handler has to answer, see
+// TimeoutHandler; the middlewares run outside of it.
+func (r *route) Timeout(d time.Duration) *route {
+	r.timeout = d
+	return r
+}
+
+// wrap returns the route handler wrapped in the middlewares of the mux
+// and of the route, the first added being the outermost.
+func (r *route) wrap(mux *RouteMux) HandlerFunc {
+	handler := r.handler
+	if r.timeout > 0 {
+		handler = TimeoutHandler(handler, r.timeout).ServeLDAP
+	}
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+	for i := len(mux.middlewares) - 1; i >= 0; i-- {
+		handler = mux.middlewares[i](handler)
+	}
+	return handler
+}
+
+// specificity ranks the routes matching a request: the route with the
+// deepest base DN wins, then the one with the most conditions.
+func (r *route) specificity() (depth, conditions int) {
+	if r.uBasedn {
+		base, _ := ParseDN(r.sBasedn)
+		depth = 1 + len(base)
+	}
+	for _, set := range []bool{r.uExoName, r.uBasedn, r.uFilter, r.uScope, r.uAuthChoice, r.uMechanism} {
+		if set {
+			conditions++
+		}
+	}
+	return depth, conditions
+}
+
+// moreSpecific reports whether r ranks before o, see specificity.
+func (r *route) moreSpecific(o *route) bool {
+	rd, rc := r.specificity()
+	od, oc := o.specificity()
+	return rd > od || rd == od && rc > oc
+}
+
 // NewRouteMux returns a new *RouteMux
 // RouteMux implements ldapserver.Handler
 func NewRouteMux() *RouteMux {
@@ -138,25 +258,56 @@ type Handler interface {
 
 // ServeLDAP dispatches the request to the handler whose
 // pattern most closely matches the request request Message.
+// Among the matching routes the most specific wins, then the first
+// added.
 func (h *RouteMux) ServeLDAP(ctx context.Context, w ResponseWriter, r *Message) {
 
-	//find a matching Route
+	//find the best matching Route
+	var best *route
 	for _, route := range h.routes {
-		if route.Match(r) {
-			route.handler(ctx, w, r)
-			return
+		if route.Match(r) && (best == nil || route.moreSpecific(best)) {
+			best = route
 		}
 	}
+	if best != nil {
+		best.wrap(h)(ctx, w, r)
+		return
+	}
+
+	if op := r.ProtocolOpName(); op == ABANDON || op == UNBIND {
+		// these requests have no response, nothing to do
+		return
+	}
+	notFound := h.notFoundOps[r.ProtocolOpName()]
+	if notFound == nil {
+		notFound = h.notFoundRoute
+	}
+	if notFound == nil {
+		notFound = &route{handler: NotImplemented}
+	}
+	notFound.wrap(h)(ctx, w, r)
+}
 
-	if h.notFoundRoute != nil {
-		h.notFoundRoute.handler(ctx, w, r)
-	} else {
-		res := NewResponse(LDAPResultUnwillingToPerform)
-		res.SetDiagnosticMessage("Operation not implemented by server")
+// NotImplemented answers the request with unwillingToPerform, in the
+// response matching the operation (e.g. SearchResultDone for searches). It
+// is what RouteMux does for the requests no route matches without NotFound
+// handler.
+func NotImplemented(ctx context.Context, w ResponseWriter, m *Message) {
+	if res := responseFor(m.ProtocolOp(), LDAPResultUnwillingToPerform, "Operation not implemented by server"); res != nil {
 		w.Write(res)
 	}
 }
 
+// Use adds middlewares run around the handler of every route, NotFound
+// included, the first added being the outermost:
+//
+//	routes.Use(logRequests, requireBind)
+//
+// Middlewares added after the routes apply to them too.
+func (h *RouteMux) Use(middlewares ...Middleware) {
+	h.middlewares = append(h.middlewares, middlewares...)
+}
+
 // Adds a new Route to the Handler
 func (h *RouteMux) addRoute(r *route) {
 	//and finally append to the list of Routes
@@ -164,6 +315,19 @@ func (h *RouteMux) addRoute(r *route) {
 	h.routes = append(h.routes, r)
 }
 
+// extendedNames returns the request names of the Extended routes.
+func (h *RouteMux) extendedNames() []ldap.LDAPOID {
+	var names []ldap.LDAPOID
+	for _, r := range h.routes {
+		if r.operation == EXTENDED && r.uExoName {
+			names = append(names, ldap.LDAPOID(r.exoName))
+		}
+	}
+	return names
+}
+
+// NotFound sets the handler of the requests no route matches, when no
+// NotFoundFor handler is set for their operation.
 func (h *RouteMux) NotFound(handler HandlerFunc) *route {
 	route := &route{}
 	route.handler = handler
@@ -171,6 +335,19 @@ func (h *RouteMux) NotFound(handler HandlerFunc) *route {
 	return route
 }
 
+// NotFoundFor sets the handler of the requests of the operation, e.g.
+// SEARCH, no route matches.
+func (h *RouteMux) NotFoundFor(operation string, handler HandlerFunc) *route {
+	if h.notFoundOps == nil {
+		h.notFoundOps = make(map[string]*route)
+	}
+	route := &route{}
+	route.operation = operation
+	route.handler = handler
+	h.notFoundOps[operation] = route
+	return route
+}
+
 func (h *RouteMux) Bind(handler HandlerFunc) *route {
 	route := &route{}
 	route.operation = BIND
@@ -226,3 +403,34 @@ func (h *RouteMux) Extended(handler HandlerFunc) *route {
 	h.addRoute(route)
 	return route
 }
+
+func (h *RouteMux) ModifyDN(handler HandlerFunc) *route {
+	route := &route{}
+	route.operation = MODIFYDN
+	route.handler = handler
+	h.addRoute(route)
+	return route
+}
+
+// Abandon adds a route called after the operation named by an abandon
+// request is cancelled, e.g. to log abandons or free the state of the
+// abandoned operation. Abandon handlers run on the connection read loop and
+// must not block; abandon requests have no response.
+func (h *RouteMux) Abandon(handler HandlerFunc) *route {
+	route := &route{}
+	route.operation = ABANDON
+	route.handler = handler
+	h.addRoute(route)
+	return route
+}
+
+// Unbind adds a route called when the client sends an unbind request, once
+// its operations are cancelled; the connection is closed afterwards. See
+// also Server.OnDisconnect, called for every closed connection.
+func (h *RouteMux) Unbind(handler HandlerFunc) *route {
+	route := &route{}
+	route.operation = UNBIND
+	route.handler = handler
+	h.addRoute(route)
+	return route
+}
@@ -0,0 +1,159 @@
+package ldapserver
+
+import (
+	"context"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// SaslMechanism starts the server side of a SASL authentication exchange
+// for the bind request m. It is registered in Server.SaslMechanisms.
+type SaslMechanism func(m *Message) (SaslServer, error)
+
+// SaslServer is one SASL authentication exchange. The exchange lasts
+// across the bind requests of the connection until Next reports done or
+// fails, or the client aborts it (RFC 4513 section 5.2.1.2).
+type SaslServer interface {
+	// Next processes the credentials of a bind request and returns the
+	// server credentials (challenge) to send back. When done is false the
+	// client is answered with saslBindInProgress; an error ends the
+	// exchange with invalidCredentials.
+	Next(ctx context.Context, credentials []byte) (challenge []byte, done bool, err error)
+
+	// Identity returns the DN (or authorization identity) the session is
+	// bound to once the exchange is done.
+	Identity() string
+}
+
+// saslExchange is the SASL bind in progress on a connection.
+type saslExchange struct {
+	mechanism string
+	server    SaslServer // nil when the exchange is run by the handler
+	state     any        // see SetSaslState
+}
+
+// NewSaslBindResponse returns a bind response carrying SASL server
+// credentials, typically with LDAPResultSaslBindInProgress.
+func NewSaslBindResponse(resultCode int, serverCredentials []byte) ldap.BindResponse {
+	if serverCredentials == nil {
+		return NewBindResponse(resultCode)
+	}
+	// BindResponse ::= [APPLICATION 1] SEQUENCE { COMPONENTS OF
+	//     LDAPResult, serverSaslCreds [7] OCTET STRING OPTIONAL }
+	op := berEncode(berClassApplication, true, 1,
+		berEnumerated(int64(resultCode)),
+		berString(""),
+		berString(""),
+		berEncode(berClassContext, false, 7, serverCredentials),
+	)
+	po, err := decodeProtocolOp(op)
+	if err != nil {
+		return NewBindResponse(resultCode)
+	}
+	return po.(ldap.BindResponse)
+}
+
+// SaslState returns the value stored with SetSaslState during the SASL
+// bind in progress, nil at its first step.
+func (c *client) SaslState() any {
+	c.Lock()
+	defer c.Unlock()
+	if c.sasl == nil {
+		return nil
+	}
+	return c.sasl.state
+}
+
+// SetSaslState keeps a value across the steps of the SASL bind handled by
+// the current request, for handlers implementing a multi-step mechanism
+// themselves: they answer with NewSaslBindResponse and
+// LDAPResultSaslBindInProgress, and get the value back from SaslState on
+// the next bind request. The value is dropped when the exchange ends.
+func (c *client) SetSaslState(state any) {
+	c.Lock()
+	defer c.Unlock()
+	if c.sasl != nil {
+		c.sasl.state = state
+	}
+}
+
+// beginSasl finds or starts the SASL exchange of a request. Any request
+// other than a SASL bind with the same mechanism aborts the exchange in
+// progress.
+func (c *client) beginSasl(po ldap.ProtocolOp) *saslExchange {
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := po.(ldap.BindRequest)
+	if !ok {
+		c.sasl = nil
+		return nil
+	}
+	mechanism, _, ok := saslCredentials(r)
+	if !ok {
+		c.sasl = nil
+		return nil
+	}
+	if c.sasl == nil || !strings.EqualFold(c.sasl.mechanism, mechanism) {
+		c.sasl = &saslExchange{mechanism: mechanism}
+	}
+	return c.sasl
+}
+
+// endSasl updates the SASL exchange from the result of a bind request.
+// c must be locked.
+func (c *client) endSasl(code int) {
+	if code != LDAPResultSaslBindInProgress {
+		c.sasl = nil
+	}
+}
+
+// saslBind runs a step of an exchange with a mechanism registered in
+// Server.SaslMechanisms. It returns false if the request is left to the
+// handler.
+func (c *client) saslBind(ctx context.Context, w ResponseWriter, m *Message, ex *saslExchange) bool {
+	mechanism, credentials, _ := saslCredentials(m.GetBindRequest())
+	if mechanism == "" {
+		res := NewBindResponse(LDAPResultAuthMethodNotSupported)
+		res.SetDiagnosticMessage("missing SASL mechanism")
+		w.Write(res)
+		return true
+	}
+
+	if ex.server == nil {
+		mech := c.srv.SaslMechanisms[strings.ToUpper(mechanism)]
+		if _, ok := c.ClientCertDN(); mech == nil && ok && strings.EqualFold(mechanism, SaslExternal) {
+			mech = defaultExternalMechanism
+		}
+		if mech == nil {
+			return false
+		}
+		server, err := mech(m)
+		if err != nil {
+			res := NewBindResponse(LDAPResultAuthMethodNotSupported)
+			res.SetDiagnosticMessage(err.Error())
+			w.Write(res)
+			return true
+		}
+		ex.server = server
+	}
+
+	challenge, done, err := ex.server.Next(ctx, credentials)
+	switch {
+	case err != nil:
+		res := NewBindResponse(LDAPResultInvalidCredentials)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+	case !done:
+		w.Write(NewSaslBindResponse(LDAPResultSaslBindInProgress, challenge))
+	default:
+		if sl, ok := ex.server.(SaslSecurityLayer); ok {
+			if layer := sl.SecurityLayer(); layer != nil {
+				c.SetSecurityLayer(layer)
+			}
+		}
+		w.Write(NewSaslBindResponse(LDAPResultSuccess, challenge))
+	}
+	return true
+}
@@ -0,0 +1,405 @@
+package ldapserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SASL names of the legacy shared secret mechanisms.
+const (
+	SaslDigestMD5 = "DIGEST-MD5"
+	SaslCramMD5   = "CRAM-MD5"
+)
+
+// DigestConfig configures the DIGEST-MD5 (RFC 2831) and CRAM-MD5 (RFC
+// 2195) mechanisms. Both are obsolete and need the clear text password, or
+// for DIGEST-MD5 its HA1 hash, on the server: they are only meant for
+// interoperability with old clients refusing simple binds, and are only
+// enabled when registered in Server.SaslMechanisms.
+type DigestConfig struct {
+	// Realm is the realm offered by DIGEST-MD5, the host name if empty.
+	Realm string
+
+	// Password returns the password of a user. realm is "" for CRAM-MD5.
+	Password func(ctx context.Context, username, realm string) (string, error)
+
+	// HA1, used by DIGEST-MD5 when Password is nil, returns
+	// MD5(username ":" realm ":" password).
+	HA1 func(ctx context.Context, username, realm string) ([]byte, error)
+
+	// Identity returns the identity the session is bound to. authzID is
+	// the authorization identity requested with DIGEST-MD5, "" when none.
+	// If nil, the identity is the username and authzID must be empty.
+	Identity func(username, realm, authzID string) (string, error)
+
+	// Integrity and Confidentiality offer the DIGEST-MD5 security layers,
+	// qop "auth-int" and "auth-conf" with the rc4 cipher, see
+	// SecurityLayer.
+	Integrity       bool
+	Confidentiality bool
+
+	// MaxBufferSize is the largest security layer buffer the server
+	// accepts, 65536 if zero.
+	MaxBufferSize int
+}
+
+var errDigestCredentials = errors.New("invalid credentials")
+
+func (cfg *DigestConfig) realm() string {
+	if cfg.Realm != "" {
+		return cfg.Realm
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+func (cfg *DigestConfig) identity(username, realm, authzID string) (string, error) {
+	if cfg.Identity != nil {
+		return cfg.Identity(username, realm, authzID)
+	}
+	if authzID != "" && authzID != username {
+		return "", errors.New("authorization identity not allowed")
+	}
+	return username, nil
+}
+
+// SaslCramMD5Mechanism returns the CRAM-MD5 mechanism. cfg.Password is
+// required.
+func SaslCramMD5Mechanism(cfg DigestConfig) SaslMechanism {
+	return func(m *Message) (SaslServer, error) {
+		if cfg.Password == nil {
+			return nil, errors.New("CRAM-MD5 is not configured")
+		}
+		return &cramServer{cfg: &cfg}, nil
+	}
+}
+
+type cramServer struct {
+	cfg       *DigestConfig
+	challenge string
+	identity  string
+}
+
+func (s *cramServer) Next(ctx context.Context, credentials []byte) ([]byte, bool, error) {
+	if s.challenge == "" {
+		if len(credentials) > 0 {
+			return nil, false, errors.New("CRAM-MD5 has no initial response")
+		}
+		host, _ := os.Hostname()
+		s.challenge = fmt.Sprintf("<%s.%d@%s>", randomToken(8), time.Now().Unix(), host)
+		return []byte(s.challenge), false, nil
+	}
+
+	// response: username SP hex(HMAC-MD5(password, challenge))
+	i := strings.LastIndexByte(string(credentials), ' ')
+	if i < 0 {
+		return nil, false, errDigestCredentials
+	}
+	username, digest := string(credentials[:i]), string(credentials[i+1:])
+	password, err := s.cfg.Password(ctx, username, "")
+	if err != nil {
+		return nil, false, errDigestCredentials
+	}
+	mac := hmac.New(md5.New, []byte(password))
+	mac.Write([]byte(s.challenge))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.ToLower(digest))) != 1 {
+		return nil, false, errDigestCredentials
+	}
+	s.identity, err = s.cfg.identity(username, "", "")
+	if err != nil {
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+func (s *cramServer) Identity() string {
+	return s.identity
+}
+
+// SaslDigestMD5Mechanism returns the DIGEST-MD5 mechanism, with the
+// security layers enabled in cfg. cfg.Password or cfg.HA1 is required.
+func SaslDigestMD5Mechanism(cfg DigestConfig) SaslMechanism {
+	return func(m *Message) (SaslServer, error) {
+		if cfg.Password == nil && cfg.HA1 == nil {
+			return nil, errors.New("DIGEST-MD5 is not configured")
+		}
+		return &digestServer{cfg: &cfg, realm: cfg.realm()}, nil
+	}
+}
+
+type digestServer struct {
+	cfg      *DigestConfig
+	realm    string
+	nonce    string
+	identity string
+	layer    SecurityLayer
+}
+
+func (s *digestServer) Next(ctx context.Context, credentials []byte) ([]byte, bool, error) {
+	if s.nonce == "" {
+		if len(credentials) > 0 {
+			return nil, false, errors.New("DIGEST-MD5 has no initial response")
+		}
+		s.nonce = randomToken(16)
+		challenge := fmt.Sprintf(`realm="%s",nonce="%s",qop="%s",charset=utf-8,algorithm=md5-sess`,
+			digestQuote(s.realm), s.nonce, strings.Join(s.qops(), ","))
+		if s.cfg.Confidentiality {
+			challenge += `,cipher="rc4"`
+		}
+		if s.cfg.Integrity || s.cfg.Confidentiality {
+			challenge += fmt.Sprintf(",maxbuf=%d", s.cfg.maxBufferSize())
+		}
+		return []byte(challenge), false, nil
+	}
+
+	p, err := parseDigestParams(string(credentials))
+	if err != nil {
+		return nil, false, err
+	}
+	username, realm, authzID := p["username"], p["realm"], p["authzid"]
+	if p["nonce"] != s.nonce || p["nc"] != "00000001" || p["cnonce"] == "" {
+		return nil, false, errDigestCredentials
+	}
+	qop := p["qop"]
+	if qop == "" {
+		qop = "auth"
+	}
+	if !slices.Contains(s.qops(), qop) {
+		return nil, false, errors.New("unsupported quality of protection " + qop)
+	}
+	if qop == "auth-conf" && p["cipher"] != "rc4" {
+		return nil, false, errors.New("unsupported cipher " + p["cipher"])
+	}
+	if realm == "" {
+		realm = s.realm
+	}
+	uri := p["digest-uri"]
+	if !strings.HasPrefix(strings.ToLower(uri), "ldap/") {
+		return nil, false, errDigestCredentials
+	}
+
+	var ha1 []byte
+	if s.cfg.Password != nil {
+		password, err := s.cfg.Password(ctx, username, realm)
+		if err != nil {
+			return nil, false, errDigestCredentials
+		}
+		h := md5.Sum([]byte(username + ":" + realm + ":" + password))
+		ha1 = h[:]
+	} else if ha1, err = s.cfg.HA1(ctx, username, realm); err != nil {
+		return nil, false, errDigestCredentials
+	}
+
+	a1 := string(ha1) + ":" + s.nonce + ":" + p["cnonce"]
+	if authzID != "" {
+		a1 += ":" + authzID
+	}
+	kd := func(a2 string) string {
+		if qop != "auth" {
+			a2 += ":00000000000000000000000000000000"
+		}
+		return md5Hex(md5Hex(a1) + ":" + s.nonce + ":" + p["nc"] + ":" + p["cnonce"] + ":" + qop + ":" + md5Hex(a2))
+	}
+	if subtle.ConstantTimeCompare([]byte(kd("AUTHENTICATE:"+uri)), []byte(strings.ToLower(p["response"]))) != 1 {
+		return nil, false, errDigestCredentials
+	}
+
+	s.identity, err = s.cfg.identity(username, realm, authzID)
+	if err != nil {
+		return nil, false, err
+	}
+	if qop != "auth" {
+		maxSend := 65536
+		if v, ok := p["maxbuf"]; ok {
+			if maxSend, err = strconv.Atoi(v); err != nil || maxSend < 16 {
+				return nil, false, errors.New("invalid maxbuf " + v)
+			}
+		}
+		s.layer = newDigestLayer(md5.Sum([]byte(a1)), qop == "auth-conf", maxSend, s.cfg.maxBufferSize())
+	}
+	// the response-auth comes with the successful bind response
+	return []byte("rspauth=" + kd(":"+uri)), true, nil
+}
+
+func (s *digestServer) Identity() string {
+	return s.identity
+}
+
+func (s *digestServer) SecurityLayer() SecurityLayer {
+	return s.layer
+}
+
+// qops returns the qualities of protection offered to the client.
+func (s *digestServer) qops() []string {
+	qops := []string{"auth"}
+	if s.cfg.Integrity {
+		qops = append(qops, "auth-int")
+	}
+	if s.cfg.Confidentiality {
+		qops = append(qops, "auth-conf")
+	}
+	return qops
+}
+
+func (cfg *DigestConfig) maxBufferSize() int {
+	if cfg.MaxBufferSize <= 0 {
+		return 65536
+	}
+	return cfg.MaxBufferSize
+}
+
+// digestLayer is the DIGEST-MD5 security layer (RFC 2831 section 2.3 and
+// 2.4): each buffer is the message, encrypted with rc4 for auth-conf,
+// followed by the first 10 octets of its HMAC-MD5, the message type 1 and
+// the sequence number.
+type digestLayer struct {
+	sendKey, recvKey       []byte // integrity keys
+	sendCipher, recvCipher *rc4.Cipher
+	sendSeq, recvSeq       uint32
+	maxSend, maxReceive    int
+}
+
+const digestLayerOverhead = 10 + 2 + 4 // MAC, message type, sequence number
+
+func newDigestLayer(ha1 [md5.Size]byte, confidential bool, maxSend, maxReceive int) *digestLayer {
+	key := func(h []byte, magic string) []byte {
+		k := md5.Sum(append(append([]byte{}, h...), magic...))
+		return k[:]
+	}
+	l := &digestLayer{
+		sendKey:    key(ha1[:], "Digest session key to server-to-client signing key magic constant"),
+		recvKey:    key(ha1[:], "Digest session key to client-to-server signing key magic constant"),
+		maxSend:    maxSend - digestLayerOverhead,
+		maxReceive: maxReceive,
+	}
+	if confidential {
+		l.sendCipher, _ = rc4.NewCipher(key(ha1[:], "Digest H(A1) to server-to-client sealing key magic constant"))
+		l.recvCipher, _ = rc4.NewCipher(key(ha1[:], "Digest H(A1) to client-to-server sealing key magic constant"))
+	}
+	return l
+}
+
+func digestMAC(key []byte, seq uint32, msg []byte) []byte {
+	mac := hmac.New(md5.New, key)
+	binary.Write(mac, binary.BigEndian, seq)
+	mac.Write(msg)
+	return mac.Sum(nil)[:10]
+}
+
+func (l *digestLayer) Wrap(p []byte) ([]byte, error) {
+	body := append(append([]byte{}, p...), digestMAC(l.sendKey, l.sendSeq, p)...)
+	if l.sendCipher != nil {
+		l.sendCipher.XORKeyStream(body, body)
+	}
+	body = append(body, 0, 1)
+	body = binary.BigEndian.AppendUint32(body, l.sendSeq)
+	l.sendSeq++
+	return body, nil
+}
+
+func (l *digestLayer) Unwrap(buf []byte) ([]byte, error) {
+	if len(buf) < digestLayerOverhead {
+		return nil, errors.New("DIGEST-MD5 buffer too short")
+	}
+	n := len(buf) - 6
+	if buf[n] != 0 || buf[n+1] != 1 || binary.BigEndian.Uint32(buf[n+2:]) != l.recvSeq {
+		return nil, errors.New("DIGEST-MD5 sequence error")
+	}
+	body := append([]byte{}, buf[:n]...)
+	if l.recvCipher != nil {
+		l.recvCipher.XORKeyStream(body, body)
+	}
+	msg, mac := body[:n-10], body[n-10:]
+	if !hmac.Equal(mac, digestMAC(l.recvKey, l.recvSeq, msg)) {
+		return nil, errors.New("DIGEST-MD5 integrity check failed")
+	}
+	l.recvSeq++
+	return msg, nil
+}
+
+func (l *digestLayer) MaxSendSize() int {
+	return l.maxSend
+}
+
+func (l *digestLayer) MaxReceiveSize() int {
+	return l.maxReceive
+}
+
+func (l *digestLayer) SecurityStrength() int {
+	if l.sendCipher != nil {
+		return 128
+	}
+	return 1
+}
+
+func md5Hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func digestQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// parseDigestParams parses the comma separated name=value pairs of a
+// DIGEST-MD5 response, values being optionally quoted.
+func parseDigestParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, errors.New("malformed digest-response")
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, errors.New("malformed digest-response")
+			}
+			s = s[i+1:]
+		} else {
+			i := strings.IndexByte(s, ',')
+			if i < 0 {
+				i = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+		if _, dup := params[name]; dup {
+			return nil, errors.New("duplicate directive " + name)
+		}
+		params[name] = value.String()
+	}
+}
@@ -0,0 +1,81 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SaslExternal is the name of the SASL EXTERNAL mechanism (RFC 4422
+// appendix A).
+const SaslExternal = "EXTERNAL"
+
+// ExternalAuthorizer decides the identity of a SASL EXTERNAL bind. authcID
+// is the identity established outside of LDAP, see ExternalIdentity, and
+// authzID the authorization identity requested by the client, "" when
+// none. Returning an error fails the bind with invalidCredentials.
+type ExternalAuthorizer func(m *Message, authcID, authzID string) (identity string, err error)
+
+// SaslExternalMechanism returns the SASL EXTERNAL mechanism, to register
+// in Server.SaslMechanisms. A nil authorize accepts an empty authzID or
+// "dn:" followed by authcID.
+//
+// Without registration, EXTERNAL binds on connections with a mapped client
+// certificate are answered with the default authorizer, and the other
+// EXTERNAL binds reach the handler.
+func SaslExternalMechanism(authorize ExternalAuthorizer) SaslMechanism {
+	if authorize == nil {
+		authorize = defaultExternalAuthorizer
+	}
+	return func(m *Message) (SaslServer, error) {
+		return &externalServer{m: m, authorize: authorize}, nil
+	}
+}
+
+// ExternalIdentity returns the identity of the connection for SASL
+// EXTERNAL: the DN mapped from the TLS client certificate or, for ldapi://
+// connections, the peer credentials DN in the OpenLDAP form
+// "gidNumber=<gid>+uidNumber=<uid>,cn=peercred,cn=external,cn=auth".
+func (c *client) ExternalIdentity() (authcID string, ok bool) {
+	if dn, ok := c.ClientCertDN(); ok {
+		return dn, true
+	}
+	if cred, ok := c.PeerCredentials(); ok {
+		return fmt.Sprintf("gidNumber=%d+uidNumber=%d,cn=peercred,cn=external,cn=auth", cred.GID, cred.UID), true
+	}
+	return "", false
+}
+
+func defaultExternalAuthorizer(m *Message, authcID, authzID string) (string, error) {
+	if authzID != "" && !strings.EqualFold(authzID, "dn:"+authcID) {
+		return "", errors.New("authorization identity does not match the external identity")
+	}
+	return authcID, nil
+}
+
+var defaultExternalMechanism = SaslExternalMechanism(nil)
+
+// externalServer is a single step SASL EXTERNAL exchange.
+type externalServer struct {
+	m         *Message
+	authorize ExternalAuthorizer
+	identity  string
+}
+
+func (s *externalServer) Next(ctx context.Context, credentials []byte) ([]byte, bool, error) {
+	authcID, ok := s.m.Client.ExternalIdentity()
+	if !ok {
+		return nil, false, errors.New("no external identity")
+	}
+	identity, err := s.authorize(s.m, authcID, string(credentials))
+	if err != nil {
+		return nil, false, err
+	}
+	s.identity = identity
+	return nil, true, nil
+}
+
+func (s *externalServer) Identity() string {
+	return s.identity
+}
@@ -0,0 +1,315 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SASL names of the Kerberos mechanisms.
+const (
+	SaslGSSAPI    = "GSSAPI"     // RFC 4752
+	SaslGSSSPNEGO = "GSS-SPNEGO" // Microsoft, SPNEGO negotiated Kerberos
+)
+
+// RFC 4752 security layers.
+const (
+	gssLayerNone            = 1
+	gssLayerIntegrity       = 2
+	gssLayerConfidentiality = 4
+)
+
+// GSSContext is the acceptor side of a GSS-API security context. The krb5
+// subpackage provides a keytab based Kerberos implementation.
+type GSSContext interface {
+	// Accept processes a context token of the initiator and returns the
+	// token to send back, possibly empty. established reports that the
+	// context is complete.
+	Accept(token []byte) (output []byte, established bool, err error)
+
+	// Wrap protects a message for the initiator, encrypting it when
+	// confidential is true.
+	Wrap(message []byte, confidential bool) ([]byte, error)
+
+	// Unwrap verifies a message of the initiator and reports whether it
+	// was encrypted.
+	Unwrap(token []byte) (message []byte, confidential bool, err error)
+
+	// SourceName returns the authenticated initiator, e.g.
+	// "alice@EXAMPLE.COM", once the context is established.
+	SourceName() string
+}
+
+// GSSChannelBinder is implemented by GSSContext implementations able to
+// verify channel bindings. SetChannelBindings is called before the first
+// Accept with the application data of the bindings, e.g.
+// "tls-server-end-point:" followed by the certificate hash.
+type GSSChannelBinder interface {
+	SetChannelBindings(applicationData []byte)
+}
+
+// GSSConfig configures the GSSAPI and GSS-SPNEGO mechanisms.
+type GSSConfig struct {
+	// NewContext returns a new acceptor context for each exchange.
+	NewContext func() (GSSContext, error)
+
+	// Identity returns the identity the session is bound to from the
+	// authenticated principal and the authorization identity requested
+	// by the client, "" when none. If nil, the identity is the principal
+	// and authzID must be empty or name the principal ("u:" prefix
+	// optional).
+	Identity func(principal, authzID string) (string, error)
+
+	// MaxBufferSize is the largest security layer buffer the server
+	// accepts, 65536 if zero.
+	MaxBufferSize int
+
+	// Integrity and Confidentiality offer the RFC 4752 security layers to
+	// GSSAPI clients, see SecurityLayer. Confidentiality needs a
+	// GSSContext able to encrypt.
+	Integrity       bool
+	Confidentiality bool
+
+	// ChannelBinding, e.g. ChannelBindingTLSServerEndPoint as used by
+	// Active Directory, binds the exchanges on TLS connections to the TLS
+	// session, when the GSSContext implements GSSChannelBinder.
+	ChannelBinding string
+}
+
+func (cfg *GSSConfig) maxBufferSize() int {
+	if cfg.MaxBufferSize <= 0 {
+		return 65536
+	}
+	return cfg.MaxBufferSize
+}
+
+// layers returns the security layers offered to the client.
+func (cfg *GSSConfig) layers() byte {
+	layers := byte(gssLayerNone)
+	if cfg.Integrity {
+		layers |= gssLayerIntegrity
+	}
+	if cfg.Confidentiality {
+		layers |= gssLayerConfidentiality
+	}
+	return layers
+}
+
+func (cfg *GSSConfig) identity(principal, authzID string) (string, error) {
+	if cfg.Identity != nil {
+		return cfg.Identity(principal, authzID)
+	}
+	if authzID != "" && authzID != principal && authzID != "u:"+principal {
+		return "", errors.New("authorization identity not allowed")
+	}
+	return principal, nil
+}
+
+// SaslGSSAPIMechanism returns the GSSAPI mechanism (RFC 4752). The
+// exchange negotiates the security layers enabled in cfg.
+func SaslGSSAPIMechanism(cfg GSSConfig) SaslMechanism {
+	return func(m *Message) (SaslServer, error) {
+		if cfg.NewContext == nil {
+			return nil, errors.New("GSSAPI is not configured")
+		}
+		gc, err := cfg.NewContext()
+		if err != nil {
+			return nil, err
+		}
+		return &gssServer{cfg: &cfg, m: m, ctx: gc}, nil
+	}
+}
+
+// SaslGSSSPNEGOMechanism returns the GSS-SPNEGO mechanism used by Windows
+// clients: the exchange ends with the SPNEGO context establishment, with
+// no RFC 4752 security layer negotiation. cfg.NewContext must accept
+// SPNEGO tokens.
+func SaslGSSSPNEGOMechanism(cfg GSSConfig) SaslMechanism {
+	return func(m *Message) (SaslServer, error) {
+		if cfg.NewContext == nil {
+			return nil, errors.New("GSS-SPNEGO is not configured")
+		}
+		gc, err := cfg.NewContext()
+		if err != nil {
+			return nil, err
+		}
+		return &gssServer{cfg: &cfg, m: m, ctx: gc, spnego: true}, nil
+	}
+}
+
+type gssServer struct {
+	cfg         *GSSConfig
+	m           *Message
+	ctx         GSSContext
+	spnego      bool
+	established bool
+	offered     bool // security layers sent, waiting for the choice
+	identity    string
+	layer       SecurityLayer
+	bound       bool // channel bindings handed to the context
+}
+
+func (s *gssServer) Next(ctx context.Context, credentials []byte) ([]byte, bool, error) {
+	if !s.bound {
+		s.bound = true
+		if err := s.bindChannel(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	switch {
+	case !s.established:
+		output, established, err := s.ctx.Accept(credentials)
+		if err != nil {
+			return nil, false, err
+		}
+		s.established = established
+		if !established {
+			return output, false, nil
+		}
+		if s.spnego {
+			return output, true, s.authorize("")
+		}
+		if len(output) > 0 {
+			// the client answers the last context token with an
+			// empty response before the security layer negotiation
+			return output, false, nil
+		}
+		return s.offerLayers()
+
+	case !s.offered:
+		return s.offerLayers()
+	}
+
+	// the client choice: layer, max buffer size, authzid
+	msg, _, err := s.ctx.Unwrap(credentials)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(msg) < 4 {
+		return nil, false, errors.New("malformed GSSAPI security layer message")
+	}
+	choice := msg[0]
+	if choice&s.cfg.layers() != choice || choice&(choice-1) != 0 || choice == 0 {
+		return nil, false, fmt.Errorf("unsupported GSSAPI security layer %d", choice)
+	}
+	if choice != gssLayerNone {
+		maxSend := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+		s.layer = &gssLayer{
+			ctx:          s.ctx,
+			confidential: choice == gssLayerConfidentiality,
+			maxSend:      maxSend - gssWrapOverhead,
+			maxReceive:   s.cfg.maxBufferSize(),
+		}
+	}
+	return nil, true, s.authorize(string(msg[4:]))
+}
+
+// offerLayers sends the security layers the server supports with its
+// maximum buffer size.
+func (s *gssServer) offerLayers() ([]byte, bool, error) {
+	size := s.cfg.maxBufferSize()
+	offer := []byte{s.cfg.layers(), byte(size >> 16), byte(size >> 8), byte(size)}
+	token, err := s.ctx.Wrap(offer, false)
+	if err != nil {
+		return nil, false, err
+	}
+	s.offered = true
+	return token, false, nil
+}
+
+// bindChannel hands the TLS channel bindings to the context.
+func (s *gssServer) bindChannel() error {
+	binder, ok := s.ctx.(GSSChannelBinder)
+	if !ok || s.cfg.ChannelBinding == "" {
+		return nil
+	}
+	if _, ok := s.m.Client.TLSConnectionState(); !ok {
+		return nil
+	}
+	data, err := s.m.Client.ChannelBinding(s.cfg.ChannelBinding)
+	if err != nil {
+		return err
+	}
+	binder.SetChannelBindings(append([]byte(s.cfg.ChannelBinding+":"), data...))
+	return nil
+}
+
+func (s *gssServer) authorize(authzID string) error {
+	principal := s.ctx.SourceName()
+	identity, err := s.cfg.identity(principal, authzID)
+	if err != nil {
+		return err
+	}
+	s.identity = identity
+	s.m.Client.setPrincipal(principal)
+	return nil
+}
+
+func (s *gssServer) Identity() string {
+	return s.identity
+}
+
+func (s *gssServer) SecurityLayer() SecurityLayer {
+	return s.layer
+}
+
+// gssWrapOverhead bounds the size a wrap token adds to its message (RFC
+// 4121 token header, checksum and encryption padding).
+const gssWrapOverhead = 128
+
+// gssLayer is the RFC 4752 security layer: each buffer is a GSS-API wrap
+// token.
+type gssLayer struct {
+	ctx          GSSContext
+	confidential bool
+	maxSend      int
+	maxReceive   int
+}
+
+func (l *gssLayer) Wrap(p []byte) ([]byte, error) {
+	return l.ctx.Wrap(p, l.confidential)
+}
+
+func (l *gssLayer) Unwrap(buf []byte) ([]byte, error) {
+	msg, confidential, err := l.ctx.Unwrap(buf)
+	if err != nil {
+		return nil, err
+	}
+	if l.confidential && !confidential {
+		return nil, errors.New("unencrypted GSSAPI message")
+	}
+	return msg, nil
+}
+
+func (l *gssLayer) MaxSendSize() int {
+	if l.maxSend <= 0 {
+		return 0
+	}
+	return l.maxSend
+}
+
+func (l *gssLayer) MaxReceiveSize() int {
+	return l.maxReceive
+}
+
+func (l *gssLayer) SecurityStrength() int {
+	if l.confidential {
+		return 128
+	}
+	return 1
+}
+
+// Principal returns the Kerberos principal authenticated by a GSSAPI or
+// GSS-SPNEGO bind on the connection.
+func (c *client) Principal() (principal string, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	return c.principal, c.principal != ""
+}
+
+func (c *client) setPrincipal(principal string) {
+	c.Lock()
+	c.principal = principal
+	c.Unlock()
+}
@@ -0,0 +1,177 @@
+package ldapserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// SecurityLayer protects the LDAP messages exchanged after a SASL bind
+// negotiated integrity or confidentiality (RFC 4422 section 3.7). Once
+// installed, every message is carried in SASL buffers: a 4 octet length in
+// network byte order followed by the protected data.
+type SecurityLayer interface {
+	// Wrap protects at most MaxSendSize octets for the client.
+	Wrap(p []byte) ([]byte, error)
+
+	// Unwrap verifies, and decrypts, a buffer received from the client.
+	Unwrap(buf []byte) ([]byte, error)
+
+	// MaxSendSize is the largest plaintext Wrap accepts, derived from the
+	// maximum buffer size of the client.
+	MaxSendSize() int
+
+	// MaxReceiveSize is the largest buffer accepted from the client.
+	MaxReceiveSize() int
+
+	// SecurityStrength is the strength factor of the layer, 1 for
+	// integrity only, see Client.SecurityStrength.
+	SecurityStrength() int
+}
+
+// SaslSecurityLayer is implemented by SaslServer exchanges able to
+// negotiate a security layer. SecurityLayer returns nil when the client
+// chose none.
+type SaslSecurityLayer interface {
+	SecurityLayer() SecurityLayer
+}
+
+// SetSecurityLayer installs a security layer negotiated by a SASL
+// mechanism run by the handler. The layer takes effect right after the
+// successful bind response, which is sent in clear; it is discarded if the
+// bind fails.
+func (c *client) SetSecurityLayer(layer SecurityLayer) {
+	c.Lock()
+	defer c.Unlock()
+	c.pendingLayer = layer
+}
+
+// isSaslBind reports whether the message is a SASL bind, after which the
+// read loop waits in case a security layer gets installed.
+func isSaslBind(message *ldap.LDAPMessage) bool {
+	r, ok := message.ProtocolOp().(ldap.BindRequest)
+	return ok && r.AuthenticationChoice() == "sasl"
+}
+
+// installSecurityLayer puts the security layer negotiated by the last
+// bind, if any, under the message codec. It runs on the serve loop while
+// the read loop is parked, once the bind response is on the wire.
+func (c *client) installSecurityLayer() {
+	c.Lock()
+	layer := c.pendingLayer
+	inProgress := c.sasl != nil
+	c.Unlock()
+	if layer == nil || inProgress {
+		return
+	}
+
+	c.flush()
+
+	c.Lock()
+	c.pendingLayer = nil
+	if _, ok := c.rwc.(*saslConn); ok {
+		// RFC 4513 section 5.2.1.3: layers are not stacked
+		c.Unlock()
+		c.srv.logf("client %d SASL security layer already installed", c.Numero)
+		return
+	}
+	c.Unlock()
+
+	c.SetConn(&saslConn{Conn: c.rwc, r: c.br, layer: layer})
+	c.srv.logf("client %d SASL security layer installed", c.Numero)
+}
+
+// flush waits until the responses queued so far have been written to the
+// connection.
+func (c *client) flush() {
+	written := make(chan struct{})
+	c.chanOut <- &outMessage{written: written}
+	<-written
+}
+
+// transport returns the connection under the SASL security layer.
+func (c *client) transport() net.Conn {
+	c.Lock()
+	defer c.Unlock()
+	if sc, ok := c.rwc.(*saslConn); ok {
+		return sc.Conn
+	}
+	return c.rwc
+}
+
+// securityLayer returns the installed SASL security layer, nil if none.
+func (c *client) securityLayer() SecurityLayer {
+	c.Lock()
+	defer c.Unlock()
+	if sc, ok := c.rwc.(*saslConn); ok {
+		return sc.layer
+	}
+	return nil
+}
+
+var errSaslBufferTooLarge = errors.New("SASL buffer exceeds the maximum buffer size")
+
+// saslConn carries the connection data in SASL buffers.
+type saslConn struct {
+	net.Conn
+	r     *bufio.Reader // reader of the connection before the layer
+	layer SecurityLayer
+	in    []byte // unwrapped data not read yet
+	wmu   sync.Mutex
+}
+
+func (c *saslConn) Read(b []byte) (int, error) {
+	for len(c.in) == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if max := c.layer.MaxReceiveSize(); max > 0 && size > uint32(max) {
+			return 0, errSaslBufferTooLarge
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return 0, err
+		}
+		data, err := c.layer.Unwrap(buf)
+		if err != nil {
+			return 0, fmt.Errorf("SASL security layer: %w", err)
+		}
+		c.in = data
+	}
+	n := copy(b, c.in)
+	c.in = c.in[n:]
+	return n, nil
+}
+
+func (c *saslConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if max := c.layer.MaxSendSize(); max > 0 && len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		buf, err := c.layer.Wrap(chunk)
+		if err != nil {
+			return written, fmt.Errorf("SASL security layer: %w", err)
+		}
+		out := make([]byte, 4, 4+len(buf))
+		binary.BigEndian.PutUint32(out, uint32(len(buf)))
+		if _, err := c.Conn.Write(append(out, buf...)); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
@@ -0,0 +1,825 @@
+package ldapserver
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a registry of the definitions of a directory schema (RFC 4512
+// section 4.1): attribute types, object classes, matching rules and
+// syntaxes, used to validate the entries, see ValidateEntry.
+//
+// DefaultSchema returns the standard definitions, which LoadLDIF can
+// extend. A Schema is not safe for concurrent modification: load it before
+// serving.
+type Schema struct {
+	attributeTypes []*AttributeType
+	objectClasses  []*ObjectClass
+	matchingRules  []*MatchingRule
+	syntaxes       []*Syntax
+
+	// by lower-cased OID and names
+	attributeTypeIndex map[string]*AttributeType
+	objectClassIndex   map[string]*ObjectClass
+	matchingRuleIndex  map[string]*MatchingRule
+	syntaxIndex        map[string]*Syntax
+}
+
+// Usages of attribute types (RFC 4512 section 4.1.2).
+const (
+	UserApplications     = "userApplications"
+	DirectoryOperation   = "directoryOperation"
+	DistributedOperation = "distributedOperation"
+	DSAOperation         = "dSAOperation"
+)
+
+// AttributeType is an attribute type definition (RFC 4512 section 4.1.2).
+type AttributeType struct {
+	OID                string
+	Names              []string
+	Description        string
+	Obsolete           bool
+	Superior           string
+	Equality           string
+	Ordering           string
+	Substring          string
+	Syntax             string
+	SyntaxLength       int // 0 for no bound
+	SingleValue        bool
+	Collective         bool
+	NoUserModification bool
+	Usage              string // "" for UserApplications
+	Extensions         []Extension
+}
+
+// Kinds of object classes (RFC 4512 section 4.1.1).
+const (
+	ObjectClassStructural = "STRUCTURAL"
+	ObjectClassAbstract   = "ABSTRACT"
+	ObjectClassAuxiliary  = "AUXILIARY"
+)
+
+// ObjectClass is an object class definition (RFC 4512 section 4.1.1).
+type ObjectClass struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Superiors   []string
+	Kind        string // ObjectClassStructural when not given
+	Must        []string
+	May         []string
+	Extensions  []Extension
+}
+
+// MatchingRule is a matching rule definition (RFC 4512 section 4.1.3).
+type MatchingRule struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Syntax      string
+	Extensions  []Extension
+}
+
+// Syntax is an LDAP syntax definition (RFC 4512 section 4.1.5).
+type Syntax struct {
+	OID         string
+	Description string
+	Extensions  []Extension
+}
+
+// Extension is an extension of a definition, e.g. X-ORIGIN.
+type Extension struct {
+	Name   string
+	Values []string
+}
+
+// NewSchema returns an empty schema.
+func NewSchema() *Schema {
+	return &Schema{
+		attributeTypeIndex: make(map[string]*AttributeType),
+		objectClassIndex:   make(map[string]*ObjectClass),
+		matchingRuleIndex:  make(map[string]*MatchingRule),
+		syntaxIndex:        make(map[string]*Syntax),
+	}
+}
+
+// DefaultSchema returns a schema with the definitions of the LDAP core
+// schema (RFC 4512, RFC 4517 and RFC 4519) and of the common person and
+// account object classes: inetOrgPerson (RFC 2798), the COSINE object
+// classes (RFC 4524) and posixAccount, posixGroup and shadowAccount (RFC
+// 2307).
+func DefaultSchema() *Schema {
+	s := NewSchema()
+	if err := s.LoadLDIF(strings.NewReader(defaultSchemaLDIF)); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// LoadLDIF adds the definitions of an LDIF file, the values of its
+// attributeTypes, objectClasses, matchingRules and ldapSyntaxes attributes,
+// the other attributes being ignored. The olc prefix and {n} indexes of an
+// OpenLDAP cn=config schema are accepted. A definition replaces the
+// definition with the same OID.
+func (s *Schema) LoadLDIF(r io.Reader) error {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, 1<<20)
+	var line string
+	n, start := 0, 0 // line numbers of the scanner and of line
+	flush := func() error {
+		if line == "" {
+			return nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		line = ""
+		if !ok {
+			return fmt.Errorf("ldapserver: line %d: missing ':'", start)
+		}
+		if strings.HasPrefix(value, ":") {
+			b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return fmt.Errorf("ldapserver: line %d: %v", start, err)
+			}
+			value = string(b)
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "{") {
+			if i := strings.IndexByte(value, '}'); i > 0 {
+				value = value[i+1:]
+			}
+		}
+		var err error
+		switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "olc") {
+		case "attributetypes":
+			err = s.AddAttributeType(value)
+		case "objectclasses":
+			err = s.AddObjectClass(value)
+		case "matchingrules":
+			err = s.AddMatchingRule(value)
+		case "ldapsyntaxes":
+			err = s.AddSyntax(value)
+		}
+		if err != nil {
+			return fmt.Errorf("ldapserver: line %d: %v", start, err)
+		}
+		return nil
+	}
+	for lines.Scan() {
+		n++
+		text := lines.Text()
+		switch {
+		case strings.HasPrefix(text, " "):
+			line += text[1:] // continuation
+			continue
+		case strings.HasPrefix(text, "#"):
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		line, start = text, n
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// AddAttributeType adds an attribute type description, e.g.
+// "( 2.5.4.3 NAME ( 'cn' 'commonName' ) SUP name )".
+func (s *Schema) AddAttributeType(definition string) error {
+	d, err := parseDefinition(definition)
+	if err != nil {
+		return err
+	}
+	a := &AttributeType{
+		OID:                d.oid,
+		Names:              d.fields["NAME"],
+		Description:        d.first("DESC"),
+		Obsolete:           d.has("OBSOLETE"),
+		Superior:           d.first("SUP"),
+		Equality:           d.first("EQUALITY"),
+		Ordering:           d.first("ORDERING"),
+		Substring:          d.first("SUBSTR"),
+		SingleValue:        d.has("SINGLE-VALUE"),
+		Collective:         d.has("COLLECTIVE"),
+		NoUserModification: d.has("NO-USER-MODIFICATION"),
+		Usage:              d.first("USAGE"),
+		Extensions:         d.extensions,
+	}
+	if syntax := d.first("SYNTAX"); syntax != "" {
+		a.Syntax = syntax
+		if oid, bound, ok := strings.Cut(syntax, "{"); ok {
+			a.Syntax = oid
+			a.SyntaxLength, _ = strconv.Atoi(strings.TrimSuffix(bound, "}"))
+		}
+	}
+	if a.Usage == UserApplications {
+		a.Usage = ""
+	}
+	if a.Superior == "" && a.Syntax == "" {
+		return fmt.Errorf("attribute type %s has no SUP nor SYNTAX", a.OID)
+	}
+	s.attributeTypes = replaceDefinition(s.attributeTypes, a, s.attributeTypeIndex, a.OID, a.Names)
+	return nil
+}
+
+// AddObjectClass adds an object class description, e.g.
+// "( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MUST ( sn $ cn ) )".
+func (s *Schema) AddObjectClass(definition string) error {
+	d, err := parseDefinition(definition)
+	if err != nil {
+		return err
+	}
+	o := &ObjectClass{
+		OID:         d.oid,
+		Names:       d.fields["NAME"],
+		Description: d.first("DESC"),
+		Obsolete:    d.has("OBSOLETE"),
+		Superiors:   d.fields["SUP"],
+		Kind:        ObjectClassStructural,
+		Must:        d.fields["MUST"],
+		May:         d.fields["MAY"],
+		Extensions:  d.extensions,
+	}
+	for _, kind := range []string{ObjectClassAbstract, ObjectClassAuxiliary} {
+		if d.has(kind) {
+			o.Kind = kind
+		}
+	}
+	s.objectClasses = replaceDefinition(s.objectClasses, o, s.objectClassIndex, o.OID, o.Names)
+	return nil
+}
+
+// AddMatchingRule adds a matching rule description, e.g.
+// "( 2.5.13.2 NAME 'caseIgnoreMatch' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )".
+func (s *Schema) AddMatchingRule(definition string) error {
+	d, err := parseDefinition(definition)
+	if err != nil {
+		return err
+	}
+	m := &MatchingRule{
+		OID:         d.oid,
+		Names:       d.fields["NAME"],
+		Description: d.first("DESC"),
+		Obsolete:    d.has("OBSOLETE"),
+		Syntax:      d.first("SYNTAX"),
+		Extensions:  d.extensions,
+	}
+	s.matchingRules = replaceDefinition(s.matchingRules, m, s.matchingRuleIndex, m.OID, m.Names)
+	return nil
+}
+
+// AddSyntax adds an LDAP syntax description, e.g.
+// "( 1.3.6.1.4.1.1466.115.121.1.15 DESC 'Directory String' )".
+func (s *Schema) AddSyntax(definition string) error {
+	d, err := parseDefinition(definition)
+	if err != nil {
+		return err
+	}
+	x := &Syntax{OID: d.oid, Description: d.first("DESC"), Extensions: d.extensions}
+	s.syntaxes = replaceDefinition(s.syntaxes, x, s.syntaxIndex, x.OID, nil)
+	return nil
+}
+
+// replaceDefinition adds a definition to its list and index, replacing the
+// definition with the same OID.
+func replaceDefinition[T any](list []*T, def *T, index map[string]*T, oid string, names []string) []*T {
+	if old, ok := index[strings.ToLower(oid)]; ok {
+		for k, v := range index {
+			if v == old {
+				delete(index, k)
+			}
+		}
+		for i, v := range list {
+			if v == old {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+	}
+	index[strings.ToLower(oid)] = def
+	for _, name := range names {
+		index[strings.ToLower(name)] = def
+	}
+	return append(list, def)
+}
+
+// AttributeType returns the attribute type of the name or OID, ignoring
+// the options of an attribute description such as "cn;lang-fr".
+func (s *Schema) AttributeType(name string) (*AttributeType, bool) {
+	name, _, _ = strings.Cut(name, ";")
+	a, ok := s.attributeTypeIndex[strings.ToLower(name)]
+	return a, ok
+}
+
+// ObjectClass returns the object class of the name or OID.
+func (s *Schema) ObjectClass(name string) (*ObjectClass, bool) {
+	o, ok := s.objectClassIndex[strings.ToLower(name)]
+	return o, ok
+}
+
+// MatchingRule returns the matching rule of the name or OID.
+func (s *Schema) MatchingRule(name string) (*MatchingRule, bool) {
+	m, ok := s.matchingRuleIndex[strings.ToLower(name)]
+	return m, ok
+}
+
+// Syntax returns the syntax of the OID.
+func (s *Schema) Syntax(oid string) (*Syntax, bool) {
+	x, ok := s.syntaxIndex[strings.ToLower(oid)]
+	return x, ok
+}
+
+// AttributeTypes returns the attribute types in the order they were added.
+func (s *Schema) AttributeTypes() []*AttributeType {
+	return append([]*AttributeType(nil), s.attributeTypes...)
+}
+
+// ObjectClasses returns the object classes in the order they were added.
+func (s *Schema) ObjectClasses() []*ObjectClass {
+	return append([]*ObjectClass(nil), s.objectClasses...)
+}
+
+// MatchingRules returns the matching rules in the order they were added.
+func (s *Schema) MatchingRules() []*MatchingRule {
+	return append([]*MatchingRule(nil), s.matchingRules...)
+}
+
+// Syntaxes returns the syntaxes in the order they were added.
+func (s *Schema) Syntaxes() []*Syntax {
+	return append([]*Syntax(nil), s.syntaxes...)
+}
+
+// Name returns the first name of the attribute type, its OID if it has
+// none.
+func (a *AttributeType) Name() string {
+	if len(a.Names) > 0 {
+		return a.Names[0]
+	}
+	return a.OID
+}
+
+// Operational reports whether the attribute type is operational, its
+// usage not being userApplications.
+func (a *AttributeType) Operational() bool {
+	return a.Usage != "" && a.Usage != UserApplications
+}
+
+// Name returns the first name of the object class, its OID if it has
+// none.
+func (o *ObjectClass) Name() string {
+	if len(o.Names) > 0 {
+		return o.Names[0]
+	}
+	return o.OID
+}
+
+// String returns the attribute type description (RFC 4512 section 4.1.2).
+func (a *AttributeType) String() string {
+	var b definitionBuilder
+	b.start(a.OID)
+	b.qdescrs("NAME", a.Names)
+	b.qdstring("DESC", a.Description)
+	b.flag("OBSOLETE", a.Obsolete)
+	b.oids("SUP", optional(a.Superior))
+	b.oids("EQUALITY", optional(a.Equality))
+	b.oids("ORDERING", optional(a.Ordering))
+	b.oids("SUBSTR", optional(a.Substring))
+	if a.Syntax != "" {
+		syntax := a.Syntax
+		if a.SyntaxLength > 0 {
+			syntax += "{" + strconv.Itoa(a.SyntaxLength) + "}"
+		}
+		b.oids("SYNTAX", []string{syntax})
+	}
+	b.flag("SINGLE-VALUE", a.SingleValue)
+	b.flag("COLLECTIVE", a.Collective)
+	b.flag("NO-USER-MODIFICATION", a.NoUserModification)
+	b.oids("USAGE", optional(a.Usage))
+	return b.end(a.Extensions)
+}
+
+// String returns the object class description (RFC 4512 section 4.1.1).
+func (o *ObjectClass) String() string {
+	var b definitionBuilder
+	b.start(o.OID)
+	b.qdescrs("NAME", o.Names)
+	b.qdstring("DESC", o.Description)
+	b.flag("OBSOLETE", o.Obsolete)
+	b.oids("SUP", o.Superiors)
+	b.flag(o.Kind, o.Kind != "")
+	b.oids("MUST", o.Must)
+	b.oids("MAY", o.May)
+	return b.end(o.Extensions)
+}
+
+// String returns the matching rule description (RFC 4512 section 4.1.3).
+func (m *MatchingRule) String() string {
+	var b definitionBuilder
+	b.start(m.OID)
+	b.qdescrs("NAME", m.Names)
+	b.qdstring("DESC", m.Description)
+	b.flag("OBSOLETE", m.Obsolete)
+	b.oids("SYNTAX", optional(m.Syntax))
+	return b.end(m.Extensions)
+}
+
+// String returns the syntax description (RFC 4512 section 4.1.5).
+func (x *Syntax) String() string {
+	var b definitionBuilder
+	b.start(x.OID)
+	b.qdstring("DESC", x.Description)
+	return b.end(x.Extensions)
+}
+
+// ValidateEntry checks an entry against the schema (RFC 4512 section
+// 2.4), returning an *Error with the result code of the first violation:
+//
+//   - objectClassViolation when the entry has no object class, an unknown
+//     one, no structural object class or several unrelated ones, misses
+//     an attribute required by its object classes, or has an attribute
+//     they do not allow, unless it is an extensibleObject,
+//   - undefinedAttributeType for the attributes not in the schema,
+//   - constraintViolation for the single-valued attributes with several
+//     values,
+//   - invalidAttributeSyntax for the values invalid for the syntax of
+//     their attribute, checked for the usual syntaxes only.
+func (s *Schema) ValidateEntry(e *Entry) error {
+	classes, err := s.entryClasses(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.structural(classes); err != nil {
+		return err
+	}
+
+	allowed := make(map[*AttributeType]bool)
+	extensible := false
+	for _, o := range classes {
+		extensible = extensible || strings.EqualFold(o.Name(), "extensibleObject")
+		for _, name := range o.May {
+			if a, ok := s.AttributeType(name); ok {
+				allowed[a] = true
+			}
+		}
+	}
+	present := make(map[*AttributeType]bool)
+	for _, attr := range e.Attributes {
+		a, ok := s.AttributeType(attr.Name)
+		if !ok {
+			return NewError(LDAPResultUndefinedAttributeType, "unknown attribute type "+attr.Name)
+		}
+		present[a] = true
+		if a.SingleValue && len(attr.Values) > 1 {
+			return NewError(LDAPResultConstraintViolation, "attribute "+attr.Name+" is single valued")
+		}
+		if validate := syntaxValidators[s.syntaxOf(a)]; validate != nil {
+			for _, v := range attr.Values {
+				if !validate(v) {
+					return NewError(LDAPResultInvalidAttributeSyntax, "invalid value for attribute "+attr.Name+": "+v)
+				}
+			}
+		}
+	}
+	for _, o := range classes {
+		for _, name := range o.Must {
+			a, ok := s.AttributeType(name)
+			if !ok {
+				continue
+			}
+			if !present[a] {
+				return NewError(LDAPResultObjectClassViolation, "object class "+o.Name()+" requires attribute "+a.Name())
+			}
+			allowed[a] = true
+		}
+	}
+	if !extensible {
+		for _, attr := range e.Attributes {
+			if a, _ := s.AttributeType(attr.Name); !allowed[a] && !a.Operational() {
+				return NewError(LDAPResultObjectClassViolation, "attribute "+attr.Name+" not allowed")
+			}
+		}
+	}
+	return nil
+}
+
+// StructuralObjectClass returns the structural object class of the entry:
+// the most subordinate of its structural object classes. It fails with
+// objectClassViolation as ValidateEntry.
+func (s *Schema) StructuralObjectClass(e *Entry) (*ObjectClass, error) {
+	classes, err := s.entryClasses(e)
+	if err != nil {
+		return nil, err
+	}
+	return s.structural(classes)
+}
+
+// entryClasses returns the object classes of the entry with their
+// superiors.
+func (s *Schema) entryClasses(e *Entry) ([]*ObjectClass, error) {
+	names := e.Get("objectClass")
+	if len(names) == 0 {
+		return nil, NewError(LDAPResultObjectClassViolation, "no objectClass attribute")
+	}
+	var classes []*ObjectClass
+	seen := make(map[*ObjectClass]bool)
+	var add func(name string, given bool) error
+	add = func(name string, given bool) error {
+		o, ok := s.ObjectClass(name)
+		if !ok {
+			if given {
+				return NewError(LDAPResultObjectClassViolation, "unknown object class "+name)
+			}
+			return nil
+		}
+		if seen[o] {
+			return nil
+		}
+		seen[o] = true
+		classes = append(classes, o)
+		for _, sup := range o.Superiors {
+			if err := add(sup, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := add(name, true); err != nil {
+			return nil, err
+		}
+	}
+	return classes, nil
+}
+
+// structural returns the structural class of classes, the superiors of
+// which are the other structural classes.
+func (s *Schema) structural(classes []*ObjectClass) (*ObjectClass, error) {
+	var structural []*ObjectClass
+	for _, o := range classes {
+		if o.Kind == ObjectClassStructural {
+			structural = append(structural, o)
+		}
+	}
+	for _, o := range structural {
+		if len(s.superiors(o)) == len(structural)-1 {
+			return o, nil
+		}
+	}
+	if len(structural) == 0 {
+		return nil, NewError(LDAPResultObjectClassViolation, "no structural object class")
+	}
+	names := make([]string, len(structural))
+	for i, o := range structural {
+		names[i] = o.Name()
+	}
+	return nil, NewError(LDAPResultObjectClassViolation, "unrelated structural object classes "+strings.Join(names, ", "))
+}
+
+// superiors returns the structural superiors of the object class.
+func (s *Schema) superiors(o *ObjectClass) map[*ObjectClass]bool {
+	res := make(map[*ObjectClass]bool)
+	var walk func(o *ObjectClass)
+	walk = func(o *ObjectClass) {
+		for _, name := range o.Superiors {
+			if sup, ok := s.ObjectClass(name); ok && !res[sup] {
+				if sup.Kind == ObjectClassStructural {
+					res[sup] = true
+				}
+				walk(sup)
+			}
+		}
+	}
+	walk(o)
+	return res
+}
+
+// syntaxOf returns the syntax of the attribute type, inherited from its
+// superiors when not given.
+func (s *Schema) syntaxOf(a *AttributeType) string {
+	for i := 0; a != nil && i < 16; i++ {
+		if a.Syntax != "" {
+			return a.Syntax
+		}
+		a, _ = s.AttributeType(a.Superior)
+	}
+	return ""
+}
+
+// syntaxValidators check the values of the usual syntaxes (RFC 4517
+// section 3.3), by OID.
+var syntaxValidators = map[string]func(v string) bool{
+	// Boolean
+	"1.3.6.1.4.1.1466.115.121.1.7": func(v string) bool { return v == "TRUE" || v == "FALSE" },
+	// DN
+	"1.3.6.1.4.1.1466.115.121.1.12": func(v string) bool {
+		_, err := ParseDN(v)
+		return err == nil
+	},
+	// Directory String
+	"1.3.6.1.4.1.1466.115.121.1.15": func(v string) bool { return v != "" && utf8.ValidString(v) },
+	// IA5 String
+	"1.3.6.1.4.1.1466.115.121.1.26": func(v string) bool {
+		for i := 0; i < len(v); i++ {
+			if v[i] >= 0x80 {
+				return false
+			}
+		}
+		return true
+	},
+	// INTEGER
+	"1.3.6.1.4.1.1466.115.121.1.27": func(v string) bool {
+		_, ok := normalizeInteger(v)
+		return ok
+	},
+	// Numeric String
+	"1.3.6.1.4.1.1466.115.121.1.36": func(v string) bool {
+		return v != "" && strings.Trim(v, "0123456789 ") == ""
+	},
+}
+
+// definition is a parsed RFC 4512 description: its OID and the values of
+// its fields by keyword, the flags having no value.
+type definition struct {
+	oid        string
+	fields     map[string][]string
+	extensions []Extension
+}
+
+func (d *definition) has(keyword string) bool {
+	_, ok := d.fields[keyword]
+	return ok
+}
+
+func (d *definition) first(keyword string) string {
+	if values := d.fields[keyword]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// definitionFlags are the keywords without value.
+var definitionFlags = map[string]bool{
+	"OBSOLETE": true, "SINGLE-VALUE": true, "COLLECTIVE": true, "NO-USER-MODIFICATION": true,
+	ObjectClassStructural: true, ObjectClassAbstract: true, ObjectClassAuxiliary: true,
+}
+
+// parseDefinition parses an RFC 4512 description: "(", a numeric OID, the
+// fields and ")". The quoted strings, the lists in parentheses and the
+// OIDs separated by "$" are returned as the values of the fields.
+func parseDefinition(s string) (*definition, error) {
+	tokens, err := definitionTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid definition %q: %s", s, reason)
+	}
+	if len(tokens) < 3 || tokens[0] != "(" || tokens[len(tokens)-1] != ")" {
+		return nil, invalid("missing parentheses")
+	}
+	d := &definition{oid: tokens[1], fields: make(map[string][]string)}
+	if d.oid == "(" || d.oid == ")" || strings.HasPrefix(d.oid, "'") {
+		return nil, invalid("missing OID")
+	}
+	tokens = tokens[2 : len(tokens)-1]
+	for len(tokens) > 0 {
+		keyword := tokens[0]
+		tokens = tokens[1:]
+		if strings.HasPrefix(keyword, "'") || keyword == "(" || keyword == ")" || keyword == "$" {
+			return nil, invalid("unexpected " + keyword)
+		}
+		var values []string
+		switch {
+		case definitionFlags[keyword]:
+		case len(tokens) == 0:
+			return nil, invalid("missing value of " + keyword)
+		case tokens[0] == "(":
+			end := 1
+			for end < len(tokens) && tokens[end] != ")" {
+				end++
+			}
+			if end == len(tokens) {
+				return nil, invalid("missing ')'")
+			}
+			for _, t := range tokens[1:end] {
+				if t != "$" {
+					values = append(values, strings.TrimPrefix(t, "'"))
+				}
+			}
+			tokens = tokens[end+1:]
+		default:
+			values = []string{strings.TrimPrefix(tokens[0], "'")}
+			tokens = tokens[1:]
+		}
+		if strings.HasPrefix(keyword, "X-") {
+			d.extensions = append(d.extensions, Extension{Name: keyword, Values: values})
+			continue
+		}
+		d.fields[keyword] = values
+	}
+	return d, nil
+}
+
+// definitionTokens splits a description into "(", ")", "$", the words and
+// the quoted strings, unescaped and kept with their leading quote.
+func definitionTokens(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '$':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid definition %q: unterminated string", s)
+			}
+			value := s[i+1 : i+1+end]
+			value = strings.NewReplacer(`\27`, "'", `\5C`, `\`, `\5c`, `\`).Replace(value)
+			tokens = append(tokens, "'"+value)
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\n\r()$'", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+// definitionBuilder writes RFC 4512 descriptions.
+type definitionBuilder struct {
+	strings.Builder
+}
+
+func (b *definitionBuilder) start(oid string) {
+	b.WriteString("( " + oid)
+}
+
+func (b *definitionBuilder) end(extensions []Extension) string {
+	for _, x := range extensions {
+		b.qdescrs(x.Name, x.Values)
+	}
+	b.WriteString(" )")
+	return b.String()
+}
+
+func (b *definitionBuilder) flag(keyword string, set bool) {
+	if set {
+		b.WriteString(" " + keyword)
+	}
+}
+
+func (b *definitionBuilder) qdstring(keyword, value string) {
+	if value != "" {
+		b.WriteString(" " + keyword + " " + quoteDefinition(value))
+	}
+}
+
+func (b *definitionBuilder) qdescrs(keyword string, values []string) {
+	switch len(values) {
+	case 0:
+	case 1:
+		b.WriteString(" " + keyword + " " + quoteDefinition(values[0]))
+	default:
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = quoteDefinition(v)
+		}
+		b.WriteString(" " + keyword + " ( " + strings.Join(quoted, " ") + " )")
+	}
+}
+
+func (b *definitionBuilder) oids(keyword string, values []string) {
+	switch len(values) {
+	case 0:
+	case 1:
+		b.WriteString(" " + keyword + " " + values[0])
+	default:
+		b.WriteString(" " + keyword + " ( " + strings.Join(values, " $ ") + " )")
+	}
+}
+
+func quoteDefinition(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\5C`, "'", `\27`).Replace(s) + "'"
+}
+
+func optional(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
@@ -0,0 +1,130 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ErrSearchDone is returned by the SearchResponseWriter methods once the
+// SearchResultDone was written.
+var ErrSearchDone = errors.New("ldapserver: search already done")
+
+// SearchResponseWriter writes the results of a search request in order:
+// entries and references, then a single SearchResultDone. Its methods
+// return the context error once the request is abandoned, so that the
+// handler can stop, and ErrSearchDone after the SearchResultDone.
+//
+//	routes.Search(ldap.SearchHandler(func(ctx context.Context, sw *ldap.SearchResponseWriter, m *ldap.Message) {
+//		for _, e := range entries {
+//			if err := sw.WriteEntry(e); err != nil {
+//				return
+//			}
+//		}
+//		sw.WriteDone(ldap.LDAPResultSuccess)
+//	}))
+//
+// It is also a ResponseWriter, so it can be put in front of the other
+// search writers.
+type SearchResponseWriter struct {
+	w   ResponseWriter
+	ctx context.Context
+
+	mu      sync.Mutex
+	entries int
+	refs    int
+	done    bool
+}
+
+// NewSearchResponseWriter returns the writer of the results of a search
+// served with the context ctx.
+func NewSearchResponseWriter(ctx context.Context, w ResponseWriter) *SearchResponseWriter {
+	return &SearchResponseWriter{w: w, ctx: ctx}
+}
+
+// SearchHandler adapts a search handler using a SearchResponseWriter. If
+// the handler returns without writing the SearchResultDone, the search
+// ends with operationsError.
+func SearchHandler(f func(ctx context.Context, sw *SearchResponseWriter, m *Message)) HandlerFunc {
+	return func(ctx context.Context, w ResponseWriter, m *Message) {
+		sw := NewSearchResponseWriter(ctx, w)
+		f(ctx, sw, m)
+		sw.finish()
+	}
+}
+
+// WriteEntry writes a search result entry.
+func (sw *SearchResponseWriter) WriteEntry(e ldap.SearchResultEntry, controls ...Control) error {
+	return sw.write(e, controls)
+}
+
+// WriteReference writes a search result reference to the urls.
+func (sw *SearchResponseWriter) WriteReference(urls ...string) error {
+	return sw.write(NewSearchResultReference(urls...), nil)
+}
+
+// WriteDone ends the search with the result code. It is written even when
+// the request is abandoned, in case the client still waits for it.
+func (sw *SearchResponseWriter) WriteDone(resultCode int, controls ...Control) error {
+	return sw.WriteResult(NewSearchResultDoneResponse(resultCode), controls...)
+}
+
+// WriteResult ends the search with res, e.g. with a diagnostic message.
+func (sw *SearchResponseWriter) WriteResult(res ldap.SearchResultDone, controls ...Control) error {
+	return sw.write(res, controls)
+}
+
+// Count returns the number of entries and references written.
+func (sw *SearchResponseWriter) Count() (entries, references int) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.entries, sw.refs
+}
+
+// Done reports whether the SearchResultDone was written.
+func (sw *SearchResponseWriter) Done() bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.done
+}
+
+func (sw *SearchResponseWriter) Write(po ldap.ProtocolOp) error {
+	return sw.write(po, nil)
+}
+
+func (sw *SearchResponseWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	return sw.write(po, controls)
+}
+
+func (sw *SearchResponseWriter) write(po ldap.ProtocolOp, controls []Control) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.done {
+		return ErrSearchDone
+	}
+	switch po.(type) {
+	case ldap.SearchResultDone:
+		sw.done = true
+	default:
+		if err := sw.ctx.Err(); err != nil {
+			return err
+		}
+		switch po.(type) {
+		case ldap.SearchResultEntry:
+			sw.entries++
+		case ldap.SearchResultReference:
+			sw.refs++
+		}
+	}
+	return sw.w.WriteWithControls(po, controls...)
+}
+
+// finish ends the search with operationsError if it is not done.
+func (sw *SearchResponseWriter) finish() {
+	var res ldap.LDAPResult
+	res.SetResultCode(LDAPResultOperationsError)
+	res.SetDiagnosticMessage("search ended without result")
+	sw.WriteResult(ldap.SearchResultDone(res))
+}
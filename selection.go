@@ -0,0 +1,133 @@
+package ldapserver
+
+import (
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// SelectAttributes returns the search result entry of e with the attributes
+// requested by a search (RFC 4511 section 4.5.1.8):
+//
+//   - an empty selection or "*" selects all the user attributes,
+//   - "+" selects all the operational attributes (RFC 3673),
+//   - "1.1" alone selects no attribute,
+//   - other names select the attribute of that name, compared case
+//     insensitively, with its subtypes: "cn" selects "cn;lang-fr".
+//
+// Operational attributes are only returned when named or with "+". With
+// typesOnly, the attributes are returned without their values.
+//
+//	for _, e := range entries {
+//		w.Write(ldap.SelectAttributes(e, r.Attributes(), bool(r.TypesOnly())))
+//	}
+func SelectAttributes(e *Entry, selection ldap.AttributeSelection, typesOnly bool) ldap.SearchResultEntry {
+	user := len(selection) == 0
+	operational := false
+	var names []string
+	for _, s := range selection {
+		switch name := string(s); name {
+		case "*":
+			user = true
+		case "+":
+			operational = true
+		case "1.1":
+		default:
+			names = append(names, name)
+		}
+	}
+
+	r := NewSearchResultEntry(e.DN)
+	for _, a := range e.Attributes {
+		if isOperational(a.Name) {
+			if !operational && !selected(a.Name, names) {
+				continue
+			}
+		} else if !user && !selected(a.Name, names) {
+			continue
+		}
+		var values []ldap.AttributeValue
+		if !typesOnly {
+			values = make([]ldap.AttributeValue, len(a.Values))
+			for i, v := range a.Values {
+				values[i] = ldap.AttributeValue(v)
+			}
+		}
+		r.AddAttribute(ldap.AttributeDescription(a.Name), values...)
+	}
+	return r
+}
+
+// selected reports whether the attribute description is selected by one of
+// the names: the same description, or its attribute type without options.
+func selected(description string, names []string) bool {
+	attrType, _, _ := strings.Cut(description, ";")
+	for _, name := range names {
+		if strings.EqualFold(name, description) || strings.EqualFold(name, attrType) {
+			return true
+		}
+	}
+	return false
+}
+
+// operationalAttributes are the well-known operational attributes, lower
+// cased, only returned by searches naming them or "+".
+var operationalAttributes = map[string]bool{
+	// RFC 4512
+	"createtimestamp":         true,
+	"modifytimestamp":         true,
+	"creatorsname":            true,
+	"modifiersname":           true,
+	"structuralobjectclass":   true,
+	"governingstructurerule":  true,
+	"subschemasubentry":       true,
+	"altserver":               true,
+	"namingcontexts":          true,
+	"supportedcontrol":        true,
+	"supportedextension":      true,
+	"supportedfeatures":       true,
+	"supportedldapversion":    true,
+	"supportedsaslmechanisms": true,
+	"attributetypes":          true,
+	"objectclasses":           true,
+	"matchingrules":           true,
+	"matchingruleuse":         true,
+	"ldapsyntaxes":            true,
+	"ditcontentrules":         true,
+	"ditstructurerules":       true,
+	"nameforms":               true,
+	// RFC 3045
+	"vendorname":    true,
+	"vendorversion": true,
+	// RFC 4530
+	"entryuuid": true,
+	// RFC 5020
+	"entrydn": true,
+	// X.501 and draft-boreham-numsubordinates
+	"hassubordinates": true,
+	"numsubordinates": true,
+	// RFC 2589
+	"entryttl": true,
+	// draft-behera-ldap-password-policy
+	"pwdchangedtime":       true,
+	"pwdaccountlockedtime": true,
+	"pwdfailuretime":       true,
+	"pwdhistory":           true,
+	"pwdgraceusetime":      true,
+	"pwdreset":             true,
+	"pwdpolicysubentry":    true,
+	// RFC 4533
+	"entrycsn":   true,
+	"contextcsn": true,
+	// draft-good-ldap-changelog
+	"changelog":         true,
+	"firstchangenumber": true,
+	"lastchangenumber":  true,
+}
+
+// isOperational reports whether the attribute description is a well-known
+// operational attribute.
+func isOperational(description string) bool {
+	attrType, _, _ := strings.Cut(description, ";")
+	return operationalAttributes[strings.ToLower(attrType)]
+}
@@ -2,9 +2,15 @@ package ldapserver
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,16 +21,186 @@ type Server struct {
 	wg           sync.WaitGroup // group of goroutines (1 by client)
 	chDone       chan bool      // Channel Done, value => shutdown
 
+	// TLSConfig, when set, enables the built-in StartTLS extended
+	// operation: the connection is upgraded in place with tls.Server.
+	// When nil, StartTLS requests reach the handler like any other
+	// extended request.
+	TLSConfig *tls.Config
+
+	// CertMapper, if set, maps the verified client certificate of a TLS
+	// connection to a DN identifying the connection. Request client
+	// certificates with tls.Config.ClientAuth, e.g.
+	// tls.RequireAndVerifyClientCert. The DN is available to handlers
+	// through Client.ClientCertDN and satisfies SASL EXTERNAL binds. If
+	// the mapper fails, the connection is closed.
+	CertMapper func(*x509.Certificate) (dn string, err error)
+
+	// CertManager, if set, selects the certificate of LDAPS and StartTLS
+	// handshakes, overriding the certificates of the TLS configuration.
+	CertManager *CertManager
+
+	// UnixSocketMode, if not zero, sets the permissions of the socket
+	// file of ldapi:// listeners.
+	UnixSocketMode os.FileMode
+
+	// AcceptProxyProtocol makes the server expect a HAProxy PROXY
+	// protocol header (v1 or v2) at the start of every TCP connection
+	// accepted by ListenAndServe and ListenAndServeTLS, so Client.Addr
+	// reports the real client behind the proxy. Connections without a
+	// valid header are closed. Wrap listeners given to Serve with
+	// ProxyProtocolListener instead.
+	AcceptProxyProtocol bool
+
+	// ProxyHeaderTimeout bounds the time to receive the PROXY header,
+	// DefaultProxyHeaderTimeout if zero.
+	ProxyHeaderTimeout time.Duration
+
+	// TCPKeepAlive sets the keep-alive period of accepted TCP
+	// connections. Zero keeps the Go default (enabled, 15s), a negative
+	// value disables keep-alives.
+	TCPKeepAlive time.Duration
+
+	// DisableNoDelay enables Nagle's algorithm on accepted TCP
+	// connections. Go sets TCP_NODELAY by default, which suits LDAP.
+	DisableNoDelay bool
+
+	// Linger, if positive, sets SO_LINGER on accepted TCP connections:
+	// Close blocks until unsent data is sent or the timeout expires.
+	Linger time.Duration
+
+	// OnAccept, if set, is called for every new connection, once its
+	// PROXY protocol header is read, before any client state is
+	// allocated. Returning an error closes the connection immediately,
+	// e.g. to enforce IP allow/deny lists.
+	OnAccept func(net.Conn) error
+
+	// MaxConnections limits the number of simultaneous client
+	// connections, 0 means no limit.
+	MaxConnections int
+
+	// MaxConnectionsPerIP limits the number of simultaneous connections
+	// from a single client IP address, 0 means no limit.
+	MaxConnectionsPerIP int
+
+	// BusyNotice makes the server answer connections refused by
+	// MaxConnections or MaxConnectionsPerIP with a Notice of Disconnection
+	// (resultCode busy) instead of just closing them.
+	BusyNotice bool
+
+	// SaslMechanisms are the SASL mechanisms run by the server, keyed by
+	// upper case name. SASL binds with other mechanisms reach the handler,
+	// which can still run a multi-step exchange, see Client.SetSaslState.
+	SaslMechanisms map[string]SaslMechanism
+
+	// RequireAuthentication answers the search, compare and update
+	// requests of anonymous sessions with insufficientAccessRights, so
+	// handlers only see bound sessions. Base searches of the root DSE
+	// are still allowed.
+	RequireAuthentication bool
+
+	// BindThrottle, if set, delays and locks out repeated failed binds
+	// from a client IP address or for a DN.
+	BindThrottle *BindThrottle
+
+	// ProxyAuthorizer, if set, handles the Proxied Authorization control
+	// (RFC 4370): requests carrying it reach the handler only when
+	// allowed, with the identity in ProxiedAuthorizationFromContext.
+	// When nil, the control is left to the handlers.
+	ProxyAuthorizer ProxyAuthorizer
+
+	// WhoAmI answers the Who Am I? extended operation (RFC 4532) with the
+	// authorization identity of the session, before the handlers.
+	WhoAmI bool
+
+	// UnansweredResult is the result code sent when a handler returns
+	// without writing the final response of its request, so that the
+	// client does not wait forever: operationsError if zero, nothing if
+	// negative.
+	UnansweredResult int
+
+	// EnforceSearchLimits makes the server honor the sizeLimit and
+	// timeLimit of search requests: the context of the handler expires
+	// after timeLimit and the search ends with sizeLimitExceeded or
+	// timeLimitExceeded when the handler goes beyond the limits.
+	EnforceSearchLimits bool
+
+	// SortMemoryLimit is the memory budget of the result sets held by
+	// SortedSearchWriter and VLVSearchWriter, which spill beyond it to
+	// temporary files in SortTempDir, os.TempDir() if empty; it is
+	// DefaultSortMemoryLimit if zero. SortMaxEntries and SortMaxBytes cap
+	// their number of entries and size, 0 meaning no cap: the larger
+	// result sets get adminLimitExceeded.
+	SortMemoryLimit int
+	SortMaxEntries  int
+	SortMaxBytes    int64
+	SortTempDir     string
+
+	// Transactions, if set, handles LDAP transactions (RFC 5805): updates
+	// carrying the Transaction Specification control are queued and run
+	// through the handlers at commit, atomically with the backend.
+	Transactions TransactionBackend
+
 	// HandleConnection is called on new connections.
 	HandleConnection func(c net.Conn) Handler
 
+	// BaseContext optionally returns the base context of the requests
+	// served on a listener, context.Background() if nil. It must not
+	// return nil.
+	BaseContext func(net.Listener) context.Context
+
+	// ConnContext optionally derives the context of the requests of a new
+	// connection from the base context, e.g. to attach a tenant ID. It
+	// must not return nil.
+	ConnContext func(ctx context.Context, c net.Conn) context.Context
+
+	// ConnState, if set, is called when a client connection changes
+	// state, see ConnState. It is not called for CLDAP requests.
+	ConnState func(net.Conn, ConnState)
+
+	// OnDisconnect, if set, is called once a client connection is closed,
+	// after an unbind request or not, so that applications can release
+	// the resources they hold for it.
+	OnDisconnect func(ClientInfo)
+
 	// DebugLogger can be useful for development.
 	DebugLogger func(string)
 
-	mu        sync.Mutex
-	listeners map[*net.Listener]struct{}
+	// ErrorLogger receives the errors of the server, such as the panics
+	// of handlers with their stack. The standard logger is used if nil.
+	ErrorLogger func(string)
+
+	// DropOnPanic closes the connection of a request whose handler
+	// panicked, after answering the request with other. By default the
+	// connection stays open.
+	DropOnPanic bool
+
+	// NotificationQueueSize is the number of events buffered for each
+	// Dispatcher subscription, DefaultNotificationQueueSize if zero.
+	NotificationQueueSize int
+
+	// NotifyTimeout bounds the wait of Client.Notify and Broadcast for a
+	// client to take a notification, DefaultNotifyTimeout if zero.
+	NotifyTimeout time.Duration
+
+	// MaxSubscriptionsPerConn limits the number of Dispatcher
+	// subscriptions a single connection may hold, 0 means no limit.
+	MaxSubscriptionsPerConn int
+
+	numero      atomic.Int64 // last client number, unique across listeners
+	mu          sync.Mutex
+	listeners   map[*net.Listener]struct{}
+	packetConns map[net.PacketConn]struct{}
+	dispatcher  *Dispatcher
+	connCount   int
+	connsPerIP  map[string]int
+	clients     map[*client]struct{}
+	inShutdown  atomic.Bool
 }
 
+// ErrServerClosed is returned by Serve, ServeUDP and the ListenAndServe
+// functions after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("ldapserver: Server closed")
+
 func (s *Server) log(msg string) {
 	if s.DebugLogger != nil {
 		s.DebugLogger(msg)
@@ -39,30 +215,82 @@ func (s *Server) logf(format string, a ...any) {
 
 // ListenAndServe listens on the TCP network address s.Addr and then
 // calls Serve to handle requests on incoming connections.  If
-// s.Addr is blank, ":389" is used.
+// s.Addr is blank, ":389" is used. An "ldapi://" address listens on a
+// unix socket instead, see DefaultLDAPISocket.
 func (s *Server) ListenAndServe(addr string) error {
 	if addr == "" {
 		addr = ":389"
 	}
 
-	listener, err := net.Listen("tcp", addr)
+	cfg := &ListenerConfig{Addr: addr, ProxyProtocol: s.AcceptProxyProtocol}
+	listener, err := s.listen(cfg)
 	if err != nil {
 		return err
 	}
 	defer listener.Close()
 
-	return s.Serve(listener)
+	return s.serve(listener, cfg)
+}
+
+// ListenAndServeTLS listens on the TCP network address addr and serves
+// LDAPS: incoming connections are wrapped with tls.Server using tlsConfig.
+// If addr is blank, ":636" is used. Handlers get the negotiated
+// tls.ConnectionState with Client.TLSConnectionState.
+func (s *Server) ListenAndServeTLS(addr string, tlsConfig *tls.Config) error {
+	if addr == "" {
+		addr = ":636"
+	}
+	if tlsConfig == nil && s.CertManager == nil {
+		return fmt.Errorf("no TLS configuration defined")
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{} // certificates come from the CertManager
+	}
+	cfg := &ListenerConfig{Addr: addr, TLSConfig: tlsConfig, ProxyProtocol: s.AcceptProxyProtocol}
+	listener, err := s.listen(cfg)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	return s.serve(listener, cfg)
+}
+
+// tlsConfig returns the configuration to use for a handshake, plugging in
+// the CertManager when there is one.
+func (s *Server) tlsConfig(cfg *tls.Config) *tls.Config {
+	if s.CertManager != nil {
+		return s.CertManager.TLSConfig(cfg)
+	}
+	return cfg
 }
 
 func (s *Server) Serve(listener net.Listener) error {
+	return s.serve(listener, nil)
+}
+
+// serve runs the accept loop of a listener. cfg holds the per-listener
+// settings, it is nil for listeners given to Serve.
+func (s *Server) serve(listener net.Listener, cfg *ListenerConfig) error {
 	if s.HandleConnection == nil {
 		return fmt.Errorf("no LDAP Request Handler defined")
 	}
 
-	s.mu.Lock()
-	if s.chDone == nil {
-		s.chDone = make(chan bool)
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	baseCtx := context.Background()
+	if s.BaseContext != nil {
+		baseCtx = s.BaseContext(listener)
+		if baseCtx == nil {
+			panic("ldapserver: BaseContext returned a nil context")
+		}
 	}
+
+	s.mu.Lock()
+	s.doneChanLocked()
 	if s.listeners == nil {
 		s.listeners = make(map[*net.Listener]struct{})
 	}
@@ -74,11 +302,12 @@ func (s *Server) Serve(listener net.Listener) error {
 		s.mu.Unlock()
 	}()
 
-	i := 0
-
 	for {
 		rw, err := listener.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
 			// Temporary is deprecated, but still used by net/http (2024-08-10)
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				time.Sleep(100 * time.Millisecond)
@@ -86,19 +315,182 @@ func (s *Server) Serve(listener net.Listener) error {
 			}
 			return err
 		}
+		// the PROXY header and the admission of the connection are
+		// waited for in its goroutine, not to stall the accept loop
+		s.wg.Add(1)
+		go func() {
+			cli, err := s.newClient(rw, cfg, baseCtx)
+			if err != nil {
+				s.wg.Done()
+				return
+			}
+			cli.serve()
+		}()
+	}
+}
 
-		i++
-		cli := &client{
-			Numero: i,
-			srv:    s,
-			rwc:    rw,
-			br:     bufio.NewReader(rw),
-			bw:     bufio.NewWriter(rw),
+var errTooManyConnections = errors.New("ldapserver: too many connections")
+
+// newClient admits an accepted connection and registers its client, to be
+// served with cli.serve(), which calls s.wg.Done() as the caller called
+// s.wg.Add(1). Refused connections are closed. It reads the PROXY header
+// of the connection, if any.
+func (s *Server) newClient(rw net.Conn, cfg *ListenerConfig, baseCtx context.Context) (*client, error) {
+	s.tuneConn(rw)
+	if err := proxyHeader(rw); err != nil {
+		s.logf("connection refused: %s", err)
+		return nil, err
+	}
+	if s.OnAccept != nil {
+		if err := s.OnAccept(rw); err != nil {
+			s.logf("connection from %s refused: %s", rw.RemoteAddr(), err)
+			rw.Close()
+			return nil, err
 		}
+	}
+	ip, ok := s.admit(rw)
+	if !ok {
+		s.logf("connection from %s refused: too many connections", rw.RemoteAddr())
+		s.refuse(rw)
+		return nil, errTooManyConnections
+	}
 
-		s.logf("Connection client [%d] from %s accepted", cli.Numero, cli.rwc.RemoteAddr().String())
-		s.wg.Add(1)
-		go cli.serve()
+	cli := &client{
+		Numero:      int(s.numero.Add(1)),
+		srv:         s,
+		listener:    cfg,
+		ip:          ip,
+		ctx:         s.connContext(baseCtx, rw),
+		conn:        rw,
+		connectedAt: time.Now(),
+		rwc:         rw,
+		br:          bufio.NewReader(rw),
+		bw:          bufio.NewWriter(rw),
+		chanOut:     make(chan *outMessage),
+		closing:     make(chan bool),
+	}
+
+	s.mu.Lock()
+	if s.shuttingDown() {
+		// Close did not see the client
+		s.mu.Unlock()
+		s.release(ip)
+		rw.Close()
+		return nil, ErrServerClosed
+	}
+	if s.clients == nil {
+		s.clients = make(map[*client]struct{})
+	}
+	s.clients[cli] = struct{}{}
+	s.mu.Unlock()
+
+	cli.setState(StateNew)
+	return cli, nil
+}
+
+// ServeConn serves a single connection accepted elsewhere, e.g. by a
+// connection multiplexer, an SSH channel or a net.Pipe in tests. It runs
+// like a connection accepted by Serve, OnAccept and the connection limits
+// included, and returns once the connection is closed. Request contexts
+// derive from context.Background() through ConnContext.
+func (s *Server) ServeConn(conn net.Conn) error {
+	if s.HandleConnection == nil {
+		return fmt.Errorf("no LDAP Request Handler defined")
+	}
+	if s.shuttingDown() {
+		conn.Close()
+		return ErrServerClosed
+	}
+
+	s.mu.Lock()
+	s.doneChanLocked()
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	cli, err := s.newClient(conn, nil, context.Background())
+	if err != nil {
+		s.wg.Done()
+		return err
+	}
+	cli.serve()
+	return nil
+}
+
+// connContext returns the context of the requests of a new connection.
+func (s *Server) connContext(ctx context.Context, conn net.Conn) context.Context {
+	if s.ConnContext == nil {
+		return ctx
+	}
+	ctx = s.ConnContext(ctx, conn)
+	if ctx == nil {
+		panic("ldapserver: ConnContext returned a nil context")
+	}
+	return ctx
+}
+
+// tuneConn applies the TCP settings to an accepted connection, looking
+// through TLS and PROXY protocol wrappers.
+func (s *Server) tuneConn(conn net.Conn) {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+			continue
+		case *proxyConn:
+			conn = c.Conn
+			continue
+		}
+		break
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if s.TCPKeepAlive > 0 {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(s.TCPKeepAlive)
+	} else if s.TCPKeepAlive < 0 {
+		tc.SetKeepAlive(false)
+	}
+	if s.DisableNoDelay {
+		tc.SetNoDelay(false)
+	}
+	if s.Linger > 0 {
+		tc.SetLinger(int((s.Linger + time.Second - 1) / time.Second))
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// doneChanLocked returns the channel closed when the server stops,
+// creating it if needed. s.mu must be held.
+func (s *Server) doneChanLocked() chan bool {
+	if s.chDone == nil {
+		s.chDone = make(chan bool)
+	}
+	return s.chDone
+}
+
+// closeLocked closes the listeners and signals clients the server is
+// stopping. s.mu must be held.
+func (s *Server) closeLocked() {
+	for listener := range s.listeners {
+		(*listener).Close()
+	}
+	clear(s.listeners)
+	for conn := range s.packetConns {
+		conn.Close()
+	}
+	clear(s.packetConns)
+
+	ch := s.doneChanLocked()
+	select {
+	case <-ch:
+	default:
+		close(ch)
 	}
 }
 
@@ -112,15 +504,44 @@ func (s *Server) Serve(listener net.Listener) error {
 // terminate the session by ceasing communication and closing the
 // transport connection.
 // In either case, when the LDAP session is terminated.
-func (s *Server) Shutdown() {
+//
+// Shutdown waits for the handlers in progress to return. If ctx is done
+// first, the remaining connections are closed as with Close and ctx.Err()
+// is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
 	s.mu.Lock()
-	for listener := range s.listeners {
-		(*listener).Close()
-	}
-	clear(s.listeners)
+	s.closeLocked()
 	s.mu.Unlock()
-	close(s.chDone)
 	s.log("gracefully closing client connections...")
-	s.wg.Wait()
-	s.log("all clients connection closed")
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.log("all clients connection closed")
+		return nil
+	case <-ctx.Done():
+		s.log("shutdown timed out, closing remaining client connections")
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes all listeners and client connections and
+// cancels the context of the requests in progress, without sending a
+// Notice of Disconnection or waiting for handlers to return. Use Shutdown
+// for a graceful stop.
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+	for c := range s.clients {
+		c.abort()
+	}
+	return nil
 }
@@ -0,0 +1,53 @@
+package ldapserver
+
+import "io"
+
+// Store sets the value of key in the session of the connection, for
+// handlers keeping state across the requests of a client, e.g. an upstream
+// connection. Keys should be of an unexported type, as for
+// context.WithValue. The values are dropped with the connection, those
+// implementing io.Closer being closed. It is safe for concurrent use.
+//
+//	type upstreamKey struct{}
+//
+//	m.Client.Store(upstreamKey{}, conn)
+//	...
+//	conn, ok := m.Client.Load(upstreamKey{})
+func (c *client) Store(key, value any) {
+	c.Lock()
+	defer c.Unlock()
+	if c.values == nil {
+		c.values = make(map[any]any)
+	}
+	c.values[key] = value
+}
+
+// Load returns the value of key in the session of the connection, ok is
+// false when it was not stored.
+func (c *client) Load(key any) (value any, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	value, ok = c.values[key]
+	return value, ok
+}
+
+// Delete removes key from the session of the connection.
+func (c *client) Delete(key any) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.values, key)
+}
+
+// closeValues closes the values of the session implementing io.Closer,
+// once the connection is closed.
+func (c *client) closeValues() {
+	c.Lock()
+	values := c.values
+	c.values = nil
+	c.Unlock()
+	for _, value := range values {
+		if closer, ok := value.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
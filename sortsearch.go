@@ -0,0 +1,351 @@
+package ldapserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ControlSortResponse is the OID of the sortResult response control (RFC
+// 2891).
+const ControlSortResponse ldap.LDAPOID = "1.2.840.113556.1.4.474"
+
+// Ordering matching rules supported by SortEntries, by OID and name.
+var orderingRules = map[string]func(a, b string) int{
+	"2.5.13.3":                     compareCaseIgnore,
+	"caseignoreorderingmatch":      compareCaseIgnore,
+	"2.5.13.5":                     strings.Compare,
+	"caseexactorderingmatch":       strings.Compare,
+	"2.5.13.9":                     compareNumericString,
+	"numericstringorderingmatch":   compareNumericString,
+	"2.5.13.15":                    compareInteger,
+	"integerorderingmatch":         compareInteger,
+	"2.5.13.28":                    strings.Compare,
+	"generalizedtimeorderingmatch": strings.Compare,
+	"2.5.13.13":                    strings.Compare, // octetStringOrderingMatch
+	"octetstringorderingmatch":     strings.Compare,
+}
+
+func compareCaseIgnore(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func compareNumericString(a, b string) int {
+	return strings.Compare(strings.ReplaceAll(a, " ", ""), strings.ReplaceAll(b, " ", ""))
+}
+
+func compareInteger(a, b string) int {
+	x, okX := new(big.Int).SetString(strings.TrimSpace(a), 10)
+	y, okY := new(big.Int).SetString(strings.TrimSpace(b), 10)
+	if !okX || !okY {
+		return strings.Compare(a, b)
+	}
+	return x.Cmp(y)
+}
+
+// SortError is returned by SortEntries when a sort key can not be used.
+type SortError struct {
+	ResultCode    int // sortResult code, e.g. inappropriateMatching
+	AttributeType string
+}
+
+func (e *SortError) Error() string {
+	return fmt.Sprintf("can not sort on %s (result code %d)", e.AttributeType, e.ResultCode)
+}
+
+// SortEntries sorts search result entries by the keys of a Server Side
+// Sorting control (RFC 2891 section 2.2): the first key decides, the next
+// ones break ties. Entries without the attribute come after the others.
+// The least value of multi-valued attributes is used, the greatest in
+// reverse order. Keys without ordering rule compare values ignoring case.
+func SortEntries(entries []ldap.SearchResultEntry, keys []SortKey) error {
+	sorter, err := newEntrySorter(keys)
+	if err != nil {
+		return err
+	}
+	items := make([]sortedEntry, len(entries))
+	for i, e := range entries {
+		if items[i], err = sorter.item(e, i); err != nil {
+			return err
+		}
+	}
+	sort.Slice(items, func(a, b int) bool { return sorter.less(items[a], items[b]) })
+	for i, item := range items {
+		entries[i] = item.entry
+	}
+	return nil
+}
+
+// entrySorter compares the entries of a result set by sort keys.
+type entrySorter struct {
+	keys     []SortKey
+	compares []func(a, b string) int
+}
+
+// sortedEntry is an entry of a result set with its sort values, nil when
+// the attribute is absent, and its position in the results, which keeps
+// the sort stable.
+type sortedEntry struct {
+	entry  ldap.SearchResultEntry
+	values []*string
+	seq    int
+}
+
+// newEntrySorter returns the sorter of the keys, failing with a
+// *SortError when a key has an unsupported ordering rule.
+func newEntrySorter(keys []SortKey) (*entrySorter, error) {
+	s := &entrySorter{keys: keys, compares: make([]func(a, b string) int, len(keys))}
+	for i, key := range keys {
+		if s.compares[i] = key.compare(); s.compares[i] == nil {
+			return nil, &SortError{ResultCode: LDAPResultInappropriateMatching, AttributeType: key.AttributeType}
+		}
+	}
+	return s, nil
+}
+
+// item returns the entry e at the position seq of the results, with its
+// sort values.
+func (s *entrySorter) item(e ldap.SearchResultEntry, seq int) (sortedEntry, error) {
+	_, attributes, err := decodeSearchResultEntry(e)
+	if err != nil {
+		return sortedEntry{}, err
+	}
+	values := make([]*string, len(s.keys))
+	for k, key := range s.keys {
+		values[k] = key.value(attributes, s.compares[k])
+	}
+	return sortedEntry{entry: e, values: values, seq: seq}, nil
+}
+
+func (s *entrySorter) less(a, b sortedEntry) bool {
+	for k, key := range s.keys {
+		switch {
+		case a.values[k] == nil && b.values[k] == nil:
+			continue
+		case a.values[k] == nil:
+			return key.Reverse
+		case b.values[k] == nil:
+			return !key.Reverse
+		}
+		c := s.compares[k](*a.values[k], *b.values[k])
+		if c == 0 {
+			continue
+		}
+		return (c < 0) != key.Reverse
+	}
+	return a.seq < b.seq
+}
+
+// spillSorter returns a SpillSorter of the entries of the client c, with
+// the limits of its server, sorting them with less.
+func (s *entrySorter) spillSorter(c *client, less func(a, b sortedEntry) bool) *SpillSorter[sortedEntry] {
+	spill := &SpillSorter[sortedEntry]{
+		Less:      less,
+		Marshal:   s.marshal,
+		Unmarshal: s.unmarshal,
+	}
+	if c != nil {
+		spill.MemoryLimit = c.srv.SortMemoryLimit
+		spill.MaxItems = c.srv.SortMaxEntries
+		spill.MaxBytes = c.srv.SortMaxBytes
+		spill.TempDir = c.srv.SortTempDir
+	}
+	return spill
+}
+
+// marshal encodes the item as its position followed by its entry; the
+// sort values are computed again by unmarshal.
+func (s *entrySorter) marshal(item sortedEntry) ([]byte, error) {
+	data, err := protocolOpBytes(item.entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.AppendUvarint(nil, uint64(item.seq)), data...), nil
+}
+
+func (s *entrySorter) unmarshal(data []byte) (sortedEntry, error) {
+	seq, n := binary.Uvarint(data)
+	if n <= 0 {
+		return sortedEntry{}, errors.New("malformed sort record")
+	}
+	po, err := decodeProtocolOp(data[n:])
+	if err != nil {
+		return sortedEntry{}, err
+	}
+	e, ok := po.(ldap.SearchResultEntry)
+	if !ok {
+		return sortedEntry{}, errors.New("malformed sort record")
+	}
+	return s.item(e, int(seq))
+}
+
+// compare returns the comparison function of the ordering rule of the key,
+// nil when it is not supported.
+func (key SortKey) compare() func(a, b string) int {
+	if key.OrderingRule == "" {
+		return compareCaseIgnore
+	}
+	return orderingRules[strings.ToLower(key.OrderingRule)]
+}
+
+// value returns the value of the entry attributes the key sorts on, nil
+// when the attribute is absent.
+func (key SortKey) value(attributes []EntryAttribute, compare func(a, b string) int) *string {
+	var value *string
+	for _, attr := range attributes {
+		if !strings.EqualFold(attr.Name, key.AttributeType) {
+			continue
+		}
+		for _, v := range attr.Values {
+			v := v
+			if value == nil || (compare(v, *value) < 0) != key.Reverse {
+				value = &v
+			}
+		}
+	}
+	return value
+}
+
+// SortedSearchWriter implements the Server Side Sorting control for a
+// search handler: entries written to it are held until the
+// SearchResultDone, then sorted and sent with the sortResult control. Put
+// it in front of a PagedSearchWriter to sort before paging:
+//
+//	pw := ldap.NewPagedSearchWriter(w, m)
+//	if pw.Resume() {
+//		return
+//	}
+//	sw := ldap.NewSortedSearchWriter(pw, m)
+//
+// The entries beyond Server.SortMemoryLimit are spilled to temporary
+// files. Beyond Server.SortMaxEntries or SortMaxBytes, the search fails
+// with adminLimitExceeded if the control is critical; otherwise the
+// entries are sent unsorted, the sortResult being adminLimitExceeded.
+//
+// Without the control, the results go straight to the ResponseWriter.
+type SortedSearchWriter struct {
+	ResponseWriter
+	keys      []SortKey
+	critical  bool
+	sorter    *entrySorter
+	entries   *SpillSorter[sortedEntry] // nil once the entries are sent
+	code      int                       // sortResult, the entries are sent unsorted unless success
+	attribute string                    // attribute of the sortResult
+	n         int                       // entries written
+}
+
+// NewSortedSearchWriter returns the writer of the results of the search
+// request m.
+func NewSortedSearchWriter(w ResponseWriter, m *Message) *SortedSearchWriter {
+	s := &SortedSearchWriter{ResponseWriter: w}
+	c, ok := m.Control(ControlSortRequest)
+	if !ok || c.Err != nil {
+		return s
+	}
+	s.keys = c.Decoded.([]SortKey)
+	s.critical = c.Critical
+	sorter, err := newEntrySorter(s.keys)
+	if err != nil {
+		se := err.(*SortError)
+		s.code, s.attribute = se.ResultCode, se.AttributeType
+		return s
+	}
+	s.sorter = sorter
+	s.entries = sorter.spillSorter(m.Client, sorter.less)
+	return s
+}
+
+func (s *SortedSearchWriter) Write(po ldap.ProtocolOp) error {
+	return s.WriteWithControls(po)
+}
+
+func (s *SortedSearchWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if s.keys == nil {
+		return s.ResponseWriter.WriteWithControls(po, controls...)
+	}
+	switch r := po.(type) {
+	case ldap.SearchResultEntry:
+		return s.add(r)
+	case ldap.SearchResultDone:
+		if s.code != LDAPResultSuccess && s.critical {
+			s.close()
+			// RFC 2891 section 1.1: a critical control fails the search
+			code := LDAPResultUnavailableCriticalExtension
+			if s.code == LDAPResultAdminLimitExceeded {
+				code = LDAPResultAdminLimitExceeded
+			}
+			return s.ResponseWriter.WriteWithControls(responseFor(ldap.SearchRequest{}, code, "can not sort"),
+				sortResultControl(s.code, s.attribute))
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+		return s.ResponseWriter.WriteWithControls(r, append(controls, sortResultControl(s.code, s.attribute))...)
+	}
+	return s.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// add holds the entry e for sorting. When the entries can not be sorted,
+// it is sent as is unless the control is critical.
+func (s *SortedSearchWriter) add(e ldap.SearchResultEntry) error {
+	if s.code == LDAPResultSuccess {
+		item, err := s.sorter.item(e, s.n)
+		if err == nil {
+			err = s.entries.Add(item)
+		}
+		s.n++
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, ErrSortLimitExceeded):
+			s.code = LDAPResultAdminLimitExceeded
+		default:
+			s.code = LDAPResultOperationsError
+		}
+		if s.critical {
+			s.close()
+			return nil
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	if s.critical {
+		return nil
+	}
+	return s.ResponseWriter.Write(e)
+}
+
+// flush sends the entries held, in order.
+func (s *SortedSearchWriter) flush() error {
+	if s.entries == nil {
+		return nil
+	}
+	defer s.close()
+	return s.entries.Each(func(item sortedEntry) error {
+		return s.ResponseWriter.Write(item.entry)
+	})
+}
+
+func (s *SortedSearchWriter) close() {
+	if s.entries != nil {
+		s.entries.Close()
+		s.entries = nil
+	}
+}
+
+// sortResultControl returns the sortResult response control.
+func sortResultControl(code int, attribute string) Control {
+	// SortResult ::= SEQUENCE { sortResult ENUMERATED,
+	//     attributeType [0] AttributeDescription OPTIONAL }
+	fields := [][]byte{berEnumerated(int64(code))}
+	if attribute != "" {
+		fields = append(fields, berEncode(berClassContext, false, 0, []byte(attribute)))
+	}
+	return Control{OID: ControlSortResponse, Value: berSequence(fields...)}
+}
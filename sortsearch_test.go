@@ -0,0 +1,139 @@
+package ldapserver
+
+import (
+	"fmt"
+	"testing"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// recorder is a ResponseWriter keeping the responses.
+type recorder struct {
+	responses []ldap.ProtocolOp
+	controls  [][]Control
+}
+
+func (r *recorder) Write(po ldap.ProtocolOp) error {
+	return r.WriteWithControls(po)
+}
+
+func (r *recorder) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	r.responses = append(r.responses, po)
+	r.controls = append(r.controls, controls)
+	return nil
+}
+
+// entryDNs returns the DNs of the entries written to r.
+func (r *recorder) entryDNs(t *testing.T) []string {
+	var dns []string
+	for _, po := range r.responses {
+		if e, ok := po.(ldap.SearchResultEntry); ok {
+			dn, _, err := decodeSearchResultEntry(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dns = append(dns, dn)
+		}
+	}
+	return dns
+}
+
+// testSearch returns the message of a search of the client c with the
+// controls.
+func testSearch(t *testing.T, c *client, controls ...Control) *Message {
+	r, err := NewSearchRequest("", SearchRequestHomeSubtree, "(cn=*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := newResponseMessage(1, r, controls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Message{LDAPMessage: message, Client: c}
+}
+
+// sortByCN is the Server Side Sorting control sorting by cn.
+func sortByCN(critical bool) Control {
+	return Control{OID: ControlSortRequest, Critical: critical, Value: berSequence(berSequence(berString("cn")))}
+}
+
+// writeEntries writes n entries with decreasing cn.
+func writeEntries(w ResponseWriter, n int) {
+	for i := n; i > 0; i-- {
+		cn := fmt.Sprintf("%04d", i)
+		w.Write(NewEntry("cn="+cn, map[string][]string{"cn": {cn}, "description": {"some text to spill"}}).SearchResultEntry())
+	}
+}
+
+// sortResultCode returns the sortResult of the final response of r.
+func sortResultCode(t *testing.T, r *recorder) int {
+	for _, c := range r.controls[len(r.controls)-1] {
+		if c.OID == ControlSortResponse {
+			seq, err := berParseAll(c.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields, _ := seq.children()
+			code, _ := fields[0].int()
+			return int(code)
+		}
+	}
+	t.Fatal("no sortResult control")
+	return 0
+}
+
+func TestSortedSearchWriterSpills(t *testing.T) {
+	srv := &Server{SortMemoryLimit: 512, SortTempDir: t.TempDir()}
+	r := &recorder{}
+	sw := NewSortedSearchWriter(r, testSearch(t, &client{srv: srv}, sortByCN(true)))
+	writeEntries(sw, 200)
+	if len(sw.entries.runs) == 0 {
+		t.Fatal("the entries were not spilled")
+	}
+	sw.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+
+	dns := r.entryDNs(t)
+	if len(dns) != 200 {
+		t.Fatalf("got %d entries, want 200", len(dns))
+	}
+	for i, dn := range dns {
+		if want := fmt.Sprintf("cn=%04d", i+1); dn != want {
+			t.Fatalf("entry %d is %s, want %s", i, dn, want)
+		}
+	}
+	if code := sortResultCode(t, r); code != LDAPResultSuccess {
+		t.Errorf("sortResult %d, want success", code)
+	}
+}
+
+func TestSortedSearchWriterCap(t *testing.T) {
+	srv := &Server{SortMemoryLimit: 512, SortMaxEntries: 50, SortTempDir: t.TempDir()}
+
+	r := &recorder{}
+	sw := NewSortedSearchWriter(r, testSearch(t, &client{srv: srv}, sortByCN(true)))
+	writeEntries(sw, 100)
+	sw.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	if n := len(r.entryDNs(t)); n != 0 {
+		t.Errorf("critical: got %d entries, want none", n)
+	}
+	if code, _ := ResultCode(r.responses[len(r.responses)-1]); code != LDAPResultAdminLimitExceeded {
+		t.Errorf("critical: result %d, want adminLimitExceeded", code)
+	}
+	if code := sortResultCode(t, r); code != LDAPResultAdminLimitExceeded {
+		t.Errorf("critical: sortResult %d, want adminLimitExceeded", code)
+	}
+
+	r = &recorder{}
+	sw = NewSortedSearchWriter(r, testSearch(t, &client{srv: srv}, sortByCN(false)))
+	writeEntries(sw, 100)
+	sw.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	if n := len(r.entryDNs(t)); n != 100 {
+		t.Errorf("not critical: got %d entries, want 100", n)
+	}
+	if code, _ := ResultCode(r.responses[len(r.responses)-1]); code != LDAPResultSuccess {
+		t.Errorf("not critical: result %d, want success", code)
+	}
+	if code := sortResultCode(t, r); code != LDAPResultAdminLimitExceeded {
+		t.Errorf("not critical: sortResult %d, want adminLimitExceeded", code)
+	}
+}
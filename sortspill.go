@@ -0,0 +1,240 @@
+package ldapserver
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// DefaultSortMemoryLimit is the memory budget used by a SpillSorter when
+// MemoryLimit is zero.
+const DefaultSortMemoryLimit = 16 << 20
+
+// ErrSortLimitExceeded is returned by SpillSorter.Add when the hard cap is
+// reached. Handlers should answer it with LDAPResultAdminLimitExceeded.
+var ErrSortLimitExceeded = errors.New("ldapserver: sort limit exceeded")
+
+// SpillSorter sorts result sets which may not fit in memory. Items are kept
+// in memory until MemoryLimit is reached, then sorted and written to a
+// temporary run file; Each merges the runs back in order (external merge
+// sort).
+//
+// The zero value is not usable: Less, Marshal and Unmarshal must be set.
+type SpillSorter[T any] struct {
+	Less      func(a, b T) bool
+	Marshal   func(T) ([]byte, error)
+	Unmarshal func([]byte) (T, error)
+
+	MemoryLimit int    // approximate bytes held in memory before spilling
+	MaxItems    int    // hard cap on the number of items, 0 means no cap
+	MaxBytes    int64  // hard cap on the total marshaled size, 0 means no cap
+	TempDir     string // directory for run files, os.TempDir() if blank
+
+	items []T
+	size  int
+	total int64
+	count int
+	runs  []*os.File
+}
+
+// Len returns the number of items added so far.
+func (s *SpillSorter[T]) Len() int {
+	return s.count
+}
+
+// Add adds an item to the sorter, spilling the in-memory set to disk when
+// the memory budget is exhausted.
+func (s *SpillSorter[T]) Add(item T) error {
+	if s.MaxItems > 0 && s.count >= s.MaxItems {
+		return ErrSortLimitExceeded
+	}
+	data, err := s.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if s.MaxBytes > 0 && s.total+int64(len(data)) > s.MaxBytes {
+		return ErrSortLimitExceeded
+	}
+
+	s.items = append(s.items, item)
+	s.size += len(data)
+	s.total += int64(len(data))
+	s.count++
+
+	limit := s.MemoryLimit
+	if limit <= 0 {
+		limit = DefaultSortMemoryLimit
+	}
+	if s.size >= limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill sorts the in-memory items and writes them to a new run file.
+func (s *SpillSorter[T]) spill() error {
+	sort.SliceStable(s.items, func(i, j int) bool { return s.Less(s.items[i], s.items[j]) })
+
+	f, err := os.CreateTemp(s.TempDir, "ldapserver-sort-*")
+	if err != nil {
+		return err
+	}
+	// the file is only reachable through its descriptor from now on
+	os.Remove(f.Name())
+	s.runs = append(s.runs, f)
+
+	w := bufio.NewWriter(f)
+	for _, item := range s.items {
+		data, err := s.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if err := writeRecord(w, data); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	clear(s.items)
+	s.items = s.items[:0]
+	s.size = 0
+	return nil
+}
+
+// Each calls fn for every item in sorted order, stopping at the first
+// error returned by fn.
+func (s *SpillSorter[T]) Each(fn func(T) error) error {
+	sort.SliceStable(s.items, func(i, j int) bool { return s.Less(s.items[i], s.items[j]) })
+	if len(s.runs) == 0 {
+		for _, item := range s.items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	h := &mergeHeap[T]{less: s.Less}
+	for _, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		src := &runReader[T]{br: bufio.NewReader(f), unmarshal: s.Unmarshal}
+		if err := h.push(src); err != nil {
+			return err
+		}
+	}
+	if len(s.items) > 0 {
+		if err := h.push(&runReader[T]{items: s.items, unmarshal: s.Unmarshal}); err != nil {
+			return err
+		}
+	}
+
+	for h.Len() > 0 {
+		src := h.srcs[0]
+		if err := fn(src.cur); err != nil {
+			return err
+		}
+		ok, err := src.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// Close releases the run files. The sorter can not be used afterwards.
+func (s *SpillSorter[T]) Close() error {
+	var err error
+	for _, f := range s.runs {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.runs = nil
+	s.items = nil
+	return err
+}
+
+func writeRecord(w io.Writer, data []byte) error {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(data)))
+	if _, err := w.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readRecord(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(br, data)
+	return data, err
+}
+
+// runReader iterates over a sorted run, either on disk or in memory.
+type runReader[T any] struct {
+	br        *bufio.Reader
+	items     []T
+	unmarshal func([]byte) (T, error)
+	cur       T
+}
+
+func (r *runReader[T]) next() (bool, error) {
+	if r.br == nil {
+		if len(r.items) == 0 {
+			return false, nil
+		}
+		r.cur, r.items = r.items[0], r.items[1:]
+		return true, nil
+	}
+	data, err := readRecord(r.br)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.cur, err = r.unmarshal(data)
+	return err == nil, err
+}
+
+type mergeHeap[T any] struct {
+	srcs []*runReader[T]
+	less func(a, b T) bool
+}
+
+func (h *mergeHeap[T]) push(src *runReader[T]) error {
+	ok, err := src.next()
+	if err != nil || !ok {
+		return err
+	}
+	heap.Push(h, src)
+	return nil
+}
+
+func (h *mergeHeap[T]) Len() int           { return len(h.srcs) }
+func (h *mergeHeap[T]) Less(i, j int) bool { return h.less(h.srcs[i].cur, h.srcs[j].cur) }
+func (h *mergeHeap[T]) Swap(i, j int)      { h.srcs[i], h.srcs[j] = h.srcs[j], h.srcs[i] }
+func (h *mergeHeap[T]) Push(x any)         { h.srcs = append(h.srcs, x.(*runReader[T])) }
+func (h *mergeHeap[T]) Pop() any {
+	old := h.srcs
+	x := old[len(old)-1]
+	h.srcs = old[:len(old)-1]
+	return x
+}
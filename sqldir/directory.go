@@ -0,0 +1,474 @@
+// Package sqldir exposes the tables of an SQL database, such as the users
+// and groups of an application, as a read-only directory for ldapserver.
+// Each Mapping maps the rows of a table to the entries below a base DN,
+// their attributes to columns, and the search filters to the WHERE
+// clause of the queries:
+//
+//	dir := sqldir.New(db,
+//		&sqldir.Mapping{
+//			Table:         "users",
+//			Base:          "ou=people,dc=example,dc=com",
+//			RDN:           "uid",
+//			ObjectClasses: []string{"top", "inetOrgPerson"},
+//			Columns:       map[string]string{"uid": "login", "cn": "full_name", "sn": "last_name", "mail": "email"},
+//			Password:      "password_hash",
+//		},
+//		&sqldir.Mapping{
+//			Table:         "groups",
+//			Base:          "ou=groups,dc=example,dc=com",
+//			RDN:           "cn",
+//			ObjectClasses: []string{"top", "groupOfNames"},
+//			Columns:       map[string]string{"cn": "name"},
+//			Joins: map[string]sqldir.Join{"member": {
+//				Query:  "SELECT u.login FROM members m JOIN users u ON u.id = m.user_id JOIN groups g ON g.id = m.group_id WHERE g.name = ?",
+//				Format: "uid=%s,ou=people,dc=example,dc=com",
+//			}},
+//		},
+//	)
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return dir }
+package sqldir
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/password"
+)
+
+// Mapping maps the rows of a table to entries.
+type Mapping struct {
+	// Table is the table, or view, of the entries.
+	Table string
+
+	// Base is the DN of the parent of the entries. It and the entries
+	// above it are served as entries with the values of their RDN, and
+	// the object classes top and organizationalUnit, organization or
+	// domain for the ou, o and dc RDNs.
+	Base string
+
+	// RDN is the attribute of the RDN of the entries, which must be in
+	// Columns and unique in the table.
+	RDN string
+
+	// ObjectClasses are the objectClass values of the entries.
+	ObjectClasses []string
+
+	// Columns maps the attributes of the entries to the columns of the
+	// table. NULL and empty values are omitted.
+	Columns map[string]string
+
+	// Joins maps the multi-valued attributes of the entries to the
+	// queries of their values, e.g. the members of a group.
+	Joins map[string]Join
+
+	// Where, if set, restricts the entries to the rows matching this SQL
+	// condition.
+	Where string
+
+	// Password, if set, is the column of the password of the binds,
+	// checked with password.Verify. It is not an attribute.
+	Password string
+}
+
+// Join selects the values of a multi-valued attribute.
+type Join struct {
+	// Query selects the values of the entry, its only parameter being
+	// the value of the RDN column.
+	Query string
+
+	// Format, if set, formats the values, e.g. into the DNs of other
+	// entries with "uid=%s,ou=people,dc=example,dc=com".
+	Format string
+}
+
+// Directory serves the entries of the mappings, and is their ldap.Backend.
+// Searches, compares and simple binds are supported, the other operations
+// are answered with unwillingToPerform.
+//
+// The filters are translated to SQL conditions selecting the candidate
+// rows, and evaluated on the entries. The values of the case-insensitive
+// attributes are compared with LOWER, their spaces as stored: the
+// databases should hold them in normalized form, without repeated spaces.
+type Directory struct {
+	// Placeholder returns the placeholder of the nth parameter of the
+	// queries, n starting at 1: "?" if nil, see Dollar for PostgreSQL.
+	Placeholder func(n int) string
+
+	db       *sql.DB
+	mappings []*mappingDN
+	mux      *ldap.RouteMux
+}
+
+// mappingDN is a Mapping with its parsed base DN.
+type mappingDN struct {
+	*Mapping
+	base ldap.DN
+}
+
+// Dollar is the Directory.Placeholder of PostgreSQL, "$n".
+func Dollar(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// New returns a directory serving the rows of db with the mappings. It
+// panics if the base DN of a mapping is invalid, or its RDN is not in its
+// Columns.
+func New(db *sql.DB, mappings ...*Mapping) *Directory {
+	d := &Directory{db: db}
+	for _, m := range mappings {
+		base, err := ldap.ParseDN(m.Base)
+		if err != nil {
+			panic("sqldir: " + err.Error())
+		}
+		if column(m, m.RDN) == "" {
+			panic("sqldir: the RDN " + m.RDN + " of " + m.Table + " is not a column")
+		}
+		d.mappings = append(d.mappings, &mappingDN{Mapping: m, base: base})
+	}
+	d.mux = ldap.BackendMux(d)
+	return d
+}
+
+// ServeLDAP serves the request m from the directory.
+func (d *Directory) ServeLDAP(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	d.mux.ServeLDAP(ctx, w, m)
+}
+
+// Bind checks a simple bind against the password column of the entry.
+func (d *Directory) Bind(ctx context.Context, name, secret string) error {
+	dn, err := ldap.ParseDN(name)
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, "")
+	}
+	for _, mapping := range d.mappings {
+		if mapping.Password == "" || !mapping.parentOf(dn) {
+			continue
+		}
+		var hash string
+		c := d.condition()
+		err := d.query(ctx, mapping, c.rdn(mapping, dn[0]), c.args, func(e *ldap.Entry, password string) error {
+			hash = password
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if ok, _ := password.Verify(hash, secret); ok && hash != "" {
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, "")
+}
+
+// Search calls f with the entries in the scope of the search matching its
+// filter, the containers first.
+func (d *Directory) Search(ctx context.Context, r goldap.SearchRequest, f func(*ldap.Entry) error) error {
+	base, err := ldap.ParseDN(string(r.BaseObject()))
+	if err != nil {
+		return err
+	}
+	scope := int(r.Scope())
+	filter, err := ldap.NewSearchFilter(r)
+	if err != nil {
+		return err
+	}
+
+	write := func(e *ldap.Entry) error {
+		ok, err := filter.Match(*e)
+		if err != nil || !ok {
+			return err
+		}
+		return f(e)
+	}
+
+	exists := len(base) == 0
+	for _, e := range d.containers() {
+		dn, _ := ldap.ParseDN(e.DN)
+		exists = exists || dn.Equal(base)
+		if dn.InScope(base, scope) {
+			if err := write(e); err != nil {
+				return err
+			}
+		}
+	}
+	for _, mapping := range d.mappings {
+		c := d.condition()
+		var where string
+		switch {
+		case mapping.parentOf(base):
+			where = c.rdn(mapping, base[0])
+		case scope == ldap.SearchRequestHomeSubtree && mapping.base.InScope(base, scope),
+			scope == ldap.SearchRequestSingleLevel && mapping.base.Equal(base):
+			where = c.filter(mapping, r.Filter())
+		default:
+			continue
+		}
+		err := d.query(ctx, mapping, where, c.args, func(e *ldap.Entry, _ string) error {
+			exists = true
+			if scope == ldap.SearchRequestSingleLevel && mapping.parentOf(base) {
+				return nil
+			}
+			return write(e)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !exists {
+		return &ldap.Error{ResultCode: ldap.LDAPResultNoSuchObject, MatchedDN: d.matchedDN(base), DiagnosticMessage: "no such entry: " + base.String()}
+	}
+	return nil
+}
+
+// Compare reports whether the attribute name of the entry dn has the
+// value, with the equality rule of the attribute, see ldap.CompareEntry.
+func (d *Directory) Compare(ctx context.Context, dn, name, value string) (bool, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return false, err
+	}
+	e, err := d.entry(ctx, parsed)
+	if err != nil {
+		return false, err
+	}
+	if e == nil {
+		return false, &ldap.Error{ResultCode: ldap.LDAPResultNoSuchObject, MatchedDN: d.matchedDN(parsed), DiagnosticMessage: "no such entry: " + dn}
+	}
+	return ldap.CompareEntry(e, name, value, nil)
+}
+
+// errReadOnly is the error of the update operations.
+var errReadOnly = ldap.NewError(ldap.LDAPResultUnwillingToPerform, "read-only directory")
+
+// Add fails with unwillingToPerform, as the other update operations.
+func (d *Directory) Add(ctx context.Context, e *ldap.Entry) error { return errReadOnly }
+
+func (d *Directory) Delete(ctx context.Context, dn string) error { return errReadOnly }
+
+func (d *Directory) Modify(ctx context.Context, dn string, changes []ldap.Modification) error {
+	return errReadOnly
+}
+
+func (d *Directory) ModifyDN(ctx context.Context, r ldap.ModifyDNRequest) error { return errReadOnly }
+
+// entry returns the entry dn, nil when there is none.
+func (d *Directory) entry(ctx context.Context, dn ldap.DN) (*ldap.Entry, error) {
+	for _, e := range d.containers() {
+		if c, _ := ldap.ParseDN(e.DN); c.Equal(dn) {
+			return e, nil
+		}
+	}
+	var entry *ldap.Entry
+	for _, mapping := range d.mappings {
+		if !mapping.parentOf(dn) {
+			continue
+		}
+		c := d.condition()
+		err := d.query(ctx, mapping, c.rdn(mapping, dn[0]), c.args, func(e *ldap.Entry, _ string) error {
+			entry = e
+			return nil
+		})
+		if err != nil || entry != nil {
+			return entry, err
+		}
+	}
+	return nil, nil
+}
+
+// containers returns the entries of the base DNs of the mappings and of
+// the entries above them, parents before their children.
+func (d *Directory) containers() []*ldap.Entry {
+	seen := make(map[string]bool)
+	var dns []ldap.DN
+	for _, mapping := range d.mappings {
+		for dn := mapping.base; len(dn) > 0; dn = dn.Parent() {
+			if k := dn.Normalize(); !seen[k] {
+				seen[k] = true
+				dns = append(dns, dn)
+			}
+		}
+	}
+	sort.SliceStable(dns, func(i, j int) bool { return len(dns[i]) < len(dns[j]) })
+	entries := make([]*ldap.Entry, len(dns))
+	for i, dn := range dns {
+		e := &ldap.Entry{DN: dn.String()}
+		e.Add("objectClass", "top")
+		for _, ava := range dn[0] {
+			e.Add(ava.Type, ava.Value)
+			if oc := containerClasses[strings.ToLower(ava.Type)]; oc != "" && !e.Has("objectClass", oc) {
+				e.Add("objectClass", oc)
+			}
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+// containerClasses are the object classes of the containers by RDN
+// attribute.
+var containerClasses = map[string]string{
+	"ou": "organizationalUnit",
+	"o":  "organization",
+	"dc": "domain",
+}
+
+// matchedDN returns the DN of the deepest container above dn.
+func (d *Directory) matchedDN(dn ldap.DN) string {
+	for p := dn.Parent(); len(p) > 0; p = p.Parent() {
+		for _, e := range d.containers() {
+			if c, _ := ldap.ParseDN(e.DN); c.Equal(p) {
+				return e.DN
+			}
+		}
+	}
+	return ""
+}
+
+// parentOf reports whether dn is the DN of an entry of the mapping, with
+// a single valued RDN of its RDN attribute.
+func (m *mappingDN) parentOf(dn ldap.DN) bool {
+	return len(dn) == len(m.base)+1 && dn.Parent().Equal(m.base) &&
+		len(dn[0]) == 1 && strings.EqualFold(dn[0][0].Type, m.RDN)
+}
+
+// query runs the query of the rows of the mapping matching the SQL
+// condition where, calling f with their entries and passwords.
+func (d *Directory) query(ctx context.Context, m *mappingDN, where string, args []any, f func(e *ldap.Entry, password string) error) error {
+	attributes := make([]string, 0, len(m.Columns))
+	for a := range m.Columns {
+		attributes = append(attributes, a)
+	}
+	sort.Strings(attributes)
+	columns := make([]string, len(attributes))
+	for i, a := range attributes {
+		columns[i] = m.Columns[a]
+	}
+	if m.Password != "" {
+		columns = append(columns, m.Password)
+	}
+	q := "SELECT " + strings.Join(columns, ", ") + " FROM " + m.Table
+	if where := and(m.Where, where); where != "" {
+		q += " WHERE " + where
+	}
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultOther, err.Error())
+	}
+	defer rows.Close()
+	var entries []*ldap.Entry
+	var passwords []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return ldap.NewError(ldap.LDAPResultOther, err.Error())
+		}
+		e := &ldap.Entry{}
+		e.Replace("objectClass", m.ObjectClasses...)
+		var rdn string
+		for i, a := range attributes {
+			if values[i].Valid && values[i].String != "" {
+				e.Replace(a, values[i].String)
+				if strings.EqualFold(a, m.RDN) {
+					rdn = values[i].String
+				}
+			}
+		}
+		if rdn == "" {
+			continue
+		}
+		e.DN = append(ldap.DN{{{Type: m.RDN, Value: rdn}}}, m.base...).String()
+		entries = append(entries, e)
+		if m.Password != "" {
+			passwords = append(passwords, values[len(values)-1].String)
+		} else {
+			passwords = append(passwords, "")
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ldap.NewError(ldap.LDAPResultOther, err.Error())
+	}
+	rows.Close()
+
+	for i, e := range entries {
+		if err := d.join(ctx, m, e); err != nil {
+			return err
+		}
+		if err := f(e, passwords[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// join adds the values of the multi-valued attributes of the entry.
+func (d *Directory) join(ctx context.Context, m *mappingDN, e *ldap.Entry) error {
+	rdn := e.Get(m.RDN)[0]
+	for a, j := range m.Joins {
+		rows, err := d.db.QueryContext(ctx, j.Query, rdn)
+		if err != nil {
+			return ldap.NewError(ldap.LDAPResultOther, err.Error())
+		}
+		var values []string
+		for rows.Next() {
+			var v sql.NullString
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return ldap.NewError(ldap.LDAPResultOther, err.Error())
+			}
+			if v.Valid && v.String != "" {
+				if j.Format != "" {
+					v.String = fmt.Sprintf(j.Format, v.String)
+				}
+				values = append(values, v.String)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return ldap.NewError(ldap.LDAPResultOther, err.Error())
+		}
+		e.Replace(a, values...)
+	}
+	return nil
+}
+
+// condition returns a new condition of a query.
+func (d *Directory) condition() *condition {
+	placeholder := d.Placeholder
+	if placeholder == nil {
+		placeholder = func(int) string { return "?" }
+	}
+	return &condition{placeholder: placeholder}
+}
+
+// column returns the column of the attribute, "" when it has none.
+func column(m *Mapping, attribute string) string {
+	for a, c := range m.Columns {
+		if strings.EqualFold(a, attribute) {
+			return c
+		}
+	}
+	return ""
+}
+
+// and returns the conjunction of the SQL conditions, "" for none.
+func and(conditions ...string) string {
+	var parts []string
+	for _, c := range conditions {
+		if c != "" {
+			parts = append(parts, "("+c+")")
+		}
+	}
+	return strings.Join(parts, " AND ")
+}
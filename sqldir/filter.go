@@ -0,0 +1,153 @@
+package sqldir
+
+import (
+	"strings"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+)
+
+// Absolute SQL conditions.
+const (
+	sqlTrue  = "1=1"
+	sqlFalse = "1=0"
+)
+
+// condition builds the WHERE clause of a query, numbering the placeholders
+// of its arguments.
+type condition struct {
+	placeholder func(n int) string
+	args        []any
+}
+
+// arg returns the placeholder of the argument v.
+func (c *condition) arg(v string) string {
+	c.args = append(c.args, v)
+	return c.placeholder(len(c.args))
+}
+
+// rdn returns the condition selecting the row of the entry with the RDN.
+func (c *condition) rdn(m *mappingDN, rdn ldap.RDN) string {
+	return column(m.Mapping, m.RDN) + " = " + c.arg(rdn[0].Value)
+}
+
+// filter returns the condition selecting the rows whose entries may match
+// the filter f: the items of the attributes of the columns are compared
+// in SQL, the others are true unless the entries can not match them.
+func (c *condition) filter(m *mappingDN, f goldap.Filter) string {
+	switch f := f.(type) {
+	case goldap.FilterAnd:
+		if len(f) == 0 {
+			return sqlTrue
+		}
+		parts := make([]string, len(f))
+		for i, sub := range f {
+			parts[i] = c.filter(m, sub)
+		}
+		return and(parts...)
+	case goldap.FilterOr:
+		if len(f) == 0 {
+			return sqlFalse
+		}
+		parts := make([]string, len(f))
+		for i, sub := range f {
+			parts[i] = "(" + c.filter(m, sub) + ")"
+		}
+		return strings.Join(parts, " OR ")
+	case goldap.FilterEqualityMatch:
+		attr, value := string(f.AttributeDesc()), string(f.AssertionValue())
+		if col := column(m.Mapping, attr); col != "" {
+			n, ok := ldap.NormalizeValue(attr, value)
+			if !ok {
+				return sqlFalse
+			}
+			if caseIgnore(attr) {
+				return "LOWER(" + col + ") = " + c.arg(n)
+			}
+			return col + " = " + c.arg(n)
+		}
+		if strings.EqualFold(attr, "objectClass") {
+			for _, oc := range m.ObjectClasses {
+				if strings.EqualFold(oc, value) {
+					return sqlTrue
+				}
+			}
+			return sqlFalse
+		}
+		return c.other(m, attr)
+	case goldap.FilterSubstrings:
+		attr := string(f.Type_())
+		col := column(m.Mapping, attr)
+		if col == "" {
+			return c.other(m, attr)
+		}
+		var pattern strings.Builder
+		for i, s := range f.Substrings() {
+			var part string
+			switch s := s.(type) {
+			case goldap.SubstringInitial:
+				part = string(s)
+			case goldap.SubstringAny:
+				part = string(s)
+			case goldap.SubstringFinal:
+				part = string(s)
+			}
+			if _, initial := s.(goldap.SubstringInitial); i > 0 || !initial {
+				pattern.WriteByte('%')
+			}
+			pattern.WriteString(escapeLike(part))
+			if _, final := s.(goldap.SubstringFinal); i == len(f.Substrings())-1 && !final {
+				pattern.WriteByte('%')
+			}
+		}
+		if caseIgnore(attr) {
+			return "LOWER(" + col + ") LIKE " + c.arg(strings.ToLower(pattern.String())) + " ESCAPE '!'"
+		}
+		return col + " LIKE " + c.arg(pattern.String()) + " ESCAPE '!'"
+	case goldap.FilterPresent:
+		attr := string(f)
+		if col := column(m.Mapping, attr); col != "" {
+			return col + " IS NOT NULL"
+		}
+		if strings.EqualFold(attr, "objectClass") {
+			return sqlTrue
+		}
+		return c.other(m, attr)
+	case goldap.FilterGreaterOrEqual:
+		return c.other(m, string(f.AttributeDesc()))
+	case goldap.FilterLessOrEqual:
+		return c.other(m, string(f.AttributeDesc()))
+	case goldap.FilterApproxMatch:
+		return c.other(m, string(f.AttributeDesc()))
+	}
+	// not filters, whose operand is a superset, and extensible matches
+	return sqlTrue
+}
+
+// other returns the condition of the item of a filter on the attribute
+// attr not compared in SQL: false when the entries have no such
+// attribute.
+func (c *condition) other(m *mappingDN, attr string) string {
+	if column(m.Mapping, attr) != "" || strings.EqualFold(attr, "objectClass") {
+		return sqlTrue
+	}
+	for a := range m.Joins {
+		if strings.EqualFold(a, attr) {
+			return sqlTrue
+		}
+	}
+	return sqlFalse
+}
+
+// caseIgnore reports whether the values of the attribute are compared case
+// insensitively.
+func caseIgnore(attr string) bool {
+	n, ok := ldap.NormalizeValue(attr, "A")
+	return ok && n == "a"
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with '!'.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -0,0 +1,99 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// DefaultTLSHandshakeTimeout bounds the StartTLS handshake when the server
+// has no ReadTimeout.
+const DefaultTLSHandshakeTimeout = 30 * time.Second
+
+// isStartTLS reports whether the message is a StartTLS request the server
+// handles itself, which is the case when Server.TLSConfig or
+// Server.CertManager is set.
+func (c *client) isStartTLS(message *ldap.LDAPMessage) bool {
+	if c.srv.TLSConfig == nil && c.srv.CertManager == nil {
+		return false
+	}
+	r, ok := message.ProtocolOp().(ldap.ExtendedRequest)
+	return ok && r.RequestName() == NoticeOfStartTLS
+}
+
+// startTLS answers a StartTLS request (RFC 4511 section 4.14) and upgrades
+// the connection in place. It runs on the serve loop while the read loop
+// is parked, and since requests are processed one at a time no other
+// operation is outstanding.
+func (c *client) startTLS(message *ldap.LDAPMessage) {
+	res := NewExtendedResponse(LDAPResultSuccess)
+	res.SetResponseName(NoticeOfStartTLS)
+
+	if _, ok := c.rwc.(*tls.Conn); ok {
+		res.SetResultCode(LDAPResultOperationsError)
+		res.SetDiagnosticMessage("TLS already established")
+		c.writeAndFlush(message, res)
+		return
+	}
+	c.Lock()
+	saslInProgress := c.sasl != nil
+	c.sasl = nil
+	c.Unlock()
+	if saslInProgress {
+		// RFC 4513 section 3.1.1: StartTLS aborts the SASL bind in
+		// progress and is refused
+		res.SetResultCode(LDAPResultOperationsError)
+		res.SetDiagnosticMessage("SASL bind in progress")
+		c.writeAndFlush(message, res)
+		return
+	}
+	if c.securityLayer() != nil {
+		// RFC 4513 section 3.1.1
+		res.SetResultCode(LDAPResultOperationsError)
+		res.SetDiagnosticMessage("SASL security layer installed")
+		c.writeAndFlush(message, res)
+		return
+	}
+
+	// the response must be on the wire, in clear, before the handshake
+	c.writeAndFlush(message, res)
+
+	cfg := c.srv.tlsConfig(c.srv.TLSConfig)
+	tlsConn := tls.Server(c.rwc, cfg)
+	timeout := c.srv.ReadTimeout
+	if timeout <= 0 {
+		timeout = DefaultTLSHandshakeTimeout
+	}
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		// the session can not continue in clear text once the client
+		// started the handshake
+		c.srv.logf("client %d StartTLS handshake error: %s", c.Numero, err)
+		c.rwc.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	if err := c.mapClientCert(tlsConn); err != nil {
+		c.srv.logf("client %d client certificate rejected: %s", c.Numero, err)
+		c.rwc.Close()
+		return
+	}
+
+	// the authentication state survives the upgrade, a new bind resets it
+	c.Lock()
+	c.tlsConfig = cfg
+	c.Unlock()
+	c.SetConn(tlsConn)
+	c.srv.logf("client %d StartTLS established", c.Numero)
+}
+
+// writeAndFlush writes a response to message and waits until it has been
+// written to the connection.
+func (c *client) writeAndFlush(message *ldap.LDAPMessage, po ldap.ProtocolOp) {
+	m := ldap.NewLDAPMessageWithProtocolOp(po)
+	m.SetMessageID(message.MessageID().Int())
+	written := make(chan struct{})
+	c.chanOut <- &outMessage{LDAPMessage: m, written: written}
+	<-written
+}
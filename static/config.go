@@ -0,0 +1,244 @@
+package static
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/inmem"
+	"github.com/nolta/ldapserver/password"
+)
+
+// Config is the definition of a directory, read from a YAML or JSON file.
+type Config struct {
+	// Base is the DN of the naming context, e.g. dc=example,dc=com. The
+	// users are below ou=people and the groups below ou=groups.
+	Base string `json:"base" yaml:"base"`
+
+	Users  []User  `json:"users" yaml:"users"`
+	Groups []Group `json:"groups" yaml:"groups"`
+}
+
+// User is an inetOrgPerson entry, and a posixAccount one when it has a
+// UIDNumber.
+type User struct {
+	UID       string `json:"uid" yaml:"uid"`
+	Name      string `json:"name,omitempty" yaml:"name,omitempty"` // cn, the UID if empty
+	GivenName string `json:"givenName,omitempty" yaml:"givenName,omitempty"`
+	Surname   string `json:"surname,omitempty" yaml:"surname,omitempty"` // sn, the Name if empty
+	Mail      string `json:"mail,omitempty" yaml:"mail,omitempty"`
+
+	// Password is the userPassword hash, e.g. {SSHA}... or {CRYPT}$2y$...,
+	// see password.Verify. Clear text passwords are rejected.
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	UIDNumber     int    `json:"uidNumber,omitempty" yaml:"uidNumber,omitempty"`
+	GIDNumber     int    `json:"gidNumber,omitempty" yaml:"gidNumber,omitempty"`         // the UIDNumber if zero
+	HomeDirectory string `json:"homeDirectory,omitempty" yaml:"homeDirectory,omitempty"` // /home/UID if empty
+	LoginShell    string `json:"loginShell,omitempty" yaml:"loginShell,omitempty"`
+
+	// Attributes are the other attributes of the entry.
+	Attributes map[string][]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// Group is a groupOfNames entry, and a posixGroup one when it has a
+// GIDNumber.
+type Group struct {
+	Name        string `json:"name" yaml:"name"` // cn
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	GIDNumber   int    `json:"gidNumber,omitempty" yaml:"gidNumber,omitempty"`
+
+	// Members are the UIDs of the users of the group, its member and
+	// memberUid values.
+	Members []string `json:"members,omitempty" yaml:"members,omitempty"`
+
+	// Attributes are the other attributes of the entry.
+	Attributes map[string][]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// LoadConfig reads the configuration file path, in JSON if its extension
+// is .json and in YAML otherwise. Unknown fields are rejected.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(c); errors.Is(err, io.EOF) {
+			err = nil // empty file
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("static: %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// directory returns the in-memory directory of the entries of the
+// configuration.
+func (c *Config) directory() (*inmem.Directory, error) {
+	base, err := ldap.ParseDN(c.Base)
+	if err != nil {
+		return nil, fmt.Errorf("static: base: %w", err)
+	}
+	if len(base) == 0 {
+		return nil, errors.New("static: no base")
+	}
+	people, groups := "ou=people,"+base.String(), "ou=groups,"+base.String()
+
+	d := inmem.New()
+	root := map[string][]string{"objectClass": {"top"}}
+	for _, ava := range base[0] {
+		root[ava.Type] = append(root[ava.Type], ava.Value)
+		if oc := containerClasses[strings.ToLower(ava.Type)]; oc != "" {
+			root["objectClass"] = append(root["objectClass"], oc)
+		}
+	}
+	if err := d.Add(base.String(), root); err != nil {
+		return nil, err
+	}
+	for _, ou := range []string{people, groups} {
+		if err := d.Add(ou, map[string][]string{"objectClass": {"top", "organizationalUnit"}}); err != nil {
+			return nil, err
+		}
+	}
+
+	users := make(map[string]*User, len(c.Users))
+	for i := range c.Users {
+		u := &c.Users[i]
+		if u.UID == "" {
+			return nil, fmt.Errorf("static: user %d has no uid", i+1)
+		}
+		if users[u.UID] != nil {
+			return nil, fmt.Errorf("static: duplicate user %s", u.UID)
+		}
+		users[u.UID] = u
+		attributes, err := u.attributes()
+		if err != nil {
+			return nil, fmt.Errorf("static: user %s: %w", u.UID, err)
+		}
+		if err := d.Add(dn("uid", u.UID, people), attributes); err != nil {
+			return nil, fmt.Errorf("static: user %s: %w", u.UID, err)
+		}
+	}
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if g.Name == "" {
+			return nil, fmt.Errorf("static: group %d has no name", i+1)
+		}
+		attributes := g.attributes()
+		for _, uid := range g.Members {
+			if users[uid] == nil {
+				return nil, fmt.Errorf("static: group %s: unknown member %s", g.Name, uid)
+			}
+			attributes["member"] = append(attributes["member"], dn("uid", uid, people))
+			if g.GIDNumber != 0 {
+				attributes["memberUid"] = append(attributes["memberUid"], uid)
+			}
+		}
+		if err := d.Add(dn("cn", g.Name, groups), attributes); err != nil {
+			return nil, fmt.Errorf("static: group %s: %w", g.Name, err)
+		}
+	}
+	return d, nil
+}
+
+// attributes returns the attributes of the entry of the user.
+func (u *User) attributes() (map[string][]string, error) {
+	a := make(map[string][]string, len(u.Attributes)+10)
+	for name, values := range u.Attributes {
+		a[name] = append([]string(nil), values...)
+	}
+	a["objectClass"] = append(a["objectClass"], "top", "person", "organizationalPerson", "inetOrgPerson")
+	a["uid"] = []string{u.UID}
+	cn := u.Name
+	if cn == "" {
+		cn = u.UID
+	}
+	a["cn"] = []string{cn}
+	if u.Surname != "" {
+		a["sn"] = []string{u.Surname}
+	} else {
+		a["sn"] = []string{cn}
+	}
+	set(a, "givenName", u.GivenName)
+	set(a, "mail", u.Mail)
+	if u.Password != "" {
+		if !strings.HasPrefix(u.Password, "{") {
+			return nil, errors.New("the password is not hashed")
+		}
+		if _, err := password.Verify(u.Password, ""); err != nil {
+			return nil, err
+		}
+		a["userPassword"] = []string{u.Password}
+	}
+	if u.UIDNumber != 0 {
+		gid := u.GIDNumber
+		if gid == 0 {
+			gid = u.UIDNumber
+		}
+		home := u.HomeDirectory
+		if home == "" {
+			home = "/home/" + u.UID
+		}
+		a["objectClass"] = append(a["objectClass"], "posixAccount")
+		a["uidNumber"] = []string{strconv.Itoa(u.UIDNumber)}
+		a["gidNumber"] = []string{strconv.Itoa(gid)}
+		a["homeDirectory"] = []string{home}
+		set(a, "loginShell", u.LoginShell)
+	}
+	return a, nil
+}
+
+// attributes returns the attributes of the entry of the group, without
+// its members.
+func (g *Group) attributes() map[string][]string {
+	a := make(map[string][]string, len(g.Attributes)+5)
+	for name, values := range g.Attributes {
+		a[name] = append([]string(nil), values...)
+	}
+	a["objectClass"] = append(a["objectClass"], "top", "groupOfNames")
+	a["cn"] = []string{g.Name}
+	set(a, "description", g.Description)
+	if g.GIDNumber != 0 {
+		a["objectClass"] = append(a["objectClass"], "posixGroup")
+		a["gidNumber"] = []string{strconv.Itoa(g.GIDNumber)}
+	}
+	return a
+}
+
+// containerClasses are the object classes of the base entry by RDN
+// attribute.
+var containerClasses = map[string]string{
+	"ou": "organizationalUnit",
+	"o":  "organization",
+	"dc": "domain",
+}
+
+// dn returns the DN of the entry with the RDN attribute=value below
+// parent.
+func dn(attribute, value, parent string) string {
+	return ldap.DN{{{Type: attribute, Value: value}}}.String() + "," + parent
+}
+
+func set(a map[string][]string, name, value string) {
+	if value != "" {
+		a[name] = []string{value}
+	}
+}
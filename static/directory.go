@@ -0,0 +1,175 @@
+// Package static is a read-only directory for ldapserver defined by a
+// YAML or JSON file of users and groups, from which it generates the
+// inetOrgPerson, posixAccount, groupOfNames and posixGroup entries, e.g.
+// for an LDAP facade of a small application:
+//
+//	base: dc=example,dc=com
+//	users:
+//	  - uid: jdoe
+//	    name: John Doe
+//	    mail: jdoe@example.com
+//	    password: "{SSHA}..."
+//	    uidNumber: 1000
+//	groups:
+//	  - name: admins
+//	    gidNumber: 1000
+//	    members: [jdoe]
+//
+// The file is reloaded when it changes with Watch:
+//
+//	dir, err := static.Open("directory.yaml")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	dir.Watch(ctx, 10*time.Second)
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return dir }
+//
+// The memberOf attribute of the users is computed by an ldap.MemberOf
+// decorator, the reloaded groups being used once its cache expires:
+//
+//	routes := ldap.BackendMux(&ldap.MemberOf{Backend: dir})
+package static
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	goldap "github.com/lor00x/goldap/message"
+
+	ldap "github.com/nolta/ldapserver"
+	"github.com/nolta/ldapserver/inmem"
+)
+
+// Directory serves the entries of a configuration, and is their
+// ldap.Backend. Binds, searches and compares are supported, the other
+// operations are answered with unwillingToPerform.
+type Directory struct {
+	// ErrorLog, if set, receives the reload errors of Watch.
+	ErrorLog func(error)
+
+	path    string
+	mu      sync.Mutex // serializes the reloads
+	modTime time.Time
+	dir     atomic.Pointer[inmem.Directory]
+	mux     *ldap.RouteMux
+}
+
+// New returns a directory serving the entries of the configuration c.
+func New(c *Config) (*Directory, error) {
+	dir, err := c.directory()
+	if err != nil {
+		return nil, err
+	}
+	d := &Directory{}
+	d.dir.Store(dir)
+	d.mux = ldap.BackendMux(d)
+	return d, nil
+}
+
+// Open returns a directory serving the entries of the configuration file
+// path, see LoadConfig.
+func Open(path string) (*Directory, error) {
+	d := &Directory{path: path}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	d.mux = ldap.BackendMux(d)
+	return d, nil
+}
+
+// Reload reads the configuration file again and swaps its entries in. On
+// error, the previous entries are still served.
+func (d *Directory) Reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reload()
+}
+
+// reload loads the configuration file. Its modification time is recorded
+// even on error, so that Watch reports an invalid file once.
+func (d *Directory) reload() error {
+	d.modTime = d.stat()
+	c, err := LoadConfig(d.path)
+	if err != nil {
+		return err
+	}
+	dir, err := c.directory()
+	if err != nil {
+		return err
+	}
+	d.dir.Store(dir)
+	return nil
+}
+
+// Watch polls the configuration file every interval and reloads it when
+// its modification time changed, until ctx is done.
+func (d *Directory) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				d.mu.Lock()
+				var err error
+				if d.path != "" && !d.stat().Equal(d.modTime) {
+					err = d.reload()
+				}
+				d.mu.Unlock()
+				if err != nil && d.ErrorLog != nil {
+					d.ErrorLog(err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (d *Directory) stat() time.Time {
+	if fi, err := os.Stat(d.path); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+// ServeLDAP serves the request m from the directory.
+func (d *Directory) ServeLDAP(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
+	d.mux.ServeLDAP(ctx, w, m)
+}
+
+// backend returns the backend of the entries of the current configuration.
+func (d *Directory) backend() ldap.Backend {
+	return d.dir.Load().Backend()
+}
+
+// Bind checks a simple bind against the password of the user.
+func (d *Directory) Bind(ctx context.Context, dn, password string) error {
+	return d.backend().Bind(ctx, dn, password)
+}
+
+func (d *Directory) Search(ctx context.Context, r goldap.SearchRequest, f func(*ldap.Entry) error) error {
+	return d.backend().Search(ctx, r, f)
+}
+
+func (d *Directory) Compare(ctx context.Context, dn, attribute, value string) (bool, error) {
+	return d.backend().Compare(ctx, dn, attribute, value)
+}
+
+// errReadOnly is the error of the update operations.
+var errReadOnly = ldap.NewError(ldap.LDAPResultUnwillingToPerform, "read-only directory")
+
+// Add fails with unwillingToPerform, as the other update operations: the
+// entries are updated by editing the configuration file.
+func (d *Directory) Add(ctx context.Context, e *ldap.Entry) error { return errReadOnly }
+
+func (d *Directory) Delete(ctx context.Context, dn string) error { return errReadOnly }
+
+func (d *Directory) Modify(ctx context.Context, dn string, changes []ldap.Modification) error {
+	return errReadOnly
+}
+
+func (d *Directory) ModifyDN(ctx context.Context, r ldap.ModifyDNRequest) error { return errReadOnly }
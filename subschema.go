@@ -0,0 +1,136 @@
+package ldapserver
+
+import (
+	"context"
+	"strings"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// DefaultSubschemaDN is the DN of the subschema subentry published by
+// Subschema when its DN is empty.
+const DefaultSubschemaDN = "cn=Subschema"
+
+// Subschema publishes a schema in its subschema subentry (RFC 4512 section
+// 4.2), for the clients which discover the schema of a server, such as
+// directory browsers: its Handler answers the searches of the subentry
+// with the attributeTypes, objectClasses, matchingRules and ldapSyntaxes
+// of the schema, and adds the subschemaSubentry attribute pointing to it
+// to the search results which request it, the root DSE included.
+//
+//	sub := &ldap.Subschema{Schema: ldap.DefaultSchema()}
+//	server.Handle(sub.Handler(routes))
+type Subschema struct {
+	Schema *Schema // DefaultSchema if nil
+	DN     string  // DefaultSubschemaDN if empty
+}
+
+func (s *Subschema) dn() string {
+	if s.DN == "" {
+		return DefaultSubschemaDN
+	}
+	return s.DN
+}
+
+// Entry returns the subschema subentry.
+func (s *Subschema) Entry() *Entry {
+	schema := s.Schema
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+	e := &Entry{DN: s.dn()}
+	e.Replace("objectClass", "top", "subentry", "subschema")
+	if dn, err := ParseDN(e.DN); err == nil && len(dn) > 0 {
+		for _, ava := range dn[0] {
+			e.Add(ava.Type, ava.Value)
+		}
+	}
+	var values []string
+	for _, a := range schema.AttributeTypes() {
+		values = append(values, a.String())
+	}
+	e.Replace("attributeTypes", values...)
+	values = nil
+	for _, o := range schema.ObjectClasses() {
+		values = append(values, o.String())
+	}
+	e.Replace("objectClasses", values...)
+	values = nil
+	for _, m := range schema.MatchingRules() {
+		values = append(values, m.String())
+	}
+	e.Replace("matchingRules", values...)
+	values = nil
+	for _, x := range schema.Syntaxes() {
+		values = append(values, x.String())
+	}
+	e.Replace("ldapSyntaxes", values...)
+	return e
+}
+
+// Handler returns a Handler answering the searches based on the subschema
+// subentry, and passing the other requests to next.
+func (s *Subschema) Handler(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		r, ok := m.ProtocolOp().(ldap.SearchRequest)
+		if !ok {
+			next.ServeLDAP(ctx, w, m)
+			return
+		}
+		if EqualDN(string(r.BaseObject()), s.dn()) {
+			ErrorHandler(s.serveSearch).ServeLDAP(ctx, w, m)
+			return
+		}
+		for _, a := range r.Attributes() {
+			if strings.EqualFold(string(a), "subschemaSubentry") || string(a) == "+" {
+				w = &subschemaResponseWriter{ResponseWriter: w, dn: s.dn()}
+				break
+			}
+		}
+		next.ServeLDAP(ctx, w, m)
+	})
+}
+
+// serveSearch answers a search based on the subschema subentry, which has
+// no children.
+func (s *Subschema) serveSearch(ctx context.Context, w ResponseWriter, m *Message) error {
+	r := m.GetSearchRequest()
+	if int(r.Scope()) != SearchRequestSingleLevel {
+		e := s.Entry()
+		filter, err := NewSearchFilter(r)
+		if err != nil {
+			return NewError(LDAPResultProtocolError, err.Error())
+		}
+		ok, err := filter.Match(*e)
+		if err != nil {
+			return NewError(LDAPResultProtocolError, err.Error())
+		}
+		if ok {
+			if err := w.Write(SelectAttributes(e, r.Attributes(), bool(r.TypesOnly()))); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+}
+
+// subschemaResponseWriter adds the subschemaSubentry attribute to the
+// entries of search results without it.
+type subschemaResponseWriter struct {
+	ResponseWriter
+	dn string
+}
+
+func (w *subschemaResponseWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *subschemaResponseWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if r, ok := po.(ldap.SearchResultEntry); ok {
+		if e, err := EntryFromSearchResult(r); err == nil && e.Get("subschemaSubentry") == nil {
+			r.AddAttribute("subschemaSubentry", ldap.AttributeValue(w.dn))
+			po = r
+		}
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
@@ -0,0 +1,279 @@
+package ldapserver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// Controls and intermediate response of the Content Synchronization
+// operation (RFC 4533).
+const (
+	ControlSyncState ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.2"
+	ControlSyncDone  ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.3"
+	SyncInfoMessage  ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.4"
+)
+
+// SyncState is the state of an entry sent by a sync provider.
+type SyncState int
+
+const (
+	SyncPresent SyncState = iota
+	SyncAdd
+	SyncModify
+	SyncDelete
+)
+
+// CSN is a change sequence number in the OpenLDAP format
+// "20240102150405.000000Z#000000#000#000000": time, count in the second,
+// server ID and modifier. CSNs of a server sort as strings; "" is before
+// any change.
+type CSN string
+
+// NewCSN returns the CSN of the count-th change of the second of t on the
+// server sid.
+func NewCSN(t time.Time, count, sid int) CSN {
+	return CSN(fmt.Sprintf("%s#%06x#%03x#000000", t.UTC().Format("20060102150405.000000Z"), count, sid))
+}
+
+// Time returns the time of the change.
+func (csn CSN) Time() (time.Time, error) {
+	ts, _, _ := strings.Cut(string(csn), "#")
+	return time.Parse("20060102150405.000000Z", ts)
+}
+
+// SID returns the ID of the server the change was made on.
+func (csn CSN) SID() int {
+	fields := strings.Split(string(csn), "#")
+	if len(fields) < 3 {
+		return 0
+	}
+	sid, _ := strconv.ParseInt(fields[2], 16, 32)
+	return int(sid)
+}
+
+// SyncCookie is the state of a sync consumer, in the OpenLDAP format
+// "rid=001,sid=001,csn=...": the replica ID chosen by the consumer, echoed
+// by the provider, and the CSNs of the last changes received.
+type SyncCookie struct {
+	RID  int // -1 when absent
+	SID  int // -1 when absent
+	CSNs []CSN
+}
+
+// ParseSyncCookie parses the cookie of a Sync Request control.
+func ParseSyncCookie(cookie []byte) (SyncCookie, error) {
+	c := SyncCookie{RID: -1, SID: -1}
+	if len(cookie) == 0 {
+		return c, nil
+	}
+	for _, field := range strings.Split(string(cookie), ",") {
+		name, value, _ := strings.Cut(field, "=")
+		switch name {
+		case "rid":
+			rid, err := strconv.Atoi(value)
+			if err != nil {
+				return c, fmt.Errorf("invalid sync cookie rid %q", value)
+			}
+			c.RID = rid
+		case "sid":
+			sid, err := strconv.ParseInt(value, 16, 32)
+			if err != nil {
+				return c, fmt.Errorf("invalid sync cookie sid %q", value)
+			}
+			c.SID = int(sid)
+		case "csn":
+			for _, csn := range strings.Split(value, ";") {
+				c.CSNs = append(c.CSNs, CSN(csn))
+			}
+		}
+	}
+	return c, nil
+}
+
+// CSN returns the latest CSN of the cookie, "" if none.
+func (c SyncCookie) CSN() CSN {
+	var latest CSN
+	for _, csn := range c.CSNs {
+		latest = max(latest, csn)
+	}
+	return latest
+}
+
+func (c SyncCookie) String() string {
+	var fields []string
+	if c.RID >= 0 {
+		fields = append(fields, fmt.Sprintf("rid=%03d", c.RID))
+	}
+	if c.SID >= 0 {
+		fields = append(fields, fmt.Sprintf("sid=%03x", c.SID))
+	}
+	if len(c.CSNs) > 0 {
+		csns := make([]string, len(c.CSNs))
+		for i, csn := range c.CSNs {
+			csns[i] = string(csn)
+		}
+		fields = append(fields, "csn="+strings.Join(csns, ";"))
+	}
+	return strings.Join(fields, ",")
+}
+
+// SyncChange is a change of an entry in the content of a search.
+type SyncChange struct {
+	State SyncState // SyncAdd, SyncModify or SyncDelete
+	UUID  []byte    // entryUUID of the entry, 16 bytes
+	DN    string
+	Entry ldap.SearchResultEntry // attributes of added and modified entries
+	CSN   CSN
+}
+
+// SyncSource is the changelog a SyncProvider serves consumers from.
+type SyncSource interface {
+	// Changes returns the changes after since of the entries matching the
+	// search m, oldest first. It returns the whole content as SyncAdd
+	// changes, with full set, when since is "" or no longer in the
+	// changelog.
+	Changes(ctx context.Context, m *Message, since CSN) (changes []SyncChange, full bool, err error)
+
+	// Watch sends the changes after since of the entries matching the
+	// search m until ctx is done, for refreshAndPersist consumers. The
+	// channel is closed when the source stops.
+	Watch(ctx context.Context, m *Message, since CSN) (<-chan SyncChange, error)
+}
+
+// SyncProvider serves the Content Synchronization operation (RFC 4533) from
+// a SyncSource, so that OpenLDAP consumers can replicate the directory
+// with syncrepl: searches with the Sync Request control run a refresh
+// phase, the changes since the cookie of the consumer, and in
+// refreshAndPersist mode then stream the changes as they are made.
+//
+//	sync := &ldap.SyncProvider{Source: changelog, ServerID: 1}
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return sync.Handler(routes) }
+//
+// A persistent search holds its connection until the consumer abandons it.
+type SyncProvider struct {
+	Source   SyncSource
+	ServerID int // sid of the cookies, -1 for none
+}
+
+// Handler returns a handler serving the searches with the Sync Request
+// control, and passing the other requests to next.
+func (p *SyncProvider) Handler(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		req, ok := m.SyncRequest()
+		if _, search := m.ProtocolOp().(ldap.SearchRequest); !ok || !search {
+			next.ServeLDAP(ctx, w, m)
+			return
+		}
+		p.serve(ctx, w, m, req)
+	})
+}
+
+func (p *SyncProvider) serve(ctx context.Context, w ResponseWriter, m *Message, req *SyncRequest) {
+	cookie, err := ParseSyncCookie(req.Cookie)
+	if err != nil {
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultProtocolError, err.Error()))
+		return
+	}
+	changes, full, err := p.Source.Changes(ctx, m, cookie.CSN())
+	if err != nil {
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultOperationsError, err.Error()))
+		return
+	}
+
+	// refresh phase: without full content, the deleted entries are sent
+	csn := cookie.CSN()
+	for _, change := range changes {
+		if ctx.Err() != nil {
+			return
+		}
+		if full && change.State == SyncDelete {
+			continue
+		}
+		w.WriteWithControls(change.entry(), syncStateControl(change.State, change.UUID, nil))
+		csn = max(csn, change.CSN)
+	}
+	out := SyncCookie{RID: cookie.RID, SID: p.ServerID}
+	if csn != "" {
+		out.CSNs = []CSN{csn}
+	}
+
+	if req.Mode == SyncRefreshOnly {
+		w.WriteWithControls(NewSearchResultDoneResponse(LDAPResultSuccess), syncDoneControl(out, !full))
+		return
+	}
+
+	// the end of the refresh phase is an intermediate response, the search
+	// goes on with the persist phase
+	w.Write(NewIntermediateResponse(SyncInfoMessage, syncInfoRefreshDone(out, !full)))
+	watch, err := p.Source.Watch(ctx, m, csn)
+	if err != nil {
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultOperationsError, err.Error()))
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return // abandoned
+		case change, ok := <-watch:
+			if !ok {
+				w.WriteWithControls(NewSearchResultDoneResponse(LDAPResultSuccess), syncDoneControl(out, false))
+				return
+			}
+			if change.CSN != "" {
+				out.CSNs = []CSN{max(out.CSN(), change.CSN)}
+			}
+			w.WriteWithControls(change.entry(), syncStateControl(change.State, change.UUID, &out))
+		}
+	}
+}
+
+// entry returns the search result entry of the change, only the DN for
+// deleted entries.
+func (change SyncChange) entry() ldap.SearchResultEntry {
+	if change.State == SyncDelete {
+		return NewSearchResultEntry(change.DN)
+	}
+	return change.Entry
+}
+
+// syncStateControl returns the Sync State control of an entry.
+func syncStateControl(state SyncState, uuid []byte, cookie *SyncCookie) Control {
+	// syncStateValue ::= SEQUENCE { state ENUMERATED, entryUUID syncUUID,
+	//     cookie syncCookie OPTIONAL }
+	fields := [][]byte{berEnumerated(int64(state)), berOctetString(uuid)}
+	if cookie != nil {
+		fields = append(fields, berString(cookie.String()))
+	}
+	return Control{OID: ControlSyncState, Value: berSequence(fields...)}
+}
+
+// syncDoneControl returns the Sync Done control of the end of a refresh.
+func syncDoneControl(cookie SyncCookie, refreshDeletes bool) Control {
+	// syncDoneValue ::= SEQUENCE { cookie syncCookie OPTIONAL,
+	//     refreshDeletes BOOLEAN DEFAULT FALSE }
+	fields := [][]byte{berString(cookie.String())}
+	if refreshDeletes {
+		fields = append(fields, berBoolean(true))
+	}
+	return Control{OID: ControlSyncDone, Value: berSequence(fields...)}
+}
+
+// syncInfoRefreshDone returns the Sync Info message ending the refresh
+// phase of a refreshAndPersist search: refreshDelete when the deleted
+// entries were sent, else refreshPresent.
+func syncInfoRefreshDone(cookie SyncCookie, refreshDeletes bool) []byte {
+	// syncInfoValue ::= CHOICE { newcookie [0] syncCookie,
+	//     refreshDelete [1] SEQUENCE { cookie syncCookie OPTIONAL,
+	//         refreshDone BOOLEAN DEFAULT TRUE },
+	//     refreshPresent [2] SEQUENCE { ... }, syncIdSet [3] ... }
+	tag := 2
+	if refreshDeletes {
+		tag = 1
+	}
+	return berEncode(berClassContext, true, tag, berString(cookie.String()))
+}
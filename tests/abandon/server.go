@@ -35,7 +35,9 @@ func main() {
 	<-ch
 	close(ch)
 
-	server.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
 }
 
 func handleSearch(ctx context.Context, w ldap.ResponseWriter, m *ldap.Message) {
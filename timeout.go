@@ -0,0 +1,92 @@
+package ldapserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// TimeoutHandler returns a handler running h with a context cancelled
+// after d. If h has not answered by then, the request is answered with
+// timeLimitExceeded and the responses h writes afterwards are dropped;
+// the connection goes on without waiting for h to return.
+func TimeoutHandler(h Handler, d time.Duration) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, m *Message) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		m.ctx = ctx
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					panicked <- v
+				}
+				close(done)
+			}()
+			h.ServeLDAP(ctx, tw, m)
+		}()
+
+		select {
+		case <-done:
+			select {
+			case v := <-panicked:
+				panic(v) // for the recovery of the server
+			default:
+			}
+		case <-ctx.Done():
+			tw.timeout(m.ProtocolOp(), ctx.Err())
+		}
+	})
+}
+
+// timeoutWriter drops the responses written after the timeout of the
+// request.
+type timeoutWriter struct {
+	w ResponseWriter
+
+	mu       sync.Mutex
+	answered bool // the final response was written
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Write(po ldap.ProtocolOp) error {
+	return tw.WriteWithControls(po)
+}
+
+func (tw *timeoutWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return context.DeadlineExceeded
+	}
+	if err := tw.w.WriteWithControls(po, controls...); err != nil {
+		return err
+	}
+	if isFinalResponse(po) {
+		tw.answered = true
+	}
+	return nil
+}
+
+// timeout answers the request with timeLimitExceeded, unless the handler
+// already did, or the request was cancelled (abandon, unbind) rather than
+// timed out.
+func (tw *timeoutWriter) timeout(request ldap.ProtocolOp, err error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.timedOut = true
+	if tw.answered || err != context.DeadlineExceeded {
+		return
+	}
+	if res := responseFor(request, LDAPResultTimeLimitExceeded, "operation timed out"); res != nil {
+		tw.w.Write(res)
+	}
+}
@@ -0,0 +1,214 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ControlTransaction is the OID of the Transaction Specification control
+// (RFC 5805) carried by the updates of a transaction.
+const ControlTransaction ldap.LDAPOID = "1.3.6.1.1.21.2"
+
+// MaxTransactionUpdates is the number of updates a transaction can hold.
+const MaxTransactionUpdates = 1000
+
+// TransactionBackend applies the updates of LDAP transactions atomically.
+// At commit, the queued updates are run through the handlers with the
+// context returned by Begin, derived from the context of the End
+// Transaction request, then Commit is called if they all succeeded,
+// Rollback otherwise.
+type TransactionBackend interface {
+	Begin(ctx context.Context) (context.Context, error)
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// transaction is a transaction started on a connection.
+type transaction struct {
+	updates []*Message
+}
+
+// transactionOp handles the requests of LDAP transactions (RFC 5805) when
+// Server.Transactions is set: Start and End Transaction, and the updates
+// carrying the Transaction Specification control, which are queued. It
+// returns true when the request was answered.
+func (c *client) transactionOp(ctx context.Context, w ResponseWriter, m *Message, handler Handler) bool {
+	if c.srv.Transactions == nil {
+		return false
+	}
+	if r, ok := m.ProtocolOp().(ldap.ExtendedRequest); ok {
+		switch r.RequestName() {
+		case NoticeOfStartTransaction:
+			c.startTransaction(w)
+			return true
+		case NoticeOfEndTransaction:
+			c.endTransaction(ctx, w, r, handler)
+			return true
+		}
+	}
+
+	control, ok := findControl(m.LDAPMessage, ControlTransaction)
+	if !ok {
+		return false
+	}
+	switch m.ProtocolOp().(type) {
+	case ldap.AddRequest, ldap.DelRequest, ldap.ModifyRequest, ldap.ModifyDNRequest:
+	default:
+		if res := responseFor(m.ProtocolOp(), LDAPResultProtocolError, "transactions only hold updates"); res != nil {
+			w.Write(res)
+		}
+		return true
+	}
+	var id string
+	if v := control.ControlValue(); v != nil {
+		id = string(*v)
+	}
+
+	c.Lock()
+	txn := c.transactions[id]
+	full := txn != nil && len(txn.updates) >= MaxTransactionUpdates
+	if txn != nil && !full {
+		txn.updates = append(txn.updates, m)
+	}
+	c.Unlock()
+
+	switch {
+	case txn == nil:
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultUnwillingToPerform, "unknown transaction"))
+	case full:
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultAdminLimitExceeded, "too many updates in the transaction"))
+	default:
+		// RFC 5805 section 2.3: success means the update is queued
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultSuccess, ""))
+	}
+	return true
+}
+
+func (c *client) startTransaction(w ResponseWriter) {
+	c.Lock()
+	if c.transactions == nil {
+		c.transactions = make(map[string]*transaction)
+	}
+	c.txnCounter++
+	id := strconv.FormatUint(c.txnCounter, 10)
+	c.transactions[id] = &transaction{}
+	c.Unlock()
+
+	w.Write(NewExtendedResponseValue(LDAPResultSuccess, "", []byte(id)))
+}
+
+func (c *client) endTransaction(ctx context.Context, w ResponseWriter, r ldap.ExtendedRequest, handler Handler) {
+	commit, id, err := decodeEndTransaction(r.RequestValue())
+	if err != nil {
+		res := NewExtendedResponse(LDAPResultProtocolError)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+
+	c.Lock()
+	txn := c.transactions[id]
+	delete(c.transactions, id)
+	c.Unlock()
+
+	if txn == nil {
+		res := NewExtendedResponse(LDAPResultUnwillingToPerform)
+		res.SetDiagnosticMessage("unknown transaction")
+		w.Write(res)
+		return
+	}
+	if !commit {
+		w.Write(NewExtendedResponse(LDAPResultSuccess))
+		return
+	}
+
+	backend := c.srv.Transactions
+	txnCtx, err := backend.Begin(ctx)
+	if err != nil {
+		res := NewExtendedResponse(LDAPResultOperationsError)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+
+	var updatesControls [][]byte
+	for _, m := range txn.updates {
+		rec := &recordingWriter{m: m, code: LDAPResultOther}
+		m.ctx = txnCtx
+		handler.ServeLDAP(txnCtx, rec, m)
+		if rec.code != LDAPResultSuccess {
+			backend.Rollback(txnCtx)
+			// txnEndRes ::= SEQUENCE { messageID MessageID OPTIONAL, ... }
+			value := berSequence(berInteger(int64(m.MessageID().Int())))
+			res := NewExtendedResponseValue(rec.code, "", value)
+			w.Write(res)
+			return
+		}
+		if len(rec.controls) > 0 {
+			var list [][]byte
+			for _, control := range rec.controls {
+				list = append(list, control.encode())
+			}
+			updatesControls = append(updatesControls, berSequence(berInteger(int64(m.MessageID().Int())), berSequence(list...)))
+		}
+	}
+	if err := backend.Commit(txnCtx); err != nil {
+		res := NewExtendedResponse(LDAPResultOperationsError)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+	if updatesControls == nil {
+		w.Write(NewExtendedResponse(LDAPResultSuccess))
+		return
+	}
+	// updatesControls SEQUENCE OF updateControls SEQUENCE { messageID,
+	//     controls Controls } OPTIONAL
+	w.Write(NewExtendedResponseValue(LDAPResultSuccess, "", berSequence(berSequence(updatesControls...))))
+}
+
+func decodeEndTransaction(value *ldap.OCTETSTRING) (commit bool, id string, err error) {
+	// txnEndReq ::= SEQUENCE { commit BOOLEAN DEFAULT TRUE,
+	//     identifier OCTET STRING }
+	if value == nil {
+		return false, "", errors.New("missing End Transaction request value")
+	}
+	seq, err := berParseAll([]byte(*value))
+	if err != nil {
+		return false, "", err
+	}
+	fields, err := seq.children()
+	if err != nil || len(fields) == 0 || len(fields) > 2 {
+		return false, "", errors.New("malformed End Transaction request value")
+	}
+	commit = true
+	if len(fields) == 2 {
+		commit = fields[0].bool()
+	}
+	return commit, string(fields[len(fields)-1].value), nil
+}
+
+// recordingWriter keeps the result of an update run at the commit of a
+// transaction.
+type recordingWriter struct {
+	m        *Message
+	code     int
+	controls []Control
+}
+
+func (w *recordingWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *recordingWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	code, ok := ResultCode(po)
+	if !ok {
+		code = LDAPResultOther
+	}
+	w.code = code
+	w.controls = append(controls, w.m.readEntryControls(po)...)
+	return nil
+}
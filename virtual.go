@@ -0,0 +1,374 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// VirtualDirectory serves several directories under one namespace, each
+// mounted at a suffix: the requests are routed to the directory of the
+// longest suffix containing their DN, and the searches spanning several
+// suffixes are run on each of them in turn, their entries merged.
+//
+//	v := &ldap.VirtualDirectory{}
+//	v.Mount("ou=apps,dc=example,dc=com", apps)          // an inmem.Directory
+//	v.Mount("ou=corp,dc=example,dc=com", adProxy)       // a proxy.Proxy
+//	v.Mount("ou=customers,dc=example,dc=com", customers) // a sqldir.Directory
+//	server.HandleConnection = func(net.Conn) ldap.Handler { return v }
+//
+// A search of a common parent, e.g. dc=example,dc=com, which no directory
+// holds, returns the entries of the directories in its scope; one of a
+// suffix returns the entries of its directory and of those mounted below
+// it. The size limit applies to the merged entries, and each entry is only
+// returned by the directory of its longest suffix. The response controls
+// of the searches spanning several directories are dropped, e.g. the
+// paging cookies.
+//
+// A bind goes to the directory of its DN, the directory of the previous
+// bind of the connection getting an anonymous bind, and the extended
+// operations to the directory of the bound identity. A rename between two
+// directories fails with affectsMultipleDSAs.
+type VirtualDirectory struct {
+	mounts []*mount
+}
+
+// mount is a directory of a VirtualDirectory.
+type mount struct {
+	suffix  string
+	dn      DN
+	handler Handler
+}
+
+// virtualBindKey is the session key of the mount the connection bound to.
+type virtualBindKey struct{}
+
+// Mount serves the entries of suffix and below it with the handler h. The
+// directories must be mounted before serving requests.
+func (v *VirtualDirectory) Mount(suffix string, h Handler) error {
+	dn, err := ParseDN(suffix)
+	if err != nil {
+		return err
+	}
+	for _, m := range v.mounts {
+		if m.dn.Equal(dn) {
+			return fmt.Errorf("%q is already mounted", suffix)
+		}
+	}
+	v.mounts = append(v.mounts, &mount{suffix: suffix, dn: dn, handler: h})
+	return nil
+}
+
+// Suffixes returns the suffixes which are not below another one, the
+// naming contexts of the RootDSE.
+func (v *VirtualDirectory) Suffixes() []string {
+	var suffixes []string
+	for _, m := range v.mounts {
+		if owner := v.owner(m.dn.Parent()); owner == nil || len(m.dn) == 0 {
+			suffixes = append(suffixes, m.suffix)
+		}
+	}
+	return suffixes
+}
+
+// owner returns the mount of the longest suffix containing dn, nil if
+// none.
+func (v *VirtualDirectory) owner(dn DN) *mount {
+	var owner *mount
+	for _, m := range v.mounts {
+		if dn.InScope(m.dn, SearchRequestHomeSubtree) && (owner == nil || len(m.dn) > len(owner.dn)) {
+			owner = m
+		}
+	}
+	return owner
+}
+
+func (v *VirtualDirectory) ownerOf(dn string) *mount {
+	d, err := ParseDN(dn)
+	if err != nil {
+		return nil
+	}
+	return v.owner(d)
+}
+
+// ServeLDAP routes the request m to the directories.
+func (v *VirtualDirectory) ServeLDAP(ctx context.Context, w ResponseWriter, m *Message) {
+	var target string
+	switch r := m.ProtocolOp().(type) {
+	case ldap.AbandonRequest:
+		return // the abandoned request is cancelled
+	case ldap.UnbindRequest:
+		for _, mt := range v.mounts {
+			mt.handler.ServeLDAP(ctx, w, m)
+		}
+		return
+	case ldap.BindRequest:
+		v.bind(ctx, w, m, r)
+		return
+	case ldap.SearchRequest:
+		v.search(ctx, w, m, r)
+		return
+	case ldap.ExtendedRequest:
+		state, _ := AuthStateFromContext(ctx)
+		if mt := v.ownerOf(state.BoundDN); mt != nil {
+			mt.handler.ServeLDAP(ctx, w, m)
+			return
+		}
+		NotImplemented(ctx, w, m)
+		return
+	case ldap.AddRequest:
+		target = string(r.Entry())
+	case ldap.DelRequest:
+		target = string(r)
+	case ldap.ModifyRequest:
+		target = string(r.Object())
+	case ldap.CompareRequest:
+		target = string(r.Entry())
+	case ldap.ModifyDNRequest:
+		req, err := m.ModifyDN()
+		if err != nil {
+			w.Write(responseFor(r, LDAPResultProtocolError, err.Error()))
+			return
+		}
+		if v.ownerOf(req.NewDN()) != v.ownerOf(req.Entry) {
+			w.Write(responseFor(r, LDAPResultAffectsMultipleDSAs, "the new DN is in another directory"))
+			return
+		}
+		target = req.Entry
+	default:
+		NotImplemented(ctx, w, m)
+		return
+	}
+	mt := v.ownerOf(target)
+	if mt == nil {
+		w.Write(responseFor(m.ProtocolOp(), LDAPResultNoSuchObject, "no such entry"))
+		return
+	}
+	mt.handler.ServeLDAP(ctx, w, m)
+}
+
+// bind sends the bind r to the directory of its DN, and an anonymous bind
+// to the one the connection was bound to, if another.
+func (v *VirtualDirectory) bind(ctx context.Context, w ResponseWriter, m *Message, r ldap.BindRequest) {
+	name := string(r.Name())
+	mt := v.ownerOf(name)
+	if previous, ok := m.Client.Load(virtualBindKey{}); ok && previous.(*mount) != mt {
+		m.Client.Delete(virtualBindKey{})
+		previous.(*mount).unbind(ctx, m)
+	}
+	switch {
+	case mt != nil:
+	case name != "":
+		w.Write(NewBindResponse(LDAPResultInvalidCredentials))
+		return
+	case r.AuthenticationChoice() == "simple" && len(r.AuthenticationSimple()) == 0:
+		// RFC 4513 section 5.1.1, an anonymous bind
+		w.Write(NewBindResponse(LDAPResultSuccess))
+		return
+	default:
+		w.Write(NewBindResponse(LDAPResultAuthMethodNotSupported, DiagnosticMessage("the bind has no DN to route it")))
+		return
+	}
+	bw := &resultWriter{ResponseWriter: w}
+	mt.handler.ServeLDAP(ctx, bw, m)
+	if bw.code == LDAPResultSuccess && name != "" {
+		m.Client.Store(virtualBindKey{}, mt)
+	}
+}
+
+// unbind makes the identity of the connection on the mount anonymous
+// again.
+func (mt *mount) unbind(ctx context.Context, m *Message) {
+	op, err := decodeProtocolOp(berEncode(berClassApplication, true, 0,
+		berInteger(3), berString(""), berEncode(berClassContext, false, 0)))
+	if err != nil {
+		return
+	}
+	anonymous := ldap.NewLDAPMessageWithProtocolOp(op)
+	anonymous.SetMessageID(m.MessageID().Int())
+	mt.handler.ServeLDAP(ctx, discardResponseWriter{}, &Message{LDAPMessage: anonymous, Client: m.Client, ctx: m.ctx})
+}
+
+// branch is the part of a search run on one mount.
+type branch struct {
+	mount  *mount
+	base   string
+	baseDN DN
+	scope  int
+}
+
+// search runs the search r on the mounts in its scope, merging their
+// entries.
+func (v *VirtualDirectory) search(ctx context.Context, w ResponseWriter, m *Message, r ldap.SearchRequest) {
+	base, err := ParseDN(string(r.BaseObject()))
+	if err != nil {
+		w.Write(NewSearchResultDoneResponse(LDAPResultInvalidDNSyntax, DiagnosticMessage(err.Error())))
+		return
+	}
+	scope := int(r.Scope())
+	var branches []branch
+	owner := v.owner(base)
+	if owner != nil {
+		branches = append(branches, branch{owner, string(r.BaseObject()), base, scope})
+	}
+	for _, mt := range v.mounts {
+		if mt == owner || len(mt.dn) <= len(base) || !mt.dn.InScope(base, SearchRequestHomeSubtree) {
+			continue
+		}
+		switch {
+		case scope == SearchRequestHomeSubtree:
+			branches = append(branches, branch{mt, mt.suffix, mt.dn, SearchRequestHomeSubtree})
+		case scope == SearchRequestSingleLevel && len(mt.dn) == len(base)+1:
+			branches = append(branches, branch{mt, mt.suffix, mt.dn, SearchRequestScopeBaseObject})
+		}
+	}
+	switch {
+	case len(branches) == 0:
+		w.Write(NewSearchResultDoneResponse(LDAPResultNoSuchObject, DiagnosticMessage("no such entry")))
+		return
+	case len(branches) == 1 && branches[0].mount == owner:
+		owner.handler.ServeLDAP(ctx, w, m)
+		return
+	}
+
+	limit, n := int(r.SizeLimit()), 0
+	var missing ldap.ProtocolOp // the first noSuchObject result
+	found := false
+	for _, b := range branches {
+		// the mount returns at most one entry more than the limit, unless
+		// it returns entries of the mounts below it
+		subLimit := 0
+		if limit > 0 && !b.nested(branches) {
+			subLimit = limit - n + 1
+		}
+		sub, err := searchMessage(m.LDAPMessage, b.base, b.scope, subLimit)
+		if err != nil {
+			w.Write(NewSearchResultDoneResponse(LDAPResultOperationsError, DiagnosticMessage(err.Error())))
+			return
+		}
+		sw := &virtualSearchWriter{resultWriter: resultWriter{ResponseWriter: w, keep: true}, v: v, mount: b.mount, n: &n, limit: limit}
+		b.mount.handler.ServeLDAP(ctx, sw, &Message{LDAPMessage: sub, Client: m.Client, ctx: m.ctx})
+		switch {
+		case sw.exceeded:
+			w.Write(NewSearchResultDoneResponse(LDAPResultSizeLimitExceeded))
+			return
+		case sw.result == nil:
+			w.Write(NewSearchResultDoneResponse(LDAPResultOther, DiagnosticMessage("no result from the directory of "+b.mount.suffix)))
+			return
+		case sw.code == LDAPResultSuccess:
+			found = true
+		case sw.code == LDAPResultNoSuchObject:
+			if missing == nil {
+				missing = sw.result
+			}
+		default:
+			w.Write(sw.result)
+			return
+		}
+	}
+	if !found && missing != nil {
+		w.Write(missing)
+		return
+	}
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+}
+
+// nested reports whether other branches search mounts below the base of
+// b, whose entries the mount of b may return too.
+func (b branch) nested(branches []branch) bool {
+	for _, o := range branches {
+		if o.mount != b.mount && len(o.baseDN) > len(b.baseDN) && o.baseDN.InScope(b.baseDN, SearchRequestHomeSubtree) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchMessage returns the search message m with its base, scope and
+// size limit replaced.
+func searchMessage(m *ldap.LDAPMessage, base string, scope, sizeLimit int) (*ldap.LDAPMessage, error) {
+	data, err := protocolOpBytes(m.ProtocolOp())
+	if err != nil {
+		return nil, err
+	}
+	op, err := berParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := op.children()
+	if err != nil || len(fields) != 8 {
+		return nil, errors.New("malformed search request")
+	}
+	fields[0].value = []byte(base)
+	fields[1].value = berIntegerContent(int64(scope))
+	fields[3].value = berIntegerContent(int64(sizeLimit))
+	return messageWithProtocolOp(m, op.with(fields).encode())
+}
+
+// resultWriter records the final response of a request and its result
+// code, keeping it instead of writing it when keep is set.
+type resultWriter struct {
+	ResponseWriter
+	keep   bool
+	result ldap.ProtocolOp
+	code   int
+}
+
+func (w *resultWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *resultWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if !isFinalResponse(po) {
+		return w.ResponseWriter.WriteWithControls(po, controls...)
+	}
+	w.result = po
+	w.code, _ = ResultCode(po)
+	if w.keep {
+		return nil
+	}
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// virtualSearchWriter writes the entries of the mount found by a branch of
+// a search, enforcing the size limit of the merged entries.
+type virtualSearchWriter struct {
+	resultWriter
+	v        *VirtualDirectory
+	mount    *mount
+	n        *int // the entries written by the branches
+	limit    int
+	exceeded bool
+}
+
+func (w *virtualSearchWriter) Write(po ldap.ProtocolOp) error {
+	return w.WriteWithControls(po)
+}
+
+func (w *virtualSearchWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	e, ok := po.(ldap.SearchResultEntry)
+	if !ok {
+		return w.resultWriter.WriteWithControls(po, controls...)
+	}
+	dn, _, err := decodeSearchResultEntry(e)
+	if err != nil || w.v.ownerOf(dn) != w.mount {
+		return nil // served by the directory mounted below
+	}
+	if w.limit > 0 && *w.n == w.limit {
+		w.exceeded = true
+		return NewError(LDAPResultSizeLimitExceeded, "")
+	}
+	*w.n++
+	return w.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// discardResponseWriter drops the responses.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Write(po ldap.ProtocolOp) error { return nil }
+
+func (discardResponseWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	return nil
+}
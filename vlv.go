@@ -0,0 +1,303 @@
+package ldapserver
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// ControlVLVResponse is the OID of the Virtual List View response control.
+const ControlVLVResponse ldap.LDAPOID = "2.16.840.1.113730.3.4.10"
+
+// MaxVLVContextsPerConn is the number of Virtual List View result sets a
+// connection keeps for scrolling; creating another one drops the oldest.
+const MaxVLVContextsPerConn = 4
+
+// vlvSearch is the sorted result set of a search scrolled with the Virtual
+// List View control.
+type vlvSearch struct {
+	request  []byte // encoding of the search request
+	sort     []byte // value of the sort control
+	entries  *SpillSorter[sortedEntry]
+	done     ldap.SearchResultDone
+	controls []Control
+
+	mu     sync.Mutex // serializes the iterations of the entries
+	closed bool
+}
+
+// close releases the entries of the result set.
+func (s *vlvSearch) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.entries.Close()
+}
+
+// VLVSearchWriter implements the server side of the Virtual List View
+// control (draft-ietf-ldapext-ldapv3-vlv) for a search handler: the
+// handler writes all its results, sorted by a SortedSearchWriter, and only
+// the window around the target entry is sent. The sorted result set is
+// kept by the connection under a context ID, so scrolling does not run the
+// search again. Like the SortedSearchWriter, it spills the result sets
+// beyond Server.SortMemoryLimit to temporary files, and answers those
+// beyond SortMaxEntries or SortMaxBytes with adminLimitExceeded.
+//
+//	vw := ldap.NewVLVSearchWriter(w, m)
+//	if vw.Resume() {
+//		return // window sent from the result set, or an error
+//	}
+//	sw := ldap.NewSortedSearchWriter(vw, m)
+//	for _, e := range entries {
+//		sw.Write(e)
+//	}
+//	sw.Write(ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess))
+//
+// Without the control, the results go straight to the ResponseWriter.
+type VLVSearchWriter struct {
+	ResponseWriter
+	m       *Message
+	vlv     *VLVRequest
+	sort    []byte
+	keys    []SortKey
+	request []byte
+	sorter  *entrySorter              // of the first sort key, without keys if unsupported
+	entries *SpillSorter[sortedEntry] // in the order of the writes
+	code    int                       // result when the entries can not be kept
+	n       int                       // entries written
+}
+
+// NewVLVSearchWriter returns the writer of the results of the search
+// request m.
+func NewVLVSearchWriter(w ResponseWriter, m *Message) *VLVSearchWriter {
+	v := &VLVSearchWriter{ResponseWriter: w, m: m}
+	if vlv, ok := m.VLV(); ok {
+		v.vlv = vlv
+		v.request, _ = protocolOpBytes(m.ProtocolOp())
+		if c, ok := m.Control(ControlSortRequest); ok && c.Err == nil {
+			v.sort, v.keys = c.Value, c.Decoded.([]SortKey)
+		}
+	}
+	if v.keys != nil {
+		// the entries are sorted already: the values of the first key
+		// locate the target of the assertions
+		sorter, err := newEntrySorter(v.keys[:1])
+		if err != nil {
+			sorter = &entrySorter{}
+		}
+		v.sorter = sorter
+		v.entries = sorter.spillSorter(m.Client, func(a, b sortedEntry) bool { return a.seq < b.seq })
+	}
+	return v
+}
+
+// Resume answers a request scrolling a result set kept under the context
+// ID of the control, and requests without the Server Side Sorting control
+// the Virtual List View requires. It returns false when the handler must
+// run the search.
+func (v *VLVSearchWriter) Resume() bool {
+	if v.vlv == nil {
+		return false
+	}
+	if v.keys == nil {
+		v.ResponseWriter.WriteWithControls(responseFor(v.m.ProtocolOp(), LDAPResultVirtualListViewError, "VLV requires the sort control"),
+			vlvResponseControl(0, 0, LDAPResultSortControlMissing, nil))
+		return true
+	}
+	if len(v.vlv.ContextID) == 0 {
+		return false
+	}
+
+	c := v.m.Client
+	c.Lock()
+	search := c.vlvSearches[string(v.vlv.ContextID)]
+	c.Unlock()
+
+	// a stale context ID runs the search again
+	if search == nil || !bytes.Equal(search.request, v.request) || !bytes.Equal(search.sort, v.sort) {
+		return false
+	}
+	search.mu.Lock()
+	defer search.mu.Unlock()
+	if search.closed {
+		return false
+	}
+	v.window(search, v.vlv.ContextID)
+	return true
+}
+
+// Write holds the entries of the search until the SearchResultDone, then
+// sends the window of the request.
+func (v *VLVSearchWriter) Write(po ldap.ProtocolOp) error {
+	return v.WriteWithControls(po)
+}
+
+func (v *VLVSearchWriter) WriteWithControls(po ldap.ProtocolOp, controls ...Control) error {
+	if v.vlv == nil {
+		return v.ResponseWriter.WriteWithControls(po, controls...)
+	}
+	switch r := po.(type) {
+	case ldap.SearchResultEntry:
+		if v.code != LDAPResultSuccess {
+			return nil
+		}
+		item, err := v.sorter.item(r, v.n)
+		if err == nil {
+			err = v.entries.Add(item)
+		}
+		v.n++
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrSortLimitExceeded):
+			v.code = LDAPResultAdminLimitExceeded
+		default:
+			v.code = LDAPResultOperationsError
+		}
+		return nil
+	case ldap.SearchResultDone:
+		if v.code != LDAPResultSuccess {
+			v.entries.Close()
+			return v.ResponseWriter.WriteWithControls(responseFor(v.m.ProtocolOp(), v.code, "can not keep the entries of the list"),
+				vlvResponseControl(0, 0, v.code, nil))
+		}
+		if code, _ := ResultCode(r); code != LDAPResultSuccess {
+			v.entries.Close()
+			return v.ResponseWriter.WriteWithControls(r, append(controls, vlvResponseControl(0, 0, code, nil))...)
+		}
+		search := &vlvSearch{request: v.request, sort: v.sort, entries: v.entries, done: r, controls: controls}
+		v.entries = nil
+
+		c := v.m.Client
+		c.Lock()
+		if c.vlvSearches == nil {
+			c.vlvSearches = make(map[string]*vlvSearch)
+		}
+		var dropped *vlvSearch
+		if len(c.vlvSearches) >= MaxVLVContextsPerConn {
+			oldest := ""
+			for id := range c.vlvSearches {
+				if oldest == "" || cookieOrder(id) < cookieOrder(oldest) {
+					oldest = id
+				}
+			}
+			dropped = c.vlvSearches[oldest]
+			delete(c.vlvSearches, oldest)
+		}
+		c.vlvContext++
+		id := strconv.FormatUint(c.vlvContext, 10)
+		c.vlvSearches[id] = search
+		search.mu.Lock()
+		defer search.mu.Unlock()
+		c.Unlock()
+		if dropped != nil {
+			dropped.close()
+		}
+
+		return v.window(search, []byte(id))
+	}
+	return v.ResponseWriter.WriteWithControls(po, controls...)
+}
+
+// window sends the entries around the target of the request, search.mu
+// being held.
+func (v *VLVSearchWriter) window(search *vlvSearch, contextID []byte) error {
+	count := search.entries.Len()
+	target, code := v.target(search.entries)
+	if code != LDAPResultSuccess {
+		return v.ResponseWriter.WriteWithControls(responseFor(v.m.ProtocolOp(), code, "invalid VLV target"),
+			vlvResponseControl(0, count, code, contextID))
+	}
+
+	from, to := max(target-v.vlv.BeforeCount, 0), min(target+v.vlv.AfterCount+1, count)
+	i := 0
+	err := search.entries.Each(func(item sortedEntry) error {
+		if i >= to {
+			return errStopEach
+		}
+		i++
+		if i <= from {
+			return nil
+		}
+		return v.ResponseWriter.Write(item.entry)
+	})
+	if err != nil && err != errStopEach {
+		return err
+	}
+	position := target + 1
+	if count == 0 {
+		position = 0
+	}
+	return v.ResponseWriter.WriteWithControls(search.done,
+		append(append([]Control{}, search.controls...), vlvResponseControl(position, count, LDAPResultSuccess, contextID))...)
+}
+
+// errStopEach stops the iteration of a result set.
+var errStopEach = errors.New("window sent")
+
+// target returns the index of the target entry, the number of entries
+// when no entry is greater than or equal to the assertion value.
+func (v *VLVSearchWriter) target(entries *SpillSorter[sortedEntry]) (int, int) {
+	count := entries.Len()
+	if !v.vlv.ByValue {
+		switch {
+		case v.vlv.Offset < 1:
+			return 0, LDAPResultOffsetRangeError
+		case count == 0:
+			return 0, LDAPResultSuccess
+		case v.vlv.ContentCount == 0:
+			return min(v.vlv.Offset, count) - 1, LDAPResultSuccess
+		case v.vlv.Offset >= v.vlv.ContentCount:
+			return count - 1, LDAPResultSuccess
+		}
+		// the client estimate of the size of the list is scaled to ours
+		return (v.vlv.Offset - 1) * count / v.vlv.ContentCount, LDAPResultSuccess
+	}
+
+	if len(v.sorter.keys) == 0 {
+		return 0, LDAPResultInappropriateMatching
+	}
+	key, compare := v.keys[0], v.sorter.compares[0]
+	assertion := string(v.vlv.GreaterThanOrEqual)
+	target := count
+	err := entries.Each(func(item sortedEntry) error {
+		value := item.values[0]
+		if value == nil {
+			return nil
+		}
+		if c := compare(*value, assertion); (c >= 0 && !key.Reverse) || (c <= 0 && key.Reverse) {
+			target = item.seq
+			return errStopEach
+		}
+		return nil
+	})
+	if err != nil && err != errStopEach {
+		return 0, LDAPResultOperationsError
+	}
+	return target, LDAPResultSuccess
+}
+
+// vlvResponseControl returns the Virtual List View response control.
+func vlvResponseControl(position, count, code int, contextID []byte) Control {
+	// VirtualListViewResponse ::= SEQUENCE { targetPosition INTEGER,
+	//     contentCount INTEGER, virtualListViewResult ENUMERATED,
+	//     contextID OCTET STRING OPTIONAL }
+	fields := [][]byte{berInteger(int64(position)), berInteger(int64(count)), berEnumerated(int64(code))}
+	if contextID != nil {
+		fields = append(fields, berOctetString(contextID))
+	}
+	return Control{OID: ControlVLVResponse, Value: berSequence(fields...)}
+}
+
+// closeVLVSearches releases the result sets of the connection.
+func (c *client) closeVLVSearches() {
+	c.Lock()
+	searches := c.vlvSearches
+	c.vlvSearches = nil
+	c.Unlock()
+	for _, search := range searches {
+		search.close()
+	}
+}
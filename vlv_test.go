@@ -0,0 +1,71 @@
+package ldapserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+// vlvControl returns the Virtual List View control of a window of one
+// entry around the target, an offset or an assertion value.
+func vlvControl(target []byte, contextID string) Control {
+	fields := [][]byte{berInteger(1), berInteger(1), target}
+	if contextID != "" {
+		fields = append(fields, berOctetString([]byte(contextID)))
+	}
+	return Control{OID: ControlVLVRequest, Value: berSequence(fields...)}
+}
+
+func byOffset(offset int) []byte {
+	return berEncode(berClassContext, true, 0, append(berInteger(int64(offset)), berInteger(0)...))
+}
+
+func byValue(value string) []byte {
+	return berEncode(berClassContext, false, 1, []byte(value))
+}
+
+// runVLVSearch runs a search of 200 entries with the VLV control, returning
+// the responses.
+func runVLVSearch(t *testing.T, c *client, vlv Control) *recorder {
+	r := &recorder{}
+	vw := NewVLVSearchWriter(r, testSearch(t, c, sortByCN(true), vlv))
+	if vw.Resume() {
+		return r
+	}
+	sw := NewSortedSearchWriter(vw, testSearch(t, c, sortByCN(true), vlv))
+	writeEntries(sw, 200)
+	sw.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	return r
+}
+
+func TestVLVSearchWriterSpills(t *testing.T) {
+	c := &client{srv: &Server{SortMemoryLimit: 512, SortTempDir: t.TempDir()}}
+	defer c.closeVLVSearches()
+
+	r := runVLVSearch(t, c, vlvControl(byOffset(50), ""))
+	if got, want := r.entryDNs(t), []string{"cn=0049", "cn=0050", "cn=0051"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	search := c.vlvSearches["1"]
+	if search == nil || len(search.entries.runs) == 0 {
+		t.Fatal("the result set was not spilled")
+	}
+
+	// scrolling reads the result set kept under the context ID
+	r = runVLVSearch(t, c, vlvControl(byValue("0150"), "1"))
+	if got, want := r.entryDNs(t), []string{"cn=0149", "cn=0150", "cn=0151"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestVLVSearchWriterCap(t *testing.T) {
+	c := &client{srv: &Server{SortMemoryLimit: 512, SortMaxBytes: 2048, SortTempDir: t.TempDir()}}
+	defer c.closeVLVSearches()
+
+	r := runVLVSearch(t, c, vlvControl(byOffset(1), ""))
+	if n := len(r.entryDNs(t)); n != 0 {
+		t.Errorf("got %d entries, want none", n)
+	}
+	if code, _ := ResultCode(r.responses[len(r.responses)-1]); code != LDAPResultAdminLimitExceeded {
+		t.Errorf("result %d, want adminLimitExceeded", code)
+	}
+}
@@ -0,0 +1,46 @@
+package ldapserver
+
+import (
+	"context"
+
+	ldap "github.com/lor00x/goldap/message"
+)
+
+// authzID returns the authorization identity of a request (RFC 4513
+// section 5.2.1.8): "dn:<dn>", "u:<user>" for sessions authenticated
+// without a DN, "" for anonymous ones. The identity of an accepted Proxied
+// Authorization control wins.
+func (c *client) authzID(ctx context.Context) string {
+	if p, ok := ProxiedAuthorizationFromContext(ctx); ok {
+		return p.Identity
+	}
+	c.Lock()
+	defer c.Unlock()
+	switch {
+	case c.auth.BoundDN != "":
+		return "dn:" + c.auth.BoundDN
+	case c.principal != "":
+		return "u:" + c.principal
+	}
+	return ""
+}
+
+// whoAmI answers the Who Am I? extended operation (RFC 4532) when
+// Server.WhoAmI is set. It returns true when the request was answered.
+func (c *client) whoAmI(ctx context.Context, w ResponseWriter, m *Message) bool {
+	if !c.srv.WhoAmI {
+		return false
+	}
+	r, ok := m.ProtocolOp().(ldap.ExtendedRequest)
+	if !ok || r.RequestName() != NoticeOfWhoAmI {
+		return false
+	}
+	if r.RequestValue() != nil {
+		res := NewExtendedResponse(LDAPResultProtocolError)
+		res.SetDiagnosticMessage("Who Am I? request has no value")
+		w.Write(res)
+		return true
+	}
+	w.Write(NewExtendedResponseValue(LDAPResultSuccess, "", []byte(c.authzID(ctx))))
+	return true
+}